@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brojonat/gredfin/worker"
+)
+
+// Checks the extraction config against the recorded payloads in dir. Payloads
+// are matched to the config by basename (e.g., mls_info.json is checked against
+// the mls_info fields), so a directory of archived scrapes can be used as is.
+func ValidateExtractionConfig(l *slog.Logger, path, dir string) error {
+	ec, err := worker.LoadExtractionConfig(path)
+	if err != nil {
+		return err
+	}
+	l.Info("loaded extraction config", "version", ec.Version, "revision", ec.Revision)
+
+	nfiles := 0
+	nbad := 0
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		payload := strings.TrimSuffix(d.Name(), ".json")
+		if _, ok := ec.Payloads[payload]; !ok {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var data interface{}
+		if err = json.Unmarshal(b, &data); err != nil {
+			return fmt.Errorf("error parsing %s: %w", p, err)
+		}
+		nfiles += 1
+		errs := ec.Check(payload, data)
+		if len(errs) > 0 {
+			nbad += 1
+		}
+		for _, err := range errs {
+			l.Error("extraction failed", "file", p, "error", err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	l.Info("checked recorded payloads", "files", nfiles, "failed", nbad)
+	if nbad > 0 {
+		return fmt.Errorf("extraction failed for %d / %d payloads", nbad, nfiles)
+	}
+	return nil
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
+	"cloud.google.com/go/storage"
 	firebase "firebase.google.com/go"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/brojonat/gredfin/provider"
 	"github.com/brojonat/gredfin/redfin"
 	"github.com/brojonat/gredfin/server"
 	"github.com/brojonat/gredfin/worker"
@@ -18,6 +23,64 @@ import (
 	"google.golang.org/api/option"
 )
 
+// Returns the provider with the supplied name.
+func getProvider(name string, l *slog.Logger, grc redfin.Client, ec *worker.ExtractionConfig) (provider.Provider, error) {
+	switch name {
+	case provider.Redfin:
+		return worker.NewRedfinProvider(l, grc, ec), nil
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", name)
+	}
+}
+
+// Returns the blob store selected by the http-server flags. The GCS store uses
+// the Firebase service account credentials.
+func getBlobStore(ctx *cli.Context) (server.BlobStore, error) {
+	bucket := ctx.String("blob-bucket")
+	switch name := ctx.String("blob-store"); name {
+	case server.BlobStoreS3:
+		if bucket == "" {
+			return nil, fmt.Errorf("must supply a bucket for the s3 blob store")
+		}
+		awsCFG, err := config.LoadDefaultConfig(ctx.Context)
+		if err != nil {
+			return nil, err
+		}
+		return server.NewS3BlobStore(s3.NewFromConfig(awsCFG), bucket), nil
+	case server.BlobStoreGCS:
+		if bucket == "" {
+			return nil, fmt.Errorf("must supply a bucket for the gcs blob store")
+		}
+		gcs, err := storage.NewClient(ctx.Context, option.WithCredentialsJSON([]byte(ctx.String("firebase-config"))))
+		if err != nil {
+			return nil, fmt.Errorf("error initializing gcs client: %w", err)
+		}
+		return server.NewGCSBlobStore(gcs, bucket), nil
+	case server.BlobStoreLocal:
+		baseURL := ctx.String("blob-base-url")
+		if baseURL == "" {
+			baseURL = fmt.Sprintf("http://localhost:%s", ctx.String("listen-port"))
+		}
+		return server.NewLocalBlobStore(ctx.String("blob-dir"), baseURL, os.Getenv("SERVER_SECRET_KEY"))
+	default:
+		return nil, fmt.Errorf("unsupported blob store: %s", name)
+	}
+}
+
+// Returns the key ring that signs and verifies tokens, as configured by the
+// signing key flags.
+func getKeyRing(ctx *cli.Context) (*server.KeyRing, error) {
+	kr, err := server.LoadKeyRing(
+		ctx.String("signing-key-dir"),
+		ctx.String("signing-key-id"),
+		os.Getenv("SERVER_SECRET_KEY"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error loading signing keys: %w", err)
+	}
+	return kr, nil
+}
+
 func getDefaultLogger(lvl slog.Level) *slog.Logger {
 	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		AddSource: true,
@@ -37,6 +100,9 @@ func getDefaultLogger(lvl slog.Level) *slog.Logger {
 
 func main() {
 	app := &cli.App{
+		// Worker options can contain commas (e.g., user agents), so slice
+		// flags are only split by repeating them.
+		DisableSliceFlagSeparator: true,
 		Commands: []*cli.Command{
 			{
 				Name:  "admin",
@@ -159,6 +225,123 @@ func main() {
 							return add_property_query(ctx)
 						},
 					},
+					{
+						Name:  "validate-extraction-config",
+						Usage: "Check a field extraction config against recorded payloads.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "extraction-config",
+								Aliases: []string{"ec"},
+								Value:   os.Getenv("EXTRACTION_CONFIG"),
+								Usage:   "Path to a YAML or JSON field extraction config (defaults to the embedded config).",
+							},
+							&cli.StringFlag{
+								Name:     "payload-dir",
+								Aliases:  []string{"d"},
+								Usage:    "Directory of recorded payloads (e.g., mls_info.json) to check against.",
+								Required: true,
+							},
+							&cli.IntFlag{
+								Name:    "log-level",
+								Aliases: []string{"ll", "l"},
+								Usage:   "Logging level for the slog.Logger. Default is 0 (INFO), use -4 for DEBUG.",
+								Value:   0,
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							return validate_extraction_config(ctx)
+						},
+					},
+					{
+						Name:  "issue-token",
+						Usage: "Issue an auth token signed with the server's signing key.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "email",
+								Aliases:  []string{"e"},
+								Usage:    "Email of the token holder.",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:    "role",
+								Aliases: []string{"r"},
+								Usage:   "Role of the token holder (admin, worker, or reader).",
+							},
+							&cli.StringSliceFlag{
+								Name:  "scope",
+								Usage: "Additional scope to grant (read, write, jobs, scrape, or admin); can be repeated.",
+							},
+							&cli.DurationFlag{
+								Name:  "ttl",
+								Usage: "How long the token is valid for.",
+								Value: 24 * time.Hour,
+							},
+							&cli.StringFlag{
+								Name:    "signing-key-dir",
+								EnvVars: []string{"SIGNING_KEY_DIR"},
+								Usage:   "Directory of PEM encoded token signing keys named <key id>.pem (defaults to HS256 tokens signed with SERVER_SECRET_KEY).",
+							},
+							&cli.StringFlag{
+								Name:    "signing-key-id",
+								EnvVars: []string{"SIGNING_KEY_ID"},
+								Usage:   "ID of the key that signs new tokens (needed if signing-key-dir has more than one private key).",
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							return issue_token(ctx)
+						},
+					},
+					{
+						Name:  "reprocess",
+						Usage: "Re-run extraction over archived payloads and upload the results.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "server-endpoint",
+								Aliases: []string{"server", "s"},
+								Value:   os.Getenv("SERVER_ENDPOINT"),
+								Usage:   "Server endpoint.",
+							},
+							&cli.StringFlag{
+								Name:    "auth-token",
+								Aliases: []string{"token", "t"},
+								Value:   os.Getenv("AUTH_TOKEN"),
+								Usage:   "Auth token for server requests.",
+							},
+							&cli.IntFlag{
+								Name:    "property_id",
+								Aliases: []string{"pid"},
+								Usage:   "Only reprocess scrapes of this Redfin property ID.",
+							},
+							&cli.IntFlag{
+								Name:    "listing_id",
+								Aliases: []string{"lid"},
+								Usage:   "Only reprocess scrapes of this Redfin listing ID.",
+							},
+							&cli.StringFlag{
+								Name:  "start",
+								Usage: "Only reprocess scrapes at or after this date or RFC3339 timestamp (defaults to 1 year before end).",
+							},
+							&cli.StringFlag{
+								Name:  "end",
+								Usage: "Only reprocess scrapes before this date or RFC3339 timestamp (defaults to now).",
+							},
+							&cli.StringFlag{
+								Name:    "extraction-config",
+								Aliases: []string{"ec"},
+								Value:   os.Getenv("EXTRACTION_CONFIG"),
+								Usage:   "Path to a YAML or JSON field extraction config (defaults to the embedded config).",
+							},
+							&cli.IntFlag{
+								Name:    "log-level",
+								Aliases: []string{"ll", "l"},
+								Usage:   "Logging level for the slog.Logger. Default is 0 (INFO), use -4 for DEBUG.",
+								Value:   0,
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							return reprocess(ctx)
+						},
+					},
 				},
 			},
 			{
@@ -193,56 +376,51 @@ func main() {
 								Value:   os.Getenv("FIREBASE_CONFIG"),
 								Usage:   "Firebase configuration (JSON format).",
 							},
-							&cli.IntFlag{
-								Name:    "log-level",
-								Aliases: []string{"ll", "l"},
-								Usage:   "Logging level for the slog.Logger. Default is 0 (INFO), use -4 for DEBUG.",
-								Value:   0,
+							&cli.StringFlag{
+								Name:    "blob-store",
+								Aliases: []string{"bs"},
+								Value:   "s3",
+								EnvVars: []string{"BLOB_STORE"},
+								Usage:   "Object storage backend: s3, gcs, or local.",
 							},
-						},
-						Action: func(ctx *cli.Context) error {
-							return serve_http(ctx)
-						},
-					},
-					{
-						Name:  "search-worker",
-						Usage: "Run a search scrape worker.",
-						Flags: []cli.Flag{
 							&cli.StringFlag{
-								Name:    "server-endpoint",
-								Aliases: []string{"server", "s"},
-								Value:   os.Getenv("SERVER_ENDPOINT"),
-								Usage:   "Server endpoint.",
+								Name:    "blob-bucket",
+								Aliases: []string{"bucket"},
+								EnvVars: []string{"BLOB_BUCKET", "S3_PROPERTY_BUCKET"},
+								Usage:   "Bucket for the s3 and gcs blob stores.",
 							},
 							&cli.StringFlag{
-								Name:    "auth-token",
-								Aliases: []string{"token", "t"},
-								Value:   os.Getenv("AUTH_TOKEN"),
-								Usage:   "Auth token for server requests.",
+								Name:    "blob-dir",
+								Value:   "blobs",
+								EnvVars: []string{"BLOB_DIR"},
+								Usage:   "Directory for the local blob store.",
 							},
-							&cli.DurationFlag{
-								Name:    "interval",
-								Aliases: []string{"i"},
-								Value:   time.Hour,
-								Usage:   "Minimum interval between running tasks.",
+							&cli.StringFlag{
+								Name:    "blob-base-url",
+								EnvVars: []string{"BLOB_BASE_URL"},
+								Usage:   "Externally reachable URL of this server, used for local blob store URLs (defaults to http://localhost:<listen-port>).",
 							},
-							&cli.DurationFlag{
-								Name:    "claim-tasks-older-than",
-								Aliases: []string{"older", "o"},
-								Value:   24 * time.Hour,
-								Usage:   "Only claim tasks older than this value.",
+							&cli.StringFlag{
+								Name:    "signing-key-dir",
+								EnvVars: []string{"SIGNING_KEY_DIR"},
+								Usage:   "Directory of PEM encoded token signing keys named <key id>.pem (defaults to HS256 tokens signed with SERVER_SECRET_KEY).",
 							},
-							&cli.DurationFlag{
-								Name:    "property-query-delay",
-								Aliases: []string{"pqd", "d"},
-								Value:   500 * time.Millisecond,
-								Usage:   "Delay between search result property queries.",
+							&cli.StringFlag{
+								Name:    "signing-key-id",
+								EnvVars: []string{"SIGNING_KEY_ID"},
+								Usage:   "ID of the key that signs new tokens (needed if signing-key-dir has more than one private key).",
 							},
 							&cli.StringFlag{
-								Name:    "user-agent",
-								Aliases: []string{"ua", "u"},
-								Value:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36",
-								Usage:   "Redfin client User-Agent",
+								Name:    "rate-limits",
+								Value:   "default=600/1m,worker=6000/1m,admin=0",
+								EnvVars: []string{"RATE_LIMITS"},
+								Usage:   "Rate limit quotas by role as role=limit/window (comma separated); default applies to other roles and 0 means unlimited.",
+							},
+							&cli.DurationFlag{
+								Name:    "market-stats-refresh-interval",
+								Aliases: []string{"msri"},
+								Value:   time.Hour,
+								Usage:   "Interval between market stats refreshes (0 disables the refresh).",
 							},
 							&cli.IntFlag{
 								Name:    "log-level",
@@ -252,12 +430,13 @@ func main() {
 							},
 						},
 						Action: func(ctx *cli.Context) error {
-							return run_search_worker(ctx)
+							return serve_http(ctx)
 						},
 					},
 					{
-						Name:  "property-worker",
-						Usage: "Run a property scrape worker.",
+						Name:        "worker",
+						Usage:       "Run one or more kinds of worker.",
+						Description: workerKindsDescription(),
 						Flags: []cli.Flag{
 							&cli.StringFlag{
 								Name:    "server-endpoint",
@@ -271,23 +450,15 @@ func main() {
 								Value:   os.Getenv("AUTH_TOKEN"),
 								Usage:   "Auth token for server requests.",
 							},
-							&cli.DurationFlag{
-								Name:    "interval",
-								Aliases: []string{"i"},
-								Value:   time.Second,
-								Usage:   "Minimum interval between running tasks.",
-							},
-							&cli.DurationFlag{
-								Name:    "claim-tasks-older-than",
-								Aliases: []string{"older", "o"},
-								Value:   7 * 24 * time.Hour,
-								Usage:   "Only claim tasks older than this value.",
+							&cli.StringSliceFlag{
+								Name:     "kind",
+								Aliases:  []string{"k"},
+								Required: true,
+								Usage:    "Kind of worker to run (repeatable).",
 							},
-							&cli.StringFlag{
-								Name:    "user-agent",
-								Aliases: []string{"ua", "u"},
-								Value:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36",
-								Usage:   "Redfin client User-Agent",
+							&cli.StringSliceFlag{
+								Name:  "set",
+								Usage: "Worker option as option=value or kind.option=value (repeatable).",
 							},
 							&cli.IntFlag{
 								Name:    "log-level",
@@ -297,7 +468,7 @@ func main() {
 							},
 						},
 						Action: func(ctx *cli.Context) error {
-							return run_property_scrape_worker(ctx)
+							return run_workers(ctx)
 						},
 					},
 				},
@@ -315,12 +486,12 @@ func serve_http(ctx *cli.Context) error {
 	logger := getDefaultLogger(slog.Level(ctx.Int("log-level")))
 	redfinClient := redfin.NewClient("https://www.redfin.com/stingray/", ctx.String("user-agent"), nil)
 
-	// aws init
-	awsCFG, err := config.LoadDefaultConfig(ctx.Context)
+	// blob store init
+	bs, err := getBlobStore(ctx)
 	if err != nil {
 		return err
 	}
-	s3Client := s3.NewFromConfig(awsCFG)
+	logger.Info("using blob store", "blob_store", ctx.String("blob-store"))
 
 	// firebase init
 	fbapp, err := firebase.NewApp(ctx.Context, nil, option.WithCredentialsJSON([]byte(ctx.String("firebase-config"))))
@@ -332,59 +503,68 @@ func serve_http(ctx *cli.Context) error {
 		return fmt.Errorf("error initializing firebase auth client: %w", err)
 	}
 
+	// token signing keys init
+	kr, err := getKeyRing(ctx)
+	if err != nil {
+		return err
+	}
+
+	// rate limit init
+	quotas, err := server.ParseRateLimitQuotas(ctx.String("rate-limits"))
+	if err != nil {
+		return err
+	}
+	rls := server.NewRateLimits(server.NewMemoryRateLimiter(), quotas)
+
 	return server.RunHTTPServer(
 		ctx.Context,
 		ctx.String("listen-port"),
 		logger,
 		ctx.String("db-host"),
 		redfinClient,
-		s3Client,
+		bs,
 		fbc,
+		kr,
+		rls,
+		ctx.Duration("market-stats-refresh-interval"),
 	)
 }
 
-func run_search_worker(ctx *cli.Context) error {
+func run_workers(ctx *cli.Context) error {
 	logger := getDefaultLogger(slog.Level(ctx.Int("log-level")))
-	hc, err := getDefaultHTTPClient()
-	if err != nil {
-		return err
-	}
-	redfinClient := redfin.NewClient("https://www.redfin.com/stingray/", ctx.String("user-agent"), hc)
-	pqd, err := time.ParseDuration(ctx.String("property-query-delay"))
-	if err != nil {
-		log.Fatal(err)
-	}
-	worker.RunWorkerFunc(
-		ctx.Context,
+	// stop on SIGTERM so in-flight tasks can drain when the pod is shut down
+	sigCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := worker.RunWorkers(
+		sigCtx,
 		logger,
-		ctx.Duration("interval"),
-		worker.MakeSearchWorkerFunc(
-			ctx.String("server-endpoint"),
-			ctx.String("auth-token"),
-			redfinClient,
-			pqd,
-		),
+		ctx.String("server-endpoint"),
+		ctx.String("auth-token"),
+		ctx.StringSlice("kind"),
+		ctx.StringSlice("set"),
 	)
-	return nil
+	if errors.Is(err, context.Canceled) && ctx.Context.Err() == nil {
+		logger.Info("workers shut down")
+		return nil
+	}
+	return err
 }
 
-func run_property_scrape_worker(ctx *cli.Context) error {
-	logger := getDefaultLogger(slog.Level(ctx.Int("log-level")))
-	hc, err := getDefaultHTTPClient()
+func issue_token(ctx *cli.Context) error {
+	kr, err := getKeyRing(ctx)
 	if err != nil {
 		return err
 	}
-	redfinClient := redfin.NewClient("https://www.redfin.com/stingray/", ctx.String("user-agent"), hc)
-	worker.RunWorkerFunc(
-		ctx.Context,
-		logger,
-		ctx.Duration("interval"),
-		worker.MakePropertyWorkerFunc(
-			ctx.String("server-endpoint"),
-			ctx.String("auth-token"),
-			redfinClient,
-		),
+	token, err := kr.IssueToken(
+		ctx.String("email"),
+		ctx.String("role"),
+		ctx.StringSlice("scope"),
+		ctx.Duration("ttl"),
 	)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
 	return nil
 }
 
@@ -412,6 +592,39 @@ func add_property_query(ctx *cli.Context) error {
 	)
 }
 
+func validate_extraction_config(ctx *cli.Context) error {
+	logger := getDefaultLogger(slog.Level(ctx.Int("log-level")))
+	return ValidateExtractionConfig(
+		logger,
+		ctx.String("extraction-config"),
+		ctx.String("payload-dir"),
+	)
+}
+
+func reprocess(ctx *cli.Context) error {
+	logger := getDefaultLogger(slog.Level(ctx.Int("log-level")))
+	ec, err := worker.LoadExtractionConfig(ctx.String("extraction-config"))
+	if err != nil {
+		return err
+	}
+	logger.Info("loaded extraction config", "version", ec.Version, "revision", ec.Revision)
+	ts, te, err := server.ParseTimeRange(ctx.String("start"), ctx.String("end"))
+	if err != nil {
+		return err
+	}
+	return worker.ReprocessArchivedPayloads(
+		ctx.Context,
+		logger,
+		ctx.String("server-endpoint"),
+		ctx.String("auth-token"),
+		ec,
+		int32(ctx.Int("property_id")),
+		int32(ctx.Int("listing_id")),
+		ts,
+		te,
+	)
+}
+
 func test_search_query(ctx *cli.Context) error {
 	logger := getDefaultLogger(slog.Level(ctx.Int("log-level")))
 	hc, err := getDefaultHTTPClient()
@@ -33,7 +33,7 @@ func AddProperty(ctx context.Context, l *slog.Logger, endpoint, authToken, prope
 	}
 	req, err := http.NewRequest(
 		http.MethodPost,
-		fmt.Sprintf("%s/property", endpoint),
+		fmt.Sprintf("%s/v1/property", endpoint),
 		bytes.NewReader(b),
 	)
 	if err != nil {
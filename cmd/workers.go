@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/brojonat/gredfin/provider"
+	"github.com/brojonat/gredfin/redfin"
+	"github.com/brojonat/gredfin/worker"
+)
+
+// Custom worker kinds register themselves with worker.RegisterWorker from an
+// init function; blank import their packages here to include them in the CLI.
+
+const defaultUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36"
+
+// Options shared by the workers that scrape a provider.
+var providerWorkerOptions = []worker.WorkerOption{
+	{Name: "provider", Type: worker.OptionString, Default: provider.Redfin, Usage: "Listing provider to scrape."},
+	{Name: "extraction-config", Type: worker.OptionString, EnvVar: "EXTRACTION_CONFIG", Usage: "Path to a YAML or JSON field extraction config (defaults to the embedded config)."},
+	{Name: "user-agent", Type: worker.OptionString, Default: defaultUserAgent, EnvVar: "REDFIN_USER_AGENT", Usage: "Redfin client User-Agent."},
+}
+
+// Returns the provider configured by the providerWorkerOptions.
+func getWorkerProvider(l *slog.Logger, c *worker.WorkerConfig) (provider.Provider, error) {
+	hc, err := getDefaultHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	redfinClient := redfin.NewClient("https://www.redfin.com/stingray/", c.String("user-agent"), hc)
+	ec, err := worker.LoadExtractionConfig(c.String("extraction-config"))
+	if err != nil {
+		return nil, err
+	}
+	l.Info("loaded extraction config", "version", ec.Version, "revision", ec.Revision)
+	return getProvider(c.String("provider"), l, redfinClient, ec)
+}
+
+func init() {
+	worker.RegisterWorker(worker.WorkerKind{
+		Name:     "search",
+		Usage:    "Runs search queries and uploads the listings they return.",
+		Interval: time.Hour,
+		Options: append([]worker.WorkerOption{
+			{Name: "property-query-delay", Type: worker.OptionDuration, Default: "500ms", Usage: "Delay between search result property queries."},
+		}, providerWorkerOptions...),
+		Factory: func(l *slog.Logger, c *worker.WorkerConfig) (func(context.Context, *slog.Logger), error) {
+			prov, err := getWorkerProvider(l, c)
+			if err != nil {
+				return nil, err
+			}
+			return worker.MakeSearchWorkerFunc(c.Endpoint, c.AuthToken, prov, c.Duration("property-query-delay")), nil
+		},
+	})
+	worker.RegisterWorker(worker.WorkerKind{
+		Name:     "property",
+		Usage:    "Scrapes the details of property listings.",
+		Interval: time.Second,
+		Options:  providerWorkerOptions,
+		Factory: func(l *slog.Logger, c *worker.WorkerConfig) (func(context.Context, *slog.Logger), error) {
+			prov, err := getWorkerProvider(l, c)
+			if err != nil {
+				return nil, err
+			}
+			return worker.MakePropertyWorkerFunc(c.Endpoint, c.AuthToken, prov), nil
+		},
+	})
+	worker.RegisterWorker(worker.WorkerKind{
+		Name:     "image",
+		Usage:    "Mirrors listing images into the object store.",
+		Interval: time.Second,
+		Factory: func(l *slog.Logger, c *worker.WorkerConfig) (func(context.Context, *slog.Logger), error) {
+			return worker.MakeImageMirrorWorkerFunc(c.Endpoint, c.AuthToken), nil
+		},
+	})
+}
+
+// Describes the registered worker kinds and their options for the help text.
+func workerKindsDescription() string {
+	var b strings.Builder
+	b.WriteString("Runs the supplied kinds of worker in one process. Options are set with\n")
+	b.WriteString("--set option=value (applies to every kind with that option) or\n")
+	b.WriteString("--set kind.option=value. Available kinds:\n")
+	for _, k := range worker.WorkerKinds() {
+		fmt.Fprintf(&b, "\n%s: %s\n", k.Name, k.Usage)
+		for _, o := range k.Schema() {
+			fmt.Fprintf(&b, "  %s (%s", o.Name, o.Type)
+			if o.Default != "" {
+				fmt.Fprintf(&b, ", default %s", o.Default)
+			}
+			if o.EnvVar != "" {
+				fmt.Fprintf(&b, ", env %s", o.EnvVar)
+			}
+			fmt.Fprintf(&b, "): %s\n", o.Usage)
+		}
+	}
+	return b.String()
+}
@@ -108,3 +108,7 @@ UPDATE property SET last_scrape_status = 'good' WHERE property_id  = 123;
 
 
 
+
+-- Refresh the market stats views (the server also does this periodically).
+REFRESH MATERIALIZED VIEW CONCURRENTLY listing_cycle;
+REFRESH MATERIALIZED VIEW CONCURRENTLY market_stats_monthly;
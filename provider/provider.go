@@ -0,0 +1,146 @@
+// Package provider defines a source agnostic interface for listing data. The
+// workers are written against these interfaces so that adding another listing
+// source doesn't require forking them; a source just needs an adapter that
+// implements Provider (and optionally any of the capability interfaces).
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Name of the Redfin provider. This is also the default provider for records
+// created before providers were introduced.
+const Redfin = "redfin"
+
+// RedfinExternalID returns the external ID of a Redfin listing, which is
+// derived from its property and listing ids.
+func RedfinExternalID(pid, lid int32) string {
+	return fmt.Sprintf("%d:%d", pid, lid)
+}
+
+// Provider is a listing source that supports both discovery and detail.
+type Provider interface {
+	// Name returns the unique name of the provider (e.g., "redfin"). This is
+	// stored alongside each record the provider produces.
+	Name() string
+	Discoverer
+	Detailer
+}
+
+// Discoverer finds listings for a search query.
+type Discoverer interface {
+	// Search returns references to the listings matching the query. The
+	// references may be incomplete (e.g., only a URL); callers pass each one
+	// to Resolve to get a record that can be stored.
+	Search(ctx context.Context, query string) ([]ListingRef, error)
+	// Resolve fills in the identifiers and location of a listing.
+	Resolve(ctx context.Context, ref ListingRef) (*Listing, error)
+}
+
+// Detailer fetches the attributes, events, and agents for a listing. If the
+// raw payloads were fetched but the details couldn't be extracted from them,
+// implementations return the error along with details that only have Payloads
+// set, so callers can still inspect or archive them.
+type Detailer interface {
+	Details(ctx context.Context, ref ListingRef) (*ListingDetails, error)
+}
+
+// EstimateHistorian is implemented by providers that can supply a history of
+// value estimates for a listing.
+type EstimateHistorian interface {
+	EstimateHistory(ctx context.Context, ref ListingRef) ([]Estimate, error)
+}
+
+// RentEstimator is implemented by providers that can estimate monthly rent.
+// Implementations return a nil Estimate if no estimate is available.
+type RentEstimator interface {
+	RentEstimate(ctx context.Context, ref ListingRef) (*Estimate, error)
+}
+
+// SimilarSoldFinder is implemented by providers that have their own notion of
+// similar recently sold homes.
+type SimilarSoldFinder interface {
+	SimilarSold(ctx context.Context, ref ListingRef) ([]SimilarHome, error)
+}
+
+// ListingRef identifies a listing. Records are keyed by provider plus
+// external ID. Providers with native integer identifiers (i.e., Redfin) also
+// set PropertyID and ListingID; for other providers the server assigns them.
+type ListingRef struct {
+	Provider   string `json:"provider"`
+	ExternalID string `json:"external_id"`
+	URL        string `json:"url"`
+	PropertyID int32  `json:"property_id"`
+	ListingID  int32  `json:"listing_id"`
+}
+
+// Listing is the minimal record needed to store a discovered listing.
+type Listing struct {
+	ListingRef
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// ListingDetails is everything a provider knows about a listing. Payloads
+// holds the raw responses the details were extracted from, keyed by a file
+// basename (e.g., "mls_info.json"), so callers can archive them.
+type ListingDetails struct {
+	Zipcode       string
+	City          string
+	State         string
+	Attributes    Attributes
+	Events        []Event
+	Agents        []Agent
+	ImageURLs     []string
+	ThumbnailURLs []string
+	Estimate      *Estimate
+	Payloads      map[string][]byte
+}
+
+// Attributes of a listing. Nil values are unknown.
+type Attributes struct {
+	Beds         *float64
+	Baths        *float64
+	Sqft         *int32
+	LotSqft      *int32
+	YearBuilt    *int32
+	PropertyType *string
+}
+
+// Event is an entry in a listing's history (e.g., Listed, Sold).
+type Event struct {
+	Price       int32
+	Description string
+	Source      string
+	SourceID    string
+	TS          time.Time
+}
+
+// Agent is a realtor associated with a listing.
+type Agent struct {
+	Name    string
+	Company string
+}
+
+// Estimate is a point estimate of value (or rent) with an optional range.
+type Estimate struct {
+	TS    time.Time
+	Value int32
+	Low   *int32
+	High  *int32
+}
+
+// SimilarHome is a recently sold home the provider considers similar to a
+// listing. Optional attributes are nil when unknown.
+type SimilarHome struct {
+	ListingRef
+	Price     int32
+	SaleTS    *time.Time
+	Beds      *float64
+	Baths     *float64
+	Sqft      *int32
+	Latitude  float64
+	Longitude float64
+}
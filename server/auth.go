@@ -1,25 +1,135 @@
 package server
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
 	"net/http"
-	"os"
+	"slices"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
-func getSecretKey() string {
-	return os.Getenv("SERVER_SECRET_KEY")
+// Roles. A token's role grants it a fixed set of scopes.
+const RoleAdmin = "admin"
+const RoleWorker = "worker"
+const RoleReader = "reader"
+
+// Scopes. Each route requires at least one of a set of scopes.
+const ScopeRead = "read"     // GET routes
+const ScopeWrite = "write"   // routes that create, update, or delete records
+const ScopeJobs = "jobs"     // routes workers use to claim jobs and report results
+const ScopeScrape = "scrape" // routes workers use to read and write the data they scrape
+const ScopeAdmin = "admin"   // /admin routes
+
+var roleScopes = map[string][]string{
+	RoleAdmin:  {ScopeRead, ScopeWrite, ScopeJobs, ScopeScrape, ScopeAdmin},
+	RoleWorker: {ScopeJobs, ScopeScrape},
+	RoleReader: {ScopeRead},
 }
 
+var allScopes = []string{ScopeRead, ScopeWrite, ScopeJobs, ScopeScrape, ScopeAdmin}
+
+// Tokens are issued for this long unless a TTL is supplied, and never for
+// longer than maxTokenTTL.
+const defaultTokenTTL = 24 * time.Hour
+const maxTokenTTL = 90 * 24 * time.Hour
+
 type authJWTClaims struct {
 	jwt.StandardClaims
-	Email string `json:"email"`
+	Email  string   `json:"email"`
+	Role   string   `json:"role,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// Returns the principal the claims identify. Tokens issued before the subject
+// was set are identified by their email.
+func (c authJWTClaims) principal() Principal {
+	uid := c.Subject
+	if uid == "" {
+		uid = c.Email
+	}
+	scopes := c.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return Principal{
+		UserID:   uid,
+		Email:    c.Email,
+		Provider: ProviderToken,
+		Roles:    rolesOf(c.Role),
+		Scopes:   scopes,
+	}
+}
+
+// Checks that the role and scopes exist and that together they grant something.
+func validateRoleScopes(role string, scopes []string) error {
+	if role == "" && len(scopes) == 0 {
+		return fmt.Errorf("must supply a role or at least one scope")
+	}
+	if _, ok := roleScopes[role]; role != "" && !ok {
+		return fmt.Errorf("unknown role: %s", role)
+	}
+	for _, s := range scopes {
+		if !slices.Contains(allScopes, s) {
+			return fmt.Errorf("unknown scope: %s", s)
+		}
+	}
+	return nil
+}
+
+// IssueToken returns a token for the supplied email that grants the scopes of
+// the role plus any additional scopes, and expires after ttl. A zero ttl uses
+// the default. The token is signed with the ring's active key.
+func (kr *KeyRing) IssueToken(email, role string, scopes []string, ttl time.Duration) (string, error) {
+	if email == "" {
+		return "", fmt.Errorf("must supply email")
+	}
+	if err := validateRoleScopes(role, scopes); err != nil {
+		return "", err
+	}
+	if ttl == 0 {
+		ttl = defaultTokenTTL
+	}
+	if ttl < 0 || ttl > maxTokenTTL {
+		return "", fmt.Errorf("ttl must be positive and at most %s", maxTokenTTL)
+	}
+	now := time.Now()
+	c := authJWTClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   email,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+		Email:  email,
+		Role:   role,
+		Scopes: scopes,
+	}
+	return kr.sign(c)
+}
+
+// API keys are this prefix followed by random bytes encoded as base64. The first
+// apiKeyPrefixLen characters are stored in the clear to identify the key.
+const apiKeyPrefix = "gk_"
+const apiKeyBytes = 32
+const apiKeyPrefixLen = 11
+
+// Returns a new API key along with its prefix and hash.
+func generateAPIKey() (string, string, []byte, error) {
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", nil, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:apiKeyPrefixLen], hashAPIKey(key), nil
 }
 
-func generateAccessToken(claims authJWTClaims) (string, error) {
-	t := jwt.New(jwt.SigningMethodHS256)
-	t.Claims = claims
-	return t.SignedString([]byte(getSecretKey()))
+// Keys are random, so a plain SHA-256 is enough to keep them safe at rest.
+func hashAPIKey(key string) []byte {
+	h := sha256.Sum256([]byte(key))
+	return h[:]
 }
 
 // Return the default headers to use to make queries against the server.
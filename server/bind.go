@@ -0,0 +1,319 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Requests are bound to structs whose fields declare where their values come
+// from and how they're validated:
+//
+//	type compsParams struct {
+//		PropertyID int32   `query:"property_id" validate:"required"`
+//		Radius     float64 `query:"radius" validate:"gt=0"`
+//		Kind       string  `path:"kind" validate:"oneof=search property"`
+//	}
+//
+// Fields tagged with query take the named query param (repeated params fill
+// slices) and fields tagged with path take the named path wildcard. Params
+// that aren't supplied leave the field alone, so defaults can be set on the
+// struct before binding. Fields of JSON bodies are named by their json tags.
+//
+// The validate tag is a comma separated list of rules:
+//
+//	required   the param must be supplied (bodies: the field must be non-zero)
+//	min=N      numbers must be at least N; strings and slices at least N long
+//	max=N      numbers must be at most N; strings and slices at most N long
+//	gt=N       numbers must be greater than N
+//	oneof=a b  the value must be one of the space separated values
+//	sha256     strings must be hex encoded SHA-256 hashes
+//
+// Every invalid field is reported in a single ValidationError.
+
+// Binds the query params and path wildcards of the request to dst, which must
+// be a pointer to a struct, and validates it.
+func bindRequest(r *http.Request, dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("server: bindRequest requires a pointer to a struct, got %T", dst))
+	}
+	var ve ValidationError
+	bindFields(r, v.Elem(), &ve)
+	return ve.err()
+}
+
+func bindFields(r *http.Request, v reflect.Value, ve *ValidationError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+		if sf.Anonymous && fv.Kind() == reflect.Struct {
+			bindFields(r, fv, ve)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		var name string
+		var raw []string
+		if n, ok := sf.Tag.Lookup("query"); ok {
+			name = n
+			raw = r.URL.Query()[n]
+		} else if n, ok := sf.Tag.Lookup("path"); ok {
+			name = n
+			if s := r.PathValue(n); s != "" {
+				raw = []string{s}
+			}
+		} else {
+			continue
+		}
+		rules := parseRules(sf.Tag.Get("validate"))
+		supplied := len(raw) > 0 && (len(raw) > 1 || raw[0] != "")
+		if !supplied {
+			if _, ok := rules["required"]; ok {
+				ve.add(name, FieldCodeRequired, "must be set")
+			}
+			continue
+		}
+		if err := setField(fv, raw); err != nil {
+			ve.add(name, FieldCodeInvalid, fmt.Sprintf("bad value for %s", name))
+			continue
+		}
+		checkRules(ve, name, fv, rules)
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+var timeType = reflect.TypeOf(time.Time{})
+
+// Parses the raw values into the field. Slices take every value; other types
+// take the last one.
+func setField(fv reflect.Value, raw []string) error {
+	if fv.Kind() == reflect.Slice {
+		s := reflect.MakeSlice(fv.Type(), len(raw), len(raw))
+		for i, r := range raw {
+			if err := setScalar(s.Index(i), r); err != nil {
+				return err
+			}
+		}
+		fv.Set(s)
+		return nil
+	}
+	if fv.Kind() == reflect.Pointer {
+		p := reflect.New(fv.Type().Elem())
+		if err := setScalar(p.Elem(), raw[len(raw)-1]); err != nil {
+			return err
+		}
+		fv.Set(p)
+		return nil
+	}
+	return setScalar(fv, raw[len(raw)-1])
+}
+
+func setScalar(fv reflect.Value, s string) error {
+	switch {
+	case fv.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	case fv.Type() == timeType:
+		t, err := parseQueryTime(s)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	default:
+		panic(fmt.Sprintf("server: can't bind params to fields of type %s", fv.Type()))
+	}
+	return nil
+}
+
+// Times in query params are dates (midnight UTC) or RFC 3339 timestamps.
+func parseQueryTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func parseRules(tag string) map[string]string {
+	rules := map[string]string{}
+	for _, r := range strings.Split(tag, ",") {
+		if r == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(r, "=")
+		rules[k] = v
+	}
+	return rules
+}
+
+// Adds an error to ve for the first rule the value breaks.
+func checkRules(ve *ValidationError, name string, fv reflect.Value, rules map[string]string) {
+	if fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			return
+		}
+		fv = fv.Elem()
+	}
+	for _, k := range []string{"min", "max", "gt", "oneof", "sha256"} {
+		arg, ok := rules[k]
+		if !ok {
+			continue
+		}
+		if msg := checkRule(fv, k, arg); msg != "" {
+			ve.add(name, FieldCodeInvalid, msg)
+			return
+		}
+	}
+}
+
+// Returns why the value breaks the rule, or "" if it doesn't.
+func checkRule(fv reflect.Value, rule, arg string) string {
+	switch rule {
+	case "min", "max", "gt":
+		n, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("server: bad %s rule %q", rule, arg))
+		}
+		x, what, ok := ruleMagnitude(fv)
+		if !ok {
+			return ""
+		}
+		switch {
+		case rule == "min" && x < n:
+			return fmt.Sprintf("must be %sat least %s", what, arg)
+		case rule == "max" && x > n:
+			return fmt.Sprintf("must be %sat most %s", what, arg)
+		case rule == "gt" && x <= n:
+			return fmt.Sprintf("must be greater than %s", arg)
+		}
+	case "oneof":
+		opts := strings.Fields(arg)
+		if fv.Kind() == reflect.Slice {
+			for i := 0; i < fv.Len(); i++ {
+				if !slices.Contains(opts, fmt.Sprint(fv.Index(i).Interface())) {
+					return fmt.Sprintf("must be one of: %s", strings.Join(opts, ", "))
+				}
+			}
+			return ""
+		}
+		if !slices.Contains(opts, fmt.Sprint(fv.Interface())) {
+			return fmt.Sprintf("must be one of: %s", strings.Join(opts, ", "))
+		}
+	case "sha256":
+		if fv.Kind() == reflect.String && !isSHA256Hex(fv.String()) {
+			return "must be a hex encoded SHA-256 hash"
+		}
+	}
+	return ""
+}
+
+// Returns the number that min and max rules compare against: the value of
+// numbers and the length of strings and slices.
+func ruleMagnitude(fv reflect.Value) (float64, string, bool) {
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if fv.Type() == durationType {
+			return float64(fv.Int()) / float64(time.Second), "", true
+		}
+		return float64(fv.Int()), "", true
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), "", true
+	case reflect.String, reflect.Slice, reflect.Map:
+		return float64(fv.Len()), "of length ", true
+	default:
+		return 0, "", false
+	}
+}
+
+// Validates the decoded JSON body dst (a pointer to a struct, or a slice of
+// structs) against the validate tags of its fields, including those of nested
+// structs. Fields are named by their path of json tags, with elements of
+// slices named by their index (e.g., "[2].listing_id" or "changes[0].path").
+func validateBody(dst any) error {
+	var ve ValidationError
+	validateValue(reflect.ValueOf(dst), "", &ve)
+	return ve.err()
+}
+
+func validateValue(v reflect.Value, path string, ve *ValidationError) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Struct && v.Type().Elem().Kind() != reflect.Pointer {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), ve)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			fv := v.Field(i)
+			if sf.Anonymous && fv.Kind() == reflect.Struct {
+				validateValue(fv, path, ve)
+				continue
+			}
+			if !sf.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = sf.Name
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			rules := parseRules(sf.Tag.Get("validate"))
+			if fv.IsZero() {
+				if _, ok := rules["required"]; ok {
+					ve.add(name, FieldCodeRequired, "must be set")
+				}
+				continue
+			}
+			checkRules(ve, name, fv, rules)
+			validateValue(fv, name, ve)
+		}
+	}
+}
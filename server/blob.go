@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Supported blob store backends.
+const BlobStoreS3 = "s3"
+const BlobStoreGCS = "gcs"
+const BlobStoreLocal = "local"
+
+// How long presigned upload and download URLs are valid.
+const blobPresignExpiry = 10 * time.Minute
+
+// Returned by BlobStore.Get when there's no object at the key.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore is the object storage the server hands out upload URLs for and
+// reads archived objects from. Workers never talk to the store directly; they
+// upload to presigned URLs so they don't need any credentials or bucket
+// details.
+type BlobStore interface {
+	// Returns a URL the caller can PUT the object bytes to. The URL expires
+	// after the supplied duration.
+	PresignPut(ctx context.Context, key string, expires time.Duration) (string, error)
+	// Returns a URL the caller can GET the object from. The URL expires after
+	// the supplied duration.
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	// Returns a reader for the object at key. Callers must close the reader.
+	// Returns ErrBlobNotFound if there's no object at key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Reports whether there's an object at key, without reading it.
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+// Reads the whole object at key.
+func readBlob(ctx context.Context, bs BlobStore, key string) ([]byte, error) {
+	rc, err := bs.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// Returns the content addressed object key for a payload with the supplied
+// SHA-256 hash. Keys are sharded by the first byte of the hash.
+func getPayloadKey(hash string) string {
+	return fmt.Sprintf("payload/sha256/%s/%s.json", hash[:2], hash)
+}
+
+// Returns the content addressed object key for an image with the supplied
+// SHA-256 hash.
+func getImageKey(hash string) string {
+	return fmt.Sprintf("image/sha256/%s/%s", hash[:2], hash)
+}
+
+// Reports whether s is a hex encoded SHA-256 hash.
+func isSHA256Hex(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalBlobStore stores objects on the local filesystem under dir. The server
+// itself serves the upload and download URLs at /blob/{key}; the URLs are
+// signed with an HMAC of the method, key, and expiry so they can be handed
+// out like presigned S3 URLs. This is meant for local development and tests.
+type LocalBlobStore struct {
+	dir     string
+	baseURL string
+	secret  []byte
+}
+
+// The baseURL is the externally reachable address of this server (e.g.,
+// http://localhost:8080); signed URLs are relative to it.
+func NewLocalBlobStore(dir, baseURL, secret string) (*LocalBlobStore, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("local blob store requires a signing secret")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating blob directory: %w", err)
+	}
+	return &LocalBlobStore{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  []byte(secret),
+	}, nil
+}
+
+func (bs *LocalBlobStore) PresignPut(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return bs.signURL(http.MethodPut, key, time.Now().Add(expires))
+}
+
+func (bs *LocalBlobStore) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return bs.signURL(http.MethodGet, key, time.Now().Add(expires))
+}
+
+func (bs *LocalBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := bs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (bs *LocalBlobStore) Exists(ctx context.Context, key string) (bool, error) {
+	path, err := bs.path(key)
+	if err != nil {
+		return false, err
+	}
+	if _, err = os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Writes the object at key. The bytes are written to a temporary file first
+// so readers never see a partial object.
+func (bs *LocalBlobStore) Put(key string, r io.Reader) error {
+	path, err := bs.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (bs *LocalBlobStore) signURL(method, key string, expires time.Time) (string, error) {
+	if _, err := bs.path(key); err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("signature", bs.sign(method, key, expires.Unix()))
+	return fmt.Sprintf("%s/blob/%s?%s", bs.baseURL, key, q.Encode()), nil
+}
+
+func (bs *LocalBlobStore) sign(method, key string, expires int64) string {
+	mac := hmac.New(sha256.New, bs.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%d", method, key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks the signature and expiry of a request to a signed URL.
+func (bs *LocalBlobStore) verify(r *http.Request, key string) error {
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad value for expires")
+	}
+	if time.Now().Unix() > expires {
+		return fmt.Errorf("signed url expired")
+	}
+	sig, err := hex.DecodeString(r.URL.Query().Get("signature"))
+	if err != nil {
+		return fmt.Errorf("bad value for signature")
+	}
+	expected, _ := hex.DecodeString(bs.sign(r.Method, key, expires))
+	if !hmac.Equal(sig, expected) {
+		return fmt.Errorf("bad signature")
+	}
+	return nil
+}
+
+// Returns the filesystem path for key, rejecting keys that would escape dir.
+func (bs *LocalBlobStore) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || filepath.Clean(key) != filepath.FromSlash(key) {
+		return "", fmt.Errorf("bad blob key: %s", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." || strings.HasPrefix(part, ".upload-") {
+			return "", fmt.Errorf("bad blob key: %s", key)
+		}
+	}
+	return filepath.Join(bs.dir, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3BlobStore stores objects in an S3 bucket.
+type S3BlobStore struct {
+	c      *s3.Client
+	bucket string
+}
+
+func NewS3BlobStore(c *s3.Client, bucket string) *S3BlobStore {
+	return &S3BlobStore{c: c, bucket: bucket}
+}
+
+func (bs *S3BlobStore) PresignPut(ctx context.Context, key string, expires time.Duration) (string, error) {
+	ps := s3.NewPresignClient(bs.c)
+	req, err := ps.PresignPutObject(
+		ctx,
+		&s3.PutObjectInput{
+			Bucket: aws.String(bs.bucket),
+			Key:    aws.String(key),
+		},
+		func(opts *s3.PresignOptions) {
+			opts.Expires = expires
+		})
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (bs *S3BlobStore) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	ps := s3.NewPresignClient(bs.c)
+	req, err := ps.PresignGetObject(
+		ctx,
+		&s3.GetObjectInput{
+			Bucket: aws.String(bs.bucket),
+			Key:    aws.String(key),
+		},
+		func(opts *s3.PresignOptions) {
+			opts.Expires = expires
+		})
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (bs *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := bs.c.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bs.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+	return obj.Body, nil
+}
+
+func (bs *S3BlobStore) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := bs.c.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bs.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nf *types.NotFound
+		if errors.As(err, &nf) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -5,11 +5,93 @@
 package dbgen
 
 import (
+	"encoding/json"
+
 	jsonb "github.com/brojonat/gredfin/server/db/jsonb"
 	"github.com/jackc/pgx/v5/pgtype"
 	geom "github.com/twpayne/go-geom"
 )
 
+type APIKey struct {
+	KeyID      int64            `json:"key_id"`
+	Name       string           `json:"name"`
+	Owner      string           `json:"owner"`
+	Prefix     string           `json:"prefix"`
+	KeyHash    []byte           `json:"-"`
+	Role       string           `json:"role"`
+	Scopes     []string         `json:"scopes"`
+	ExpiresTS  pgtype.Timestamp `json:"expires_ts"`
+	LastUsedTS pgtype.Timestamp `json:"last_used_ts"`
+	RevokedTS  pgtype.Timestamp `json:"revoked_ts"`
+	CreatedTS  pgtype.Timestamp `json:"created_ts"`
+}
+
+type AVMAccuracy struct {
+	PropertyID     int32            `json:"property_id"`
+	ListingID      int32            `json:"listing_id"`
+	Zipcode        pgtype.Text      `json:"zipcode"`
+	ListTS         pgtype.Timestamp `json:"list_ts"`
+	ListPrice      int32            `json:"list_price"`
+	SaleTS         pgtype.Timestamp `json:"sale_ts"`
+	SalePrice      pgtype.Int4      `json:"sale_price"`
+	EstimateTS     pgtype.Timestamp `json:"estimate_ts"`
+	Estimate       int32            `json:"estimate"`
+	EstimateError  pgtype.Float8    `json:"estimate_error"`
+	ListToEstimate pgtype.Float8    `json:"list_to_estimate"`
+	SaleToList     pgtype.Float8    `json:"sale_to_list"`
+}
+
+type AVMEstimate struct {
+	PropertyID int32            `json:"property_id"`
+	ListingID  int32            `json:"listing_id"`
+	EstimateTS pgtype.Timestamp `json:"estimate_ts"`
+	Value      int32            `json:"value"`
+	ValueLow   pgtype.Int4      `json:"value_low"`
+	ValueHigh  pgtype.Int4      `json:"value_high"`
+	Source     string           `json:"source"`
+}
+
+type AuditLog struct {
+	AuditID    int64            `json:"audit_id"`
+	KeyID      pgtype.Int8      `json:"key_id"`
+	Principal  string           `json:"principal"`
+	Method     string           `json:"method"`
+	Path       string           `json:"path"`
+	Status     int32            `json:"status"`
+	RemoteAddr string           `json:"remote_addr"`
+	CreatedTS  pgtype.Timestamp `json:"created_ts"`
+}
+
+type Image struct {
+	Hash        string           `json:"hash"`
+	ContentType string           `json:"content_type"`
+	SizeBytes   int32            `json:"size_bytes"`
+	Width       int32            `json:"width"`
+	Height      int32            `json:"height"`
+	AHash       int64            `json:"ahash"`
+	DHash       int64            `json:"dhash"`
+	CreatedTS   pgtype.Timestamp `json:"created_ts"`
+}
+
+type Job struct {
+	JobID          int64            `json:"job_id"`
+	Kind           string           `json:"kind"`
+	DedupeKey      pgtype.Text      `json:"dedupe_key"`
+	Payload        json.RawMessage  `json:"payload"`
+	Status         string           `json:"status"`
+	Priority       int32            `json:"priority"`
+	Attempts       int32            `json:"attempts"`
+	MaxAttempts    int32            `json:"max_attempts"`
+	RunAt          pgtype.Timestamp `json:"run_at"`
+	RepeatSeconds  pgtype.Int4      `json:"repeat_seconds"`
+	LeaseOwner     pgtype.Text      `json:"lease_owner"`
+	LeaseExpiresTS pgtype.Timestamp `json:"lease_expires_ts"`
+	LastError      pgtype.Text      `json:"last_error"`
+	Result         json.RawMessage  `json:"result"`
+	CreatedTS      pgtype.Timestamp `json:"created_ts"`
+	UpdatedTS      pgtype.Timestamp `json:"updated_ts"`
+}
+
 type LastPropertyPriceEvent struct {
 	EventID          pgtype.Int4      `json:"event_id"`
 	PropertyID       int32            `json:"property_id"`
@@ -21,6 +103,84 @@ type LastPropertyPriceEvent struct {
 	EventTS          pgtype.Timestamp `json:"event_ts"`
 }
 
+type LastRentalEstimate struct {
+	PropertyID int32            `json:"property_id"`
+	ListingID  int32            `json:"listing_id"`
+	EstimateTS pgtype.Timestamp `json:"estimate_ts"`
+	Rent       int32            `json:"rent"`
+	RentLow    pgtype.Int4      `json:"rent_low"`
+	RentHigh   pgtype.Int4      `json:"rent_high"`
+}
+
+type ListingCycle struct {
+	EventID     pgtype.Int4      `json:"event_id"`
+	PropertyID  int32            `json:"property_id"`
+	ListingID   int32            `json:"listing_id"`
+	Zipcode     pgtype.Text      `json:"zipcode"`
+	City        pgtype.Text      `json:"city"`
+	State       pgtype.Text      `json:"state"`
+	Location    *geom.Point      `json:"location"`
+	Sqft        pgtype.Int4      `json:"sqft"`
+	ListTS      pgtype.Timestamp `json:"list_ts"`
+	ListPrice   int32            `json:"list_price"`
+	SaleTS      pgtype.Timestamp `json:"sale_ts"`
+	SalePrice   pgtype.Int4      `json:"sale_price"`
+	OffMarketTS pgtype.Timestamp `json:"off_market_ts"`
+}
+
+type ListingImage struct {
+	PropertyID int32            `json:"property_id"`
+	ListingID  int32            `json:"listing_id"`
+	SourceURL  string           `json:"source_url"`
+	Kind       string           `json:"kind"`
+	Position   int32            `json:"position"`
+	Hash       string           `json:"hash"`
+	MirroredTS pgtype.Timestamp `json:"mirrored_ts"`
+}
+
+type MarketStatsMonthly struct {
+	RegionType         string           `json:"region_type"`
+	Region             string           `json:"region"`
+	Month              pgtype.Timestamp `json:"month"`
+	InventoryCount     int64            `json:"inventory_count"`
+	NewListings        int64            `json:"new_listings"`
+	SalesCount         int64            `json:"sales_count"`
+	MedianListPrice    pgtype.Float8    `json:"median_list_price"`
+	MedianSalePrice    pgtype.Float8    `json:"median_sale_price"`
+	MedianPricePerSqft pgtype.Float8    `json:"median_price_per_sqft"`
+	MedianDaysOnMarket pgtype.Float8    `json:"median_days_on_market"`
+	SaleToListRatio    pgtype.Float8    `json:"sale_to_list_ratio"`
+}
+
+type PayloadArchive struct {
+	PropertyID int32            `json:"property_id"`
+	ListingID  int32            `json:"listing_id"`
+	Endpoint   string           `json:"endpoint"`
+	ScrapeTS   pgtype.Timestamp `json:"scrape_ts"`
+	Hash       string           `json:"hash"`
+}
+
+type PayloadDrift struct {
+	Provider       string           `json:"provider"`
+	Endpoint       string           `json:"endpoint"`
+	Path           string           `json:"path"`
+	Change         string           `json:"change"`
+	BaselineType   pgtype.Text      `json:"baseline_type"`
+	ObservedType   pgtype.Text      `json:"observed_type"`
+	Occurrences    int32            `json:"occurrences"`
+	FirstSeenTS    pgtype.Timestamp `json:"first_seen_ts"`
+	LastSeenTS     pgtype.Timestamp `json:"last_seen_ts"`
+	LastPropertyID pgtype.Int4      `json:"last_property_id"`
+	LastListingID  pgtype.Int4      `json:"last_listing_id"`
+}
+
+type PayloadSchema struct {
+	Provider  string `json:"provider"`
+	Endpoint  string `json:"endpoint"`
+	Path      string `json:"path"`
+	ValueType string `json:"value_type"`
+}
+
 type Property struct {
 	PropertyID         int32                        `json:"property_id"`
 	ListingID          int32                        `json:"listing_id"`
@@ -32,6 +192,8 @@ type Property struct {
 	LastScrapeTS       pgtype.Timestamp             `json:"last_scrape_ts"`
 	LastScrapeStatus   string                       `json:"last_scrape_status"`
 	LastScrapeMetadata jsonb.PropertyScrapeMetadata `json:"last_scrape_metadata"`
+	Provider           string                       `json:"provider"`
+	ExternalID         pgtype.Text                  `json:"external_id"`
 }
 
 type PropertyBlocklist struct {
@@ -39,6 +201,17 @@ type PropertyBlocklist struct {
 	Expl pgtype.Text `json:"expl"`
 }
 
+type PropertyDetail struct {
+	PropertyID   int32         `json:"property_id"`
+	ListingID    int32         `json:"listing_id"`
+	Beds         pgtype.Float4 `json:"beds"`
+	Baths        pgtype.Float4 `json:"baths"`
+	Sqft         pgtype.Int4   `json:"sqft"`
+	LotSqft      pgtype.Int4   `json:"lot_sqft"`
+	YearBuilt    pgtype.Int4   `json:"year_built"`
+	PropertyType pgtype.Text   `json:"property_type"`
+}
+
 type PropertyEvent struct {
 	EventID          pgtype.Int4      `json:"event_id"`
 	PropertyID       int32            `json:"property_id"`
@@ -76,10 +249,47 @@ type RealtorPropertyThrough struct {
 	ListingID  int32 `json:"listing_id"`
 }
 
+type RentalEstimate struct {
+	PropertyID int32            `json:"property_id"`
+	ListingID  int32            `json:"listing_id"`
+	EstimateTS pgtype.Timestamp `json:"estimate_ts"`
+	Rent       int32            `json:"rent"`
+	RentLow    pgtype.Int4      `json:"rent_low"`
+	RentHigh   pgtype.Int4      `json:"rent_high"`
+}
+
+type RentalYield struct {
+	PropertyID     int32            `json:"property_id"`
+	ListingID      int32            `json:"listing_id"`
+	URL            pgtype.Text      `json:"url"`
+	Zipcode        pgtype.Text      `json:"zipcode"`
+	City           pgtype.Text      `json:"city"`
+	State          pgtype.Text      `json:"state"`
+	Price          int32            `json:"price"`
+	PriceEvent     pgtype.Text      `json:"price_event"`
+	PriceTS        pgtype.Timestamp `json:"price_ts"`
+	Rent           int32            `json:"rent"`
+	RentLow        pgtype.Int4      `json:"rent_low"`
+	RentHigh       pgtype.Int4      `json:"rent_high"`
+	RentEstimateTS pgtype.Timestamp `json:"rent_estimate_ts"`
+	GrossYield     pgtype.Float8    `json:"gross_yield"`
+}
+
 type Search struct {
-	SearchID           int32                       `json:"search_id"`
-	Query              pgtype.Text                 `json:"query"`
-	LastScrapeTS       pgtype.Timestamp            `json:"last_scrape_ts"`
-	LastScrapeStatus   string                      `json:"last_scrape_status"`
-	LastScrapeMetadata *jsonb.SearchScrapeMetadata `json:"last_scrape_metadata"`
+	SearchID int32       `json:"search_id"`
+	Query    pgtype.Text `json:"query"`
+}
+
+type SimilarSold struct {
+	PropertyID        int32            `json:"property_id"`
+	ListingID         int32            `json:"listing_id"`
+	SimilarPropertyID int32            `json:"similar_property_id"`
+	SimilarListingID  pgtype.Int4      `json:"similar_listing_id"`
+	URL               pgtype.Text      `json:"url"`
+	Price             int32            `json:"price"`
+	SaleTS            pgtype.Timestamp `json:"sale_ts"`
+	Beds              pgtype.Float4    `json:"beds"`
+	Baths             pgtype.Float4    `json:"baths"`
+	Sqft              pgtype.Int4      `json:"sqft"`
+	Location          *geom.Point      `json:"location"`
 }
@@ -134,6 +134,43 @@ func (q *Queries) GetPropertyEvents(ctx context.Context, arg GetPropertyEventsPa
 	return items, nil
 }
 
+const getPropertyEventsByPropertyIDs = `-- name: GetPropertyEventsByPropertyIDs :many
+SELECT event_id, property_id, listing_id, price, event_description, source, source_id, event_ts
+FROM property_events
+WHERE property_id = ANY($1::INT[])
+ORDER BY property_id, event_ts
+`
+
+// Returns the events of each of the supplied properties.
+func (q *Queries) GetPropertyEventsByPropertyIDs(ctx context.Context, propertyIds []int32) ([]PropertyEvent, error) {
+	rows, err := q.db.Query(ctx, getPropertyEventsByPropertyIDs, propertyIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PropertyEvent
+	for rows.Next() {
+		var i PropertyEvent
+		if err := rows.Scan(
+			&i.EventID,
+			&i.PropertyID,
+			&i.ListingID,
+			&i.Price,
+			&i.EventDescription,
+			&i.Source,
+			&i.SourceID,
+			&i.EventTS,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listBlocklistedProperties = `-- name: ListBlocklistedProperties :many
 SELECT url, expl
 FROM property_blocklist
@@ -15,9 +15,9 @@ import (
 
 const createProperty = `-- name: CreateProperty :exec
 INSERT INTO property (
-  property_id, listing_id, url, location
+  property_id, listing_id, url, location, provider, external_id
 ) VALUES (
-  $1, $2, $3, $4
+  $1, $2, $3, $4, $5, $6
 )
 `
 
@@ -26,6 +26,8 @@ type CreatePropertyParams struct {
 	ListingID  int32       `json:"listing_id"`
 	URL        pgtype.Text `json:"url"`
 	Location   *geom.Point `json:"location"`
+	Provider   string      `json:"provider"`
+	ExternalID pgtype.Text `json:"external_id"`
 }
 
 func (q *Queries) CreateProperty(ctx context.Context, arg CreatePropertyParams) error {
@@ -34,6 +36,8 @@ func (q *Queries) CreateProperty(ctx context.Context, arg CreatePropertyParams)
 		arg.ListingID,
 		arg.URL,
 		arg.Location,
+		arg.Provider,
+		arg.ExternalID,
 	)
 	return err
 }
@@ -63,43 +67,6 @@ func (q *Queries) DeletePropertyListingsByID(ctx context.Context, propertyID int
 	return err
 }
 
-const getNNextPropertyScrapeForUpdate = `-- name: GetNNextPropertyScrapeForUpdate :one
-SELECT property_id, listing_id, url, zipcode, city, state, location, last_scrape_ts, last_scrape_status, last_scrape_metadata
-FROM property
-WHERE last_scrape_status = ANY($1::VARCHAR[])
-ORDER BY NOW()::timestamp - last_scrape_ts DESC
-LIMIT $2
-FOR UPDATE
-`
-
-type GetNNextPropertyScrapeForUpdateParams struct {
-	Statuses []string `json:"statuses"`
-	Count    int32    `json:"count"`
-}
-
-// Get the next N property entries that have a last_scrape_status in the
-// supplied slice. Rows are locked for update; callers are expected to set
-// status rows to PENDING after retrieving rows. Note that this query uses
-// the "basic" property table, and NOT the property_price view because
-// callers may expect this to return properties with no price events.
-func (q *Queries) GetNNextPropertyScrapeForUpdate(ctx context.Context, arg GetNNextPropertyScrapeForUpdateParams) (Property, error) {
-	row := q.db.QueryRow(ctx, getNNextPropertyScrapeForUpdate, arg.Statuses, arg.Count)
-	var i Property
-	err := row.Scan(
-		&i.PropertyID,
-		&i.ListingID,
-		&i.URL,
-		&i.Zipcode,
-		&i.City,
-		&i.State,
-		&i.Location,
-		&i.LastScrapeTS,
-		&i.LastScrapeStatus,
-		&i.LastScrapeMetadata,
-	)
-	return i, err
-}
-
 const getPropertiesWithPrice = `-- name: GetPropertiesWithPrice :many
 SELECT property_id, listing_id, price, url, zipcode, city, state, location, last_scrape_ts, last_scrape_status, last_scrape_metadata
 FROM property_price
@@ -148,8 +115,48 @@ func (q *Queries) GetPropertiesWithPrice(ctx context.Context, arg GetPropertiesW
 	return items, nil
 }
 
+const getPropertiesWithPriceByIDs = `-- name: GetPropertiesWithPriceByIDs :many
+SELECT property_id, listing_id, price, url, zipcode, city, state, location, last_scrape_ts, last_scrape_status, last_scrape_metadata
+FROM property_price
+WHERE property_id = ANY($1::INT[])
+ORDER BY property_id, listing_id
+`
+
+// Returns the listings of each of the supplied properties.
+func (q *Queries) GetPropertiesWithPriceByIDs(ctx context.Context, propertyIds []int32) ([]PropertyPrice, error) {
+	rows, err := q.db.Query(ctx, getPropertiesWithPriceByIDs, propertyIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PropertyPrice
+	for rows.Next() {
+		var i PropertyPrice
+		if err := rows.Scan(
+			&i.PropertyID,
+			&i.ListingID,
+			&i.Price,
+			&i.URL,
+			&i.Zipcode,
+			&i.City,
+			&i.State,
+			&i.Location,
+			&i.LastScrapeTS,
+			&i.LastScrapeStatus,
+			&i.LastScrapeMetadata,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getPropertyBasic = `-- name: GetPropertyBasic :one
-SELECT property_id, listing_id, url, zipcode, city, state, location, last_scrape_ts, last_scrape_status, last_scrape_metadata
+SELECT property_id, listing_id, url, zipcode, city, state, location, last_scrape_ts, last_scrape_status, last_scrape_metadata, provider, external_id
 FROM property
 WHERE property_id = $1 AND listing_id = $2
 LIMIT 1
@@ -174,6 +181,68 @@ func (q *Queries) GetPropertyBasic(ctx context.Context, arg GetPropertyBasicPara
 		&i.LastScrapeTS,
 		&i.LastScrapeStatus,
 		&i.LastScrapeMetadata,
+		&i.Provider,
+		&i.ExternalID,
+	)
+	return i, err
+}
+
+const getPropertyByExternalID = `-- name: GetPropertyByExternalID :one
+SELECT property_id, listing_id, url, zipcode, city, state, location, last_scrape_ts, last_scrape_status, last_scrape_metadata, provider, external_id
+FROM property
+WHERE provider = $1 AND external_id = $2
+LIMIT 1
+`
+
+type GetPropertyByExternalIDParams struct {
+	Provider   string      `json:"provider"`
+	ExternalID pgtype.Text `json:"external_id"`
+}
+
+func (q *Queries) GetPropertyByExternalID(ctx context.Context, arg GetPropertyByExternalIDParams) (Property, error) {
+	row := q.db.QueryRow(ctx, getPropertyByExternalID, arg.Provider, arg.ExternalID)
+	var i Property
+	err := row.Scan(
+		&i.PropertyID,
+		&i.ListingID,
+		&i.URL,
+		&i.Zipcode,
+		&i.City,
+		&i.State,
+		&i.Location,
+		&i.LastScrapeTS,
+		&i.LastScrapeStatus,
+		&i.LastScrapeMetadata,
+		&i.Provider,
+		&i.ExternalID,
+	)
+	return i, err
+}
+
+const getPropertyDetails = `-- name: GetPropertyDetails :one
+SELECT property_id, listing_id, beds, baths, sqft, lot_sqft, year_built, property_type
+FROM property_details
+WHERE property_id = $1 AND listing_id = $2
+LIMIT 1
+`
+
+type GetPropertyDetailsParams struct {
+	PropertyID int32 `json:"property_id"`
+	ListingID  int32 `json:"listing_id"`
+}
+
+func (q *Queries) GetPropertyDetails(ctx context.Context, arg GetPropertyDetailsParams) (PropertyDetail, error) {
+	row := q.db.QueryRow(ctx, getPropertyDetails, arg.PropertyID, arg.ListingID)
+	var i PropertyDetail
+	err := row.Scan(
+		&i.PropertyID,
+		&i.ListingID,
+		&i.Beds,
+		&i.Baths,
+		&i.Sqft,
+		&i.LotSqft,
+		&i.YearBuilt,
+		&i.PropertyType,
 	)
 	return i, err
 }
@@ -209,35 +278,6 @@ func (q *Queries) GetPropertyWithPrice(ctx context.Context, arg GetPropertyWithP
 	return i, err
 }
 
-const getRecentPropertyScrapeStats = `-- name: GetRecentPropertyScrapeStats :one
-SELECT
-       COUNT(*) FILTER (WHERE last_scrape_status = 'good') AS good,
-       COUNT(*) FILTER (WHERE last_scrape_status = 'pending') AS pending,
-       COUNT(*) FILTER (WHERE last_scrape_status = 'bad') AS bad,
-       COUNT(*) FILTER (WHERE last_scrape_status IS NULL) AS "null"
-FROM property
-WHERE last_scrape_ts > $1
-`
-
-type GetRecentPropertyScrapeStatsRow struct {
-	Good    int64 `json:"good"`
-	Pending int64 `json:"pending"`
-	Bad     int64 `json:"bad"`
-	Null    int64 `json:"null"`
-}
-
-func (q *Queries) GetRecentPropertyScrapeStats(ctx context.Context, lastScrapeTs pgtype.Timestamp) (GetRecentPropertyScrapeStatsRow, error) {
-	row := q.db.QueryRow(ctx, getRecentPropertyScrapeStats, lastScrapeTs)
-	var i GetRecentPropertyScrapeStatsRow
-	err := row.Scan(
-		&i.Good,
-		&i.Pending,
-		&i.Bad,
-		&i.Null,
-	)
-	return i, err
-}
-
 const listPropertiesPrices = `-- name: ListPropertiesPrices :many
 SELECT property_id, listing_id, price, url, zipcode, city, state, location, last_scrape_ts, last_scrape_status, last_scrape_metadata
 FROM property_price p
@@ -276,6 +316,17 @@ func (q *Queries) ListPropertiesPrices(ctx context.Context) ([]PropertyPrice, er
 	return items, nil
 }
 
+const nextExternalPropertyID = `-- name: NextExternalPropertyID :one
+SELECT NEXTVAL('external_property_id_seq')::INT AS property_id
+`
+
+func (q *Queries) NextExternalPropertyID(ctx context.Context) (int32, error) {
+	row := q.db.QueryRow(ctx, nextExternalPropertyID)
+	var property_id int32
+	err := row.Scan(&property_id)
+	return property_id, err
+}
+
 const putProperty = `-- name: PutProperty :exec
 UPDATE property
   SET url = $3,
@@ -318,20 +369,41 @@ func (q *Queries) PutProperty(ctx context.Context, arg PutPropertyParams) error
 	return err
 }
 
-const updatePropertyStatus = `-- name: UpdatePropertyStatus :exec
-UPDATE property
-  SET last_scrape_ts = NOW()::timestamp,
-  last_scrape_status = $3
-WHERE property_id = $1 AND listing_id = $2
+const upsertPropertyDetails = `-- name: UpsertPropertyDetails :exec
+INSERT INTO property_details (
+  property_id, listing_id, beds, baths, sqft, lot_sqft, year_built, property_type
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8
+) ON CONFLICT (property_id, listing_id) DO UPDATE
+  SET beds = EXCLUDED.beds,
+  baths = EXCLUDED.baths,
+  sqft = EXCLUDED.sqft,
+  lot_sqft = EXCLUDED.lot_sqft,
+  year_built = EXCLUDED.year_built,
+  property_type = EXCLUDED.property_type
 `
 
-type UpdatePropertyStatusParams struct {
-	PropertyID       int32  `json:"property_id"`
-	ListingID        int32  `json:"listing_id"`
-	LastScrapeStatus string `json:"last_scrape_status"`
+type UpsertPropertyDetailsParams struct {
+	PropertyID   int32         `json:"property_id"`
+	ListingID    int32         `json:"listing_id"`
+	Beds         pgtype.Float4 `json:"beds"`
+	Baths        pgtype.Float4 `json:"baths"`
+	Sqft         pgtype.Int4   `json:"sqft"`
+	LotSqft      pgtype.Int4   `json:"lot_sqft"`
+	YearBuilt    pgtype.Int4   `json:"year_built"`
+	PropertyType pgtype.Text   `json:"property_type"`
 }
 
-func (q *Queries) UpdatePropertyStatus(ctx context.Context, arg UpdatePropertyStatusParams) error {
-	_, err := q.db.Exec(ctx, updatePropertyStatus, arg.PropertyID, arg.ListingID, arg.LastScrapeStatus)
+func (q *Queries) UpsertPropertyDetails(ctx context.Context, arg UpsertPropertyDetailsParams) error {
+	_, err := q.db.Exec(ctx, upsertPropertyDetails,
+		arg.PropertyID,
+		arg.ListingID,
+		arg.Beds,
+		arg.Baths,
+		arg.Sqft,
+		arg.LotSqft,
+		arg.YearBuilt,
+		arg.PropertyType,
+	)
 	return err
 }
@@ -76,6 +76,49 @@ func (q *Queries) DeleteRealtorPropertyListing(ctx context.Context, arg DeleteRe
 	return err
 }
 
+const getListingRealtors = `-- name: GetListingRealtors :many
+SELECT rp.property_id, rp.listing_id, r.realtor_id, r.name, r.company
+FROM realtor_property_through rp
+INNER JOIN realtor r ON rp.realtor_id = r.realtor_id
+WHERE rp.property_id = ANY($1::INT[])
+ORDER BY r.name, r.company
+`
+
+type GetListingRealtorsRow struct {
+	PropertyID int32  `json:"property_id"`
+	ListingID  int32  `json:"listing_id"`
+	RealtorID  int32  `json:"realtor_id"`
+	Name       string `json:"name"`
+	Company    string `json:"company"`
+}
+
+// Returns the realtors of each of the listings of the supplied properties.
+func (q *Queries) GetListingRealtors(ctx context.Context, propertyIds []int32) ([]GetListingRealtorsRow, error) {
+	rows, err := q.db.Query(ctx, getListingRealtors, propertyIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetListingRealtorsRow
+	for rows.Next() {
+		var i GetListingRealtorsRow
+		if err := rows.Scan(
+			&i.PropertyID,
+			&i.ListingID,
+			&i.RealtorID,
+			&i.Name,
+			&i.Company,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getRealtor = `-- name: GetRealtor :one
 SELECT realtor_id, name, company
 FROM realtor
@@ -94,6 +137,67 @@ func (q *Queries) GetRealtor(ctx context.Context, arg GetRealtorParams) (Realtor
 	return i, err
 }
 
+const getRealtorListings = `-- name: GetRealtorListings :many
+SELECT
+  rp.realtor_id,
+  p.property_id, p.listing_id, p.price, p.url, p.zipcode, p.city, p.state,
+  p.location, p.last_scrape_ts, p.last_scrape_status, p.last_scrape_metadata
+FROM realtor_property_through rp
+INNER JOIN property_price p
+  ON rp.property_id = p.property_id AND rp.listing_id = p.listing_id
+WHERE rp.realtor_id = ANY($1::INT[])
+ORDER BY rp.realtor_id, p.property_id, p.listing_id
+`
+
+type GetRealtorListingsRow struct {
+	RealtorID          int32                        `json:"realtor_id"`
+	PropertyID         int32                        `json:"property_id"`
+	ListingID          int32                        `json:"listing_id"`
+	Price              int32                        `json:"price"`
+	URL                pgtype.Text                  `json:"url"`
+	Zipcode            pgtype.Text                  `json:"zipcode"`
+	City               pgtype.Text                  `json:"city"`
+	State              pgtype.Text                  `json:"state"`
+	Location           *geom.Point                  `json:"location"`
+	LastScrapeTS       pgtype.Timestamp             `json:"last_scrape_ts"`
+	LastScrapeStatus   string                       `json:"last_scrape_status"`
+	LastScrapeMetadata jsonb.PropertyScrapeMetadata `json:"last_scrape_metadata"`
+}
+
+// Returns the listings of each of the supplied realtors.
+func (q *Queries) GetRealtorListings(ctx context.Context, realtorIds []int32) ([]GetRealtorListingsRow, error) {
+	rows, err := q.db.Query(ctx, getRealtorListings, realtorIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRealtorListingsRow
+	for rows.Next() {
+		var i GetRealtorListingsRow
+		if err := rows.Scan(
+			&i.RealtorID,
+			&i.PropertyID,
+			&i.ListingID,
+			&i.Price,
+			&i.URL,
+			&i.Zipcode,
+			&i.City,
+			&i.State,
+			&i.Location,
+			&i.LastScrapeTS,
+			&i.LastScrapeStatus,
+			&i.LastScrapeMetadata,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getRealtorProperties = `-- name: GetRealtorProperties :many
 SELECT r.realtor_id, name, company, rp.realtor_id, rp.property_id, rp.listing_id, p.property_id, p.listing_id, price, url, zipcode, city, state, location, last_scrape_ts, last_scrape_status, last_scrape_metadata
 FROM realtor r
@@ -171,6 +275,89 @@ func (q *Queries) GetRealtorProperties(ctx context.Context, arg GetRealtorProper
 	return items, nil
 }
 
+const getRealtorStats = `-- name: GetRealtorStats :many
+SELECT
+  rp.realtor_id,
+  COUNT(*)::INT AS "listing_count",
+  AVG(p.price)::INT AS "avg_price",
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY p.price)::INT AS "median_price",
+  MIN(p.price)::INT AS "min_price",
+  MAX(p.price)::INT AS "max_price",
+  COALESCE(STRING_AGG(DISTINCT p.zipcode, ','), '')::TEXT AS "zipcodes"
+FROM realtor_property_through rp
+INNER JOIN property_price p
+  ON rp.property_id = p.property_id AND rp.listing_id = p.listing_id
+WHERE rp.realtor_id = ANY($1::INT[])
+GROUP BY rp.realtor_id
+`
+
+type GetRealtorStatsRow struct {
+	RealtorID    int32  `json:"realtor_id"`
+	ListingCount int32  `json:"listing_count"`
+	AvgPrice     int32  `json:"avg_price"`
+	MedianPrice  int32  `json:"median_price"`
+	MinPrice     int32  `json:"min_price"`
+	MaxPrice     int32  `json:"max_price"`
+	Zipcodes     string `json:"zipcodes"`
+}
+
+// Returns aggregate stats over the listings of each of the supplied realtors.
+// Realtors without listings are omitted.
+func (q *Queries) GetRealtorStats(ctx context.Context, realtorIds []int32) ([]GetRealtorStatsRow, error) {
+	rows, err := q.db.Query(ctx, getRealtorStats, realtorIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRealtorStatsRow
+	for rows.Next() {
+		var i GetRealtorStatsRow
+		if err := rows.Scan(
+			&i.RealtorID,
+			&i.ListingCount,
+			&i.AvgPrice,
+			&i.MedianPrice,
+			&i.MinPrice,
+			&i.MaxPrice,
+			&i.Zipcodes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRealtorsByIDs = `-- name: GetRealtorsByIDs :many
+SELECT realtor_id, name, company
+FROM realtor
+WHERE realtor_id = ANY($1::INT[])
+ORDER BY realtor_id
+`
+
+func (q *Queries) GetRealtorsByIDs(ctx context.Context, realtorIds []int32) ([]Realtor, error) {
+	rows, err := q.db.Query(ctx, getRealtorsByIDs, realtorIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Realtor
+	for rows.Next() {
+		var i Realtor
+		if err := rows.Scan(&i.RealtorID, &i.Name, &i.Company); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const searchRealtorProperties = `-- name: SearchRealtorProperties :many
 SELECT name, company, property_count, avg_price, median_price, zipcodes
 FROM (
@@ -235,3 +422,33 @@ func (q *Queries) SearchRealtorProperties(ctx context.Context, search string) ([
 	}
 	return items, nil
 }
+
+const searchRealtors = `-- name: SearchRealtors :many
+SELECT realtor_id, name, company
+FROM realtor
+WHERE
+  (POSITION(LOWER($1) IN LOWER(name)) > 0) OR
+  (POSITION(LOWER($1) IN LOWER(company)) > 0)
+ORDER BY name, company
+LIMIT 100
+`
+
+func (q *Queries) SearchRealtors(ctx context.Context, search string) ([]Realtor, error) {
+	rows, err := q.db.Query(ctx, searchRealtors, search)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Realtor
+	for rows.Next() {
+		var i Realtor
+		if err := rows.Scan(&i.RealtorID, &i.Name, &i.Company); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -26,6 +26,9 @@ const (
 	pgErrorUniqueViolation             = "23505"
 	pgErrorCheckViolation              = "23514"
 	pgErrorExclusionViolation          = "23P01"
+
+	// class 22 (e.g., invalid_text_representation, numeric_value_out_of_range)
+	pgErrorClassDataException = "22"
 )
 
 func pgErrorText(code string) string {
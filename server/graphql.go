@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// Queries are checked against these limits before they're executed, so a
+// query that would load too much is rejected without touching the database.
+// The depth of a query is how deeply its fields are nested. Its cost is the
+// number of fields it resolves, assuming every list has graphQLListSize items
+// (so a list field multiplies the cost of the fields selected under it).
+// Introspection is served from memory and isn't charged for lists, but it has
+// a depth limit of its own, since the standard introspection query is deeper
+// than any useful data query, and can't nest a list field in itself.
+const (
+	graphQLMaxDepth              = 7
+	graphQLMaxIntrospectionDepth = 15
+	graphQLMaxCost               = 10000
+	graphQLListSize              = 10
+)
+
+// Context key of the request being executed, which resolvers need to build
+// absolute URLs.
+type graphQLRequestKey struct{}
+
+// Executes a read-only GraphQL query (see graphql_schema.go). Requests that
+// can't be executed (a malformed body, a query that doesn't parse, validate
+// or is over the limits) are problems; errors raised while executing the
+// query are reported in the errors of the response alongside whatever data
+// was resolved, following the GraphQL spec.
+func handleGraphQL(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	schema, err := newGraphQLSchema(q)
+	if err != nil {
+		panic(fmt.Sprintf("bad GraphQL schema: %s", err))
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body GraphQLRequest
+		err := decodeJSONBody(r, &body)
+		if err != nil {
+			var mr *MalformedRequest
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
+			return
+		}
+		if err := validateBody(&body); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+
+		doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+			Body: []byte(body.Query),
+			Name: "GraphQL request",
+		})})
+		if err != nil {
+			msg, _, _ := strings.Cut(gqlerrors.FormatError(err).Message, "\n")
+			writeBadRequestError(w, invalidFieldf("query", "%s", msg))
+			return
+		}
+		for _, d := range doc.Definitions {
+			if op, ok := d.(*ast.OperationDefinition); ok && op.Operation != ast.OperationTypeQuery {
+				writeBadRequestError(w, invalidFieldf("query", "%s operations aren't supported, the API is read-only", op.Operation))
+				return
+			}
+		}
+		if res := graphql.ValidateDocument(&schema, doc, nil); !res.IsValid {
+			var ve ValidationError
+			for _, e := range res.Errors {
+				ve.add("query", FieldCodeInvalid, e.Message)
+			}
+			writeBadRequestError(w, &ve)
+			return
+		}
+		if err := checkGraphQLLimits(&schema, doc); err != nil {
+			writeProblem(w, newProblem(http.StatusBadRequest, ErrorCodeQueryTooComplex, err.Error()))
+			return
+		}
+
+		res := graphql.Execute(graphql.ExecuteParams{
+			Schema:        schema,
+			AST:           doc,
+			OperationName: body.OperationName,
+			Args:          body.Variables,
+			Context:       context.WithValue(r.Context(), graphQLRequestKey{}, r),
+		})
+		resp := GraphQLResponse{Data: res.Data}
+		for _, fe := range res.Errors {
+			resp.Errors = append(resp.Errors, graphQLResponseError(l, w, fe))
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// Converts an error raised while executing a query. Errors that weren't caused
+// by the query are logged and reported as internal errors, so that the client
+// doesn't see database errors.
+func graphQLResponseError(l *slog.Logger, w http.ResponseWriter, fe gqlerrors.FormattedError) GraphQLError {
+	res := GraphQLError{Message: fe.Message, Path: fe.Path, Extensions: fe.Extensions}
+	var ge *gqlerrors.Error
+	if !errors.As(fe.OriginalError(), &ge) || ge.OriginalError == nil {
+		return res
+	}
+	var qe *graphQLError
+	if errors.As(ge.OriginalError, &qe) {
+		return res
+	}
+	l.Error(
+		"GraphQL field failed",
+		"error", ge.OriginalError.Error(),
+		"path", fmt.Sprint(fe.Path),
+		"request_id", w.Header().Get(requestIDHeader),
+	)
+	res.Message = "internal error"
+	res.Extensions = map[string]any{"code": ErrorCodeInternal}
+	return res
+}
+
+// Returns an error if any operation of the document is over the depth or cost
+// limits. The document must be valid, so every field and fragment it refers to
+// exists and fragments aren't cyclic.
+func checkGraphQLLimits(schema *graphql.Schema, doc *ast.Document) error {
+	c := graphQLCostChecker{schema: schema, fragments: map[string]*ast.FragmentDefinition{}}
+	for _, d := range doc.Definitions {
+		if f, ok := d.(*ast.FragmentDefinition); ok {
+			c.fragments[f.Name.Value] = f
+		}
+	}
+	for _, d := range doc.Definitions {
+		op, ok := d.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		cost, err := c.selectionSet(op.SelectionSet, schema.QueryType(), 1, nil)
+		if err != nil {
+			return err
+		}
+		if cost > graphQLMaxCost {
+			return fmt.Errorf("query cost %d exceeds the limit of %d", cost, graphQLMaxCost)
+		}
+	}
+	return nil
+}
+
+type graphQLCostChecker struct {
+	schema    *graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+}
+
+// Returns the cost of the selection set of a value of type t whose fields are
+// at the given depth. Introspection is the introspection list fields on the
+// path, or nil outside of introspection.
+func (c *graphQLCostChecker) selectionSet(ss *ast.SelectionSet, t graphql.Type, depth int, introspection []string) (int, error) {
+	if ss == nil {
+		return 0, nil
+	}
+	total := 0
+	for _, s := range ss.Selections {
+		var cost int
+		var err error
+		switch s := s.(type) {
+		case *ast.Field:
+			cost, err = c.field(s, t, depth, introspection)
+		case *ast.InlineFragment:
+			ft := t
+			if s.TypeCondition != nil {
+				ft = c.schema.Type(s.TypeCondition.Name.Value)
+			}
+			cost, err = c.selectionSet(s.SelectionSet, ft, depth, introspection)
+		case *ast.FragmentSpread:
+			f := c.fragments[s.Name.Value]
+			cost, err = c.selectionSet(f.SelectionSet, c.schema.Type(f.TypeCondition.Name.Value), depth, introspection)
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += cost
+	}
+	return total, nil
+}
+
+func (c *graphQLCostChecker) field(f *ast.Field, t graphql.Type, depth int, introspection []string) (int, error) {
+	name := f.Name.Value
+	var def *graphql.FieldDefinition
+	switch {
+	case name == "__typename":
+		return 0, nil
+	case name == "__schema":
+		def = graphql.SchemaMetaFieldDef
+	case name == "__type":
+		def = graphql.TypeMetaFieldDef
+	default:
+		if o, ok := t.(*graphql.Object); ok {
+			def = o.Fields()[name]
+		}
+	}
+	if def == nil {
+		return 0, nil
+	}
+	if introspection == nil && strings.HasPrefix(name, "__") {
+		introspection = []string{}
+	}
+
+	maxDepth := graphQLMaxDepth
+	if introspection != nil {
+		maxDepth = graphQLMaxIntrospectionDepth
+	}
+	if depth > maxDepth {
+		return 0, fmt.Errorf("query depth exceeds the limit of %d at field %s", maxDepth, name)
+	}
+
+	ft, isList := unwrapGraphQLType(def.Type)
+	size := 1
+	if isList {
+		if introspection == nil {
+			size = graphQLListSize
+		} else {
+			for _, n := range introspection {
+				if n == name {
+					return 0, fmt.Errorf("introspection field %s can't be nested in itself", name)
+				}
+			}
+			introspection = append(introspection[:len(introspection):len(introspection)], name)
+		}
+	}
+	cost, err := c.selectionSet(f.SelectionSet, ft, depth+1, introspection)
+	if err != nil {
+		return 0, err
+	}
+	return 1 + size*cost, nil
+}
+
+// Returns the named type of a field type and whether it's a list.
+func unwrapGraphQLType(t graphql.Type) (graphql.Type, bool) {
+	isList := false
+	for {
+		switch w := t.(type) {
+		case *graphql.NonNull:
+			t = w.OfType
+		case *graphql.List:
+			isList = true
+			t = w.OfType
+		default:
+			return t, isList
+		}
+	}
+}
@@ -0,0 +1,725 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/brojonat/gredfin/server/db/jsonb"
+	"github.com/brojonat/histogram"
+	"github.com/graphql-go/graphql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// The GraphQL schema is read-only: it has a query type and nothing else. The
+// objects it resolves to are loaded in batches so that a query costs one
+// database round trip per level of nesting rather than one per object. Every
+// query that loads rows wraps them all in objects that share a set of batches,
+// one per field that needs more data (e.g., the events of a listing). The
+// first time one of those fields is resolved, its batch loads the data of every
+// sibling in a single query; the rest of the siblings then read their data from
+// the batch.
+
+// Maximum number of keys a query can look up at once (e.g., the IDs passed to
+// properties).
+const graphQLMaxKeys = 100
+
+// batch loads the data of a group of sibling objects the first time any of
+// them needs it.
+type batch[K comparable, V any] struct {
+	fetch func(context.Context) (map[K]V, error)
+	once  sync.Once
+	vals  map[K]V
+	err   error
+}
+
+func newBatch[K comparable, V any](fetch func(context.Context) (map[K]V, error)) *batch[K, V] {
+	return &batch[K, V]{fetch: fetch}
+}
+
+func (b *batch[K, V]) load(ctx context.Context, k K) (V, error) {
+	b.once.Do(func() { b.vals, b.err = b.fetch(ctx) })
+	return b.vals[k], b.err
+}
+
+type listingKey struct {
+	propertyID int32
+	listingID  int32
+}
+
+// A property, which is the group of listings that share a property ID.
+type gqlProperty struct {
+	propertyID int32
+	siblings   *propertyBatches
+}
+
+type propertyBatches struct {
+	listings *batch[int32, []*gqlListing]
+	events   *batch[int32, []dbgen.PropertyEvent]
+}
+
+func newProperties(q *dbgen.Queries, ids []int32) []*gqlProperty {
+	ids = uniqueIDs(ids)
+	pb := &propertyBatches{
+		listings: newBatch(func(ctx context.Context) (map[int32][]*gqlListing, error) {
+			rows, err := q.GetPropertiesWithPriceByIDs(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			res := map[int32][]*gqlListing{}
+			for _, l := range newListings(q, rows) {
+				res[l.PropertyID] = append(res[l.PropertyID], l)
+			}
+			return res, nil
+		}),
+		events: newEventsBatch(q, ids),
+	}
+	ps := []*gqlProperty{}
+	for _, id := range ids {
+		ps = append(ps, &gqlProperty{propertyID: id, siblings: pb})
+	}
+	return ps
+}
+
+type gqlListing struct {
+	dbgen.PropertyPrice
+	siblings *listingBatches
+}
+
+type listingBatches struct {
+	properties map[int32]*gqlProperty
+	events     *batch[int32, []dbgen.PropertyEvent]
+	realtors   *batch[listingKey, []*gqlRealtor]
+	images     *batch[listingKey, jsonb.PropertyScrapeMetadata]
+}
+
+func newListings(q *dbgen.Queries, rows []dbgen.PropertyPrice) []*gqlListing {
+	ls := []*gqlListing{}
+	ids := []int32{}
+	for _, row := range rows {
+		ls = append(ls, &gqlListing{PropertyPrice: row})
+		ids = append(ids, row.PropertyID)
+	}
+	ids = uniqueIDs(ids)
+
+	lb := &listingBatches{
+		properties: map[int32]*gqlProperty{},
+		realtors: newBatch(func(ctx context.Context) (map[listingKey][]*gqlRealtor, error) {
+			rows, err := q.GetListingRealtors(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			realtors := []dbgen.Realtor{}
+			seen := map[int32]bool{}
+			for _, row := range rows {
+				if !seen[row.RealtorID] {
+					seen[row.RealtorID] = true
+					realtors = append(realtors, dbgen.Realtor{RealtorID: row.RealtorID, Name: row.Name, Company: row.Company})
+				}
+			}
+			byID := map[int32]*gqlRealtor{}
+			for _, r := range newRealtors(q, realtors) {
+				byID[r.RealtorID] = r
+			}
+			res := map[listingKey][]*gqlRealtor{}
+			for _, row := range rows {
+				k := listingKey{row.PropertyID, row.ListingID}
+				res[k] = append(res[k], byID[row.RealtorID])
+			}
+			return res, nil
+		}),
+		images: newBatch(func(ctx context.Context) (map[listingKey]jsonb.PropertyScrapeMetadata, error) {
+			r, _ := ctx.Value(graphQLRequestKey{}).(*http.Request)
+			ms := []*jsonb.PropertyScrapeMetadata{}
+			for _, l := range ls {
+				ms = append(ms, &l.LastScrapeMetadata)
+			}
+			if err := rewriteImageURLs(ctx, q, r, ms...); err != nil {
+				return nil, err
+			}
+			res := map[listingKey]jsonb.PropertyScrapeMetadata{}
+			for _, l := range ls {
+				res[listingKey{l.PropertyID, l.ListingID}] = l.LastScrapeMetadata
+			}
+			return res, nil
+		}),
+	}
+	// the listings share the events batch of their properties
+	for _, p := range newProperties(q, ids) {
+		lb.properties[p.propertyID] = p
+		lb.events = p.siblings.events
+	}
+	for _, l := range ls {
+		l.siblings = lb
+	}
+	return ls
+}
+
+type gqlRealtor struct {
+	dbgen.Realtor
+	siblings *realtorBatches
+}
+
+type realtorBatches struct {
+	listings *batch[int32, []*gqlListing]
+	stats    *batch[int32, *dbgen.GetRealtorStatsRow]
+}
+
+func newRealtors(q *dbgen.Queries, rows []dbgen.Realtor) []*gqlRealtor {
+	ids := []int32{}
+	for _, row := range rows {
+		ids = append(ids, row.RealtorID)
+	}
+	rb := &realtorBatches{
+		listings: newBatch(func(ctx context.Context) (map[int32][]*gqlListing, error) {
+			rows, err := q.GetRealtorListings(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			pps := []dbgen.PropertyPrice{}
+			for _, row := range rows {
+				pps = append(pps, dbgen.PropertyPrice{
+					PropertyID:         row.PropertyID,
+					ListingID:          row.ListingID,
+					Price:              row.Price,
+					URL:                row.URL,
+					Zipcode:            row.Zipcode,
+					City:               row.City,
+					State:              row.State,
+					Location:           row.Location,
+					LastScrapeTS:       row.LastScrapeTS,
+					LastScrapeStatus:   row.LastScrapeStatus,
+					LastScrapeMetadata: row.LastScrapeMetadata,
+				})
+			}
+			res := map[int32][]*gqlListing{}
+			for i, l := range newListings(q, pps) {
+				res[rows[i].RealtorID] = append(res[rows[i].RealtorID], l)
+			}
+			return res, nil
+		}),
+		stats: newBatch(func(ctx context.Context) (map[int32]*dbgen.GetRealtorStatsRow, error) {
+			rows, err := q.GetRealtorStats(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			res := map[int32]*dbgen.GetRealtorStatsRow{}
+			for i := range rows {
+				res[rows[i].RealtorID] = &rows[i]
+			}
+			return res, nil
+		}),
+	}
+	rs := []*gqlRealtor{}
+	for _, row := range rows {
+		rs = append(rs, &gqlRealtor{Realtor: row, siblings: rb})
+	}
+	return rs
+}
+
+// Returns a batch that loads the events of the properties, by property ID.
+func newEventsBatch(q *dbgen.Queries, ids []int32) *batch[int32, []dbgen.PropertyEvent] {
+	return newBatch(func(ctx context.Context) (map[int32][]dbgen.PropertyEvent, error) {
+		rows, err := q.GetPropertyEventsByPropertyIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		res := map[int32][]dbgen.PropertyEvent{}
+		for _, row := range rows {
+			res[row.PropertyID] = append(res[row.PropertyID], row)
+		}
+		return res, nil
+	})
+}
+
+func uniqueIDs(ids []int32) []int32 {
+	res := []int32{}
+	seen := map[int32]bool{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// graphQLError is an error caused by the query rather than the server. Its
+// message is reported to the client as is.
+type graphQLError struct {
+	code string
+	msg  string
+}
+
+func (e *graphQLError) Error() string {
+	return e.msg
+}
+
+func (e *graphQLError) Extensions() map[string]any {
+	return map[string]any{"code": e.code}
+}
+
+// Returns a field whose value is read from the source, which must be an S.
+func gqlField[S any](t graphql.Output, get func(S) any) *graphql.Field {
+	return &graphql.Field{
+		Type: t,
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			return get(p.Source.(S)), nil
+		},
+	}
+}
+
+// Helpers that return nil for null values, so they resolve to null.
+
+func gqlText(t pgtype.Text) any {
+	if !t.Valid {
+		return nil
+	}
+	return t.String
+}
+
+func gqlInt(i pgtype.Int4) any {
+	if !i.Valid {
+		return nil
+	}
+	return i.Int32
+}
+
+func gqlFloat(f pgtype.Float8) any {
+	if !f.Valid {
+		return nil
+	}
+	return f.Float64
+}
+
+func gqlTime(t pgtype.Timestamp) any {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time
+}
+
+// Lists are non-null, so missing ones resolve to empty lists.
+func gqlList[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// Returns the IDs of a list argument.
+func gqlIDs(p graphql.ResolveParams, name string) ([]int32, error) {
+	vs, _ := p.Args[name].([]any)
+	if len(vs) > graphQLMaxKeys {
+		return nil, &graphQLError{code: ErrorCodeValidationFailed, msg: name + ": too many values"}
+	}
+	ids := []int32{}
+	for _, v := range vs {
+		ids = append(ids, int32(v.(int)))
+	}
+	return ids, nil
+}
+
+func newGraphQLSchema(q *dbgen.Queries) (graphql.Schema, error) {
+	location := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Location",
+		Description: "A point in WGS 84 coordinates.",
+		Fields: graphql.Fields{
+			"latitude":  gqlField(graphql.NewNonNull(graphql.Float), func(l *gqlListing) any { return l.Location.Y() }),
+			"longitude": gqlField(graphql.NewNonNull(graphql.Float), func(l *gqlListing) any { return l.Location.X() }),
+		},
+	})
+
+	event := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PropertyEvent",
+		Fields: graphql.Fields{
+			"eventId":     gqlField(graphql.Int, func(e dbgen.PropertyEvent) any { return gqlInt(e.EventID) }),
+			"propertyId":  gqlField(graphql.NewNonNull(graphql.Int), func(e dbgen.PropertyEvent) any { return e.PropertyID }),
+			"listingId":   gqlField(graphql.NewNonNull(graphql.Int), func(e dbgen.PropertyEvent) any { return e.ListingID }),
+			"price":       gqlField(graphql.NewNonNull(graphql.Int), func(e dbgen.PropertyEvent) any { return e.Price }),
+			"description": gqlField(graphql.String, func(e dbgen.PropertyEvent) any { return gqlText(e.EventDescription) }),
+			"source":      gqlField(graphql.String, func(e dbgen.PropertyEvent) any { return gqlText(e.Source) }),
+			"sourceId":    gqlField(graphql.String, func(e dbgen.PropertyEvent) any { return gqlText(e.SourceID) }),
+			"eventTs":     gqlField(graphql.DateTime, func(e dbgen.PropertyEvent) any { return gqlTime(e.EventTS) }),
+		},
+	})
+
+	pricePoint := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "PricePoint",
+		Description: "The price of a property at the time of one of its events.",
+		Fields: graphql.Fields{
+			"timestamp": gqlField(graphql.DateTime, func(e dbgen.PropertyEvent) any { return gqlTime(e.EventTS) }),
+			"price":     gqlField(graphql.NewNonNull(graphql.Int), func(e dbgen.PropertyEvent) any { return e.Price }),
+		},
+	})
+
+	priceBin := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "PriceBin",
+		Description: "A bucket of a price histogram; price is its lower bound.",
+		Fields: graphql.Fields{
+			"price": gqlField(graphql.NewNonNull(graphql.Float), func(b PriceBin) any { return b.Price }),
+			"count": gqlField(graphql.NewNonNull(graphql.Int), func(b PriceBin) any { return b.Count }),
+		},
+	})
+
+	realtorStats := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "RealtorStats",
+		Description: "Aggregates over the listings of a realtor.",
+		Fields: graphql.Fields{
+			"listingCount": gqlField(graphql.NewNonNull(graphql.Int), func(s *dbgen.GetRealtorStatsRow) any { return s.ListingCount }),
+			"avgPrice":     gqlField(graphql.NewNonNull(graphql.Int), func(s *dbgen.GetRealtorStatsRow) any { return s.AvgPrice }),
+			"medianPrice":  gqlField(graphql.NewNonNull(graphql.Int), func(s *dbgen.GetRealtorStatsRow) any { return s.MedianPrice }),
+			"minPrice":     gqlField(graphql.NewNonNull(graphql.Int), func(s *dbgen.GetRealtorStatsRow) any { return s.MinPrice }),
+			"maxPrice":     gqlField(graphql.NewNonNull(graphql.Int), func(s *dbgen.GetRealtorStatsRow) any { return s.MaxPrice }),
+			"zipcodes": gqlField(graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))), func(s *dbgen.GetRealtorStatsRow) any {
+				if s.Zipcodes == "" {
+					return []string{}
+				}
+				return strings.Split(s.Zipcodes, ",")
+			}),
+		},
+	})
+
+	marketStats := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "MarketStats",
+		Description: "Monthly market stats of a region.",
+		Fields: graphql.Fields{
+			"regionType":         gqlField(graphql.NewNonNull(graphql.String), func(s dbgen.MarketStatsMonthly) any { return s.RegionType }),
+			"region":             gqlField(graphql.NewNonNull(graphql.String), func(s dbgen.MarketStatsMonthly) any { return s.Region }),
+			"month":              gqlField(graphql.DateTime, func(s dbgen.MarketStatsMonthly) any { return gqlTime(s.Month) }),
+			"inventoryCount":     gqlField(graphql.NewNonNull(graphql.Int), func(s dbgen.MarketStatsMonthly) any { return s.InventoryCount }),
+			"newListings":        gqlField(graphql.NewNonNull(graphql.Int), func(s dbgen.MarketStatsMonthly) any { return s.NewListings }),
+			"salesCount":         gqlField(graphql.NewNonNull(graphql.Int), func(s dbgen.MarketStatsMonthly) any { return s.SalesCount }),
+			"medianListPrice":    gqlField(graphql.Float, func(s dbgen.MarketStatsMonthly) any { return gqlFloat(s.MedianListPrice) }),
+			"medianSalePrice":    gqlField(graphql.Float, func(s dbgen.MarketStatsMonthly) any { return gqlFloat(s.MedianSalePrice) }),
+			"medianPricePerSqft": gqlField(graphql.Float, func(s dbgen.MarketStatsMonthly) any { return gqlFloat(s.MedianPricePerSqft) }),
+			"medianDaysOnMarket": gqlField(graphql.Float, func(s dbgen.MarketStatsMonthly) any { return gqlFloat(s.MedianDaysOnMarket) }),
+			"saleToListRatio":    gqlField(graphql.Float, func(s dbgen.MarketStatsMonthly) any { return gqlFloat(s.SaleToListRatio) }),
+		},
+	})
+
+	// Properties, listings and realtors refer to each other, so their fields
+	// are thunks.
+	var property, listing, realtor *graphql.Object
+	property = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Property",
+		Description: "A property, with the listings that share its ID.",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"propertyId": gqlField(graphql.NewNonNull(graphql.Int), func(p *gqlProperty) any { return p.propertyID }),
+				"listings": {
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(listing))),
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						prop := p.Source.(*gqlProperty)
+						ls, err := prop.siblings.listings.load(p.Context, prop.propertyID)
+						return gqlList(ls), err
+					},
+				},
+				"events": {
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(event))),
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						prop := p.Source.(*gqlProperty)
+						es, err := prop.siblings.events.load(p.Context, prop.propertyID)
+						return gqlList(es), err
+					},
+				},
+				"priceHistory": {
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(pricePoint))),
+					Description: "The price of every event with one, oldest first.",
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						prop := p.Source.(*gqlProperty)
+						es, err := prop.siblings.events.load(p.Context, prop.propertyID)
+						if err != nil {
+							return nil, err
+						}
+						res := []dbgen.PropertyEvent{}
+						for _, e := range es {
+							if e.Price != 0 {
+								res = append(res, e)
+							}
+						}
+						return res, nil
+					},
+				},
+			}
+		}),
+	})
+
+	listing = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Listing",
+		Description: "A listing of a property, with its latest price.",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"propertyId":       gqlField(graphql.NewNonNull(graphql.Int), func(l *gqlListing) any { return l.PropertyID }),
+				"listingId":        gqlField(graphql.NewNonNull(graphql.Int), func(l *gqlListing) any { return l.ListingID }),
+				"price":            gqlField(graphql.NewNonNull(graphql.Int), func(l *gqlListing) any { return l.Price }),
+				"url":              gqlField(graphql.String, func(l *gqlListing) any { return gqlText(l.URL) }),
+				"zipcode":          gqlField(graphql.String, func(l *gqlListing) any { return gqlText(l.Zipcode) }),
+				"city":             gqlField(graphql.String, func(l *gqlListing) any { return gqlText(l.City) }),
+				"state":            gqlField(graphql.String, func(l *gqlListing) any { return gqlText(l.State) }),
+				"lastScrapeTs":     gqlField(graphql.DateTime, func(l *gqlListing) any { return gqlTime(l.LastScrapeTS) }),
+				"lastScrapeStatus": gqlField(graphql.NewNonNull(graphql.String), func(l *gqlListing) any { return l.LastScrapeStatus }),
+				"location": gqlField(location, func(l *gqlListing) any {
+					if l.Location == nil {
+						return nil
+					}
+					return l
+				}),
+				"imageUrls": {
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+					Description: "URLs of the listing's images, pointing at our mirror where they've been mirrored.",
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						l := p.Source.(*gqlListing)
+						m, err := l.siblings.images.load(p.Context, listingKey{l.PropertyID, l.ListingID})
+						return gqlList(m.ImageURLs), err
+					},
+				},
+				"thumbnailUrls": {
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+					Description: "URLs of the listing's thumbnails, pointing at our mirror where they've been mirrored.",
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						l := p.Source.(*gqlListing)
+						m, err := l.siblings.images.load(p.Context, listingKey{l.PropertyID, l.ListingID})
+						return gqlList(m.ThumbnailURLs), err
+					},
+				},
+				"property": gqlField(graphql.NewNonNull(property), func(l *gqlListing) any {
+					return l.siblings.properties[l.PropertyID]
+				}),
+				"events": {
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(event))),
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						l := p.Source.(*gqlListing)
+						es, err := l.siblings.events.load(p.Context, l.PropertyID)
+						if err != nil {
+							return nil, err
+						}
+						res := []dbgen.PropertyEvent{}
+						for _, e := range es {
+							if e.ListingID == l.ListingID {
+								res = append(res, e)
+							}
+						}
+						return res, nil
+					},
+				},
+				"realtors": {
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(realtor))),
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						l := p.Source.(*gqlListing)
+						rs, err := l.siblings.realtors.load(p.Context, listingKey{l.PropertyID, l.ListingID})
+						return gqlList(rs), err
+					},
+				},
+			}
+		}),
+	})
+
+	realtor = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Realtor",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"realtorId": gqlField(graphql.NewNonNull(graphql.Int), func(r *gqlRealtor) any { return r.RealtorID }),
+				"name":      gqlField(graphql.NewNonNull(graphql.String), func(r *gqlRealtor) any { return r.Name }),
+				"company":   gqlField(graphql.NewNonNull(graphql.String), func(r *gqlRealtor) any { return r.Company }),
+				"listings": {
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(listing))),
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						r := p.Source.(*gqlRealtor)
+						ls, err := r.siblings.listings.load(p.Context, r.RealtorID)
+						return gqlList(ls), err
+					},
+				},
+				"stats": {
+					Type:        realtorStats,
+					Description: "Null if the realtor has no listings.",
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						r := p.Source.(*gqlRealtor)
+						s, err := r.siblings.stats.load(p.Context, r.RealtorID)
+						if s == nil || err != nil {
+							return nil, err
+						}
+						return s, nil
+					},
+				},
+				"priceHistogram": {
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(priceBin))),
+					Description: "Histogram of the prices of the realtor's listings.",
+					Args: graphql.FieldConfigArgument{
+						"bins": {Type: graphql.Int, DefaultValue: 10},
+					},
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						r := p.Source.(*gqlRealtor)
+						n := p.Args["bins"].(int)
+						if n < 1 || n > graphQLMaxKeys {
+							return nil, &graphQLError{code: ErrorCodeValidationFailed, msg: "bins: must be between 1 and 100"}
+						}
+						ls, err := r.siblings.listings.load(p.Context, r.RealtorID)
+						if err != nil {
+							return nil, err
+						}
+						bins := []PriceBin{}
+						if len(ls) == 0 {
+							return bins, nil
+						}
+						prices := []float64{}
+						for _, l := range ls {
+							prices = append(prices, float64(l.Price))
+						}
+						// a histogram needs a span of prices to bin
+						if slices.Min(prices) == slices.Max(prices) {
+							return append(bins, PriceBin{Price: prices[0], Count: len(prices)}), nil
+						}
+						bs, err := histogram.BSExactSpan(n)(prices)
+						if err != nil {
+							return nil, err
+						}
+						h, err := histogram.Hist(prices, bs, histogram.DefaultBucketer)
+						if err != nil {
+							return nil, err
+						}
+						for _, b := range h.Buckets {
+							bins = append(bins, PriceBin{Price: b.Min, Count: b.Count})
+						}
+						return bins, nil
+					},
+				},
+			}
+		}),
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"property": {
+				Type:        property,
+				Description: "Null if the property has no listings.",
+				Args: graphql.FieldConfigArgument{
+					"propertyId": {Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					prop := newProperties(q, []int32{int32(p.Args["propertyId"].(int))})[0]
+					ls, err := prop.siblings.listings.load(p.Context, prop.propertyID)
+					if len(ls) == 0 || err != nil {
+						return nil, err
+					}
+					return prop, nil
+				},
+			},
+			"properties": {
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(property))),
+				Description: "The properties with listings among those with the given IDs (at most 100).",
+				Args: graphql.FieldConfigArgument{
+					"propertyIds": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int)))},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					ids, err := gqlIDs(p, "propertyIds")
+					if err != nil {
+						return nil, err
+					}
+					res := []*gqlProperty{}
+					for _, prop := range newProperties(q, ids) {
+						ls, err := prop.siblings.listings.load(p.Context, prop.propertyID)
+						if err != nil {
+							return nil, err
+						}
+						if len(ls) > 0 {
+							res = append(res, prop)
+						}
+					}
+					return res, nil
+				},
+			},
+			"listing": {
+				Type: listing,
+				Args: graphql.FieldConfigArgument{
+					"propertyId": {Type: graphql.NewNonNull(graphql.Int)},
+					"listingId":  {Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					row, err := q.GetPropertyWithPrice(p.Context, dbgen.GetPropertyWithPriceParams{
+						PropertyID: int32(p.Args["propertyId"].(int)),
+						ListingID:  int32(p.Args["listingId"].(int)),
+					})
+					if errors.Is(err, pgx.ErrNoRows) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, err
+					}
+					return newListings(q, []dbgen.PropertyPrice{row})[0], nil
+				},
+			},
+			"realtor": {
+				Type: realtor,
+				Args: graphql.FieldConfigArgument{
+					"realtorId": {Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					rows, err := q.GetRealtorsByIDs(p.Context, []int32{int32(p.Args["realtorId"].(int))})
+					if len(rows) == 0 || err != nil {
+						return nil, err
+					}
+					return newRealtors(q, rows)[0], nil
+				},
+			},
+			"realtors": {
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(realtor))),
+				Description: "Realtors whose name or company contains the search term (at most 100).",
+				Args: graphql.FieldConfigArgument{
+					"search": {Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					rows, err := q.SearchRealtors(p.Context, p.Args["search"].(string))
+					if err != nil {
+						return nil, err
+					}
+					return newRealtors(q, rows), nil
+				},
+			},
+			"marketStats": {
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(marketStats))),
+				Description: "Monthly stats of a zipcode, or of a city (which requires state), over a time range that defaults to the last year.",
+				Args: graphql.FieldConfigArgument{
+					"zipcode": {Type: graphql.String},
+					"city":    {Type: graphql.String},
+					"state":   {Type: graphql.String},
+					"start":   {Type: graphql.String, Description: "A date or an RFC 3339 timestamp."},
+					"end":     {Type: graphql.String, Description: "A date or an RFC 3339 timestamp."},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					zipcode, _ := p.Args["zipcode"].(string)
+					city, _ := p.Args["city"].(string)
+					state, _ := p.Args["state"].(string)
+					start, _ := p.Args["start"].(string)
+					end, _ := p.Args["end"].(string)
+					ts, te, err := ParseTimeRange(start, end)
+					if err != nil {
+						return nil, &graphQLError{code: ErrorCodeValidationFailed, msg: err.Error()}
+					}
+					params := dbgen.GetMarketStatsParams{
+						StartTs: pgtype.Timestamp{Time: ts, Valid: true},
+						EndTs:   pgtype.Timestamp{Time: te, Valid: true},
+					}
+					switch {
+					case zipcode != "":
+						params.RegionType, params.Region = "zipcode", zipcode
+					case city != "" && state != "":
+						params.RegionType, params.Region = "city", city+", "+state
+					default:
+						return nil, &graphQLError{code: ErrorCodeValidationFailed, msg: "zipcode, or city and state, must be set"}
+					}
+					stats, err := q.GetMarketStats(p.Context, params)
+					return gqlList(stats), err
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Audit log listings return this many entries unless a limit is supplied.
+const defaultAuditLogLimit = 100
+
+// Parses the key_id query param.
+func parseAPIKeyID(r *http.Request) (int64, error) {
+	var params APIKeyIDQuery
+	err := bindRequest(r, &params)
+	return params.KeyID, err
+}
+
+// Returns the API key with the supplied key_id, or lists the API keys
+// (optionally only those of the supplied owner). Keys are never returned, only
+// their prefixes.
+func handleAPIKeyGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params APIKeyQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		if params.KeyID != 0 {
+			k, err := q.GetAPIKey(r.Context(), params.KeyID)
+			if err == pgx.ErrNoRows {
+				writeEmptyResultError(w)
+				return
+			}
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(k)
+			return
+		}
+		ks, err := q.ListAPIKeys(r.Context(), params.Owner)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if ks == nil {
+			ks = []dbgen.APIKey{}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ks)
+	}
+}
+
+// Creates an API key. The response is the only time the key is revealed.
+func handleAPIKeyPost(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body PostAPIKeyBody
+		err := decodeJSONBody(r, &body)
+		if err != nil {
+			var mr *MalformedRequest
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
+			return
+		}
+		if err = validateBody(&body); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		if err = validateRoleScopes(body.Role, body.Scopes); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		if body.Scopes == nil {
+			body.Scopes = []string{}
+		}
+		var expires pgtype.Timestamp
+		if body.TTL != "" {
+			ttl, err := time.ParseDuration(body.TTL)
+			if err != nil || ttl <= 0 {
+				writeBadRequestError(w, invalidField("ttl"))
+				return
+			}
+			expires = pgtype.Timestamp{Time: time.Now().Add(ttl), Valid: true}
+		}
+		key, prefix, hash, err := generateAPIKey()
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		k, err := q.CreateAPIKey(r.Context(), dbgen.CreateAPIKeyParams{
+			Name:      body.Name,
+			Owner:     body.Owner,
+			Prefix:    prefix,
+			KeyHash:   hash,
+			Role:      body.Role,
+			Scopes:    body.Scopes,
+			ExpiresTS: expires,
+		})
+		if err != nil {
+			if isUserError(err) {
+				writeBadRequestError(w, fmt.Errorf("bad data: %w", err))
+				return
+			}
+			writeInternalError(l, w, err)
+			return
+		}
+		l.Info("created api key", "key_id", k.KeyID, "name", k.Name, "owner", k.Owner)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(APIKeyResponse{APIKey: k, Key: key})
+	}
+}
+
+// Revokes the API key with the supplied key_id. Requests made with a revoked
+// key are rejected immediately.
+func handleAPIKeyRevoke(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseAPIKeyID(r)
+		if err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		n, err := q.RevokeAPIKey(r.Context(), id)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if n == 0 {
+			writeEmptyResultError(w)
+			return
+		}
+		l.Info("revoked api key", "key_id", id)
+		writeOK(w)
+	}
+}
+
+// Replaces the key of the API key with the supplied key_id and returns the new
+// key. The name, owner, scopes, and expiry are kept, and the old key stops
+// working immediately.
+func handleAPIKeyRotate(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseAPIKeyID(r)
+		if err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		key, prefix, hash, err := generateAPIKey()
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		k, err := q.RotateAPIKey(r.Context(), dbgen.RotateAPIKeyParams{
+			Prefix:  prefix,
+			KeyHash: hash,
+			KeyID:   id,
+		})
+		if err == pgx.ErrNoRows {
+			writeEmptyResultError(w)
+			return
+		}
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		l.Info("rotated api key", "key_id", k.KeyID)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(APIKeyResponse{APIKey: k, Key: key})
+	}
+}
+
+// Lists the most recent audit log entries, optionally only those made with the
+// supplied key_id or by the supplied principal, or within the supplied
+// duration.
+func handleAuditLogGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := AuditLogQuery{Limit: defaultAuditLogLimit}
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		var since time.Time
+		if params.Duration != 0 {
+			since = time.Now().Add(-params.Duration)
+		}
+		res, err := q.ListAuditLog(r.Context(), dbgen.ListAuditLogParams{
+			KeyID:     params.KeyID,
+			Principal: params.Principal,
+			Since:     pgtype.Timestamp{Time: since, Valid: true},
+			RowLimit:  int32(params.Limit),
+		})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if res == nil {
+			res = []dbgen.AuditLog{}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(res)
+	}
+}
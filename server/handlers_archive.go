@@ -0,0 +1,292 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Pagination defaults for listing archive entries.
+const defaultPayloadArchiveLimit = 1000
+
+// Writes a presigned PUT URL for archiving a payload with the supplied SHA-256
+// hash. Payloads are content addressed, so if the payload is already in the
+// blob store this writes archived=true and no URL; callers should skip the
+// upload and just record an archive entry. The store is checked rather than
+// the archive entries, since an upload can fail after it was presigned.
+func handlePayloadArchivePresign(l *slog.Logger, bs BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params HashQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		hash := params.Hash
+		archived, err := bs.Exists(r.Context(), getPayloadKey(hash))
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if archived {
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(PresignPayloadResponse{Archived: true})
+			return
+		}
+		u, err := bs.PresignPut(r.Context(), getPayloadKey(hash), blobPresignExpiry)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(PresignPayloadResponse{URL: u})
+	}
+}
+
+// Writes the archive entries matching the query. The property_id, listing_id,
+// and start/end range are optional; the range defaults to the trailing 12
+// months. Results are paginated with limit and offset.
+func handlePayloadArchiveGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := PayloadArchiveQuery{Limit: defaultPayloadArchiveLimit}
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		ts, te, err := ParseTimeRange(params.Start, params.End)
+		if err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		es, err := q.GetPayloadArchiveEntries(r.Context(), dbgen.GetPayloadArchiveEntriesParams{
+			PropertyID: params.PropertyID,
+			ListingID:  params.ListingID,
+			StartTs:    pgtype.Timestamp{Time: ts, Valid: true},
+			EndTs:      pgtype.Timestamp{Time: te, Valid: true},
+			RowLimit:   int32(params.Limit),
+			RowOffset:  int32(params.Offset),
+		})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if es == nil {
+			es = []dbgen.PayloadArchive{}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(es)
+	}
+}
+
+// Records archive entries mapping a (property, endpoint, scrape time) to the
+// hash of the archived payload. Payloads must be uploaded before their entries
+// are recorded, so the archive never refers to a payload that isn't stored.
+func handlePayloadArchivePost(l *slog.Logger, p *pgxpool.Pool, bs BlobStore, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var es []dbgen.CreatePayloadArchiveEntryParams
+		err := decodeJSONBody(r, &es)
+		if err != nil {
+			var mr *MalformedRequest
+			var pr *time.ParseError
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else if errors.As(err, &pr) {
+				writeBadRequestError(w, fmt.Errorf("bad timestamp format: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
+			return
+		}
+
+		// validate each entry, if any are invalid, return early with 400
+		for _, e := range es {
+			if e.PropertyID == 0 || e.ListingID == 0 || e.Endpoint == "" || !e.ScrapeTS.Valid {
+				writeBadRequestError(w, fmt.Errorf("must set property_id, listing_id, endpoint, and scrape_ts"))
+				return
+			}
+			if !isSHA256Hex(e.Hash) {
+				writeBadRequestError(w, fmt.Errorf("hash must be a hex encoded SHA-256 hash"))
+				return
+			}
+		}
+		checked := map[string]bool{}
+		for _, e := range es {
+			if checked[e.Hash] {
+				continue
+			}
+			stored, err := bs.Exists(r.Context(), getPayloadKey(e.Hash))
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			if !stored {
+				writeProblem(w, newProblem(http.StatusBadRequest, ErrorCodeInvalidData, fmt.Sprintf("payload %s hasn't been uploaded", e.Hash)))
+				return
+			}
+			checked[e.Hash] = true
+		}
+
+		tx, err := p.Begin(r.Context())
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		defer tx.Rollback(r.Context())
+		q = q.WithTx(tx)
+
+		var count int64
+		for _, e := range es {
+			n, err := q.CreatePayloadArchiveEntry(r.Context(), e)
+			if err != nil {
+				if isPGError(err, pgErrorForeignKeyViolation) {
+					writePGError(w, pgErrorForeignKeyViolation, "archive entry must map to an existing property")
+					return
+				}
+				writeInternalError(l, w, err)
+				return
+			}
+			count += n
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(DefaultJSONResponse{Message: fmt.Sprintf("%d / %d", count, len(es))})
+	}
+}
+
+// Writes the archived payload with the supplied hash.
+func handlePayloadArchiveObjectGet(l *slog.Logger, bs BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params HashQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		hash := params.Hash
+		rc, err := bs.Get(r.Context(), getPayloadKey(hash))
+		if err != nil {
+			if errors.Is(err, ErrBlobNotFound) {
+				writeEmptyResultError(w)
+				return
+			}
+			writeInternalError(l, w, err)
+			return
+		}
+		defer rc.Close()
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, rc)
+	}
+}
+
+// Writes a short-lived presigned GET URL for the archived payload with the
+// supplied hash.
+func handlePayloadArchiveURLGet(l *slog.Logger, bs BlobStore, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params HashQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		hash := params.Hash
+		archived, err := q.IsPayloadArchived(r.Context(), hash)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if !archived {
+			writeEmptyResultError(w)
+			return
+		}
+		expires := time.Now().Add(blobPresignExpiry)
+		u, err := bs.PresignGet(r.Context(), getPayloadKey(hash), blobPresignExpiry)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(PresignedURLResponse{URL: u, Expires: expires})
+	}
+}
+
+// Writes the differences between the payloads a property's endpoint returned
+// at two points in time. The from and to params accept a date (2006-01-02) or
+// an RFC3339 timestamp and resolve to the latest scrape at or before that time
+// (for dates, the latest scrape on that day). The to param defaults to now.
+func handlePayloadArchiveDiffGet(l *slog.Logger, bs BlobStore, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params PayloadDiffQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		from, err := parseArchiveTime(params.From)
+		if err != nil {
+			writeBadRequestError(w, invalidField("from"))
+			return
+		}
+		to := time.Now()
+		if params.To != "" {
+			to, err = parseArchiveTime(params.To)
+			if err != nil {
+				writeBadRequestError(w, invalidField("to"))
+				return
+			}
+		}
+
+		entries := []dbgen.PayloadArchive{}
+		payloads := [][]byte{}
+		for _, ts := range []time.Time{from, to} {
+			e, err := q.GetPayloadArchiveEntryAt(r.Context(), dbgen.GetPayloadArchiveEntryAtParams{
+				PropertyID: params.PropertyID,
+				ListingID:  params.ListingID,
+				Endpoint:   params.Endpoint,
+				Ts:         pgtype.Timestamp{Time: ts, Valid: true},
+			})
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					writeEmptyResultError(w)
+					return
+				}
+				writeInternalError(l, w, err)
+				return
+			}
+			b, err := readBlob(r.Context(), bs, getPayloadKey(e.Hash))
+			if err != nil {
+				if errors.Is(err, ErrBlobNotFound) {
+					writeEmptyResultError(w)
+					return
+				}
+				writeInternalError(l, w, err)
+				return
+			}
+			entries = append(entries, e)
+			payloads = append(payloads, b)
+		}
+		changes, err := diffJSON(payloads[0], payloads[1])
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(PayloadDiffResponse{From: entries[0], To: entries[1], Changes: changes})
+	}
+}
+
+// Parses a date (2006-01-02) or RFC3339 timestamp. Dates resolve to the end of
+// the day so they include every scrape on that day.
+func parseArchiveTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Microsecond), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Sources of AVM estimates. Estimates captured on each scrape come from the
+// AVMDetails payload, while backfilled estimates come from AVMHistorical.
+const AVMSourceDetails = "avm_details"
+const AVMSourceHistorical = "avm_historical"
+
+// Writes the AVM estimate time series for a property. The listing_id is
+// optional.
+func handleAVMEstimatesGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params EstimatesQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		es, err := q.GetAVMEstimates(r.Context(), dbgen.GetAVMEstimatesParams{PropertyID: params.PropertyID, ListingID: params.ListingID})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if es == nil {
+			es = []dbgen.AVMEstimate{}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(es)
+	}
+}
+
+// Bulk creates AVM estimates. Estimates that already exist are ignored, so
+// workers can safely re-upload overlapping history.
+func handleAVMEstimatesPost(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var es []dbgen.CreateAVMEstimateParams
+		err := decodeJSONBody(r, &es)
+		if err != nil {
+			var mr *MalformedRequest
+			var pr *time.ParseError
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else if errors.As(err, &pr) {
+				writeBadRequestError(w, fmt.Errorf("bad timestamp format: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
+			return
+		}
+
+		// validate each estimate, if any are invalid, return 400 listing them all
+		var ve ValidationError
+		for i, e := range es {
+			ve.require(fmt.Sprintf("[%d].property_id", i), e.PropertyID != 0)
+			ve.require(fmt.Sprintf("[%d].listing_id", i), e.ListingID != 0)
+			ve.require(fmt.Sprintf("[%d].estimate_ts", i), e.EstimateTS.Valid)
+			if e.Value <= 0 {
+				ve.add(fmt.Sprintf("[%d].value", i), FieldCodeInvalid, "must be positive")
+			}
+			if e.Source != AVMSourceDetails && e.Source != AVMSourceHistorical {
+				ve.add(fmt.Sprintf("[%d].source", i), FieldCodeInvalid, fmt.Sprintf("must be one of: %s, %s", AVMSourceDetails, AVMSourceHistorical))
+			}
+		}
+		if err = ve.err(); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+
+		tx, err := p.Begin(r.Context())
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		defer tx.Rollback(r.Context())
+		q = q.WithTx(tx)
+
+		var count int64
+		for _, e := range es {
+			n, err := q.CreateAVMEstimate(r.Context(), e)
+			if err != nil {
+				if isPGError(err, pgErrorForeignKeyViolation) {
+					writePGError(w, pgErrorForeignKeyViolation, "estimate must map to an existing property")
+					return
+				}
+				writeInternalError(l, w, err)
+				return
+			}
+			count += n
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(DefaultJSONResponse{Message: fmt.Sprintf("%d / %d", count, len(es))})
+	}
+}
+
+// Writes a comparison of the AVM estimate at listing time with the eventual
+// sale price. By default this writes one row per sold listing, optionally
+// filtered by property_id or zipcode. Supplying group_by=zipcode or
+// group_by=realtor aggregates the rows instead; the realtor grouping can be
+// filtered by realtor_id or name.
+func handleAVMAccuracyGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params AVMAccuracyQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		zipcode := params.Zipcode
+
+		var res any
+		switch params.GroupBy {
+		case "":
+			rows, err := q.GetAVMAccuracy(r.Context(), dbgen.GetAVMAccuracyParams{
+				PropertyID: params.PropertyID,
+				Zipcode:    pgtype.Text{String: zipcode, Valid: true},
+			})
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			if rows == nil {
+				rows = []dbgen.AVMAccuracy{}
+			}
+			res = rows
+		case "zipcode":
+			rows, err := q.GetAVMAccuracyByZipcode(r.Context(), pgtype.Text{String: zipcode, Valid: true})
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			if rows == nil {
+				rows = []dbgen.GetAVMAccuracyByZipcodeRow{}
+			}
+			res = rows
+		case "realtor":
+			rows, err := q.GetAVMAccuracyByRealtor(r.Context(), dbgen.GetAVMAccuracyByRealtorParams{
+				RealtorID: params.RealtorID,
+				Name:      params.Name,
+			})
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			if rows == nil {
+				rows = []dbgen.GetAVMAccuracyByRealtorRow{}
+			}
+			res = rows
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(res)
+	}
+}
@@ -3,12 +3,13 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"runtime"
 	"time"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -17,25 +18,40 @@ func writeOK(w http.ResponseWriter) {
 	json.NewEncoder(w).Encode(DefaultJSONResponse{Message: "ok"})
 }
 
+// Writes an internal error problem and logs the error. Errors caused by bad
+// user submitted data that reached the database are written as the
+// corresponding 4xx problem instead.
 func writeInternalError(l *slog.Logger, w http.ResponseWriter, e error) {
+	var pgErr *pgconn.PgError
+	if errors.As(e, &pgErr) {
+		if p, ok := problemFromPGError(pgErr, e.Error()); ok {
+			writeProblem(w, p)
+			return
+		}
+	}
 	var pcs [1]uintptr
 	runtime.Callers(2, pcs[:]) // skip [Callers, Infof]
 	r := slog.NewRecord(time.Now(), slog.LevelError, e.Error(), pcs[0])
+	r.AddAttrs(slog.String("request_id", w.Header().Get(requestIDHeader)))
 	_ = l.Handler().Handle(context.Background(), r)
-	w.WriteHeader(http.StatusInternalServerError)
-	json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "internal error"})
+	writeProblem(w, newProblem(http.StatusInternalServerError, ErrorCodeInternal, "internal error"))
 }
 
+// Writes the problem describing err; see problemFromError.
 func writeBadRequestError(w http.ResponseWriter, err error) {
-	w.WriteHeader(http.StatusBadRequest)
-	resp := DefaultJSONResponse{Error: err.Error()}
-	json.NewEncoder(w).Encode(resp)
+	writeProblem(w, problemFromError(err))
 }
 
 func writeEmptyResultError(w http.ResponseWriter) {
-	w.WriteHeader(http.StatusNotFound)
-	resp := DefaultJSONResponse{Error: "empty result set"}
-	json.NewEncoder(w).Encode(resp)
+	writeProblem(w, newProblem(http.StatusNotFound, ErrorCodeNotFound, "empty result set"))
+}
+
+func writeUnauthorizedError(w http.ResponseWriter, msg string) {
+	writeProblem(w, newProblem(http.StatusUnauthorized, ErrorCodeUnauthorized, msg))
+}
+
+func writeForbiddenError(w http.ResponseWriter, msg string) {
+	writeProblem(w, newProblem(http.StatusForbidden, ErrorCodeForbidden, msg))
 }
 
 // handlePing pings the database
@@ -50,35 +66,53 @@ func handlePing(l *slog.Logger, p *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
-// handleGetToken returns a token
-func handleIssueToken(l *slog.Logger) http.HandlerFunc {
+// handleWhoAmI returns the principal the request was authenticated as
+func handleWhoAmI() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeUnauthorizedError(w, "unauthorized")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+// handleJWKS returns the public keys that verify server-issued tokens
+func handleJWKS(kr *KeyRing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(kr.JWKS())
+	}
+}
+
+// handleIssueToken returns a token for the role and scopes in the query
+// params. The Authorization header must be the server's secret key.
+func handleIssueToken(l *slog.Logger, kr *KeyRing) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		t := r.Header.Get("Authorization")
 		if t == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "must supply authorization header"})
+			writeBadRequestError(w, missingField("Authorization"))
 			return
 		}
-		email := r.URL.Query().Get("email")
-		if email == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "must supply email"})
+		if !kr.isSecret(t) {
+			writeUnauthorizedError(w, "not authorized")
 			return
 		}
-		if t != getSecretKey() {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "not authorized"})
+		var params IssueTokenQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
 			return
 		}
-		sc := jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(2 * 7 * 24 * time.Hour).Unix(),
-		}
-		c := authJWTClaims{
-			StandardClaims: sc,
-			Email:          email,
+		email, role, scopes := params.Email, params.Role, params.Scopes
+		token, err := kr.IssueToken(email, role, scopes, params.TTL)
+		if err != nil {
+			writeBadRequestError(w, err)
+			return
 		}
-		token, _ := generateAccessToken(c)
-		l.Warn("issuing sudo token", "token", token)
+		l.Warn("issuing token", "email", email, "role", role, "scopes", scopes)
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(DefaultJSONResponse{Message: token})
 	}
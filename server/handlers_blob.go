@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// Writes the request body to the local blob store. The request must carry a
+// valid signature from LocalBlobStore.PresignPut.
+func handleBlobPut(l *slog.Logger, bs *LocalBlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		if err := bs.verify(r, key); err != nil {
+			writeForbiddenError(w, err.Error())
+			return
+		}
+		if err := bs.Put(key, r.Body); err != nil {
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				writeBadRequestError(w, err)
+				return
+			}
+			writeInternalError(l, w, err)
+			return
+		}
+		writeOK(w)
+	}
+}
+
+// Writes an object from the local blob store. The request must carry a valid
+// signature for a GET of the object.
+func handleBlobGet(l *slog.Logger, bs *LocalBlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		if err := bs.verify(r, key); err != nil {
+			writeForbiddenError(w, err.Error())
+			return
+		}
+		rc, err := bs.Get(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, ErrBlobNotFound) {
+				writeEmptyResultError(w)
+				return
+			}
+			writeInternalError(l, w, err)
+			return
+		}
+		defer rc.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, rc)
+	}
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Sources of comps. Comps found by both the listing_cycle search and Redfin's
+// similar sold results are labeled with compSourceBoth.
+const compSourceLocal = "local"
+const compSourceRedfin = "redfin"
+const compSourceBoth = "both"
+
+// Defaults for the comps search window.
+const defaultCompRadiusMeters = 1609.0
+const defaultCompMonths = 6
+const defaultCompLimit = 10
+
+// Relative weights of each component of the comp similarity score. A component
+// only contributes when the attribute is known for both the subject and comp.
+const compWeightDistance = 3.0
+const compWeightRecency = 2.0
+const compWeightSqft = 3.0
+const compWeightBeds = 1.0
+const compWeightBaths = 1.0
+const compWeightYearBuilt = 1.0
+
+// Comps outside these tolerances are dropped when the attribute is known for
+// both the subject and the comp.
+const compMaxSqftDiff = 0.3
+const compMaxBedsDiff = 2.0
+
+// Writes comparable sales for the subject property along with a price
+// estimate. Candidates are recent sales within radius meters (default 1 mile)
+// in the last months months (default 6), merged with Redfin's similar sold
+// homes when those have been scraped. Candidates with dissimilar attributes
+// are dropped, the rest are scored, and the top limit comps are used for a
+// score weighted estimate of the subject's price. If no sales are similar
+// enough, the comps are empty and there's no estimate.
+func handleCompsGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := CompsQuery{
+			Radius: defaultCompRadiusMeters,
+			Months: defaultCompMonths,
+			Limit:  defaultCompLimit,
+		}
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+
+		_, err := q.GetPropertyBasic(r.Context(), dbgen.GetPropertyBasicParams{PropertyID: params.PropertyID, ListingID: params.ListingID})
+		if err == pgx.ErrNoRows {
+			msg := fmt.Sprintf("property does not exist (pid: %d, lid: %d)", params.PropertyID, params.ListingID)
+			writeProblem(w, newProblem(http.StatusNotFound, ErrorCodeNotFound, msg))
+			return
+		}
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+
+		// the subject details are optional; without them, comps are scored on
+		// distance and recency alone
+		subject, err := q.GetPropertyDetails(r.Context(), dbgen.GetPropertyDetailsParams{PropertyID: params.PropertyID, ListingID: params.ListingID})
+		if err != nil && err != pgx.ErrNoRows {
+			writeInternalError(l, w, err)
+			return
+		}
+
+		start := time.Now().AddDate(0, -params.Months, 0)
+		candidates, err := q.GetCompCandidates(r.Context(), dbgen.GetCompCandidatesParams{
+			Radius:     params.Radius,
+			PropertyID: params.PropertyID,
+			ListingID:  params.ListingID,
+			StartTs:    pgtype.Timestamp{Time: start, Valid: true},
+		})
+		if err != nil && err != pgx.ErrNoRows {
+			writeInternalError(l, w, err)
+			return
+		}
+		similar, err := q.GetSimilarSold(r.Context(), dbgen.GetSimilarSoldParams{PropertyID: params.PropertyID, ListingID: params.ListingID})
+		if err != nil && err != pgx.ErrNoRows {
+			writeInternalError(l, w, err)
+			return
+		}
+
+		comps := mergeComps(candidates, similar, start)
+		scored := []Comp{}
+		for _, c := range comps {
+			if !isSimilarComp(subject, c) {
+				continue
+			}
+			c.Score = scoreComp(subject, c, params.Radius, start)
+			c.AdjustedPrice = adjustCompPrice(subject, c)
+			scored = append(scored, c)
+		}
+		slices.SortFunc(scored, func(a, b Comp) int {
+			switch {
+			case a.Score > b.Score:
+				return -1
+			case a.Score < b.Score:
+				return 1
+			default:
+				return 0
+			}
+		})
+		if len(scored) > params.Limit {
+			scored = scored[:params.Limit]
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(CompsResponse{
+			PropertyID: params.PropertyID,
+			ListingID:  params.ListingID,
+			Estimate:   estimateFromComps(scored),
+			Comps:      scored,
+		})
+	}
+}
+
+// Replaces the Redfin similar sold homes for a property.
+func handleSimilarSoldPut(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body PutSimilarSoldBody
+		err := decodeJSONBody(r, &body)
+		if err != nil {
+			var mr *MalformedRequest
+			var pr *time.ParseError
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else if errors.As(err, &pr) {
+				writeBadRequestError(w, fmt.Errorf("bad timestamp format: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
+			return
+		}
+		if err = validateBody(&body); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		for _, h := range body.Homes {
+			if h.SimilarPropertyID == 0 || h.Price <= 0 {
+				writeBadRequestError(w, fmt.Errorf("must set similar_property_id and a positive price for each home"))
+				return
+			}
+		}
+
+		tx, err := p.Begin(r.Context())
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		defer tx.Rollback(r.Context())
+		q = q.WithTx(tx)
+
+		err = q.DeleteSimilarSold(r.Context(), dbgen.DeleteSimilarSoldParams{PropertyID: body.PropertyID, ListingID: body.ListingID})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		for _, h := range body.Homes {
+			h.PropertyID = body.PropertyID
+			h.ListingID = body.ListingID
+			if err = q.CreateSimilarSold(r.Context(), h); err != nil {
+				if isPGError(err, pgErrorForeignKeyViolation) {
+					writePGError(w, pgErrorForeignKeyViolation, "similar sold homes must map to an existing property")
+					return
+				}
+				writeInternalError(l, w, err)
+				return
+			}
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		writeOK(w)
+	}
+}
+
+// Combines the listing_cycle candidates with Redfin's similar sold homes. A
+// home present in both keeps the local attributes, which are more complete.
+// Redfin homes that sold before start are dropped; they aren't filtered on
+// distance since Redfin may have good reasons to reach further.
+func mergeComps(candidates []dbgen.GetCompCandidatesRow, similar []dbgen.GetSimilarSoldRow, start time.Time) []Comp {
+	comps := []Comp{}
+	idx := map[int32]int{}
+	for _, c := range candidates {
+		idx[c.PropertyID] = len(comps)
+		comps = append(comps, Comp{
+			PropertyID:   c.PropertyID,
+			ListingID:    c.ListingID,
+			URL:          c.URL,
+			Source:       compSourceLocal,
+			SaleTS:       c.SaleTS,
+			SalePrice:    c.SalePrice,
+			DistanceM:    c.DistanceM,
+			Beds:         c.Beds,
+			Baths:        c.Baths,
+			Sqft:         c.Sqft,
+			YearBuilt:    c.YearBuilt,
+			PropertyType: c.PropertyType,
+		})
+	}
+	for _, s := range similar {
+		if i, ok := idx[s.SimilarPropertyID]; ok {
+			comps[i].Source = compSourceBoth
+			continue
+		}
+		if s.SaleTS.Valid && s.SaleTS.Time.Before(start) {
+			continue
+		}
+		comps = append(comps, Comp{
+			PropertyID: s.SimilarPropertyID,
+			ListingID:  s.SimilarListingID.Int32,
+			URL:        s.URL,
+			Source:     compSourceRedfin,
+			SaleTS:     s.SaleTS,
+			SalePrice:  s.Price,
+			DistanceM:  s.DistanceM,
+			Beds:       s.Beds,
+			Baths:      s.Baths,
+			Sqft:       s.Sqft,
+		})
+	}
+	return comps
+}
+
+// Reports whether the comp is similar enough to the subject to be used. Only
+// attributes known for both are compared.
+func isSimilarComp(subject dbgen.PropertyDetail, c Comp) bool {
+	if subject.PropertyType.Valid && c.PropertyType.Valid && subject.PropertyType.String != c.PropertyType.String {
+		return false
+	}
+	if subject.Sqft.Valid && c.Sqft.Valid && subject.Sqft.Int32 > 0 {
+		if relDiff(float64(c.Sqft.Int32), float64(subject.Sqft.Int32)) > compMaxSqftDiff {
+			return false
+		}
+	}
+	if subject.Beds.Valid && c.Beds.Valid {
+		if math.Abs(float64(c.Beds.Float32-subject.Beds.Float32)) > compMaxBedsDiff {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a similarity score in [0, 1]; higher is more similar. The score is
+// a weighted average of per-attribute similarities.
+func scoreComp(subject dbgen.PropertyDetail, c Comp, radius float64, start time.Time) float64 {
+	var total, weights float64
+	add := func(weight, sim float64) {
+		total += weight * math.Max(0, math.Min(1, sim))
+		weights += weight
+	}
+	add(compWeightDistance, 1-c.DistanceM/radius)
+	if c.SaleTS.Valid {
+		window := time.Since(start).Hours()
+		add(compWeightRecency, 1-time.Since(c.SaleTS.Time).Hours()/window)
+	}
+	if subject.Sqft.Valid && c.Sqft.Valid && subject.Sqft.Int32 > 0 {
+		add(compWeightSqft, 1-relDiff(float64(c.Sqft.Int32), float64(subject.Sqft.Int32))/compMaxSqftDiff)
+	}
+	if subject.Beds.Valid && c.Beds.Valid {
+		add(compWeightBeds, 1-math.Abs(float64(c.Beds.Float32-subject.Beds.Float32))/(compMaxBedsDiff+1))
+	}
+	if subject.Baths.Valid && c.Baths.Valid {
+		add(compWeightBaths, 1-math.Abs(float64(c.Baths.Float32-subject.Baths.Float32))/3)
+	}
+	if subject.YearBuilt.Valid && c.YearBuilt.Valid {
+		add(compWeightYearBuilt, 1-math.Abs(float64(c.YearBuilt.Int32-subject.YearBuilt.Int32))/50)
+	}
+	if weights == 0 {
+		return 0
+	}
+	return total / weights
+}
+
+// Adjusts the comp sale price to the subject's size using the comp's price
+// per square foot. If either size is unknown, the sale price is used as is.
+func adjustCompPrice(subject dbgen.PropertyDetail, c Comp) int32 {
+	if !subject.Sqft.Valid || !c.Sqft.Valid || c.Sqft.Int32 <= 0 {
+		return c.SalePrice
+	}
+	return int32(math.Round(float64(c.SalePrice) / float64(c.Sqft.Int32) * float64(subject.Sqft.Int32)))
+}
+
+// Returns the score weighted mean of the adjusted comp prices, or nil if there
+// are no comps. If all scores are zero, this falls back to the unweighted mean.
+func estimateFromComps(comps []Comp) *int32 {
+	if len(comps) == 0 {
+		return nil
+	}
+	var total, weights, plain float64
+	for _, c := range comps {
+		total += c.Score * float64(c.AdjustedPrice)
+		weights += c.Score
+		plain += float64(c.AdjustedPrice)
+	}
+	var est int32
+	if weights == 0 {
+		est = int32(math.Round(plain / float64(len(comps))))
+	} else {
+		est = int32(math.Round(total / weights))
+	}
+	return &est
+}
+
+func relDiff(v, ref float64) float64 {
+	return math.Abs(v-ref) / ref
+}
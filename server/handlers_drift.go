@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Kinds of payload drift. A path is new if it's absent from the baseline,
+// missing if it's absent from the payload, and retyped if its type differs.
+const PayloadChangeNew = "new"
+const PayloadChangeMissing = "missing"
+const PayloadChangeRetyped = "retyped"
+
+// Drift is reported over the trailing week unless the caller specifies since.
+const defaultPayloadDriftWindow = 7 * 24 * time.Hour
+
+// Writes the baseline structure for a provider payload endpoint.
+func handlePayloadSchemaGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params PayloadEndpointQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		provider, endpoint := params.Provider, params.Endpoint
+		ps, err := q.GetPayloadSchema(r.Context(), dbgen.GetPayloadSchemaParams{Provider: provider, Endpoint: endpoint})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if ps == nil {
+			ps = []dbgen.PayloadSchema{}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ps)
+	}
+}
+
+// Merges the supplied paths into the baseline structure for a provider
+// payload endpoint. Workers use this to seed the baseline.
+func handlePayloadSchemaPut(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body PutPayloadSchemaBody
+		err := decodeJSONBody(r, &body)
+		if err != nil {
+			var mr *MalformedRequest
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
+			return
+		}
+		if err = validateBody(&body); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		params := dbgen.UpsertPayloadSchemaParams{Provider: body.Provider, Endpoint: body.Endpoint}
+		for path := range body.Paths {
+			params.Paths = append(params.Paths, path)
+		}
+		sort.Strings(params.Paths)
+		for _, path := range params.Paths {
+			params.ValueTypes = append(params.ValueTypes, body.Paths[path])
+		}
+		if err = q.UpsertPayloadSchema(r.Context(), params); err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		writeOK(w)
+	}
+}
+
+// Records the differences a worker observed between a payload and the
+// baseline. Each change increments the occurrence count for its path.
+func handlePayloadDriftPost(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body PostPayloadDriftBody
+		err := decodeJSONBody(r, &body)
+		if err != nil {
+			var mr *MalformedRequest
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
+			return
+		}
+		if err = validateBody(&body); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+
+		tx, err := p.Begin(r.Context())
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		defer tx.Rollback(r.Context())
+		q = q.WithTx(tx)
+
+		for _, c := range body.Changes {
+			err = q.UpsertPayloadDrift(r.Context(), dbgen.UpsertPayloadDriftParams{
+				Provider:       body.Provider,
+				Endpoint:       body.Endpoint,
+				Path:           c.Path,
+				Change:         c.Change,
+				BaselineType:   pgtype.Text{String: c.BaselineType, Valid: c.BaselineType != ""},
+				ObservedType:   pgtype.Text{String: c.ObservedType, Valid: c.ObservedType != ""},
+				LastPropertyID: pgtype.Int4{Int32: body.PropertyID, Valid: body.PropertyID != 0},
+				LastListingID:  pgtype.Int4{Int32: body.ListingID, Valid: body.ListingID != 0},
+			})
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if len(body.Changes) > 0 {
+			l.Warn("payload drift reported", "provider", body.Provider, "endpoint", body.Endpoint, "changes", len(body.Changes))
+		}
+		writeOK(w)
+	}
+}
+
+// Writes the aggregated payload drift seen since the supplied time (date or
+// RFC3339; defaults to a week ago). The provider and endpoint are optional.
+func handlePayloadDriftGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := PayloadDriftQuery{Since: time.Now().Add(-defaultPayloadDriftWindow)}
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		ds, err := q.GetPayloadDrift(r.Context(), dbgen.GetPayloadDriftParams{
+			Provider: params.Provider,
+			Endpoint: params.Endpoint,
+			Since:    pgtype.Timestamp{Time: params.Since, Valid: true},
+		})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if ds == nil {
+			ds = []dbgen.PayloadDrift{}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ds)
+	}
+}
+
+// Accepts the drift recorded for a provider payload endpoint into its
+// baseline and clears it. New and retyped paths are added with their observed
+// type, while missing paths are removed along with their descendants.
+func handlePayloadDriftAccept(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params PayloadEndpointQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		provider, endpoint := params.Provider, params.Endpoint
+
+		tx, err := p.Begin(r.Context())
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		defer tx.Rollback(r.Context())
+		q = q.WithTx(tx)
+
+		ds, err := q.GetPayloadDrift(r.Context(), dbgen.GetPayloadDriftParams{
+			Provider: provider,
+			Endpoint: endpoint,
+			Since:    pgtype.Timestamp{Time: time.Unix(0, 0), Valid: true},
+		})
+		if err != nil && err != pgx.ErrNoRows {
+			writeInternalError(l, w, err)
+			return
+		}
+		up := dbgen.UpsertPayloadSchemaParams{Provider: provider, Endpoint: endpoint}
+		for _, d := range ds {
+			if d.Change == PayloadChangeMissing {
+				err = q.DeletePayloadSchemaSubtree(r.Context(), dbgen.DeletePayloadSchemaSubtreeParams{
+					Provider: provider, Endpoint: endpoint, Path: d.Path})
+				if err != nil {
+					writeInternalError(l, w, err)
+					return
+				}
+				continue
+			}
+			up.Paths = append(up.Paths, d.Path)
+			up.ValueTypes = append(up.ValueTypes, d.ObservedType.String)
+		}
+		if len(up.Paths) > 0 {
+			if err = q.UpsertPayloadSchema(r.Context(), up); err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+		}
+		n, err := q.DeletePayloadDrift(r.Context(), dbgen.DeletePayloadDriftParams{Provider: provider, Endpoint: endpoint})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(DefaultJSONResponse{Message: fmt.Sprintf("accepted %d changes", n)})
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/brojonat/gredfin/server/db/jsonb"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Near-duplicate image search settings. The distance is the Hamming distance
+// between 64 bit difference hashes; up to ~10 bits usually means the same
+// photo after resizing, recompression, or light edits.
+const defaultNearDuplicateDistance = 6
+const defaultNearDuplicateLimit = 100
+
+// Writes a presigned PUT URL for storing an image with the supplied SHA-256
+// hash. Images are content addressed, so if the image is already stored this
+// writes stored=true and no URL; callers should skip the upload.
+func handleImagePresign(l *slog.Logger, bs BlobStore, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params HashQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		hash := params.Hash
+		stored, err := q.ImageExists(r.Context(), hash)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if stored {
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(PresignImageResponse{Stored: true})
+			return
+		}
+		u, err := bs.PresignPut(r.Context(), getImageKey(hash), blobPresignExpiry)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(PresignImageResponse{URL: u})
+	}
+}
+
+// Records a mirrored listing image.
+func handleListingImagePost(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body PostListingImageBody
+		err := decodeJSONBody(r, &body)
+		if err != nil {
+			var mr *MalformedRequest
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
+			return
+		}
+		if err = validateBody(&body); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		if !isSHA256Hex(body.Image.Hash) {
+			writeBadRequestError(w, fmt.Errorf("image hash must be a hex encoded SHA-256 hash"))
+			return
+		}
+
+		tx, err := p.Begin(r.Context())
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		defer tx.Rollback(r.Context())
+		q = q.WithTx(tx)
+
+		if err = q.CreateImage(r.Context(), body.Image); err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		err = q.CreateListingImage(r.Context(), dbgen.CreateListingImageParams{
+			PropertyID: body.PropertyID,
+			ListingID:  body.ListingID,
+			SourceURL:  body.SourceURL,
+			Kind:       body.Kind,
+			Position:   body.Position,
+			Hash:       body.Image.Hash,
+		})
+		if err != nil {
+			if isPGError(err, pgErrorForeignKeyViolation) {
+				msg := fmt.Sprintf("property does not exist (pid: %d, lid: %d)", body.PropertyID, body.ListingID)
+				writeProblem(w, newProblem(http.StatusNotFound, ErrorCodeNotFound, msg))
+				return
+			}
+			writeInternalError(l, w, err)
+			return
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		writeOK(w)
+	}
+}
+
+// Enqueues a mirroring job for each scraped image of a listing that hasn't
+// been mirrored yet. This is skipped if an update to the listing didn't
+// change its image URLs.
+func enqueueImageJobs(ctx context.Context, q *dbgen.Queries, before, after jsonb.PropertyScrapeMetadata, pid, lid int32) error {
+	if slices.Equal(before.ImageURLs, after.ImageURLs) && slices.Equal(before.ThumbnailURLs, after.ThumbnailURLs) {
+		return nil
+	}
+	_, err := q.EnqueueImageJobs(ctx, dbgen.EnqueueImageJobsParams{PropertyID: pid, ListingID: lid})
+	return err
+}
+
+// Redirects to a short-lived presigned GET URL for a mirrored image. This is
+// the stable URL that property responses point at, so it doesn't require
+// auth (it's used in img tags); the content hash makes it unguessable.
+func handleImageGet(l *slog.Logger, bs BlobStore, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params ImagePath
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		hash := params.Hash
+		stored, err := q.ImageExists(r.Context(), hash)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if !stored {
+			writeEmptyResultError(w)
+			return
+		}
+		u, err := bs.PresignGet(r.Context(), getImageKey(hash), blobPresignExpiry)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		// let clients cache the redirect for a bit less than the URL is valid
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int((blobPresignExpiry/2).Seconds())))
+		http.Redirect(w, r, u, http.StatusFound)
+	}
+}
+
+// Writes the photos on other listings that are near-duplicates of the photos
+// on the supplied property (and optionally listing). Matches on the same
+// property are flagged as relists; matches on other properties usually mean
+// reused photos.
+func handleNearDuplicateImagesGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := NearDuplicateImagesQuery{
+			MaxDistance: defaultNearDuplicateDistance,
+			Limit:       defaultNearDuplicateLimit,
+		}
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		ms, err := q.GetNearDuplicateImages(r.Context(), dbgen.GetNearDuplicateImagesParams{
+			MaxDistance: int32(params.MaxDistance),
+			PropertyID:  params.PropertyID,
+			ListingID:   params.ListingID,
+			RowLimit:    int32(params.Limit),
+		})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if ms == nil {
+			ms = []dbgen.GetNearDuplicateImagesRow{}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ms)
+	}
+}
+
+// Replaces the Redfin CDN image and thumbnail URLs in the supplied metadata
+// with the URLs of their mirrored copies (where available), so listings keep
+// their photos after Redfin drops them.
+func rewriteImageURLs(ctx context.Context, q *dbgen.Queries, r *http.Request, ms ...*jsonb.PropertyScrapeMetadata) error {
+	urls := []string{}
+	for _, m := range ms {
+		urls = append(urls, m.ImageURLs...)
+		urls = append(urls, m.ThumbnailURLs...)
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	rows, err := q.GetMirroredImageHashes(ctx, urls)
+	if err != nil {
+		return err
+	}
+	mirrored := map[string]string{}
+	for _, row := range rows {
+		mirrored[row.SourceURL] = getImageURL(r, row.Hash)
+	}
+	for _, m := range ms {
+		for i, u := range m.ImageURLs {
+			if mu, ok := mirrored[u]; ok {
+				m.ImageURLs[i] = mu
+			}
+		}
+		for i, u := range m.ThumbnailURLs {
+			if mu, ok := mirrored[u]; ok {
+				m.ThumbnailURLs[i] = mu
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the absolute URL of the mirrored image, relative to the host the
+// request was made to.
+func getImageURL(r *http.Request, hash string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s/image/%s", scheme, r.Host, apiPathPrefix, hash)
+}
@@ -0,0 +1,392 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Job queue settings. Claims lease jobs to a worker for defaultJobLease unless
+// the worker asks for a different lease, and failed jobs are retried after an
+// exponential backoff that starts at defaultJobBackoff.
+const defaultJobLease = 10 * time.Minute
+const maxJobLease = 24 * time.Hour
+const defaultJobBackoff = time.Minute
+const defaultJobMaxAttempts = 3
+const defaultJobClaimLimit = 1
+const defaultJobListLimit = 100
+
+// Scrape jobs repeat; each search and listing is scraped again this long after
+// its previous scrape finishes.
+const searchJobRepeat = 24 * time.Hour
+const propertyJobRepeat = 24 * time.Hour
+
+// Job kind for running a search query against a provider.
+const JobKindSearch = "search"
+
+// Job kind for mirroring a scraped listing image. Image jobs are enqueued by
+// EnqueueImageJobs, which hardcodes this kind.
+const JobKindImage = "image"
+
+// Returns the job kind for scraping listings from the supplied provider. Each
+// provider gets its own kind so workers only claim listings they can scrape.
+func PropertyJobKind(prov string) string {
+	return "property:" + prov
+}
+
+// Returns the dedupe key of the scrape job for a property listing.
+func propertyJobKey(pid, lid int32) string {
+	return fmt.Sprintf("%d/%d", pid, lid)
+}
+
+// Enqueues a repeating job. The dedupe key makes this a no-op (other than
+// refreshing the payload) if the job already exists.
+func enqueueRepeatingJob(ctx context.Context, q *dbgen.Queries, kind, key string, payload any, every time.Duration) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = q.EnqueueJob(ctx, dbgen.EnqueueJobParams{
+		Kind:          kind,
+		DedupeKey:     pgtype.Text{String: key, Valid: true},
+		Payload:       b,
+		MaxAttempts:   defaultJobMaxAttempts,
+		RunAt:         pgtype.Timestamp{Time: time.Now(), Valid: true},
+		RepeatSeconds: pgtype.Int4{Int32: int32(every.Seconds()), Valid: true},
+	})
+	return err
+}
+
+// Makes sure every search and property listing has a scrape job, and every
+// scraped image that hasn't been mirrored has an image job. Searches and
+// listings created through the API get their jobs when they're created (and
+// images when a scrape records them), so this only matters for rows that
+// predate the job queue.
+func enqueueScrapeJobs(ctx context.Context, l *slog.Logger, q *dbgen.Queries) error {
+	ns, err := q.EnqueueSearchJobs(ctx, int32(searchJobRepeat.Seconds()))
+	if err != nil {
+		return fmt.Errorf("error enqueueing search jobs: %w", err)
+	}
+	np, err := q.EnqueuePropertyJobs(ctx, int32(propertyJobRepeat.Seconds()))
+	if err != nil {
+		return fmt.Errorf("error enqueueing property jobs: %w", err)
+	}
+	ni, err := q.EnqueueImageJobs(ctx, dbgen.EnqueueImageJobsParams{})
+	if err != nil {
+		return fmt.Errorf("error enqueueing image jobs: %w", err)
+	}
+	if ns > 0 || np > 0 || ni > 0 {
+		l.Info("enqueued missing scrape jobs", "search", ns, "property", np, "image", ni)
+	}
+	return nil
+}
+
+// Parses an optional duration query parameter or body field.
+func parseJobDuration(v string, dflt, max time.Duration) (time.Duration, error) {
+	if v == "" {
+		return dflt, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < time.Second || d > max {
+		return 0, fmt.Errorf("bad duration %s (must be between 1s and %s)", v, max)
+	}
+	return d, nil
+}
+
+func handleJobGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := JobQuery{Limit: defaultJobListLimit}
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+
+		// job_id specified, return that job
+		if params.JobID != 0 {
+			j, err := q.GetJob(r.Context(), params.JobID)
+			if err == pgx.ErrNoRows {
+				writeEmptyResultError(w)
+				return
+			}
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(j)
+			return
+		}
+
+		// otherwise list the most recently updated jobs
+		js, err := q.ListJobs(r.Context(), dbgen.ListJobsParams{
+			Kind:     params.Kind,
+			Status:   params.Status,
+			RowLimit: int32(params.Limit),
+		})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if js == nil {
+			js = []dbgen.Job{}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(js)
+	}
+}
+
+// Enqueues a job of any kind. The server doesn't interpret the payload; that's
+// up to the workers that handle the kind.
+func handleJobPost(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body PostJobBody
+		err := decodeJSONBody(r, &body)
+		if err != nil {
+			var mr *MalformedRequest
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
+			return
+		}
+		if err = validateBody(&body); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		if len(body.Payload) == 0 {
+			body.Payload = json.RawMessage("{}")
+		}
+		if body.MaxAttempts == 0 {
+			body.MaxAttempts = defaultJobMaxAttempts
+		}
+		runAt := time.Now()
+		if body.RunAt != nil {
+			runAt = *body.RunAt
+		}
+		var repeat pgtype.Int4
+		if body.RepeatEvery != "" {
+			d, err := time.ParseDuration(body.RepeatEvery)
+			if err != nil || d < time.Second {
+				writeBadRequestError(w, invalidField("repeat_every"))
+				return
+			}
+			repeat = pgtype.Int4{Int32: int32(d.Seconds()), Valid: true}
+		}
+		j, err := q.EnqueueJob(r.Context(), dbgen.EnqueueJobParams{
+			Kind:          body.Kind,
+			DedupeKey:     pgtype.Text{String: body.DedupeKey, Valid: body.DedupeKey != ""},
+			Payload:       body.Payload,
+			Priority:      body.Priority,
+			MaxAttempts:   body.MaxAttempts,
+			RunAt:         pgtype.Timestamp{Time: runAt, Valid: true},
+			RepeatSeconds: repeat,
+		})
+		if err != nil {
+			if isUserError(err) {
+				writeBadRequestError(w, fmt.Errorf("bad data: %w", err))
+				return
+			}
+			writeInternalError(l, w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(j)
+	}
+}
+
+func handleJobDelete(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params JobIDQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		n, err := q.DeleteJob(r.Context(), params.JobID)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if n == 0 {
+			writeEmptyResultError(w)
+			return
+		}
+		writeOK(w)
+	}
+}
+
+// Leases runnable jobs of the requested kinds (?kind=a&kind=b) to the worker
+// identified by owner. Jobs whose lease expired on their last attempt are
+// given up on first, so a job that keeps crashing its worker isn't reclaimed
+// forever.
+func handleJobClaim(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := JobClaimQuery{Limit: defaultJobClaimLimit}
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		lease, err := parseJobDuration(params.Lease, defaultJobLease, maxJobLease)
+		if err != nil {
+			writeBadRequestError(w, invalidFieldf("lease", "%s", err))
+			return
+		}
+
+		n, err := q.ExpireJobLeases(r.Context(), params.Kinds)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if n > 0 {
+			l.Warn("gave up on jobs whose lease expired on their last attempt", "kinds", params.Kinds, "count", n)
+		}
+		js, err := q.ClaimJobs(r.Context(), dbgen.ClaimJobsParams{
+			LeaseOwner:   pgtype.Text{String: params.Owner, Valid: true},
+			LeaseSeconds: int32(lease.Seconds()),
+			Kinds:        params.Kinds,
+			RowLimit:     int32(params.Limit),
+		})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if js == nil {
+			js = []dbgen.Job{}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(js)
+	}
+}
+
+func handleJobComplete(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body CompleteJobBody
+		err := decodeJSONBody(r, &body)
+		if err != nil {
+			var mr *MalformedRequest
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
+			return
+		}
+		if err = validateBody(&body); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		n, err := q.CompleteJob(r.Context(), dbgen.CompleteJobParams{
+			Result:     body.Result,
+			JobID:      body.JobID,
+			LeaseOwner: pgtype.Text{String: body.LeaseOwner, Valid: true},
+		})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if n == 0 {
+			writeJobLeaseConflict(w, body.JobID, body.LeaseOwner)
+			return
+		}
+		writeOK(w)
+	}
+}
+
+// Records a failed attempt of a leased job, or deletes the job if the worker
+// reports that it no longer applies.
+func handleJobFail(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body FailJobBody
+		err := decodeJSONBody(r, &body)
+		if err != nil {
+			var mr *MalformedRequest
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
+			return
+		}
+		if err = validateBody(&body); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		if body.Drop {
+			n, err := q.DropJob(r.Context(), dbgen.DropJobParams{
+				JobID:      body.JobID,
+				LeaseOwner: pgtype.Text{String: body.LeaseOwner, Valid: true},
+			})
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			if n == 0 {
+				writeJobLeaseConflict(w, body.JobID, body.LeaseOwner)
+				return
+			}
+			l.Info("dropped job", "job_id", body.JobID, "reason", body.Error)
+			writeOK(w)
+			return
+		}
+		backoff, err := parseJobDuration(body.Backoff, defaultJobBackoff, maxJobLease)
+		if err != nil {
+			writeBadRequestError(w, invalidFieldf("backoff", "%s", err))
+			return
+		}
+		n, err := q.FailJob(r.Context(), dbgen.FailJobParams{
+			BackoffSeconds: int32(backoff.Seconds()),
+			LastError:      pgtype.Text{String: body.Error, Valid: true},
+			JobID:          body.JobID,
+			LeaseOwner:     pgtype.Text{String: body.LeaseOwner, Valid: true},
+		})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if n == 0 {
+			writeJobLeaseConflict(w, body.JobID, body.LeaseOwner)
+			return
+		}
+		writeOK(w)
+	}
+}
+
+// Workers can lose their lease if they take longer than the lease to finish a
+// job, in which case another worker may have claimed it.
+func writeJobLeaseConflict(w http.ResponseWriter, id int64, owner string) {
+	writeProblem(w, newProblem(http.StatusConflict, ErrorCodeConflict, fmt.Sprintf("job %d is not leased to %s", id, owner)))
+}
+
+// Counts jobs by kind and status. If a duration is supplied, only jobs updated
+// within that duration are counted.
+func handleJobStatsGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params JobStatsQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		var since time.Time
+		if params.Duration != 0 {
+			since = time.Now().Add(-params.Duration)
+		}
+		res, err := q.GetJobStats(r.Context(), pgtype.Timestamp{Time: since, Valid: true})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if res == nil {
+			res = []dbgen.GetJobStatsRow{}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(res)
+	}
+}
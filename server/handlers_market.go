@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkt"
+)
+
+// Parses the start and end query params into a time range. Both params accept
+// a date (2006-01-02) or an RFC3339 timestamp. If unspecified, the range
+// defaults to the trailing 12 months.
+func ParseTimeRange(start, end string) (time.Time, time.Time, error) {
+	parse := func(v string) (time.Time, error) {
+		if t, err := time.Parse(time.DateOnly, v); err == nil {
+			return t, nil
+		}
+		return time.Parse(time.RFC3339, v)
+	}
+	te := time.Now()
+	if end != "" {
+		t, err := parse(end)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("bad value for end: %s", end)
+		}
+		te = t
+	}
+	ts := te.AddDate(-1, 0, 0)
+	if start != "" {
+		t, err := parse(start)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("bad value for start: %s", start)
+		}
+		ts = t
+	}
+	if !ts.Before(te) {
+		return time.Time{}, time.Time{}, fmt.Errorf("start must be before end")
+	}
+	return ts, te, nil
+}
+
+// Writes a list of monthly market statistics for the requested region. The
+// region is specified by exactly one of: zipcode, city (with state), or
+// polygon (WKT, SRID 4326). Zipcode and city regions are served from the
+// market_stats_monthly materialized view; polygons are aggregated on the fly.
+func handleMarketStatsGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params MarketStatsQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		zipcode, city, state, polygon := params.Zipcode, params.City, params.State, params.Polygon
+
+		ts, te, err := ParseTimeRange(params.Start, params.End)
+		if err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		start := pgtype.Timestamp{Time: ts, Valid: true}
+		end := pgtype.Timestamp{Time: te, Valid: true}
+
+		var res any
+		switch {
+		case zipcode != "":
+			stats, err := q.GetMarketStats(r.Context(), dbgen.GetMarketStatsParams{
+				RegionType: "zipcode", Region: zipcode, StartTs: start, EndTs: end})
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			if stats == nil {
+				stats = []dbgen.MarketStatsMonthly{}
+			}
+			res = stats
+		case city != "":
+			if state == "" {
+				writeBadRequestError(w, missingField("state"))
+				return
+			}
+			stats, err := q.GetMarketStats(r.Context(), dbgen.GetMarketStatsParams{
+				RegionType: "city", Region: fmt.Sprintf("%s, %s", city, state), StartTs: start, EndTs: end})
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			if stats == nil {
+				stats = []dbgen.MarketStatsMonthly{}
+			}
+			res = stats
+		case polygon != "":
+			// validate the polygon here so clients get a 400 rather than an
+			// opaque PostGIS error
+			g, err := wkt.Unmarshal(polygon)
+			if err != nil {
+				writeBadRequestError(w, invalidFieldf("polygon", "bad value for polygon: %s", err))
+				return
+			}
+			if _, ok := g.(*geom.Polygon); !ok {
+				writeBadRequestError(w, invalidFieldf("polygon", "must be a WKT POLYGON"))
+				return
+			}
+			stats, err := q.GetMarketStatsByPolygon(r.Context(), dbgen.GetMarketStatsByPolygonParams{
+				StartTs: start, EndTs: end, Polygon: polygon})
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			if stats == nil {
+				stats = []dbgen.GetMarketStatsByPolygonRow{}
+			}
+			res = stats
+		default:
+			writeBadRequestError(w, fmt.Errorf("must supply zipcode, city and state, or polygon"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// Refreshes the materialized views backing the market stats routes on demand.
+func handleRefreshMarketStats(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := doRefreshMarketStats(r.Context(), q); err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		writeOK(w)
+	}
+}
+
+func doRefreshMarketStats(ctx context.Context, q *dbgen.Queries) error {
+	// order matters here; market_stats_monthly is built from listing_cycle
+	if err := q.RefreshListingCycles(ctx); err != nil {
+		return fmt.Errorf("error refreshing listing cycles: %w", err)
+	}
+	if err := q.RefreshMarketStats(ctx); err != nil {
+		return fmt.Errorf("error refreshing market stats: %w", err)
+	}
+	return nil
+}
+
+// Periodically refreshes the materialized views backing the market stats
+// routes until the context is cancelled. The refreshes are concurrent, so
+// readers aren't blocked while this runs.
+func refreshMarketStats(ctx context.Context, l *slog.Logger, q *dbgen.Queries, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			start := time.Now()
+			if err := doRefreshMarketStats(ctx, q); err != nil {
+				l.Error("error refreshing market stats", "error", err.Error())
+				continue
+			}
+			l.Debug("refreshed market stats", "duration", time.Since(start).String())
+		}
+	}
+}
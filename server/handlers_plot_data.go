@@ -5,38 +5,35 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/brojonat/gredfin/server/db/dbgen"
 	"github.com/brojonat/histogram"
-	"github.com/jackc/pgx/v5"
 )
 
 // Writes a list of { price, count } objects representing realtor's binned prices
 func handlePlotDataRealtorPrices(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		v := r.URL.Query().Get("version")
-		name := r.URL.Query().Get("name")
-		if name == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "must supply realtor name"})
+		var params RealtorPricesPlotQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
 			return
 		}
 
-		switch v {
+		switch v := params.Version; v {
 		// This case returns a [(x1, y1), ...] that can be used in StepLineSeries
 		case "", "1":
 			// get the data
-			ps, err := q.GetRealtorProperties(r.Context(), dbgen.GetRealtorPropertiesParams{Name: name})
-			if ps == nil || err == pgx.ErrNoRows {
-				writeEmptyResultError(w)
-				return
-			}
+			ps, err := q.GetRealtorProperties(r.Context(), dbgen.GetRealtorPropertiesParams{Name: params.Name})
 			if err != nil {
 				writeInternalError(l, w, err)
 				return
 			}
+			if ps == nil {
+				w.WriteHeader(http.StatusOK)
+				json.NewEncoder(w).Encode([]PriceBin{})
+				return
+			}
 			// histogram the prices
 			prices := []float64{}
 			for _, p := range ps {
@@ -53,13 +50,9 @@ func handlePlotDataRealtorPrices(l *slog.Logger, q *dbgen.Queries) http.HandlerF
 				return
 			}
 			// write the output
-			type bin struct {
-				Price float64 `json:"price"`
-				Count int     `json:"count"`
-			}
-			bins := []bin{}
+			bins := []PriceBin{}
 			for _, b := range h.Buckets {
-				bins = append(bins, bin{Price: b.Min, Count: b.Count})
+				bins = append(bins, PriceBin{Price: b.Min, Count: b.Count})
 			}
 			w.WriteHeader(http.StatusOK)
 			json.NewEncoder(w).Encode(bins)
@@ -75,33 +68,27 @@ func handlePlotDataRealtorPrices(l *slog.Logger, q *dbgen.Queries) http.HandlerF
 // be well suited to the flutterflow and SF_CartesianChart API.
 func handlePlotDataPropertyPrices(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		v := r.URL.Query().Get("version")
-		propertyID := r.URL.Query().Get("property_id")
-		pid, err := strconv.Atoi(propertyID)
-		if err != nil {
-			writeBadRequestError(w, fmt.Errorf("bad value for property_id"))
+		var params PropertyPricesPlotQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
 			return
 		}
-		events, err := q.GetPropertyEvents(r.Context(), dbgen.GetPropertyEventsParams{PropertyID: int32(pid)})
+		events, err := q.GetPropertyEvents(r.Context(), dbgen.GetPropertyEventsParams{PropertyID: params.PropertyID})
 		if err != nil {
 			writeInternalError(l, w, err)
 			return
 		}
 
-		switch v {
+		switch v := params.Version; v {
 		case "", "1":
 			// This data version will iterate over the events and send the
 			// timestamp and price if the price is non-zero.
-			type chartData struct {
-				X string `json:"timestamp"`
-				Y int32  `json:"price"`
-			}
-			res := []chartData{}
+			res := []PricePoint{}
 			for _, e := range events {
 				if e.Price == 0 {
 					continue
 				}
-				evt := chartData{
+				evt := PricePoint{
 					X: e.EventTS.Time.Format(time.RFC3339),
 					Y: e.Price,
 				}
@@ -1,18 +1,17 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/brojonat/gredfin/provider"
 	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/brojonat/gredfin/server/db/jsonb"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -21,17 +20,24 @@ import (
 
 func handlePropertyGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		propertyID := r.URL.Query().Get("property_id")
-		listingID := r.URL.Query().Get("listing_id")
+		var params PropertyQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
 
 		// no identifier, list properties
-		if propertyID == "" && listingID == "" {
+		if params.PropertyID == 0 && params.ListingID == 0 {
 			props, err := q.ListPropertiesPrices(r.Context())
-			if props == nil || err == pgx.ErrNoRows {
-				writeEmptyResultError(w)
+			if err != nil {
+				writeInternalError(l, w, err)
 				return
 			}
-			if err != nil {
+			ms := []*jsonb.PropertyScrapeMetadata{}
+			for i := range props {
+				ms = append(ms, &props[i].LastScrapeMetadata)
+			}
+			if err = rewriteImageURLs(r.Context(), q, r, ms...); err != nil {
 				writeInternalError(l, w, err)
 				return
 			}
@@ -41,26 +47,23 @@ func handlePropertyGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 		}
 
 		// no propertyID with a listingID is a bad request
-		if propertyID == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "missing property_id"})
+		if params.PropertyID == 0 {
+			writeBadRequestError(w, missingField("property_id"))
 			return
 		}
 
 		// no listingID, return a listing of properties
-		if listingID == "" {
-			pid, err := strconv.Atoi(propertyID)
+		if params.ListingID == 0 {
+			props, err := q.GetPropertiesWithPrice(r.Context(), dbgen.GetPropertiesWithPriceParams{PropertyID: params.PropertyID})
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for property_id"})
+				writeInternalError(l, w, err)
 				return
 			}
-			props, err := q.GetPropertiesWithPrice(r.Context(), dbgen.GetPropertiesWithPriceParams{PropertyID: int32(pid)})
-			if props == nil || err == pgx.ErrNoRows {
-				writeEmptyResultError(w)
-				return
+			ms := []*jsonb.PropertyScrapeMetadata{}
+			for i := range props {
+				ms = append(ms, &props[i].LastScrapeMetadata)
 			}
-			if err != nil {
+			if err = rewriteImageURLs(r.Context(), q, r, ms...); err != nil {
 				writeInternalError(l, w, err)
 				return
 			}
@@ -70,19 +73,35 @@ func handlePropertyGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 		}
 
 		// return single entry
-		pid, err := strconv.Atoi(propertyID)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for property_id"})
+		prop, err := q.GetPropertyWithPrice(r.Context(), dbgen.GetPropertyWithPriceParams{PropertyID: params.PropertyID, ListingID: params.ListingID})
+		if err == pgx.ErrNoRows {
+			writeEmptyResultError(w)
 			return
 		}
-		lid, err := strconv.Atoi(listingID)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for listing_id"})
+			writeInternalError(l, w, err)
+			return
+		}
+		if err = rewriteImageURLs(r.Context(), q, r, &prop.LastScrapeMetadata); err != nil {
+			writeInternalError(l, w, err)
 			return
 		}
-		prop, err := q.GetPropertyWithPrice(r.Context(), dbgen.GetPropertyWithPriceParams{PropertyID: int32(pid), ListingID: int32(lid)})
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(makeLocationSerializable(prop))
+	}
+}
+
+// Returns a single property listing from the "basic" property table. Unlike
+// GET /property, this returns listings that don't have any price events yet,
+// which is what scrape workers need.
+func handlePropertyBasicGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params PropertyKeyQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		prop, err := q.GetPropertyBasic(r.Context(), dbgen.GetPropertyBasicParams{PropertyID: params.PropertyID, ListingID: params.ListingID})
 		if err == pgx.ErrNoRows {
 			writeEmptyResultError(w)
 			return
@@ -98,28 +117,56 @@ func handlePropertyGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 
 func handlePropertyPost(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var body struct {
-			dbgen.CreatePropertyParams
-			Location Location `json:"location"`
-		}
+		var body CreatePropertyParams
 		err := decodeJSONBody(r, &body)
 		if err != nil {
 			var mr *MalformedRequest
 			if errors.As(err, &mr) {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: fmt.Sprintf("bad request payload: %s", err.Error())})
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
 			} else {
 				writeInternalError(l, w, err)
 			}
 			return
 		}
 		if len(body.Location.Coordinates) == 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "client didn't set location correctly"})
+			writeBadRequestError(w, &ValidationError{Fields: []FieldError{
+				{Field: "location.coordinates", Code: FieldCodeRequired, Message: "client didn't set location correctly"},
+			}})
 			return
 		}
 		body.CreatePropertyParams.Location = geom.NewPoint(geom.XY).MustSetCoords(body.Location.Coordinates).SetSRID(4326)
 
+		// Records are keyed by provider and external id. Redfin listings carry
+		// their own integer ids, but other providers need ids assigned here.
+		if body.Provider == "" {
+			body.Provider = provider.Redfin
+		}
+		if body.PropertyID == 0 || body.ListingID == 0 {
+			if body.Provider == provider.Redfin || body.ExternalID.String == "" {
+				writeBadRequestError(w, fmt.Errorf("must supply property_id and listing_id, or a non-redfin provider and external_id"))
+				return
+			}
+			_, err := q.GetPropertyByExternalID(r.Context(), dbgen.GetPropertyByExternalIDParams{
+				Provider: body.Provider, ExternalID: body.ExternalID})
+			if err == nil {
+				writeOK(w)
+				return
+			}
+			if err != pgx.ErrNoRows {
+				writeInternalError(l, w, err)
+				return
+			}
+			id, err := q.NextExternalPropertyID(r.Context())
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			body.PropertyID = id
+			body.ListingID = id
+		} else if body.Provider == provider.Redfin && body.ExternalID.String == "" {
+			body.ExternalID = pgtype.Text{String: provider.RedfinExternalID(body.PropertyID, body.ListingID), Valid: true}
+		}
+
 		// check if this url is blocklisted, return early with a 204 if so
 		bps, err := q.ListBlocklistedProperties(r.Context(), []string{body.URL.String})
 		if err == nil && len(bps) > 0 {
@@ -150,6 +197,20 @@ func handlePropertyPost(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 			writeInternalError(l, w, err)
 			return
 		}
+
+		// schedule the listing scrape
+		err = enqueueRepeatingJob(
+			r.Context(),
+			q,
+			PropertyJobKind(body.Provider),
+			propertyJobKey(body.PropertyID, body.ListingID),
+			PropertyJobPayload{PropertyID: body.PropertyID, ListingID: body.ListingID},
+			propertyJobRepeat,
+		)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
 		writeOK(w)
 	}
 }
@@ -157,18 +218,17 @@ func handlePropertyPost(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 // Gets the current property with the supplied property_id and listing_id, then
 // for each field that is specified in the input, updates the current with the
 // specified data, then writes the resulting object to the model.
+//
+// Deprecated: zero values are treated as absent, so fields can't be cleared;
+// use handlePropertyPatch. This is kept for workers that haven't been updated.
 func handlePropertyUpdate(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var body struct {
-			dbgen.PutPropertyParams
-			Location *Location `json:"location"`
-		}
+		var body PutPropertyBody
 		err := decodeJSONBody(r, &body)
 		if err != nil {
 			var mr *MalformedRequest
 			if errors.As(err, &mr) {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: fmt.Sprintf("bad request payload: %s", err.Error())})
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
 			} else {
 				writeInternalError(l, w, err)
 			}
@@ -192,9 +252,8 @@ func handlePropertyUpdate(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) htt
 		})
 		if err != nil {
 			if err == pgx.ErrNoRows {
-				w.WriteHeader(http.StatusNotFound)
 				msg := fmt.Sprintf("property does not exist (pid: %d, lid: %d)", body.PropertyID, body.ListingID)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: msg})
+				writeProblem(w, newProblem(http.StatusNotFound, ErrorCodeNotFound, msg))
 				return
 			}
 			writeInternalError(l, w, err)
@@ -242,14 +301,11 @@ func handlePropertyUpdate(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) htt
 		if body.LastScrapeStatus != "" {
 			pd.LastScrapeStatus = body.LastScrapeStatus
 		}
-		if body.LastScrapeMetadata.InitialInfoHash != "" {
-			pd.LastScrapeMetadata.InitialInfoHash = body.LastScrapeMetadata.InitialInfoHash
-		}
-		if body.LastScrapeMetadata.MLSHash != "" {
-			pd.LastScrapeMetadata.MLSHash = body.LastScrapeMetadata.MLSHash
+		if body.LastScrapeMetadata.PayloadHashes != nil {
+			pd.LastScrapeMetadata.PayloadHashes = body.LastScrapeMetadata.PayloadHashes
 		}
-		if body.LastScrapeMetadata.AVMHash != "" {
-			pd.LastScrapeMetadata.AVMHash = body.LastScrapeMetadata.AVMHash
+		if body.LastScrapeMetadata.AVMHistoryBackfilled {
+			pd.LastScrapeMetadata.AVMHistoryBackfilled = true
 		}
 		if body.LastScrapeMetadata.ImageURLs != nil {
 			pd.LastScrapeMetadata.ImageURLs = body.LastScrapeMetadata.ImageURLs
@@ -266,177 +322,253 @@ func handlePropertyUpdate(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) htt
 			writeInternalError(l, w, err)
 			return
 		}
+		err = enqueueImageJobs(r.Context(), q, current.LastScrapeMetadata, pd.LastScrapeMetadata, pd.PropertyID, pd.ListingID)
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
 		writeOK(w)
 	}
 }
 
-func handlePropertyDelete(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+// Applies a JSON merge patch (RFC 7396) to the property identified by the
+// property_id and listing_id query params. Unlike PUT, fields can be cleared by
+// patching them to null.
+func handlePropertyPatch(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		propertyID := r.URL.Query().Get("property_id")
-		listingID := r.URL.Query().Get("listing_id")
-
-		if propertyID == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "must supply property_id"})
+		var params PropertyKeyQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
 			return
 		}
-
-		// no listingID, delete all property entries under the ID
-		if listingID == "" {
-			pid, err := strconv.Atoi(propertyID)
-			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for property_id"})
-				return
-			}
-			err = q.DeletePropertyListingsByID(r.Context(), int32(pid))
-			if err != nil {
+		var body PatchPropertyBody
+		err := decodeMergePatchBody(r, &body)
+		if err != nil {
+			var mr *MalformedRequest
+			var pr *time.ParseError
+			if errors.As(err, &mr) || errors.As(err, &pr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else {
 				writeInternalError(l, w, err)
-				return
 			}
-			writeOK(w)
 			return
 		}
 
-		// delete property listing
-		pid, err := strconv.Atoi(propertyID)
+		tx, err := p.Begin(r.Context())
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for property_id"})
+			writeInternalError(l, w, err)
 			return
 		}
-		lid, err := strconv.Atoi(listingID)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for listing_id"})
+		defer tx.Commit(r.Context())
+		q = q.WithTx(tx)
+
+		current, err := q.GetPropertyBasic(r.Context(), dbgen.GetPropertyBasicParams{
+			PropertyID: params.PropertyID,
+			ListingID:  params.ListingID,
+		})
+		if err == pgx.ErrNoRows {
+			writeEmptyResultError(w)
 			return
 		}
-		err = q.DeletePropertyListing(
-			r.Context(),
-			dbgen.DeletePropertyListingParams{
-				PropertyID: int32(pid),
-				ListingID:  int32(lid),
-			},
-		)
 		if err != nil {
 			writeInternalError(l, w, err)
 			return
 		}
-		writeOK(w)
-	}
-}
-
-// claims the next property to be scraped and sets the status to pending
-func handlePropertyClaimNext(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		tx, err := p.Begin(r.Context())
+		pd, err := applyPropertyPatch(current, body)
 		if err != nil {
-			writeInternalError(l, w, err)
+			writeBadRequestError(w, err)
 			return
 		}
-		defer tx.Commit(r.Context())
-		q = q.WithTx(tx)
-		prop, err := q.GetNNextPropertyScrapeForUpdate(
-			r.Context(),
-			dbgen.GetNNextPropertyScrapeForUpdateParams{
-				Count: 1, Statuses: []string{ScrapeStatusGood}},
-		)
-		if err == pgx.ErrNoRows {
-			writeEmptyResultError(w)
+		err = q.PutProperty(r.Context(), pd)
+		if err != nil {
+			writeInternalError(l, w, err)
 			return
 		}
+		err = enqueueImageJobs(r.Context(), q, current.LastScrapeMetadata, pd.LastScrapeMetadata, pd.PropertyID, pd.ListingID)
 		if err != nil {
 			writeInternalError(l, w, err)
 			return
 		}
-		q.UpdatePropertyStatus(
-			r.Context(),
-			dbgen.UpdatePropertyStatusParams{
-				PropertyID:       prop.PropertyID,
-				ListingID:        prop.ListingID,
-				LastScrapeStatus: ScrapeStatusPending,
-			},
-		)
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(makeLocationSerializable(prop))
+		writeOK(w)
 	}
 }
 
-func handleGetPresignedPutURL(l *slog.Logger, s3c *s3.Client, q *dbgen.Queries) http.HandlerFunc {
+// Returns the property with the patch applied, or a ValidationError if the
+// patch clears a required field or sets a bad value.
+func applyPropertyPatch(current dbgen.Property, body PatchPropertyBody) (dbgen.PutPropertyParams, error) {
+	pd := dbgen.PutPropertyParams{
+		PropertyID:         current.PropertyID,
+		ListingID:          current.ListingID,
+		URL:                current.URL,
+		Zipcode:            current.Zipcode,
+		City:               current.City,
+		State:              current.State,
+		Location:           current.Location,
+		LastScrapeTS:       current.LastScrapeTS,
+		LastScrapeStatus:   current.LastScrapeStatus,
+		LastScrapeMetadata: current.LastScrapeMetadata,
+	}
+	patchText := func(dst *pgtype.Text, pv PatchValue[string]) {
+		if pv.Set {
+			*dst = pgtype.Text{String: pv.Value, Valid: !pv.Null}
+		}
+	}
+	patchText(&pd.URL, body.URL)
+	patchText(&pd.Zipcode, body.Zipcode)
+	patchText(&pd.City, body.City)
+	patchText(&pd.State, body.State)
+
+	var ve ValidationError
+	if body.Location.Set {
+		if body.Location.Null {
+			ve.add("location", FieldCodeInvalid, "must not be null")
+		} else if gp, err := geom.NewPoint(geom.XY).SetCoords(body.Location.Value.Coordinates); err != nil {
+			ve.add("location", FieldCodeInvalid, "bad coordinates")
+		} else {
+			pd.Location = gp.SetSRID(4326)
+		}
+	}
+	if body.LastScrapeTS.Set {
+		pd.LastScrapeTS = pgtype.Timestamp{Time: body.LastScrapeTS.Value, Valid: !body.LastScrapeTS.Null}
+	}
+	if body.LastScrapeStatus.Set {
+		switch {
+		case body.LastScrapeStatus.Null:
+			ve.add("last_scrape_status", FieldCodeInvalid, "must not be null")
+		case body.LastScrapeStatus.Value != ScrapeStatusGood && body.LastScrapeStatus.Value != ScrapeStatusBad:
+			ve.add("last_scrape_status", FieldCodeInvalid, fmt.Sprintf("must be one of: %s, %s", ScrapeStatusGood, ScrapeStatusBad))
+		default:
+			pd.LastScrapeStatus = body.LastScrapeStatus.Value
+		}
+	}
+	if body.LastScrapeMetadata.Set {
+		pd.LastScrapeMetadata = jsonb.PropertyScrapeMetadata{}
+		if !body.LastScrapeMetadata.Null {
+			md, err := patchScrapeMetadata(current.LastScrapeMetadata, body.LastScrapeMetadata.Value)
+			if err != nil {
+				ve.add("last_scrape_metadata", FieldCodeInvalid, err.Error())
+			}
+			pd.LastScrapeMetadata = md
+		}
+	}
+	return pd, ve.err()
+}
+
+// Merge patches the scrape metadata. Members the metadata doesn't have are
+// rejected rather than dropped.
+func patchScrapeMetadata(md jsonb.PropertyScrapeMetadata, patch json.RawMessage) (jsonb.PropertyScrapeMetadata, error) {
+	b, err := json.Marshal(md)
+	if err != nil {
+		return md, err
+	}
+	if b, err = mergePatch(b, patch); err != nil {
+		return md, err
+	}
+	var res jsonb.PropertyScrapeMetadata
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&res); err != nil {
+		return md, err
+	}
+	return res, nil
+}
+
+func handlePropertyDelete(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		propertyID := r.URL.Query().Get("property_id")
-		listingID := r.URL.Query().Get("listing_id")
-		basename := r.URL.Query().Get("basename")
-		if propertyID == "" || listingID == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "must supply property_id and listing_id"})
+		var params PropertyQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
 			return
 		}
-		if !strings.HasSuffix(basename, ".json") || len(basename) <= 5 {
-			w.WriteHeader(http.StatusBadRequest)
-			msg := fmt.Sprintf("invalid basename %s; must be valid filename with .json extension", basename)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: msg})
+
+		if params.PropertyID == 0 {
+			writeBadRequestError(w, missingField("property_id"))
 			return
 		}
-		bucket, err := getPropertyBucket()
-		if err != nil {
-			writeInternalError(l, w, err)
+
+		// no listingID, delete all property entries under the ID
+		if params.ListingID == 0 {
+			err := q.DeletePropertyListingsByID(r.Context(), params.PropertyID)
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			writeOK(w)
 			return
 		}
-		pid, err := strconv.Atoi(propertyID)
+
+		// delete property listing
+		err := q.DeletePropertyListing(
+			r.Context(),
+			dbgen.DeletePropertyListingParams{
+				PropertyID: params.PropertyID,
+				ListingID:  params.ListingID,
+			},
+		)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "invalid value for property_id"})
+			writeInternalError(l, w, err)
 			return
 		}
-		lid, err := strconv.Atoi(listingID)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "invalid value for listing_id"})
+		writeOK(w)
+	}
+}
+
+func handlePropertyDetailsGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params PropertyKeyQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
 			return
 		}
-		key, err := getPropertyKey(r.Context(), q, int32(pid), int32(lid), basename)
-		if err != nil {
-			writeInternalError(l, w, err)
+		pd, err := q.GetPropertyDetails(r.Context(), dbgen.GetPropertyDetailsParams{PropertyID: params.PropertyID, ListingID: params.ListingID})
+		if err == pgx.ErrNoRows {
+			writeEmptyResultError(w)
 			return
 		}
-		ps := s3.NewPresignClient(s3c)
-		presignedPutRequest, err := ps.PresignPutObject(
-			r.Context(),
-			&s3.PutObjectInput{
-				Bucket: aws.String(bucket),
-				Key:    aws.String(key),
-			},
-			func(opts *s3.PresignOptions) {
-				opts.Expires = time.Duration(600 * int64(time.Second))
-			})
 		if err != nil {
 			writeInternalError(l, w, err)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(DefaultJSONResponse{Message: presignedPutRequest.URL})
+		json.NewEncoder(w).Encode(pd)
 	}
 }
 
-func handleGetRecentPropertyScrapeStats(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+// Creates or replaces the details (beds, baths, sqft, etc.) of a property
+// listing. Workers hit this on every scrape, so this is an upsert.
+func handlePropertyDetailsPut(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		dp := r.URL.Query().Get("duration")
-		if dp == "" {
-			dp = "5m"
-		}
-		dur, err := time.ParseDuration(dp)
+		var body dbgen.UpsertPropertyDetailsParams
+		err := decodeJSONBody(r, &body)
 		if err != nil {
-			writeBadRequestError(w, fmt.Errorf("could not parse duration %s", dp))
+			var mr *MalformedRequest
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
 			return
 		}
-		ts := time.Now().Add(-dur)
-		res, err := q.GetRecentPropertyScrapeStats(r.Context(), pgtype.Timestamp{Time: ts, Valid: true})
+		var ve ValidationError
+		ve.require("property_id", body.PropertyID != 0)
+		ve.require("listing_id", body.ListingID != 0)
+		if err = ve.err(); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		err = q.UpsertPropertyDetails(r.Context(), body)
 		if err != nil {
+			if isPGError(err, pgErrorForeignKeyViolation) {
+				writePGError(w, pgErrorForeignKeyViolation, "details must map to an existing property")
+				return
+			}
+			if isUserError(err) {
+				writeBadRequestError(w, fmt.Errorf("bad data: %w", err))
+				return
+			}
 			writeInternalError(l, w, err)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(res)
+		writeOK(w)
 	}
 }
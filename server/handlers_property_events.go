@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/brojonat/gredfin/server/db/dbgen"
@@ -15,13 +14,12 @@ import (
 
 func handlePropertyEventsGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		propertyID := r.URL.Query().Get("property_id")
-		pid, err := strconv.Atoi(propertyID)
-		if err != nil {
-			writeBadRequestError(w, fmt.Errorf("bad value for property_id"))
+		var params PropertyEventsQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
 			return
 		}
-		events, err := q.GetPropertyEvents(r.Context(), dbgen.GetPropertyEventsParams{PropertyID: int32(pid)})
+		events, err := q.GetPropertyEvents(r.Context(), dbgen.GetPropertyEventsParams{PropertyID: params.PropertyID})
 		if err != nil {
 			writeInternalError(l, w, err)
 			return
@@ -39,11 +37,9 @@ func handlePropertyEventsPost(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc
 			var mr *MalformedRequest
 			var pr *time.ParseError
 			if errors.As(err, &mr) {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: fmt.Sprintf("bad request payload: %s", err.Error())})
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
 			} else if errors.As(err, &pr) {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: fmt.Sprintf("bad timestamp format: %s", err.Error())})
+				writeBadRequestError(w, fmt.Errorf("bad timestamp format: %w", err))
 			} else {
 				writeInternalError(l, w, err)
 			}
@@ -51,14 +47,13 @@ func handlePropertyEventsPost(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc
 		}
 
 		// validate each event, if any are invalid, return early with 400
-		for _, p := range ps {
-			if p.PropertyID == 0 || p.ListingID == 0 ||
-				!p.EventDescription.Valid ||
-				!p.EventTS.Valid {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad request payload: must set property_id, listing_id, event_description, and event_ts"})
-				return
-			}
+		var ve ValidationError
+		for i, p := range ps {
+			validatePropertyEvent(&ve, i, p)
+		}
+		if err := ve.err(); err != nil {
+			writeBadRequestError(w, err)
+			return
 		}
 		// create and return status
 		count, err := q.CreatePropertyEvent(r.Context(), ps)
@@ -66,17 +61,16 @@ func handlePropertyEventsPost(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc
 			// Since this is a bulk creation, we may get some successful and some unsuccessful items,
 			// so write a 400 response but include the count of successful events.
 			if isPGError(err, pgErrorForeignKeyViolation) {
-				writeBadRequestError(w, fmt.Errorf("event must map to an existing property (created %d / %d)", count, len(ps)))
+				writePGError(w, pgErrorForeignKeyViolation, fmt.Sprintf("event must map to an existing property (created %d / %d)", count, len(ps)))
 				return
 			}
 			if isPGError(err, pgErrorNotNullViolation) {
-				writeBadRequestError(w, fmt.Errorf("missing required field (created %d / %d)", count, len(ps)))
+				writePGError(w, pgErrorNotNullViolation, fmt.Sprintf("missing required field (created %d / %d)", count, len(ps)))
 				return
 			}
 			// this case is expected for some clients and has its own status code
 			if isPGError(err, pgErrorUniqueViolation) {
-				w.WriteHeader(http.StatusConflict)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Message: fmt.Sprintf("%d / %d", count, len(ps))})
+				writePGError(w, pgErrorUniqueViolation, fmt.Sprintf("event already exists (created %d / %d)", count, len(ps)))
 				return
 			}
 			// default unhandled error
@@ -96,11 +90,9 @@ func handlePropertyEventsPut(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries)
 			var mr *MalformedRequest
 			var pr *time.ParseError
 			if errors.As(err, &mr) {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: fmt.Sprintf("bad request payload: %s", err.Error())})
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
 			} else if errors.As(err, &pr) {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: fmt.Sprintf("bad timestamp format: %s", err.Error())})
+				writeBadRequestError(w, fmt.Errorf("bad timestamp format: %w", err))
 			} else {
 				writeInternalError(l, w, err)
 			}
@@ -113,17 +105,16 @@ func handlePropertyEventsPut(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries)
 		}
 
 		// validate each event, if any are invalid, return early with 400
+		var ve ValidationError
 		pids := map[int32]struct{}{}
-		for _, p := range ps {
-			if p.PropertyID == 0 || p.ListingID == 0 ||
-				!p.EventDescription.Valid ||
-				!p.EventTS.Valid {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad request payload: must set property_id, listing_id, event_description, and event_ts"})
-				return
-			}
+		for i, p := range ps {
+			validatePropertyEvent(&ve, i, p)
 			pids[p.PropertyID] = struct{}{}
 		}
+		if err := ve.err(); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
 
 		// the PUT route will delete first, and then bulk create
 		tx, err := p.Begin(r.Context())
@@ -145,17 +136,16 @@ func handlePropertyEventsPut(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries)
 		count, err := q.CreatePropertyEvent(r.Context(), ps)
 		if err != nil {
 			if isPGError(err, pgErrorForeignKeyViolation) {
-				writeBadRequestError(w, fmt.Errorf("event must map to an existing property (created %d / %d)", count, len(ps)))
+				writePGError(w, pgErrorForeignKeyViolation, fmt.Sprintf("event must map to an existing property (created %d / %d)", count, len(ps)))
 				return
 			}
 			if isPGError(err, pgErrorNotNullViolation) {
-				writeBadRequestError(w, fmt.Errorf("missing required field (created %d / %d)", count, len(ps)))
+				writePGError(w, pgErrorNotNullViolation, fmt.Sprintf("missing required field (created %d / %d)", count, len(ps)))
 				return
 			}
 			// on the PUT route, this should never really happen since we just cleared the events
 			if isPGError(err, pgErrorUniqueViolation) {
-				w.WriteHeader(http.StatusConflict)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Message: fmt.Sprintf("%d / %d", count, len(ps))})
+				writePGError(w, pgErrorUniqueViolation, fmt.Sprintf("event already exists (created %d / %d)", count, len(ps)))
 				return
 			}
 			// default unhandled error
@@ -169,22 +159,12 @@ func handlePropertyEventsPut(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries)
 
 func handlePropertyEventsDelete(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		event_ids := r.URL.Query()["event_id"]
-		if len(event_ids) == 0 {
-			writeBadRequestError(w, fmt.Errorf("must supply at least one event_id"))
+		var params PropertyEventsDeleteQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
 			return
 		}
-
-		ids := []int32{}
-		for _, eid := range event_ids {
-			id, err := strconv.Atoi(eid)
-			if err != nil {
-				writeBadRequestError(w, fmt.Errorf("bad event_id: %s", eid))
-				return
-			}
-			ids = append(ids, int32(id))
-		}
-		err := q.DeletePropertyEvents(r.Context(), ids)
+		err := q.DeletePropertyEvents(r.Context(), params.EventIDs)
 		if err != nil {
 			writeInternalError(l, w, err)
 			return
@@ -192,3 +172,11 @@ func handlePropertyEventsDelete(l *slog.Logger, q *dbgen.Queries) http.HandlerFu
 		writeOK(w)
 	}
 }
+
+// Adds the missing fields of the i-th event in a request body to ve.
+func validatePropertyEvent(ve *ValidationError, i int, p dbgen.CreatePropertyEventParams) {
+	ve.require(fmt.Sprintf("[%d].property_id", i), p.PropertyID != 0)
+	ve.require(fmt.Sprintf("[%d].listing_id", i), p.ListingID != 0)
+	ve.require(fmt.Sprintf("[%d].event_description", i), p.EventDescription.Valid)
+	ve.require(fmt.Sprintf("[%d].event_ts", i), p.EventTS.Valid)
+}
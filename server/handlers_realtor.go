@@ -3,9 +3,9 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
-	"strconv"
 
 	"github.com/brojonat/gredfin/server/db/dbgen"
 	"github.com/jackc/pgx/v5"
@@ -14,42 +14,37 @@ import (
 
 func handleRealtorGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		realtorID := r.URL.Query().Get("id")
-		name := r.URL.Query().Get("name")
-		search := r.URL.Query().Get("search")
+		var params RealtorQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
 
 		// no identifiers, return listings based on search (if unspecified, all
 		// rows will be returned)
-		if realtorID == "" && name == "" {
-			rs, err := q.SearchRealtorProperties(r.Context(), search)
-			if rs == nil || err == pgx.ErrNoRows {
-				writeEmptyResultError(w)
-				return
-			}
+		if params.RealtorID == 0 && params.Name == "" {
+			rs, err := q.SearchRealtorProperties(r.Context(), params.Search)
 			if err != nil {
 				writeInternalError(l, w, err)
 				return
 			}
+			if rs == nil {
+				rs = []dbgen.SearchRealtorPropertiesRow{}
+			}
 			w.WriteHeader(http.StatusOK)
 			json.NewEncoder(w).Encode(rs)
 			return
 		}
 
 		// realtor_id specified, return listings under that id
-		if realtorID != "" {
-			rid, err := strconv.Atoi(realtorID)
+		if params.RealtorID != 0 {
+			rs, err := q.GetRealtorProperties(r.Context(), dbgen.GetRealtorPropertiesParams{RealtorID: params.RealtorID})
 			if err != nil {
 				writeInternalError(l, w, err)
 				return
 			}
-			rs, err := q.GetRealtorProperties(r.Context(), dbgen.GetRealtorPropertiesParams{RealtorID: int32(rid)})
-			if rs == nil || err == pgx.ErrNoRows {
-				writeEmptyResultError(w)
-				return
-			}
-			if err != nil {
-				writeInternalError(l, w, err)
-				return
+			if rs == nil {
+				rs = []dbgen.GetRealtorPropertiesRow{}
 			}
 			w.WriteHeader(http.StatusOK)
 			json.NewEncoder(w).Encode(rs)
@@ -57,15 +52,14 @@ func handleRealtorGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 		}
 
 		// name specified, return listings under that name
-		rs, err := q.GetRealtorProperties(r.Context(), dbgen.GetRealtorPropertiesParams{Name: name})
-		if rs == nil || err == pgx.ErrNoRows {
-			writeEmptyResultError(w)
-			return
-		}
+		rs, err := q.GetRealtorProperties(r.Context(), dbgen.GetRealtorPropertiesParams{Name: params.Name})
 		if err != nil {
 			writeInternalError(l, w, err)
 			return
 		}
+		if rs == nil {
+			rs = []dbgen.GetRealtorPropertiesRow{}
+		}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(makeLocationSerializable(rs))
 	}
@@ -78,8 +72,7 @@ func handleRealtorPost(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.H
 		if err != nil {
 			var mr *MalformedRequest
 			if errors.As(err, &mr) {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad request"})
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
 			} else {
 				writeInternalError(l, w, err)
 			}
@@ -125,19 +118,15 @@ func handleRealtorPost(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.H
 
 func handleRealtorDelete(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		realtor_id := r.URL.Query().Get("realtor_id")
-		rid, err := strconv.Atoi(realtor_id)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for realtor_id"})
+		var params RealtorDeleteQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
 			return
 		}
-		propertyID := r.URL.Query().Get("property_id")
-		listingID := r.URL.Query().Get("listing_id")
 
 		// delete all entries for this realtor
-		if propertyID != "" && listingID == "" {
-			err := q.DeleteRealtor(r.Context(), int32(rid))
+		if params.PropertyID != 0 && params.ListingID == 0 {
+			err := q.DeleteRealtor(r.Context(), params.RealtorID)
 			if err != nil {
 				writeInternalError(l, w, err)
 				return
@@ -147,26 +136,16 @@ func handleRealtorDelete(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 		}
 
 		// bad request
-		if propertyID == "" || listingID == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "must supply property_id and listing_id"})
+		var ve ValidationError
+		ve.require("property_id", params.PropertyID != 0)
+		ve.require("listing_id", params.ListingID != 0)
+		if err := ve.err(); err != nil {
+			writeBadRequestError(w, err)
 			return
 		}
 
 		// delete single entry
-		pid, err := strconv.Atoi(propertyID)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for property_id"})
-			return
-		}
-		lid, err := strconv.Atoi(listingID)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for listing_id"})
-			return
-		}
-		err = q.DeletePropertyListing(r.Context(), dbgen.DeletePropertyListingParams{PropertyID: int32(pid), ListingID: int32(lid)})
+		err := q.DeletePropertyListing(r.Context(), dbgen.DeletePropertyListingParams{PropertyID: params.PropertyID, ListingID: params.ListingID})
 		if err != nil {
 			writeInternalError(l, w, err)
 			return
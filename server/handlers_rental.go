@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Writes the rental estimate time series for a property. The listing_id is
+// optional.
+func handleRentalEstimatesGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params EstimatesQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		es, err := q.GetRentalEstimates(r.Context(), dbgen.GetRentalEstimatesParams{PropertyID: params.PropertyID, ListingID: params.ListingID})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if es == nil {
+			es = []dbgen.RentalEstimate{}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(es)
+	}
+}
+
+// Bulk creates rental estimates. Estimates that already exist are ignored.
+func handleRentalEstimatesPost(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var es []dbgen.CreateRentalEstimateParams
+		err := decodeJSONBody(r, &es)
+		if err != nil {
+			var mr *MalformedRequest
+			var pr *time.ParseError
+			if errors.As(err, &mr) {
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
+			} else if errors.As(err, &pr) {
+				writeBadRequestError(w, fmt.Errorf("bad timestamp format: %w", err))
+			} else {
+				writeInternalError(l, w, err)
+			}
+			return
+		}
+
+		// validate each estimate, if any are invalid, return 400 listing them all
+		var ve ValidationError
+		for i, e := range es {
+			ve.require(fmt.Sprintf("[%d].property_id", i), e.PropertyID != 0)
+			ve.require(fmt.Sprintf("[%d].listing_id", i), e.ListingID != 0)
+			ve.require(fmt.Sprintf("[%d].estimate_ts", i), e.EstimateTS.Valid)
+			if e.Rent <= 0 {
+				ve.add(fmt.Sprintf("[%d].rent", i), FieldCodeInvalid, "must be positive")
+			}
+		}
+		if err = ve.err(); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+
+		tx, err := p.Begin(r.Context())
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		defer tx.Rollback(r.Context())
+		q = q.WithTx(tx)
+
+		var count int64
+		for _, e := range es {
+			n, err := q.CreateRentalEstimate(r.Context(), e)
+			if err != nil {
+				if isPGError(err, pgErrorForeignKeyViolation) {
+					writePGError(w, pgErrorForeignKeyViolation, "estimate must map to an existing property")
+					return
+				}
+				writeInternalError(l, w, err)
+				return
+			}
+			count += n
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(DefaultJSONResponse{Message: fmt.Sprintf("%d / %d", count, len(es))})
+	}
+}
+
+// Writes the estimated gross rental yield (annual rent over price) for
+// properties with a rental estimate, highest yield first. Results can be
+// filtered by zipcode, city, state, and a minimum yield (e.g., 0.06).
+func handleRentalYieldGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params RentalYieldQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		ys, err := q.GetRentalYield(r.Context(), dbgen.GetRentalYieldParams{
+			Zipcode:  pgtype.Text{String: params.Zipcode, Valid: true},
+			City:     pgtype.Text{String: params.City, Valid: true},
+			State:    pgtype.Text{String: params.State, Valid: true},
+			MinYield: params.MinYield,
+		})
+		if err != nil {
+			writeInternalError(l, w, err)
+			return
+		}
+		if ys == nil {
+			ys = []dbgen.RentalYield{}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ys)
+	}
+}
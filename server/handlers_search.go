@@ -6,11 +6,8 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
-	"strconv"
-	"time"
 
 	"github.com/brojonat/gredfin/server/db/dbgen"
-	"github.com/brojonat/gredfin/server/db/jsonb"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -18,32 +15,30 @@ import (
 
 func handleSearchGet(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		search_id := r.URL.Query().Get("search_id")
-		search_query := r.URL.Query().Get("search_query")
+		var params SearchQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
+			return
+		}
+		search_query := params.Query
 
 		// no identifier supplied, return listing
-		if search_id == "" && search_query == "" {
+		if params.SearchID == 0 && search_query == "" {
 			ss, err := q.ListSearches(r.Context())
-			if ss == nil || err == pgx.ErrNoRows {
-				writeEmptyResultError(w)
-				return
-			}
 			if err != nil {
 				writeInternalError(l, w, err)
 				return
 			}
+			if ss == nil {
+				ss = []dbgen.Search{}
+			}
 			json.NewEncoder(w).Encode(ss)
 			return
 		}
 
 		// search_id specified, return that search
-		if search_id != "" {
-			sid, err := strconv.Atoi(search_id)
-			if err != nil {
-				writeInternalError(l, w, err)
-				return
-			}
-			s, err := q.GetSearch(r.Context(), int32(sid))
+		if params.SearchID != 0 {
+			s, err := q.GetSearch(r.Context(), params.SearchID)
 			if err == pgx.ErrNoRows {
 				writeEmptyResultError(w)
 				return
@@ -81,8 +76,7 @@ func handleSearchPost(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 		if err != nil {
 			var mr *MalformedRequest
 			if errors.As(err, &mr) {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad request"})
+				writeBadRequestError(w, fmt.Errorf("bad request payload: %w", err))
 			} else {
 				writeInternalError(l, w, err)
 			}
@@ -95,41 +89,9 @@ func handleSearchPost(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 			writeInternalError(l, w, err)
 			return
 		}
-		writeOK(w)
-	}
-}
-
-func handleSearchDelete(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		search_id := r.URL.Query().Get("search_id")
-		search_query := r.URL.Query().Get("search_query")
-
-		// no identifier supplied, error out
-		if search_id == "" && search_query == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "must supply search_id or search_query"})
-			return
-		}
-
-		// search_query specified, delete that search
-		if search_query != "" {
-			err := q.DeleteSearchByQuery(r.Context(), pgtype.Text{String: search_query, Valid: true})
-			if err != nil {
-				writeInternalError(l, w, err)
-				return
-			}
-			writeOK(w)
-			return
-		}
 
-		// search_id specified, delete that search
-		sid, err := strconv.Atoi(search_id)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for search_id"})
-			return
-		}
-		err = q.DeleteSearch(r.Context(), int32(sid))
+		// schedule the search scrape; this is a no-op if it's already scheduled
+		err = enqueueRepeatingJob(r.Context(), q, JobKindSearch, p.String, SearchJobPayload{Query: p.String}, searchJobRepeat)
 		if err != nil {
 			writeInternalError(l, w, err)
 			return
@@ -138,113 +100,68 @@ func handleSearchDelete(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
 	}
 }
 
-func handleSearchClaimNext(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.HandlerFunc {
+func handleSearchDelete(l *slog.Logger, p *pgxpool.Pool, q *dbgen.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tx, err := p.Begin(r.Context())
-		if err != nil {
-			writeInternalError(l, w, err)
-			return
-		}
-		defer tx.Commit(r.Context())
-		q = q.WithTx(tx)
-
-		s, err := q.GetNNextSearchScrapeForUpdate(
-			r.Context(),
-			dbgen.GetNNextSearchScrapeForUpdateParams{
-				Count: 1, Statuses: []string{ScrapeStatusGood}},
-		)
-		if err == pgx.ErrNoRows {
-			writeEmptyResultError(w)
-			return
-		}
-		if err != nil {
-			writeInternalError(l, w, err)
-			return
-		}
-		// FIXME: verify that this doesn't overwrite the metadata to {}
-		err = q.UpdateSearchStatus(
-			r.Context(),
-			dbgen.UpdateSearchStatusParams{
-				SearchID:         s.SearchID,
-				LastScrapeStatus: ScrapeStatusPending,
-			},
-		)
-		if err != nil {
-			writeInternalError(l, w, err)
+		var params SearchQuery
+		if err := bindRequest(r, &params); err != nil {
+			writeBadRequestError(w, err)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(s)
-	}
-}
+		search_query := params.Query
 
-func handleSearchSetStatus(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		search_id := r.URL.Query().Get("search_id")
-		status := r.URL.Query().Get("status")
-		success_count := r.URL.Query().Get("success_count")
-		error_count := r.URL.Query().Get("error_count")
-
-		if search_id == "" || status == "" || !isValidStatus(status) {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "must specify search_id, valid status, and property_count"})
+		// no identifier supplied, error out
+		if params.SearchID == 0 && search_query == "" {
+			writeBadRequestError(w, fmt.Errorf("must supply search_id or search_query"))
 			return
 		}
 
-		sid, err := strconv.Atoi(search_id)
+		tx, err := p.Begin(r.Context())
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for search_id"})
+			writeInternalError(l, w, err)
 			return
 		}
+		defer tx.Rollback(r.Context())
+		q = q.WithTx(tx)
 
-		sc, err := strconv.Atoi(success_count)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for success_count"})
-			return
-		}
-		ec, err := strconv.Atoi(error_count)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "bad value for error_count"})
-			return
+		// search_id specified, look up the query so its scrape job can be
+		// deleted along with it
+		if search_query == "" {
+			s, err := q.GetSearch(r.Context(), params.SearchID)
+			if err == pgx.ErrNoRows {
+				writeOK(w)
+				return
+			}
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+			search_query = s.Query.String
+			err = q.DeleteSearch(r.Context(), s.SearchID)
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
+		} else {
+			err = q.DeleteSearchByQuery(r.Context(), pgtype.Text{String: search_query, Valid: true})
+			if err != nil {
+				writeInternalError(l, w, err)
+				return
+			}
 		}
 
-		err = q.UpdateSearchStatus(
-			r.Context(),
-			dbgen.UpdateSearchStatusParams{
-				SearchID:           int32(sid),
-				LastScrapeStatus:   status,
-				LastScrapeMetadata: &jsonb.SearchScrapeMetadata{SuccessCount: sc, ErrorCount: ec},
-			},
-		)
+		// delete the search's scrape job
+		err = q.DeleteJobByDedupeKey(r.Context(), dbgen.DeleteJobByDedupeKeyParams{
+			Kind:      JobKindSearch,
+			DedupeKey: pgtype.Text{String: search_query, Valid: true},
+		})
 		if err != nil {
 			writeInternalError(l, w, err)
 			return
 		}
-		writeOK(w)
-	}
-}
-
-func handleGetRecentSearchScrapeStats(l *slog.Logger, q *dbgen.Queries) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		dp := r.URL.Query().Get("duration")
-		if dp == "" {
-			dp = "5m"
-		}
-		dur, err := time.ParseDuration(dp)
-		if err != nil {
-			writeBadRequestError(w, fmt.Errorf("could not parse duration %s", dp))
-			return
-		}
-		ts := time.Now().Add(-dur)
-		res, err := q.GetRecentSearchScrapeStats(r.Context(), pgtype.Timestamp{Time: ts, Valid: true})
-		if err != nil {
+		if err = tx.Commit(r.Context()); err != nil {
 			writeInternalError(l, w, err)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(res)
+		writeOK(w)
 	}
 }
@@ -44,10 +44,19 @@ func (mr *MalformedRequest) Error() string {
 }
 
 func decodeJSONBody(r *http.Request, dst interface{}) error {
+	return decodeBody(r, dst, "application/json")
+}
+
+// Decodes a JSON merge patch (RFC 7396) body; see PatchValue.
+func decodeMergePatchBody(r *http.Request, dst interface{}) error {
+	return decodeBody(r, dst, mergePatchContentType)
+}
+
+func decodeBody(r *http.Request, dst interface{}, contentType string) error {
 	if r.Header.Get("Content-Type") != "" {
 		value, _ := header.ParseValueAndParams(r.Header, "Content-Type")
-		if value != "application/json" {
-			msg := "Content-Type header is not application/json"
+		if value != contentType {
+			msg := fmt.Sprintf("Content-Type header is not %s", contentType)
 			return &MalformedRequest{Status: http.StatusUnsupportedMediaType, Msg: msg}
 		}
 	}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// KeyRing holds the keys that sign and verify server-issued tokens. Tokens are
+// signed with the active key and carry its ID in their kid header. Any key in
+// the ring can verify tokens, so a retired key can stay in the ring until the
+// tokens it signed have expired; that way rotating the active key doesn't
+// invalidate any tokens. The public keys are published as a JWKS so that other
+// services can verify tokens without holding any secrets.
+//
+// Tokens without a kid are HS256 tokens signed with the server's secret key.
+// A ring without asymmetric keys signs tokens this way, and a ring with them
+// still accepts such tokens so existing tokens keep working after switching.
+type KeyRing struct {
+	activeKID string
+	keys      map[string]ringKey
+	secret    []byte
+}
+
+type ringKey struct {
+	method  jwt.SigningMethod
+	public  crypto.PublicKey
+	private crypto.PrivateKey
+}
+
+// LoadKeyRing loads the PEM encoded keys in dir (if any); each file is named
+// after its key ID, e.g., 2024-06.pem. Files can hold an RSA or ECDSA private
+// key, or just the public key of a retired key. The key with the activeKID
+// signs new tokens; it can be omitted if there's only one private key. The
+// secret is the server's secret key.
+func LoadKeyRing(dir, activeKID, secret string) (*KeyRing, error) {
+	kr := &KeyRing{keys: map[string]ringKey{}, secret: []byte(secret)}
+	if dir == "" {
+		if secret == "" {
+			return nil, fmt.Errorf("must supply a secret key or a signing key directory")
+		}
+		return kr, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	privateKIDs := []string{}
+	for _, p := range paths {
+		kid := strings.TrimSuffix(filepath.Base(p), ".pem")
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		k, err := parseRingKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("error loading signing key %s: %w", kid, err)
+		}
+		kr.keys[kid] = k
+		if k.private != nil {
+			privateKIDs = append(privateKIDs, kid)
+		}
+	}
+	sort.Strings(privateKIDs)
+	switch {
+	case activeKID != "":
+		if k, ok := kr.keys[activeKID]; !ok || k.private == nil {
+			return nil, fmt.Errorf("no private key for active signing key %s in %s", activeKID, dir)
+		}
+		kr.activeKID = activeKID
+	case len(privateKIDs) == 1:
+		kr.activeKID = privateKIDs[0]
+	default:
+		return nil, fmt.Errorf("must choose the active signing key from %v", privateKIDs)
+	}
+	return kr, nil
+}
+
+// Parses a PEM encoded private or public key and determines the signing method
+// to use with it.
+func parseRingKey(b []byte) (ringKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return ringKey{}, fmt.Errorf("no PEM data found")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return ringKey{}, fmt.Errorf("unsupported PEM block type %s", block.Type)
+	}
+	if err != nil {
+		return ringKey{}, err
+	}
+
+	var rk ringKey
+	if signer, ok := key.(crypto.Signer); ok {
+		rk.private = key
+		rk.public = signer.Public()
+	} else {
+		rk.public = key
+	}
+	switch pub := rk.public.(type) {
+	case *rsa.PublicKey:
+		rk.method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			rk.method = jwt.SigningMethodES256
+		case elliptic.P384():
+			rk.method = jwt.SigningMethodES384
+		case elliptic.P521():
+			rk.method = jwt.SigningMethodES512
+		default:
+			return ringKey{}, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+		}
+	default:
+		return ringKey{}, fmt.Errorf("unsupported key type %T", rk.public)
+	}
+	return rk, nil
+}
+
+// Signs the claims with the active key, or with the secret key if the ring
+// has no asymmetric keys.
+func (kr *KeyRing) sign(claims jwt.Claims) (string, error) {
+	if kr.activeKID == "" {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(kr.secret)
+	}
+	k := kr.keys[kr.activeKID]
+	t := jwt.NewWithClaims(k.method, claims)
+	t.Header["kid"] = kr.activeKID
+	return t.SignedString(k.private)
+}
+
+// Returns the key that verifies the token. The token's algorithm must match
+// the key's so that a public key can't be passed off as an HMAC secret.
+func (kr *KeyRing) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		if t.Method != jwt.SigningMethodHS256 || len(kr.secret) == 0 {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return kr.secret, nil
+	}
+	k, ok := kr.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", kid)
+	}
+	if t.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", t.Method.Alg(), kid)
+	}
+	return k.public, nil
+}
+
+// Reports whether the supplied value is the server's secret key.
+func (kr *KeyRing) isSecret(v string) bool {
+	return len(kr.secret) > 0 && subtle.ConstantTimeCompare([]byte(v), kr.secret) == 1
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Returns the public keys in the ring as a JWKS, sorted by key ID. The secret
+// key is never included.
+func (kr *KeyRing) JWKS() JWKS {
+	kids := []string{}
+	for kid := range kr.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	res := JWKS{Keys: []JWK{}}
+	for _, kid := range kids {
+		k := kr.keys[kid]
+		jwk := JWK{KeyID: kid, Use: "sig", Algorithm: k.method.Alg()}
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = pub.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+		}
+		res.Keys = append(res.Keys, jwk)
+	}
+	return res
+}
@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"firebase.google.com/go/auth"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/brojonat/gredfin/redfin"
 	"github.com/brojonat/gredfin/server/db/dbgen"
 	"github.com/jackc/pgx/v5"
@@ -39,8 +39,11 @@ func RunHTTPServer(
 	l *slog.Logger,
 	dbHost string,
 	c redfin.Client,
-	s3 *s3.Client,
+	bs BlobStore,
 	fbc *auth.Client,
+	kr *KeyRing,
+	rls *RateLimits,
+	marketStatsInterval time.Duration,
 ) error {
 	db, err := getConnPool(ctx, dbHost)
 	if err != nil {
@@ -48,9 +51,17 @@ func RunHTTPServer(
 	}
 	q := dbgen.New(db)
 
+	if err = enqueueScrapeJobs(ctx, l, q); err != nil {
+		return err
+	}
+
+	if marketStatsInterval > 0 {
+		go refreshMarketStats(ctx, l, q, marketStatsInterval)
+	}
+
 	l.Info(fmt.Sprintf("listening on %s...", port))
 	return http.ListenAndServe(
 		fmt.Sprintf(":%s", port),
-		getRootHandler(l, db, q, s3, fbc),
+		getRootHandler(l, db, q, bs, fbc, kr, rls),
 	)
 }
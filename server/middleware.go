@@ -2,23 +2,24 @@ package server
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"firebase.google.com/go/auth"
+	"github.com/brojonat/gredfin/server/db/dbgen"
 	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/handlers"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 const FirebaseJWTHeader = "Firebase-JWT"
 
-type contextKey int
-
-var jwtCtxKey contextKey = 1
-
 type handlerAdapter func(http.HandlerFunc) http.HandlerFunc
 
 // AdaptHandler will wrap h with the supplied middleware; note that the
@@ -33,14 +34,16 @@ func adaptHandler(h http.HandlerFunc, opts ...handlerAdapter) http.HandlerFunc {
 
 // Convenience middleware that applies commonly used middleware to the wrapped
 // handler. This will make the handler gracefully handle panics, sets the
-// content type to application/json, limits the body size that clients can send,
-// wraps the handler with the usual CORS settings.
+// content type to application/json, tags the request with an ID, limits the
+// body size that clients can send, wraps the handler with the usual CORS
+// settings.
 func apiMode(l *slog.Logger, maxBytes int64, headers, methods, origins []string) handlerAdapter {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			next = makeGraceful(l)(next)
 			next = setMaxBytesReader(maxBytes)(next)
 			next = setContentType("application/json")(next)
+			next = setRequestID()(next)
 			handlers.CORS(
 				handlers.AllowedHeaders(headers),
 				handlers.AllowedMethods(methods),
@@ -81,6 +84,42 @@ func makeGraceful(l *slog.Logger) handlerAdapter {
 	}
 }
 
+// Requests are identified by this header in both directions. Clients can
+// supply their own ID (e.g., to correlate with their logs); otherwise one is
+// generated.
+const requestIDHeader = "X-Request-ID"
+const maxRequestIDLen = 128
+
+// Sets the request ID response header, which problem responses and error logs
+// include.
+func setRequestID() handlerAdapter {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(requestIDHeader)
+			if !validRequestID(id) {
+				b := make([]byte, 16)
+				rand.Read(b)
+				id = hex.EncodeToString(b)
+			}
+			w.Header().Set(requestIDHeader, id)
+			next(w, r)
+		}
+	}
+}
+
+// Reports whether a client supplied request ID is safe to echo and log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
 func setMaxBytesReader(mb int64) handlerAdapter {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
@@ -90,34 +129,101 @@ func setMaxBytesReader(mb int64) handlerAdapter {
 	}
 }
 
-func bearerAuthorizer() func(*http.Request) bool {
+// Authorizes requests that supply a token issued by the server as a bearer
+// token. The token must verify with one of the keys in the ring.
+func bearerAuthorizer(kr *KeyRing) func(*http.Request) bool {
 	return func(r *http.Request) bool {
 		var claims authJWTClaims
 		ts := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 		if ts == "" {
 			return false
 		}
-		kf := func(token *jwt.Token) (interface{}, error) {
-			return []byte(getSecretKey()), nil
-		}
-		token, err := jwt.ParseWithClaims(ts, &claims, kf)
+		token, err := jwt.ParseWithClaims(ts, &claims, kr.keyFunc)
 		if err != nil || !token.Valid {
 			return false
 		}
-		// FIXME: verify this actually works and then do something similar in
-		// the firebase authorizer since right now they don't have parity
-		ctx := context.WithValue(r.Context(), jwtCtxKey, token.Claims)
-		*r = *r.WithContext(ctx)
+		setPrincipal(r, claims.principal())
 		return true
 	}
 }
 
-// Uses Firebase-JWT header and firebase client to auth
-func firebaseAuthorizer(hname string, fbc *auth.Client) func(*http.Request) bool {
+// Interval at which the use of an API key is recorded.
+const apiKeyTouchInterval = time.Minute
+
+// When this replica last recorded the use of each API key. This is shared by
+// the authorizers of every route.
+var apiKeyTouches = struct {
+	sync.Mutex
+	last map[int64]time.Time
+}{last: map[int64]time.Time{}}
+
+// Authorizes requests that supply an API key as a bearer token. Revoked and
+// expired keys are rejected. When a key was last used is only recorded once
+// per interval (per replica, and TouchAPIKey skips keys another replica has
+// just recorded), so authorizing a key doesn't write to the database on every
+// request.
+func apiKeyAuthorizer(q *dbgen.Queries) func(*http.Request) bool {
 	return func(r *http.Request) bool {
-		if _, err := fbc.VerifyIDToken(r.Context(), r.Header.Get(hname)); err != nil {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !strings.HasPrefix(key, apiKeyPrefix) {
+			return false
+		}
+		k, err := q.GetAPIKeyByHash(r.Context(), hashAPIKey(key))
+		if err != nil {
 			return false
 		}
+		apiKeyTouches.Lock()
+		now := time.Now()
+		stale := now.Sub(apiKeyTouches.last[k.KeyID]) >= apiKeyTouchInterval
+		if stale {
+			apiKeyTouches.last[k.KeyID] = now
+		}
+		apiKeyTouches.Unlock()
+		if stale {
+			// last_used_ts is informational, so failing to record it doesn't
+			// fail the request
+			_ = q.TouchAPIKey(r.Context(), k.KeyID)
+		}
+		setPrincipal(r, Principal{
+			UserID:   k.Owner,
+			Email:    k.Owner,
+			Provider: ProviderAPIKey,
+			Roles:    rolesOf(k.Role),
+			Scopes:   k.Scopes,
+			APIKeyID: k.KeyID,
+		})
+		return true
+	}
+}
+
+// IDTokenVerifier verifies Firebase ID tokens; it's implemented by the
+// Firebase auth client.
+type IDTokenVerifier interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error)
+}
+
+// Uses Firebase-JWT header and firebase client to auth. Firebase users are
+// readers unless their custom claims assign them a known role.
+func firebaseAuthorizer(hname string, fbc IDTokenVerifier) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		token, err := fbc.VerifyIDToken(r.Context(), r.Header.Get(hname))
+		if err != nil {
+			return false
+		}
+		role := RoleReader
+		if cr, ok := token.Claims["role"].(string); ok {
+			if _, known := roleScopes[cr]; known {
+				role = cr
+			}
+		}
+		email, _ := token.Claims["email"].(string)
+		setPrincipal(r, Principal{
+			UserID:   token.UID,
+			Email:    email,
+			Provider: ProviderFirebase,
+			Roles:    rolesOf(role),
+			Scopes:   []string{},
+		})
 		return true
 	}
 }
@@ -134,8 +240,58 @@ func atLeastOneAuth(authorizers ...func(*http.Request) bool) handlerAdapter {
 				next(w, r)
 				return
 			}
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(DefaultJSONResponse{Error: "unauthorized"})
+			writeUnauthorizedError(w, "unauthorized")
+		}
+	}
+}
+
+// Requires the principal put in the context by the authorizers to have at
+// least one of the supplied scopes, otherwise a forbidden response is written.
+// This must come after atLeastOneAuth.
+func requireScope(scopes ...string) handlerAdapter {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFromContext(r.Context())
+			if !ok || !p.HasScope(scopes...) {
+				writeForbiddenError(w, "forbidden")
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// Captures the status code written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// Records the request in the audit log once the handler returns. Requests that
+// are rejected by requireScope are recorded too, so this must come after
+// atLeastOneAuth and before requireScope.
+func auditRequest(l *slog.Logger, q *dbgen.Queries) handlerAdapter {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next(sr, r)
+			p, _ := PrincipalFromContext(r.Context())
+			err := q.InsertAuditLog(context.WithoutCancel(r.Context()), dbgen.InsertAuditLogParams{
+				KeyID:      pgtype.Int8{Int64: p.APIKeyID, Valid: p.APIKeyID != 0},
+				Principal:  p.UserID,
+				Method:     r.Method,
+				Path:       r.URL.RequestURI(),
+				Status:     int32(sr.status),
+				RemoteAddr: r.RemoteAddr,
+			})
+			if err != nil {
+				l.Error("error recording audit log", "error", err.Error(), "method", r.Method, "path", r.URL.Path)
+			}
 		}
 	}
 }
@@ -0,0 +1,854 @@
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/twpayne/go-geom"
+)
+
+// The OpenAPI 3.1 spec is generated from the routes registered in
+// getRootHandler and the routeSpecs that document them. It documents the
+// versioned paths; the deprecated unversioned aliases (see version.go) aren't
+// listed. Schemas are reflected from the Go types the handlers bind, decode,
+// and encode, so the spec can't drift from the types; getRootHandler panics if
+// a route isn't documented. Setting OPENAPI_CHECK_RESPONSES checks every
+// response against the spec and logs the ones that don't conform, which
+// catches handlers whose behavior has drifted from their routeSpec (e.g., run
+// the Bruno collection against it).
+
+//go:embed openapi.html
+var openAPIDocsPage []byte
+
+const openAPIVersion = "3.1.0"
+const apiVersion = "1.0.0"
+
+// Responses over this size aren't checked against the spec.
+const maxCheckedResponseBytes = 4 * 1048576
+
+// routeSpec documents a route for the OpenAPI spec. Params is the struct the
+// handler binds its query and path params to, Body is what it decodes the
+// request body into, and Response is what it writes on success. Routes that
+// take or write something other than JSON use rawContent.
+type routeSpec struct {
+	Summary  string
+	Params   any
+	Body     any
+	Response any
+	// Success statuses; defaults to 200.
+	Statuses []int
+	// Scopes the principal needs (any of them). Routes that any principal can
+	// hit leave this empty.
+	Scopes []string
+	// Routes that don't require a token.
+	Public bool
+	// Routes that also accept a Firebase-JWT.
+	Firebase bool
+	// Set for deprecated routes, whose responses carry deprecation headers.
+	Deprecated *deprecation
+}
+
+// rawContent is the Body or Response of a route that isn't JSON encoded from a
+// Go type; the value is its content type.
+type rawContent string
+
+// oneOf is the Response of a route that writes one of several types depending
+// on its params.
+type oneOf []any
+
+// routeTable is a ServeMux that serves routes under the current API version
+// and remembers the routes registered on it, so that the spec covers exactly
+// the routes that are served.
+type routeTable struct {
+	*http.ServeMux
+	// Routes in the order they're registered, and the route of every served
+	// pattern, including aliases.
+	routes []route
+	served map[string]route
+	// Use of deprecated routes is logged here.
+	deprecations *deprecationLog
+	// When the unversioned aliases will stop being served, if it's decided.
+	unversionedSunset time.Time
+	spec              []byte
+	// The spec as decoded JSON, which responses are checked against.
+	doc map[string]any
+	// Set to check responses against the spec.
+	checker *slog.Logger
+}
+
+// route is a registered route. Its pattern is the one it's served and
+// documented at (e.g., "GET /v1/property") and spec is the key of its
+// routeSpec, which is the unversioned pattern (e.g., "GET /property").
+type route struct {
+	pattern string
+	spec    string
+}
+
+func newRouteTable(l *slog.Logger) *routeTable {
+	return &routeTable{
+		ServeMux:     http.NewServeMux(),
+		served:       map[string]route{},
+		deprecations: newDeprecationLog(l),
+	}
+}
+
+// Registers the route with the supplied unversioned pattern under the current
+// API version, and at the unversioned pattern as a deprecated alias. Routes
+// that are deprecated in their own right keep their deprecation at both.
+func (rt *routeTable) HandleFunc(pattern string, h http.HandlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	r := route{pattern: method + " " + apiPathPrefix + path, spec: pattern}
+	rt.routes = append(rt.routes, r)
+	dep := routeSpecs[pattern].Deprecated
+	if dep == nil {
+		rt.serve(r.pattern, r, h)
+		dep = &deprecation{Since: unversionedDeprecated, Sunset: rt.unversionedSunset, Successor: versionedSuccessor}
+	} else {
+		rt.serve(r.pattern, r, adaptHandler(h, deprecate(rt.deprecations, r.pattern, *dep)))
+	}
+	rt.serve(pattern, r, adaptHandler(h, deprecate(rt.deprecations, pattern, *dep)))
+}
+
+// Registers a route that was added after the API was versioned, so it's only
+// served under the current version.
+func (rt *routeTable) handleVersioned(pattern string, h http.HandlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	r := route{pattern: method + " " + apiPathPrefix + path, spec: pattern}
+	rt.routes = append(rt.routes, r)
+	if dep := routeSpecs[pattern].Deprecated; dep != nil {
+		h = adaptHandler(h, deprecate(rt.deprecations, r.pattern, *dep))
+	}
+	rt.serve(r.pattern, r, h)
+}
+
+// Registers a route that isn't versioned.
+func (rt *routeTable) handleUnversioned(pattern string, h http.HandlerFunc) {
+	r := route{pattern: pattern, spec: pattern}
+	rt.routes = append(rt.routes, r)
+	if dep := routeSpecs[pattern].Deprecated; dep != nil {
+		h = adaptHandler(h, deprecate(rt.deprecations, pattern, *dep))
+	}
+	rt.serve(pattern, r, h)
+}
+
+// Serves the handler of the route at the pattern.
+func (rt *routeTable) serve(pattern string, r route, h http.HandlerFunc) {
+	rt.served[pattern] = r
+	rt.ServeMux.HandleFunc(pattern, h)
+}
+
+// Generates the spec from the registered routes. This must be called once all
+// the routes are registered.
+func (rt *routeTable) buildSpec() error {
+	doc, err := buildOpenAPISpec(rt.routes)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	rt.spec = b
+	return json.Unmarshal(b, &rt.doc)
+}
+
+func (rt *routeTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rt.checker == nil {
+		rt.ServeMux.ServeHTTP(w, r)
+		return
+	}
+	_, pattern := rt.ServeMux.Handler(r)
+	rec := &responseRecorder{statusRecorder: statusRecorder{ResponseWriter: w, status: http.StatusOK}}
+	rt.ServeMux.ServeHTTP(rec, r)
+	route, ok := rt.served[pattern]
+	if _, documented := routeSpecs[route.spec]; !ok || !documented {
+		return
+	}
+	if err := rt.checkResponse(route.pattern, rec); err != nil {
+		rt.checker.Error(
+			"response does not conform to the API spec",
+			"route", pattern,
+			"status", rec.status,
+			"error", err.Error(),
+			"request_id", w.Header().Get(requestIDHeader),
+		)
+	}
+}
+
+// Captures the status and body written by the wrapped handler.
+type responseRecorder struct {
+	statusRecorder
+	body bytes.Buffer
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.body.Len() <= maxCheckedResponseBytes {
+		rr.body.Write(b)
+	}
+	return rr.ResponseWriter.Write(b)
+}
+
+// Writes the OpenAPI spec.
+func handleOpenAPISpec(rt *routeTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write(rt.spec)
+	}
+}
+
+// Writes a page that renders the OpenAPI spec.
+func handleOpenAPIDocs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(openAPIDocsPage)
+	}
+}
+
+// Returns the OpenAPI document for the routes. Every route except OPTIONS
+// routes must have a routeSpec.
+func buildOpenAPISpec(routes []route) (map[string]any, error) {
+	g := newSchemaGen()
+	paths := map[string]map[string]any{}
+	missing := []string{}
+	for _, r := range routes {
+		method, path, _ := strings.Cut(r.pattern, " ")
+		if method == http.MethodOptions {
+			continue
+		}
+		spec, ok := routeSpecs[r.spec]
+		if !ok {
+			missing = append(missing, r.spec)
+			continue
+		}
+		path = strings.ReplaceAll(path, "...}", "}")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		// operations are named after the unversioned path, so they keep their
+		// names across versions
+		_, specPath, _ := strings.Cut(strings.ReplaceAll(r.spec, "...}", "}"), " ")
+		paths[path][strings.ToLower(method)] = g.operation(method, specPath, spec)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("routes missing from routeSpecs: %s", strings.Join(missing, ", "))
+	}
+	g.component(reflect.TypeOf(Problem{}), false)
+	return map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":   "gredfin",
+			"version": apiVersion,
+			"description": fmt.Sprintf("Routes under %s are also served without the prefix; those paths are deprecated "+
+				"and their responses carry Deprecation and Sunset headers.", apiPathPrefix),
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"responses": map[string]any{
+				"Problem": map[string]any{
+					"description": "An RFC 7807 problem; branch on its code.",
+					"content": map[string]any{
+						problemContentType: map[string]any{"schema": ref("Problem")},
+					},
+				},
+			},
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT or API key",
+				},
+				"firebaseAuth": map[string]any{
+					"type": "apiKey",
+					"in":   "header",
+					"name": FirebaseJWTHeader,
+				},
+			},
+		},
+	}, nil
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// Reflects JSON schemas from Go types, collecting named struct types as
+// components.
+type schemaGen struct {
+	schemas map[string]any
+	names   map[schemaKey]string
+}
+
+// Request bodies and responses get separate schemas, since fields are required
+// in requests when they're validated as such and in responses when they're
+// always written.
+type schemaKey struct {
+	t     reflect.Type
+	input bool
+}
+
+func newSchemaGen() *schemaGen {
+	return &schemaGen{schemas: map[string]any{}, names: map[schemaKey]string{}}
+}
+
+func (g *schemaGen) operation(method, path string, spec routeSpec) map[string]any {
+	op := map[string]any{
+		"operationId": operationID(method, path),
+		"summary":     spec.Summary,
+		"tags":        []string{operationTag(path)},
+	}
+	if spec.Deprecated != nil {
+		op["deprecated"] = true
+	}
+	if !spec.Public {
+		op["security"] = securityOf(spec)
+	}
+	if spec.Params != nil {
+		op["parameters"] = g.parameters(reflect.TypeOf(spec.Params))
+	}
+	if spec.Body != nil {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content":  g.content(spec.Body, method == http.MethodPatch, true),
+		}
+	}
+	responses := map[string]any{"default": map[string]any{"$ref": "#/components/responses/Problem"}}
+	for _, status := range successStatuses(spec) {
+		res := map[string]any{"description": http.StatusText(status)}
+		if spec.Response != nil {
+			res["content"] = g.content(spec.Response, false, false)
+		}
+		responses[strconv.Itoa(status)] = res
+	}
+	op["responses"] = responses
+	return op
+}
+
+func successStatuses(spec routeSpec) []int {
+	if len(spec.Statuses) == 0 {
+		return []int{http.StatusOK}
+	}
+	return spec.Statuses
+}
+
+// Tokens and API keys are bearer tokens; routes list the scopes they require
+// as the roles of the requirement, which OpenAPI 3.1 allows.
+func securityOf(spec routeSpec) []map[string][]string {
+	scopes := spec.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	sec := []map[string][]string{{"bearerAuth": scopes}}
+	if spec.Firebase {
+		sec = append(sec, map[string][]string{"firebaseAuth": scopes})
+	}
+	return sec
+}
+
+func (g *schemaGen) content(v any, mergePatch, input bool) map[string]any {
+	if rc, ok := v.(rawContent); ok {
+		schema := map[string]any{}
+		if rc != "application/json" {
+			schema = map[string]any{"type": "string", "format": "binary"}
+		}
+		return map[string]any{string(rc): map[string]any{"schema": schema}}
+	}
+	ct := "application/json"
+	if mergePatch {
+		ct = mergePatchContentType
+	}
+	return map[string]any{ct: map[string]any{"schema": g.valueSchema(v, input)}}
+}
+
+func (g *schemaGen) valueSchema(v any, input bool) map[string]any {
+	if vs, ok := v.(oneOf); ok {
+		schemas := []any{}
+		for _, v := range vs {
+			schemas = append(schemas, g.schema(reflect.TypeOf(v), input))
+		}
+		return map[string]any{"oneOf": schemas}
+	}
+	return g.schema(reflect.TypeOf(v), input)
+}
+
+// Returns the parameters declared by the query and path tags of the struct.
+func (g *schemaGen) parameters(t reflect.Type) []map[string]any {
+	params := []map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			params = append(params, g.parameters(sf.Type)...)
+			continue
+		}
+		in, name := "query", sf.Tag.Get("query")
+		if n, ok := sf.Tag.Lookup("path"); ok {
+			in, name = "path", n
+		}
+		if name == "" {
+			continue
+		}
+		rules := parseRules(sf.Tag.Get("validate"))
+		_, required := rules["required"]
+		schema := paramSchema(sf.Type)
+		applyRules(schema, sf.Type, rules)
+		params = append(params, map[string]any{
+			"name":     name,
+			"in":       in,
+			"required": required || in == "path",
+			"schema":   schema,
+		})
+	}
+	return params
+}
+
+// Params are parsed by setScalar, so durations and times are strings.
+func paramSchema(t reflect.Type) map[string]any {
+	switch {
+	case t == durationType:
+		return map[string]any{"type": "string", "examples": []string{"24h"}}
+	case t == timeType:
+		return map[string]any{"anyOf": []any{
+			map[string]any{"type": "string", "format": "date"},
+			map[string]any{"type": "string", "format": "date-time"},
+		}}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return paramSchema(t.Elem())
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": paramSchema(t.Elem())}
+	default:
+		return primitiveSchema(t)
+	}
+}
+
+// Applies the validate rules of a field to its schema.
+func applyRules(schema map[string]any, t reflect.Type, rules map[string]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	minKey, maxKey := "minimum", "maximum"
+	switch {
+	case t == durationType || t == timeType:
+		return
+	case t.Kind() == reflect.String:
+		minKey, maxKey = "minLength", "maxLength"
+	case t.Kind() == reflect.Slice:
+		minKey, maxKey = "minItems", "maxItems"
+	}
+	for k, arg := range rules {
+		n, _ := strconv.ParseFloat(arg, 64)
+		switch k {
+		case "min":
+			schema[minKey] = n
+		case "max":
+			schema[maxKey] = n
+		case "gt":
+			schema["exclusiveMinimum"] = n
+		case "oneof":
+			if t.Kind() == reflect.Slice {
+				schema["items"].(map[string]any)["enum"] = strings.Fields(arg)
+			} else {
+				schema["enum"] = strings.Fields(arg)
+			}
+		case "sha256":
+			schema["pattern"] = "^[0-9a-f]{64}$"
+		}
+	}
+}
+
+func primitiveSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return map[string]any{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// Schemas of types that have their own JSON encoding.
+var knownSchemas = map[reflect.Type]map[string]any{
+	timeType:                             {"type": "string", "format": "date-time"},
+	reflect.TypeOf(json.RawMessage{}):    {},
+	reflect.TypeOf(pgtype.Text{}):        {"type": []string{"string", "null"}},
+	reflect.TypeOf(pgtype.Bool{}):        {"type": []string{"boolean", "null"}},
+	reflect.TypeOf(pgtype.Int4{}):        {"type": []string{"integer", "null"}, "format": "int32"},
+	reflect.TypeOf(pgtype.Int8{}):        {"type": []string{"integer", "null"}, "format": "int64"},
+	reflect.TypeOf(pgtype.Float4{}):      {"type": []string{"number", "null"}, "format": "float"},
+	reflect.TypeOf(pgtype.Float8{}):      {"type": []string{"number", "null"}, "format": "double"},
+	reflect.TypeOf(pgtype.Timestamp{}):   {"type": []string{"string", "null"}, "format": "date-time"},
+	reflect.TypeOf(pgtype.Timestamptz{}): {"type": []string{"string", "null"}, "format": "date-time"},
+	reflect.TypeOf(pgtype.Date{}):        {"type": []string{"string", "null"}, "format": "date"},
+}
+
+// Implemented by PatchValue, whose schema is that of its value.
+type patchField interface {
+	patchValueType() reflect.Type
+}
+
+var patchFieldType = reflect.TypeOf((*patchField)(nil)).Elem()
+
+func (g *schemaGen) schema(t reflect.Type, input bool) map[string]any {
+	if s, ok := knownSchemas[t]; ok {
+		return copySchema(s)
+	}
+	if t.Implements(patchFieldType) {
+		return nullable(g.schema(reflect.Zero(t).Interface().(patchField).patchValueType(), input))
+	}
+	if t == reflect.TypeOf(geom.Point{}) {
+		// *geom.Point fields are replaced with a Location, see
+		// makeLocationSerializable
+		return g.schema(reflect.TypeOf(Location{}), input)
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(g.schema(t.Elem(), input))
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": []string{"array", "null"}, "items": g.schema(t.Elem(), input)}
+	case reflect.Map:
+		return map[string]any{"type": []string{"object", "null"}, "additionalProperties": g.schema(t.Elem(), input)}
+	case reflect.Interface:
+		return map[string]any{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t, input)
+		}
+		return ref(g.component(t, input))
+	default:
+		return primitiveSchema(t)
+	}
+}
+
+// Returns the name of the component for the struct, adding it if needed.
+func (g *schemaGen) component(t reflect.Type, input bool) string {
+	key := schemaKey{t, input}
+	if name, ok := g.names[key]; ok {
+		return name
+	}
+	name := t.Name()
+	if input {
+		if _, ok := g.names[schemaKey{t, false}]; ok {
+			name += "Input"
+		}
+	} else if other, ok := g.names[schemaKey{t, true}]; ok && other == name {
+		name += "Output"
+	}
+	if _, taken := g.schemas[name]; taken {
+		name = strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + name
+	}
+	g.names[key] = name
+	g.schemas[name] = map[string]any{} // placeholder for recursive types
+	g.schemas[name] = g.structSchema(t, input)
+	return name
+}
+
+// Fields of structs follow the encoding/json rules: embedded structs are
+// flattened, and shallower fields hide deeper ones with the same name.
+func (g *schemaGen) structSchema(t reflect.Type, input bool) map[string]any {
+	props := map[string]any{}
+	required := []string{}
+	for _, f := range jsonFields(t) {
+		s := g.schema(f.field.Type, input)
+		rules := parseRules(f.field.Tag.Get("validate"))
+		applyRules(s, f.field.Type, rules)
+		props[f.name] = s
+		_, validated := rules["required"]
+		if (input && validated) || (!input && !f.omitEmpty) {
+			required = append(required, f.name)
+		}
+	}
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		slices.Sort(required)
+		s["required"] = required
+	}
+	return s
+}
+
+type jsonField struct {
+	name      string
+	field     reflect.StructField
+	omitEmpty bool
+	depth     int
+}
+
+func jsonFields(t reflect.Type) []jsonField {
+	fields := map[string]jsonField{}
+	var walk func(t reflect.Type, depth int)
+	walk = func(t reflect.Type, depth int) {
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag := sf.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+				walk(sf.Type, depth+1)
+				continue
+			}
+			if !sf.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = sf.Name
+			}
+			if f, ok := fields[name]; ok && f.depth <= depth {
+				continue
+			}
+			fields[name] = jsonField{name, sf, slices.Contains(strings.Split(opts, ","), "omitempty"), depth}
+		}
+	}
+	walk(t, 0)
+	res := []jsonField{}
+	for _, f := range fields {
+		res = append(res, f)
+	}
+	slices.SortFunc(res, func(a, b jsonField) int { return strings.Compare(a.name, b.name) })
+	return res
+}
+
+func nullable(s map[string]any) map[string]any {
+	switch typ := s["type"].(type) {
+	case string:
+		s["type"] = []string{typ, "null"}
+		return s
+	case []string:
+		if !slices.Contains(typ, "null") {
+			s["type"] = append(typ, "null")
+		}
+		return s
+	}
+	if len(s) == 0 {
+		return s
+	}
+	return map[string]any{"oneOf": []any{s, map[string]any{"type": "null"}}}
+}
+
+func copySchema(s map[string]any) map[string]any {
+	c := map[string]any{}
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
+
+// e.g., "GET /payload-archive/diff" is getPayloadArchiveDiff and
+// "GET /image/{hash}" is getImageByHash.
+func operationID(method, path string) string {
+	var sb strings.Builder
+	sb.WriteString(strings.ToLower(method))
+	upper := true
+	for _, seg := range strings.Split(path, "/") {
+		if strings.HasPrefix(seg, "{") {
+			seg = "by-" + strings.Trim(seg, "{}")
+		}
+		for _, c := range seg {
+			if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				upper = true
+				continue
+			}
+			if upper {
+				c = unicode.ToUpper(c)
+				upper = false
+			}
+			sb.WriteRune(c)
+		}
+		upper = true
+	}
+	return sb.String()
+}
+
+// Operations are tagged with the first segment of their path.
+func operationTag(path string) string {
+	seg, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return strings.TrimPrefix(seg, ".")
+}
+
+// Checks a response against the spec of its route. Errors must be problems,
+// and other responses must have a documented status and match its schema.
+func (rt *routeTable) checkResponse(pattern string, rec *responseRecorder) error {
+	method, path, _ := strings.Cut(pattern, " ")
+	path = strings.ReplaceAll(path, "...}", "}")
+	op := lookup(rt.doc, "paths", path, strings.ToLower(method))
+	schemas, _ := lookup(rt.doc, "components", "schemas").(map[string]any)
+
+	ct, _, _ := strings.Cut(rec.Header().Get("Content-Type"), ";")
+	status := strconv.Itoa(rec.status)
+	if rec.status >= 400 {
+		if ct != problemContentType {
+			return fmt.Errorf("error response has content type %q", ct)
+		}
+		status = "default"
+	}
+	res, ok := lookup(op, "responses", status).(map[string]any)
+	if !ok {
+		return fmt.Errorf("status %d is not documented", rec.status)
+	}
+	if r, ok := res["$ref"].(string); ok {
+		res, _ = lookup(rt.doc, strings.Split(strings.TrimPrefix(r, "#/"), "/")...).(map[string]any)
+	}
+	content, _ := res["content"].(map[string]any)
+	if len(content) == 0 || rec.body.Len() > maxCheckedResponseBytes {
+		return nil
+	}
+	media, ok := content[ct]
+	if !ok {
+		return fmt.Errorf("content type %q is not documented", ct)
+	}
+	if ct != "application/json" && ct != problemContentType {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(rec.body.Bytes(), &v); err != nil {
+		return fmt.Errorf("response is not JSON: %w", err)
+	}
+	return validateSchema(lookup(media, "schema"), v, "", schemas)
+}
+
+// Returns the value at the path of keys in the decoded JSON object, or nil.
+func lookup(v any, keys ...string) any {
+	for _, k := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// Validates the decoded JSON value against the subset of JSON Schema that the
+// generated spec uses.
+func validateSchema(schema any, v any, path string, schemas map[string]any) error {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return nil
+	}
+	if r, ok := s["$ref"].(string); ok {
+		name := strings.TrimPrefix(r, "#/components/schemas/")
+		return validateSchema(schemas[name], v, path, schemas)
+	}
+	if alts, ok := s["oneOf"].([]any); ok {
+		return validateAny(alts, v, path, schemas)
+	}
+	if alts, ok := s["anyOf"].([]any); ok {
+		return validateAny(alts, v, path, schemas)
+	}
+	if typ, ok := s["type"]; ok && !matchesType(typ, v) {
+		return fmt.Errorf("%s: %s is not of type %v", pathOrRoot(path), jsonKind(v), typ)
+	}
+	if enum, ok := s["enum"].([]any); ok && !slices.Contains(enum, v) {
+		return fmt.Errorf("%s: %v is not one of %v", pathOrRoot(path), v, enum)
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		req, _ := s["required"].([]any)
+		for _, name := range req {
+			if _, ok := v[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing %s", pathOrRoot(path), name)
+			}
+		}
+		props, _ := s["properties"].(map[string]any)
+		for k, fv := range v {
+			fs, ok := props[k]
+			if !ok {
+				fs = s["additionalProperties"]
+			}
+			if fs == nil {
+				if props != nil {
+					return fmt.Errorf("%s: undocumented field %s", pathOrRoot(path), k)
+				}
+				continue
+			}
+			if err := validateSchema(fs, fv, path+"."+k, schemas); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for i, e := range v {
+			if err := validateSchema(s["items"], e, fmt.Sprintf("%s[%d]", path, i), schemas); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateAny(alts []any, v any, path string, schemas map[string]any) error {
+	msgs := []string{}
+	for _, alt := range alts {
+		err := validateSchema(alt, v, path, schemas)
+		if err == nil {
+			return nil
+		}
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("%s: matches none of the alternatives (%s)", pathOrRoot(path), strings.Join(msgs, "; "))
+}
+
+func matchesType(typ any, v any) bool {
+	switch typ := typ.(type) {
+	case string:
+		return typ == jsonKind(v) || (typ == "number" && jsonKind(v) == "integer")
+	case []any:
+		for _, t := range typ {
+			if matchesType(t, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func jsonKind(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "response"
+	}
+	return "response" + path
+}
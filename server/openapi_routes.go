@@ -0,0 +1,470 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// routeSpecs documents every route registered in getRootHandler, keyed by its
+// pattern. The scopes and auth here mirror the adapters the route is
+// registered with; keep them in sync when changing either (the tests check
+// that they are).
+var routeSpecs = map[string]routeSpec{
+	// helper routes
+	"GET /ping": {
+		Summary:  "Check that the server can reach the database",
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeRead, ScopeJobs},
+		Firebase: true,
+	},
+	"GET /.well-known/jwks.json": {
+		Summary:  "List the public keys tokens are signed with",
+		Response: JWKS{},
+		Public:   true,
+	},
+	"GET /whoami": {
+		Summary:  "Describe the principal making the request",
+		Response: Principal{},
+		Firebase: true,
+	},
+	"POST /token": {
+		Summary:  "Issue a token for a user",
+		Params:   IssueTokenQuery{},
+		Response: DefaultJSONResponse{},
+		Public:   true,
+	},
+	"GET /openapi.json": {
+		Summary:  "Get this OpenAPI spec",
+		Response: rawContent("application/json"),
+		Public:   true,
+	},
+	"GET /docs": {
+		Summary:  "Browse the API documentation",
+		Response: rawContent("text/html"),
+		Public:   true,
+	},
+
+	// realtor CRUDL routes
+	"GET /realtor": {
+		Summary:  "List the listings of a realtor, or search realtors' listings",
+		Params:   RealtorQuery{},
+		Response: oneOf{[]dbgen.SearchRealtorPropertiesRow{}, []dbgen.GetRealtorPropertiesRow{}},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+	"POST /realtor": {
+		Summary:  "Add a realtor's listing",
+		Body:     PostRealtorBody{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite, ScopeScrape},
+	},
+	"DELETE /realtor": {
+		Summary:  "Delete a realtor's listings",
+		Params:   RealtorDeleteQuery{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite},
+	},
+
+	// search CRUDL routes
+	"GET /search": {
+		Summary:  "Get a search, or list every search",
+		Params:   SearchQuery{},
+		Response: oneOf{[]dbgen.Search{}, dbgen.Search{}},
+		Scopes:   []string{ScopeRead},
+	},
+	"POST /search": {
+		Summary:  "Add a search and schedule its scrape",
+		Body:     pgtype.Text{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite},
+	},
+	"DELETE /search": {
+		Summary:  "Delete a search",
+		Params:   SearchQuery{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite},
+	},
+
+	// property CRUDL routes
+	"GET /property": {
+		Summary:  "Get a property with its latest price, or list them",
+		Params:   PropertyQuery{},
+		Response: oneOf{[]PropertyPriceResponse{}, PropertyPriceResponse{}},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+	"GET /property-basic": {
+		Summary:  "Get a property",
+		Params:   PropertyKeyQuery{},
+		Response: PropertyResponse{},
+		Scopes:   []string{ScopeRead, ScopeScrape},
+	},
+	"POST /property": {
+		Summary:  "Add a property and schedule its scrape",
+		Body:     CreatePropertyParams{},
+		Response: DefaultJSONResponse{},
+		Statuses: []int{http.StatusOK, http.StatusAccepted},
+		Scopes:   []string{ScopeWrite, ScopeScrape},
+	},
+	"PUT /property": {
+		Summary:    "Replace a property",
+		Body:       PutPropertyBody{},
+		Response:   DefaultJSONResponse{},
+		Scopes:     []string{ScopeWrite},
+		Deprecated: &deprecation{Since: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+	},
+	"PATCH /property": {
+		Summary:  "Update a property with a JSON merge patch",
+		Params:   PropertyKeyQuery{},
+		Body:     PatchPropertyBody{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite, ScopeScrape},
+	},
+	"DELETE /property": {
+		Summary:  "Delete a property",
+		Params:   PropertyQuery{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite},
+	},
+
+	// property-details routes
+	"GET /property-details": {
+		Summary:  "Get the details of a property",
+		Params:   PropertyKeyQuery{},
+		Response: dbgen.PropertyDetail{},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+	"PUT /property-details": {
+		Summary:  "Add or replace the details of a property",
+		Body:     dbgen.UpsertPropertyDetailsParams{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite, ScopeScrape},
+	},
+
+	// property-event CRUDL routes
+	"GET /property-events": {
+		Summary:  "List the events of a property",
+		Params:   PropertyEventsQuery{},
+		Response: []dbgen.PropertyEvent{},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+	"POST /property-events": {
+		Summary:  "Add property events",
+		Body:     []dbgen.CreatePropertyEventParams{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite},
+	},
+	"PUT /property-events": {
+		Summary:  "Add property events, ignoring ones that already exist",
+		Body:     []dbgen.CreatePropertyEventParams{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite, ScopeScrape},
+	},
+	"DELETE /property-events": {
+		Summary:  "Delete property events",
+		Params:   PropertyEventsDeleteQuery{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite},
+	},
+
+	// job queue routes
+	"GET /job": {
+		Summary:  "Get a job, or list jobs",
+		Params:   JobQuery{},
+		Response: oneOf{dbgen.Job{}, []dbgen.Job{}},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+	"POST /job": {
+		Summary:  "Enqueue a job",
+		Body:     PostJobBody{},
+		Response: dbgen.Job{},
+		Scopes:   []string{ScopeWrite},
+	},
+	"DELETE /job": {
+		Summary:  "Delete a job",
+		Params:   JobIDQuery{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite},
+	},
+	"POST /job/claim": {
+		Summary:  "Claim jobs to run",
+		Params:   JobClaimQuery{},
+		Response: []dbgen.Job{},
+		Scopes:   []string{ScopeJobs},
+	},
+	"POST /job/complete": {
+		Summary:  "Report that a claimed job succeeded",
+		Body:     CompleteJobBody{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeJobs},
+	},
+	"POST /job/fail": {
+		Summary:  "Report that a claimed job failed",
+		Body:     FailJobBody{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeJobs},
+	},
+
+	// plot data routes
+	"GET /realtor-prices-plot": {
+		Summary:  "Get a histogram of a realtor's listing prices",
+		Params:   RealtorPricesPlotQuery{},
+		Response: []PriceBin{},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+	"GET /property-prices-plot": {
+		Summary:  "Get the price history of a property",
+		Params:   PropertyPricesPlotQuery{},
+		Response: []PricePoint{},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+
+	// market stats routes
+	"GET /market-stats": {
+		Summary:  "Get monthly market stats for a zipcode, city, or polygon",
+		Params:   MarketStatsQuery{},
+		Response: oneOf{[]dbgen.MarketStatsMonthly{}, []dbgen.GetMarketStatsByPolygonRow{}},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+	"POST /admin/refresh-market-stats": {
+		Summary:  "Recompute the monthly market stats",
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeAdmin},
+	},
+
+	// avm routes
+	"GET /avm-estimates": {
+		Summary:  "List the AVM estimates of a listing",
+		Params:   EstimatesQuery{},
+		Response: []dbgen.AVMEstimate{},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+	"POST /avm-estimates": {
+		Summary:  "Add AVM estimates",
+		Body:     []dbgen.CreateAVMEstimateParams{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite, ScopeScrape},
+	},
+	"GET /avm-accuracy": {
+		Summary:  "Get the accuracy of AVM estimates against sale prices",
+		Params:   AVMAccuracyQuery{},
+		Response: oneOf{[]dbgen.AVMAccuracy{}, []dbgen.GetAVMAccuracyByZipcodeRow{}, []dbgen.GetAVMAccuracyByRealtorRow{}},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+
+	// rental routes
+	"GET /rental-estimates": {
+		Summary:  "List the rental estimates of a listing",
+		Params:   EstimatesQuery{},
+		Response: []dbgen.RentalEstimate{},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+	"POST /rental-estimates": {
+		Summary:  "Add rental estimates",
+		Body:     []dbgen.CreateRentalEstimateParams{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite, ScopeScrape},
+	},
+	"GET /rental-yield": {
+		Summary:  "List the gross rental yields of listings",
+		Params:   RentalYieldQuery{},
+		Response: []dbgen.RentalYield{},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+
+	// comps routes
+	"GET /comps": {
+		Summary:  "Get comparable sales for a listing",
+		Params:   CompsQuery{},
+		Response: CompsResponse{},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+	"PUT /similar-sold": {
+		Summary:  "Replace the similar sold homes Redfin reports for a listing",
+		Body:     PutSimilarSoldBody{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite, ScopeScrape},
+	},
+
+	// payload drift routes
+	"GET /payload-schema": {
+		Summary:  "Get the accepted schema of a provider endpoint's payloads",
+		Params:   PayloadEndpointQuery{},
+		Response: []dbgen.PayloadSchema{},
+		Scopes:   []string{ScopeRead, ScopeScrape},
+		Firebase: true,
+	},
+	"PUT /payload-schema": {
+		Summary:  "Replace the accepted schema of a provider endpoint's payloads",
+		Body:     PutPayloadSchemaBody{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite, ScopeScrape},
+	},
+	"POST /payload-drift": {
+		Summary:  "Report the paths observed in a payload",
+		Body:     PostPayloadDriftBody{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite, ScopeScrape},
+	},
+	"GET /admin/payload-drift": {
+		Summary:  "List recent payload drift",
+		Params:   PayloadDriftQuery{},
+		Response: []dbgen.PayloadDrift{},
+		Scopes:   []string{ScopeAdmin},
+		Firebase: true,
+	},
+	"POST /admin/payload-drift/accept": {
+		Summary:  "Accept the drift of a provider endpoint into its schema",
+		Params:   PayloadEndpointQuery{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeAdmin},
+	},
+
+	// payload archive routes
+	"POST /payload-archive/presign": {
+		Summary:  "Get a presigned URL to upload a raw payload to",
+		Params:   HashQuery{},
+		Response: PresignPayloadResponse{},
+		Scopes:   []string{ScopeWrite, ScopeScrape},
+	},
+	"GET /payload-archive": {
+		Summary:  "List the archived payloads of a listing",
+		Params:   PayloadArchiveQuery{},
+		Response: []dbgen.PayloadArchive{},
+		Scopes:   []string{ScopeRead, ScopeScrape},
+		Firebase: true,
+	},
+	"POST /payload-archive": {
+		Summary:  "Record archived payloads",
+		Body:     []dbgen.CreatePayloadArchiveEntryParams{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeWrite, ScopeScrape},
+	},
+	"GET /payload-archive/object": {
+		Summary:  "Get an archived payload",
+		Params:   HashQuery{},
+		Response: rawContent("application/json"),
+		Scopes:   []string{ScopeRead, ScopeScrape},
+		Firebase: true,
+	},
+	"GET /payload-archive/url": {
+		Summary:  "Get a presigned URL to download an archived payload from",
+		Params:   HashQuery{},
+		Response: PresignedURLResponse{},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+	"GET /payload-archive/diff": {
+		Summary:  "Diff two archived payloads",
+		Params:   PayloadDiffQuery{},
+		Response: PayloadDiffResponse{},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+
+	// image mirroring routes
+	"POST /listing-image": {
+		Summary:  "Record a mirrored listing image",
+		Body:     PostListingImageBody{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeScrape},
+	},
+	"POST /image/presign": {
+		Summary:  "Get a presigned URL to upload an image to",
+		Params:   HashQuery{},
+		Response: PresignImageResponse{},
+		Scopes:   []string{ScopeScrape},
+	},
+	"GET /near-duplicate-images": {
+		Summary:  "List images that are near duplicates of each other",
+		Params:   NearDuplicateImagesQuery{},
+		Response: []dbgen.GetNearDuplicateImagesRow{},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+	"GET /image/{hash}": {
+		Summary:  "Redirect to a mirrored image",
+		Params:   ImagePath{},
+		Statuses: []int{http.StatusFound},
+		Public:   true,
+	},
+
+	// signed blob routes
+	"PUT /blob/{key...}": {
+		Summary:  "Upload an object to the local blob store",
+		Params:   BlobParams{},
+		Body:     rawContent("application/octet-stream"),
+		Response: DefaultJSONResponse{},
+		Public:   true,
+	},
+	"GET /blob/{key...}": {
+		Summary:  "Download an object from the local blob store",
+		Params:   BlobParams{},
+		Response: rawContent("application/octet-stream"),
+		Public:   true,
+	},
+
+	// api key routes
+	"GET /api-key": {
+		Summary:  "Get an API key, or list the keys of an owner",
+		Params:   APIKeyQuery{},
+		Response: oneOf{dbgen.APIKey{}, []dbgen.APIKey{}},
+		Scopes:   []string{ScopeAdmin},
+	},
+	"POST /api-key": {
+		Summary:  "Create an API key",
+		Body:     PostAPIKeyBody{},
+		Response: APIKeyResponse{},
+		Scopes:   []string{ScopeAdmin},
+	},
+	"POST /api-key/revoke": {
+		Summary:  "Revoke an API key",
+		Params:   APIKeyIDQuery{},
+		Response: DefaultJSONResponse{},
+		Scopes:   []string{ScopeAdmin},
+	},
+	"POST /api-key/rotate": {
+		Summary:  "Replace an API key with a new one",
+		Params:   APIKeyIDQuery{},
+		Response: APIKeyResponse{},
+		Scopes:   []string{ScopeAdmin},
+	},
+	"GET /admin/audit-log": {
+		Summary:  "List audited requests",
+		Params:   AuditLogQuery{},
+		Response: []dbgen.AuditLog{},
+		Scopes:   []string{ScopeAdmin},
+	},
+
+	// job stats routes
+	"GET /admin/job-stats": {
+		Summary:  "Get job queue stats",
+		Params:   JobStatsQuery{},
+		Response: []dbgen.GetJobStatsRow{},
+		Scopes:   []string{ScopeAdmin},
+		Firebase: true,
+	},
+
+	// graphql routes
+	"POST /graphql": {
+		Summary:  "Run a read-only GraphQL query over properties, listings, events, realtors, and market stats",
+		Body:     GraphQLRequest{},
+		Response: GraphQLResponse{},
+		Scopes:   []string{ScopeRead},
+		Firebase: true,
+	},
+}
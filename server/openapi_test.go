@@ -0,0 +1,315 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The database stub answers every query with no rows, so list routes return
+// empty arrays and lookups return not found.
+type stubDB struct{}
+
+func (stubDB) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+func (stubDB) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	return &emptyRows{}, nil
+}
+
+func (stubDB) QueryRow(context.Context, string, ...interface{}) pgx.Row {
+	return emptyRow{}
+}
+
+func (stubDB) CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error) {
+	return 0, nil
+}
+
+type emptyRows struct{}
+
+func (*emptyRows) Close()                                       {}
+func (*emptyRows) Err() error                                   { return nil }
+func (*emptyRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (*emptyRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (*emptyRows) Next() bool                                   { return false }
+func (*emptyRows) Scan(...any) error                            { return pgx.ErrNoRows }
+func (*emptyRows) Values() ([]any, error)                       { return nil, pgx.ErrNoRows }
+func (*emptyRows) RawValues() [][]byte                          { return nil }
+func (*emptyRows) Conn() *pgx.Conn                              { return nil }
+
+type emptyRow struct{}
+
+func (emptyRow) Scan(...any) error { return pgx.ErrNoRows }
+
+// Accepts any non-empty Firebase token as an admin, so that routes that accept
+// Firebase users can be told apart from routes that don't.
+type stubIDTokenVerifier struct{}
+
+func (stubIDTokenVerifier) VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error) {
+	if idToken == "" {
+		return nil, errors.New("ID token must be a non-empty string")
+	}
+	return &auth.Token{UID: "firebase-user", Claims: map[string]interface{}{"role": RoleAdmin}}, nil
+}
+
+type testServer struct {
+	rt  *routeTable
+	kr  *KeyRing
+	log *bytes.Buffer
+}
+
+// Builds the root handler with stub dependencies. Responses are checked against
+// the spec; the ones that don't conform are logged to the server's log.
+func newTestServer(t *testing.T) *testServer {
+	t.Setenv("OPENAPI_CHECK_RESPONSES", "true")
+	var log bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&log, nil))
+
+	// transactions fail, since there's no database to connect to
+	cfg, err := pgxpool.ParseConfig("postgres://test@localhost/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New("no database in tests")
+	}
+	p, err := pgxpool.NewWithConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+
+	kr, err := LoadKeyRing("", "", "test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := NewLocalBlobStore(t.TempDir(), "http://localhost", "test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := getRootHandler(l, p, dbgen.New(stubDB{}), bs, stubIDTokenVerifier{}, kr, NewRateLimits(nil, nil))
+	return &testServer{rt: h.(*routeTable), kr: kr, log: &log}
+}
+
+// Returns a token that grants the role and scopes.
+func (ts *testServer) token(t *testing.T, role string, scopes ...string) string {
+	token, err := ts.kr.IssueToken("test@example.com", role, scopes, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+// Serves a request to the route with sample params and body, authenticated
+// with the supplied headers.
+func (ts *testServer) serve(t *testing.T, r route, auth http.Header) *httptest.ResponseRecorder {
+	req := newRouteRequest(r)
+	for k, vs := range auth {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if _, pattern := ts.rt.Handler(req); pattern != r.pattern {
+		t.Fatalf("%s: request %s %s is served by %q", r.pattern, req.Method, req.URL, pattern)
+	}
+	w := httptest.NewRecorder()
+	ts.rt.ServeHTTP(w, req)
+	return w
+}
+
+// Returns the messages of the response check failures logged since the last
+// call.
+func (ts *testServer) nonconforming(t *testing.T) []string {
+	var msgs []string
+	sc := bufio.NewScanner(ts.log)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var rec map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec["msg"] == "response does not conform to the API spec" {
+			msgs = append(msgs, fmt.Sprintf("status %v: %v", rec["status"], rec["error"]))
+		}
+	}
+	ts.log.Reset()
+	return msgs
+}
+
+// Documented routes, other than OPTIONS routes.
+func (ts *testServer) documentedRoutes() []route {
+	var rs []route
+	for _, r := range ts.rt.routes {
+		if strings.HasPrefix(r.pattern, http.MethodOptions+" ") {
+			continue
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+func TestRoutesConformToSpec(t *testing.T) {
+	ts := newTestServer(t)
+	admin := http.Header{"Authorization": {ts.token(t, RoleAdmin)}}
+	for _, r := range ts.documentedRoutes() {
+		w := ts.serve(t, r, admin)
+		for _, msg := range ts.nonconforming(t) {
+			t.Errorf("%s: response does not conform to the spec (%s): %s", r.pattern, msg, w.Body.String())
+		}
+	}
+}
+
+// The auth of each routeSpec mirrors the adapters its route is registered
+// with. Public routes don't require a token, only Firebase routes accept a
+// Firebase-JWT, and a token with a single scope is forbidden from exactly the
+// routes whose Scopes don't include it.
+func TestRouteSpecsMatchAuth(t *testing.T) {
+	ts := newTestServer(t)
+	for _, r := range ts.documentedRoutes() {
+		spec := routeSpecs[r.spec]
+
+		w := ts.serve(t, r, nil)
+		if got := isAdapterError(w, http.StatusUnauthorized, "unauthorized"); got == spec.Public {
+			t.Errorf("%s: Public is %t but a request without a token got %d", r.pattern, spec.Public, w.Code)
+		}
+		if spec.Public {
+			continue
+		}
+
+		w = ts.serve(t, r, http.Header{FirebaseJWTHeader: {"firebase-token"}})
+		if got := isAdapterError(w, http.StatusUnauthorized, "unauthorized"); got == spec.Firebase {
+			t.Errorf("%s: Firebase is %t but a request with a Firebase-JWT got %d", r.pattern, spec.Firebase, w.Code)
+		}
+
+		for _, s := range allScopes {
+			w = ts.serve(t, r, http.Header{"Authorization": {ts.token(t, "", s)}})
+			want := len(spec.Scopes) > 0 && !slices.Contains(spec.Scopes, s)
+			if got := isAdapterError(w, http.StatusForbidden, "forbidden"); got != want {
+				t.Errorf("%s: Scopes are %v but a token with the %s scope got %d", r.pattern, spec.Scopes, s, w.Code)
+			}
+		}
+	}
+	ts.nonconforming(t)
+}
+
+// Reports whether the response is the error the auth adapters write, rather
+// than one written by the handler.
+func isAdapterError(w *httptest.ResponseRecorder, status int, detail string) bool {
+	if w.Code != status {
+		return false
+	}
+	var p Problem
+	return json.Unmarshal(w.Body.Bytes(), &p) == nil && p.Detail == detail
+}
+
+// Returns a request to the route. Path params and required query params get
+// sample values that pass validation; the body is the zero value of the
+// route's Body.
+func newRouteRequest(r route) *http.Request {
+	spec := routeSpecs[r.spec]
+	var params []reflect.StructField
+	if spec.Params != nil {
+		params = paramFields(reflect.TypeOf(spec.Params))
+	}
+
+	method, path, _ := strings.Cut(r.pattern, " ")
+	query := []string{}
+	for _, sf := range params {
+		if name, ok := sf.Tag.Lookup("path"); ok {
+			path = strings.NewReplacer("{"+name+"}", sampleParam(sf), "{"+name+"...}", sampleParam(sf)).Replace(path)
+			continue
+		}
+		name := sf.Tag.Get("query")
+		if _, required := parseRules(sf.Tag.Get("validate"))["required"]; name != "" && required {
+			query = append(query, name+"="+sampleParam(sf))
+		}
+	}
+	path = strings.NewReplacer("{key...}", "test/key").Replace(path)
+	if len(query) > 0 {
+		path += "?" + strings.Join(query, "&")
+	}
+
+	var body io.Reader
+	ct := "application/json"
+	switch b := spec.Body.(type) {
+	case nil:
+	case rawContent:
+		body, ct = strings.NewReader("test"), string(b)
+	default:
+		bs, _ := json.Marshal(reflect.New(reflect.TypeOf(b)).Interface())
+		body = bytes.NewReader(bs)
+		if method == http.MethodPatch {
+			ct = mergePatchContentType
+		}
+	}
+	req := httptest.NewRequest(method, path, body)
+	if body != nil {
+		req.Header.Set("Content-Type", ct)
+	}
+	return req
+}
+
+// Returns the fields of the params struct, including embedded ones.
+func paramFields(t reflect.Type) []reflect.StructField {
+	var fs []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			fs = append(fs, paramFields(sf.Type)...)
+			continue
+		}
+		fs = append(fs, sf)
+	}
+	return fs
+}
+
+// Returns a value of the param that passes its validation rules.
+func sampleParam(sf reflect.StructField) string {
+	rules := parseRules(sf.Tag.Get("validate"))
+	if v, ok := rules["oneof"]; ok {
+		return strings.Fields(v)[0]
+	}
+	if _, ok := rules["sha256"]; ok {
+		return strings.Repeat("0", 64)
+	}
+	t := sf.Type
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	switch {
+	case t == durationType:
+		return "1h"
+	case t == timeType:
+		return "2024-01-01"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+		if v, ok := rules["min"]; ok && v != "0" {
+			return v
+		}
+		return "1"
+	}
+	return "test"
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+const mergePatchContentType = "application/merge-patch+json"
+
+// PatchValue is a field of a JSON merge patch (RFC 7396). Set reports whether
+// the field was present in the patch at all, and Null whether it was
+// explicitly null, which clears the field; only when it's set and not null
+// does Value hold the new value.
+type PatchValue[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+func (PatchValue[T]) patchValueType() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func (pv *PatchValue[T]) UnmarshalJSON(b []byte) error {
+	pv.Set = true
+	if bytes.Equal(bytes.TrimSpace(b), []byte("null")) {
+		pv.Null = true
+		return nil
+	}
+	return json.Unmarshal(b, &pv.Value)
+}
+
+// Applies the JSON merge patch (RFC 7396) to the target document and returns
+// the result. Objects are merged recursively, nulls remove members, and any
+// other value replaces the target outright.
+func mergePatch(target, patch []byte) ([]byte, error) {
+	var t, p any
+	if len(target) > 0 {
+		if err := json.Unmarshal(target, &t); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatchValue(t, p))
+}
+
+func mergePatchValue(target, patch any) any {
+	po, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	to, ok := target.(map[string]any)
+	if !ok {
+		to = map[string]any{}
+	}
+	for k, v := range po {
+		if v == nil {
+			delete(to, k)
+			continue
+		}
+		to[k] = mergePatchValue(to[k], v)
+	}
+	return to
+}
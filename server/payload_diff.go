@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Kinds of difference between two versions of a payload.
+const PayloadDiffAdded = "added"
+const PayloadDiffRemoved = "removed"
+const PayloadDiffChanged = "changed"
+
+// Returns the differences between two JSON documents as a list of changed
+// leaf values keyed by path (e.g., "publicRecordsInfo.basicInfo.beds" or
+// "events[3].price"). Objects are compared key by key and arrays index by
+// index; a value that changes type is reported as a single change.
+func diffJSON(from, to []byte) ([]PayloadDiff, error) {
+	var fv, tv interface{}
+	if err := json.Unmarshal(from, &fv); err != nil {
+		return nil, fmt.Errorf("error parsing from payload: %w", err)
+	}
+	if err := json.Unmarshal(to, &tv); err != nil {
+		return nil, fmt.Errorf("error parsing to payload: %w", err)
+	}
+	diffs := []PayloadDiff{}
+	diffJSONValue("", fv, tv, &diffs)
+	return diffs, nil
+}
+
+func diffJSONValue(path string, fv, tv interface{}, diffs *[]PayloadDiff) {
+	switch f := fv.(type) {
+	case map[string]interface{}:
+		t, ok := tv.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := []string{}
+		for k := range f {
+			keys = append(keys, k)
+		}
+		for k := range t {
+			if _, ok := f[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			fe, fok := f[k]
+			te, tok := t[k]
+			switch {
+			case !fok:
+				*diffs = append(*diffs, PayloadDiff{Path: p, Change: PayloadDiffAdded, To: te})
+			case !tok:
+				*diffs = append(*diffs, PayloadDiff{Path: p, Change: PayloadDiffRemoved, From: fe})
+			default:
+				diffJSONValue(p, fe, te, diffs)
+			}
+		}
+		return
+	case []interface{}:
+		t, ok := tv.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(f) || i < len(t); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(f):
+				*diffs = append(*diffs, PayloadDiff{Path: p, Change: PayloadDiffAdded, To: t[i]})
+			case i >= len(t):
+				*diffs = append(*diffs, PayloadDiff{Path: p, Change: PayloadDiffRemoved, From: f[i]})
+			default:
+				diffJSONValue(p, f[i], t[i], diffs)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(fv, tv) {
+		*diffs = append(*diffs, PayloadDiff{Path: path, Change: PayloadDiffChanged, From: fv, To: tv})
+	}
+}
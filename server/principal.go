@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+)
+
+// Identity providers a principal can be authenticated by.
+const ProviderToken = "token"
+const ProviderAPIKey = "api-key"
+const ProviderFirebase = "firebase"
+
+// Principal is the identity behind an authenticated request. Every authorizer
+// puts one in the request context, so handlers and middleware can rely on it
+// regardless of how the request was authenticated.
+type Principal struct {
+	UserID   string   `json:"user_id"`
+	Email    string   `json:"email"`
+	Provider string   `json:"provider"`
+	Roles    []string `json:"roles"`
+	Scopes   []string `json:"scopes"`
+	// Only set for principals authenticated by an API key.
+	APIKeyID int64 `json:"api_key_id,omitempty"`
+}
+
+// Reports whether the principal is granted at least one of the supplied
+// scopes, either through its roles or explicitly.
+func (p Principal) HasScope(scopes ...string) bool {
+	for _, s := range scopes {
+		if slices.Contains(p.Scopes, s) {
+			return true
+		}
+		for _, role := range p.Roles {
+			if slices.Contains(roleScopes[role], s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type principalCtxKey struct{}
+
+// Returns the principal of the request the context belongs to. It's only
+// present for requests that passed atLeastOneAuth.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey{}).(Principal)
+	return p, ok
+}
+
+// Attaches the principal to the request's context.
+func setPrincipal(r *http.Request, p Principal) {
+	*r = *r.WithContext(context.WithValue(r.Context(), principalCtxKey{}, p))
+}
+
+// Returns the role as a list of roles, which is empty if there's no role.
+func rolesOf(role string) []string {
+	if role == "" {
+		return []string{}
+	}
+	return []string{role}
+}
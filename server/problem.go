@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Machine-readable error codes. Clients should branch on these rather than on
+// the status or the detail text; codes are never renamed or repurposed. Errors
+// from the database use the names from pgErrorText as their codes.
+const (
+	ErrorCodeBadRequest           = "bad_request"
+	ErrorCodeMalformedBody        = "malformed_body"
+	ErrorCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrorCodeBodyTooLarge         = "body_too_large"
+	ErrorCodeValidationFailed     = "validation_failed"
+	ErrorCodeInvalidData          = "invalid_data"
+	ErrorCodeUnauthorized         = "unauthorized"
+	ErrorCodeForbidden            = "forbidden"
+	ErrorCodeNotFound             = "not_found"
+	ErrorCodeConflict             = "conflict"
+	ErrorCodeRateLimited          = "rate_limited"
+	ErrorCodeQueryTooComplex      = "query_too_complex"
+	ErrorCodeInternal             = "internal_error"
+)
+
+// Codes of invalid fields in a ValidationError.
+const (
+	FieldCodeRequired = "required"
+	FieldCodeInvalid  = "invalid"
+)
+
+const problemContentType = "application/problem+json"
+const problemTypePrefix = "urn:gredfin:problem:"
+
+// Problem is an RFC 7807 problem details response. Type is derived from Code.
+// Error repeats Detail so that clients that decode DefaultJSONResponse keep
+// working.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+func newProblem(status int, code, detail string) Problem {
+	return Problem{
+		Type:   problemTypePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// FieldError describes why a single field of a request is invalid. Field is
+// the name of the query param or the JSON field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects the invalid fields of a request. It renders as a
+// validation_failed problem listing each field.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (ve *ValidationError) Error() string {
+	msgs := []string{}
+	for _, f := range ve.Fields {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", f.Field, f.Message))
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
+
+// Adds an invalid field.
+func (ve *ValidationError) add(field, code, msg string) {
+	ve.Fields = append(ve.Fields, FieldError{Field: field, Code: code, Message: msg})
+}
+
+// Adds a required field error if ok is false.
+func (ve *ValidationError) require(field string, ok bool) {
+	if !ok {
+		ve.add(field, FieldCodeRequired, "must be set")
+	}
+}
+
+// Returns the error if any fields are invalid, otherwise nil.
+func (ve *ValidationError) err() error {
+	if len(ve.Fields) == 0 {
+		return nil
+	}
+	return ve
+}
+
+// Returns a ValidationError for a missing field.
+func missingField(field string) error {
+	return &ValidationError{Fields: []FieldError{{Field: field, Code: FieldCodeRequired, Message: "must be set"}}}
+}
+
+// Returns a ValidationError for a field with a bad value.
+func invalidField(field string) error {
+	return invalidFieldf(field, "bad value for %s", field)
+}
+
+// Returns a ValidationError for a field with a bad value, explaining why.
+func invalidFieldf(field, format string, args ...any) error {
+	return &ValidationError{Fields: []FieldError{{Field: field, Code: FieldCodeInvalid, Message: fmt.Sprintf(format, args...)}}}
+}
+
+// Returns the problem describing err. Errors that aren't recognized are bad
+// requests.
+func problemFromError(err error) Problem {
+	var ve *ValidationError
+	var mr *MalformedRequest
+	var pr *time.ParseError
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &ve):
+		p := newProblem(http.StatusBadRequest, ErrorCodeValidationFailed, err.Error())
+		p.Errors = ve.Fields
+		return p
+	case errors.As(err, &mr):
+		switch mr.Status {
+		case http.StatusUnsupportedMediaType:
+			return newProblem(mr.Status, ErrorCodeUnsupportedMediaType, err.Error())
+		case http.StatusRequestEntityTooLarge:
+			return newProblem(mr.Status, ErrorCodeBodyTooLarge, err.Error())
+		default:
+			return newProblem(http.StatusBadRequest, ErrorCodeMalformedBody, err.Error())
+		}
+	case errors.As(err, &pr):
+		return newProblem(http.StatusBadRequest, ErrorCodeMalformedBody, err.Error())
+	case errors.As(err, &pgErr):
+		if p, ok := problemFromPGError(pgErr, err.Error()); ok {
+			return p
+		}
+	}
+	return newProblem(http.StatusBadRequest, ErrorCodeBadRequest, err.Error())
+}
+
+// Maps the errors caused by bad user submitted data to a problem. Conflicts
+// with existing rows are 409s and other integrity constraint violations and
+// data exceptions are 400s. Anything else is the server's fault.
+func problemFromPGError(pgErr *pgconn.PgError, detail string) (Problem, bool) {
+	switch {
+	case pgErr.Code == pgErrorUniqueViolation || pgErr.Code == pgErrorExclusionViolation:
+		return newProblem(http.StatusConflict, pgErrorText(pgErr.Code), detail), true
+	case pgErrorText(pgErr.Code) != "":
+		return newProblem(http.StatusBadRequest, pgErrorText(pgErr.Code), detail), true
+	case strings.HasPrefix(pgErr.Code, pgErrorClassDataException):
+		return newProblem(http.StatusBadRequest, ErrorCodeInvalidData, detail), true
+	default:
+		return Problem{}, false
+	}
+}
+
+// Writes the problem for a pg error with the supplied code, using a friendlier
+// detail than the database's message.
+func writePGError(w http.ResponseWriter, code, detail string) {
+	p, _ := problemFromPGError(&pgconn.PgError{Code: code}, detail)
+	writeProblem(w, p)
+}
+
+// Writes the problem as an application/problem+json response, tagged with the
+// request's ID.
+func writeProblem(w http.ResponseWriter, p Problem) {
+	p.RequestID = w.Header().Get(requestIDHeader)
+	p.Error = p.Detail
+	if p.Error == "" {
+		p.Error = p.Title
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
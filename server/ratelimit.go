@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limit costs of routes. Most routes cost costDefault; routes that run
+// expensive queries or read objects from the blob store cost more.
+const costDefault = 1
+const costQuery = 5
+const costHeavy = 10
+
+// Quota is the number of cost units a principal can spend per window. Unused
+// units accrue continuously up to the limit, so a principal can burst up to
+// the limit and then sustain Limit units per Window. A zero limit means no
+// limit.
+type Quota struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Result of charging a principal for a request.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // until the quota is full again
+	RetryAfter time.Duration // until the request would be allowed
+}
+
+// RateLimiter tracks how much of its quota each key has spent. The in-memory
+// implementation is only correct for a single server; a shared backend (e.g.,
+// Redis) can be supplied by implementing this interface.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, cost int, q Quota) (RateLimitResult, error)
+}
+
+// MemoryRateLimiter is a token bucket RateLimiter that keeps its state in
+// memory.
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	quota  Quota
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: map[string]*tokenBucket{}, lastSweep: time.Now()}
+}
+
+func (m *MemoryRateLimiter) Allow(ctx context.Context, key string, cost int, q Quota) (RateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.sweep(now)
+
+	rate := float64(q.Limit) / q.Window.Seconds()
+	b, ok := m.buckets[key]
+	if !ok || b.quota != q {
+		b = &tokenBucket{tokens: float64(q.Limit), last: now, quota: q}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(q.Limit), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	// requests that cost more than the whole quota are charged the quota
+	c := math.Min(float64(cost), float64(q.Limit))
+	res := RateLimitResult{Allowed: b.tokens >= c}
+	if res.Allowed {
+		b.tokens -= c
+	} else {
+		res.RetryAfter = time.Duration((c - b.tokens) / rate * float64(time.Second))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration((float64(q.Limit) - b.tokens) / rate * float64(time.Second))
+	return res, nil
+}
+
+// Drops buckets that have refilled since they were last used; they're
+// indistinguishable from new buckets. This runs at most once a minute.
+func (m *MemoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for k, b := range m.buckets {
+		if now.Sub(b.last) >= b.quota.Window {
+			delete(m.buckets, k)
+		}
+	}
+}
+
+// RateLimits applies quotas to principals. Principals get the largest quota of
+// their roles, or the default quota if none of their roles has one.
+type RateLimits struct {
+	limiter RateLimiter
+	quotas  map[string]Quota
+}
+
+// Key of the quota for principals whose roles don't have a quota.
+const defaultQuotaKey = "default"
+
+func NewRateLimits(rl RateLimiter, quotas map[string]Quota) *RateLimits {
+	return &RateLimits{limiter: rl, quotas: quotas}
+}
+
+// Parses quotas of the form "default=600/1m,worker=6000/1m,admin=0", where the
+// names are roles (or default) and a zero limit means no limit.
+func ParseRateLimitQuotas(s string) (map[string]Quota, error) {
+	quotas := map[string]Quota{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad quota %q (expected name=limit/window)", part)
+		}
+		if _, known := roleScopes[name]; !known && name != defaultQuotaKey {
+			return nil, fmt.Errorf("bad quota %q: unknown role %s", part, name)
+		}
+		limit, window, _ := strings.Cut(v, "/")
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad quota %q: bad limit", part)
+		}
+		q := Quota{Limit: n}
+		if n > 0 {
+			if q.Window, err = time.ParseDuration(window); err != nil || q.Window <= 0 {
+				return nil, fmt.Errorf("bad quota %q: bad window", part)
+			}
+		}
+		quotas[name] = q
+	}
+	return quotas, nil
+}
+
+// Returns the quota of the principal. Since a zero limit means no limit, it
+// beats any other quota.
+func (rls *RateLimits) quota(p Principal) Quota {
+	var best *Quota
+	for _, role := range p.Roles {
+		q, ok := rls.quotas[role]
+		if !ok {
+			continue
+		}
+		if best == nil || q.Limit == 0 || (best.Limit != 0 && q.Limit*int(best.Window) > best.Limit*int(q.Window)) {
+			best = &q
+		}
+	}
+	if best == nil {
+		return rls.quotas[defaultQuotaKey]
+	}
+	return *best
+}
+
+// Returns the key a principal's usage is tracked under. Each API key has its
+// own quota; other principals are tracked by user.
+func rateLimitKey(p Principal) string {
+	if p.APIKeyID != 0 {
+		return fmt.Sprintf("%s:%d", ProviderAPIKey, p.APIKeyID)
+	}
+	return fmt.Sprintf("%s:%s", p.Provider, p.UserID)
+}
+
+// Charges the principal put in the context by the authorizers the supplied
+// cost, and writes a too many requests response if they're over quota. The
+// RateLimit-* headers tell clients how much of their quota is left. This must
+// come after atLeastOneAuth. If the limiter fails, requests are let through.
+func rateLimit(l *slog.Logger, rls *RateLimits, cost int) handlerAdapter {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFromContext(r.Context())
+			q := rls.quota(p)
+			if !ok || q.Limit == 0 {
+				next(w, r)
+				return
+			}
+			res, err := rls.limiter.Allow(r.Context(), rateLimitKey(p), cost, q)
+			if err != nil {
+				l.Error("error checking rate limit", "error", err.Error())
+				next(w, r)
+				return
+			}
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", q.Limit, int(q.Window.Seconds())))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(q.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+				writeProblem(w, newProblem(http.StatusTooManyRequests, ErrorCodeRateLimited, "rate limit exceeded"))
+				return
+			}
+			next(w, r)
+		}
+	}
+}
@@ -1,6 +1,12 @@
 package server
 
-import "github.com/brojonat/gredfin/server/db/dbgen"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/jackc/pgx/v5/pgtype"
+)
 
 type DefaultJSONResponse struct {
 	Message string `json:"message,omitempty"`
@@ -14,16 +20,236 @@ type PostRealtorBody struct {
 	ListingID  int32  `json:"listing_id"`
 }
 
+// Body of POST /graphql.
+type GraphQLRequest struct {
+	Query         string         `json:"query" validate:"required"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response of POST /graphql. Data is shaped by the query.
+type GraphQLResponse struct {
+	Data   any            `json:"data"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// An error raised while executing a GraphQL query. Extensions carry the
+// error code.
+type GraphQLError struct {
+	Message    string         `json:"message"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+type PutSimilarSoldBody struct {
+	PropertyID int32                           `json:"property_id" validate:"required"`
+	ListingID  int32                           `json:"listing_id" validate:"required"`
+	Homes      []dbgen.CreateSimilarSoldParams `json:"homes"`
+}
+
+type PutPayloadSchemaBody struct {
+	Provider string            `json:"provider" validate:"required"`
+	Endpoint string            `json:"endpoint" validate:"required"`
+	Paths    map[string]string `json:"paths" validate:"required,min=1"`
+}
+
+type PayloadChange struct {
+	Path         string `json:"path" validate:"required"`
+	Change       string `json:"change" validate:"required,oneof=new missing retyped"`
+	BaselineType string `json:"baseline_type,omitempty"`
+	ObservedType string `json:"observed_type,omitempty"`
+}
+
+type PostPayloadDriftBody struct {
+	Provider   string          `json:"provider" validate:"required"`
+	Endpoint   string          `json:"endpoint" validate:"required"`
+	PropertyID int32           `json:"property_id"`
+	ListingID  int32           `json:"listing_id"`
+	Changes    []PayloadChange `json:"changes"`
+}
+
+type PresignPayloadResponse struct {
+	Archived bool   `json:"archived"`
+	URL      string `json:"url,omitempty"`
+}
+
+type PresignedURLResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+type PayloadDiff struct {
+	Path   string      `json:"path"`
+	Change string      `json:"change"`
+	From   interface{} `json:"from,omitempty"`
+	To     interface{} `json:"to,omitempty"`
+}
+
+type PayloadDiffResponse struct {
+	From    dbgen.PayloadArchive `json:"from"`
+	To      dbgen.PayloadArchive `json:"to"`
+	Changes []PayloadDiff        `json:"changes"`
+}
+
+type PresignImageResponse struct {
+	Stored bool   `json:"stored"`
+	URL    string `json:"url,omitempty"`
+}
+
+// Records that a scraped listing image was mirrored to the blob store.
+type PostListingImageBody struct {
+	PropertyID int32                   `json:"property_id" validate:"required"`
+	ListingID  int32                   `json:"listing_id" validate:"required"`
+	SourceURL  string                  `json:"source_url" validate:"required"`
+	Kind       string                  `json:"kind" validate:"oneof=image thumbnail"`
+	Position   int32                   `json:"position" validate:"min=0"`
+	Image      dbgen.CreateImageParams `json:"image"`
+}
+
+// Enqueues a job. A job with a DedupeKey replaces the payload and settings of
+// an existing job of the same kind and key. RunAt defaults to now, and jobs
+// with a RepeatEvery duration (e.g., "24h") are requeued after they finish.
+type PostJobBody struct {
+	Kind        string          `json:"kind" validate:"required,max=64"`
+	DedupeKey   string          `json:"dedupe_key"`
+	Payload     json.RawMessage `json:"payload"`
+	Priority    int32           `json:"priority"`
+	MaxAttempts int32           `json:"max_attempts" validate:"min=1"`
+	RunAt       *time.Time      `json:"run_at"`
+	RepeatEvery string          `json:"repeat_every"`
+}
+
+// Reports that a leased job succeeded. The Result is stored on the job.
+type CompleteJobBody struct {
+	JobID      int64           `json:"job_id" validate:"required"`
+	LeaseOwner string          `json:"lease_owner" validate:"required"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// Reports that a leased job failed. Backoff (e.g., "30s") overrides the
+// server's default retry delay. Drop deletes the job instead of retrying it,
+// for jobs that no longer apply.
+type FailJobBody struct {
+	JobID      int64  `json:"job_id" validate:"required"`
+	LeaseOwner string `json:"lease_owner" validate:"required"`
+	Error      string `json:"error"`
+	Backoff    string `json:"backoff"`
+	Drop       bool   `json:"drop"`
+}
+
+// Payload of search scrape jobs.
+type SearchJobPayload struct {
+	Query string `json:"query"`
+}
+
+// Payload of property scrape jobs.
+type PropertyJobPayload struct {
+	PropertyID int32 `json:"property_id"`
+	ListingID  int32 `json:"listing_id"`
+}
+
+// Payload of image mirroring jobs.
+type ImageJobPayload struct {
+	PropertyID int32  `json:"property_id"`
+	ListingID  int32  `json:"listing_id"`
+	Kind       string `json:"kind"`
+	Position   int32  `json:"position"`
+	SourceURL  string `json:"source_url"`
+}
+
+type Comp struct {
+	PropertyID    int32            `json:"property_id"`
+	ListingID     int32            `json:"listing_id"`
+	URL           pgtype.Text      `json:"url"`
+	Source        string           `json:"source"`
+	SaleTS        pgtype.Timestamp `json:"sale_ts"`
+	SalePrice     int32            `json:"sale_price"`
+	AdjustedPrice int32            `json:"adjusted_price"`
+	DistanceM     float64          `json:"distance_m"`
+	Beds          pgtype.Float4    `json:"beds"`
+	Baths         pgtype.Float4    `json:"baths"`
+	Sqft          pgtype.Int4      `json:"sqft"`
+	YearBuilt     pgtype.Int4      `json:"year_built"`
+	PropertyType  pgtype.Text      `json:"property_type"`
+	Score         float64          `json:"score"`
+}
+
+// Estimate is omitted if there are no comps.
+type CompsResponse struct {
+	PropertyID int32  `json:"property_id"`
+	ListingID  int32  `json:"listing_id"`
+	Estimate   *int32 `json:"estimate,omitempty"`
+	Comps      []Comp `json:"comps"`
+}
+
+type PostAPIKeyBody struct {
+	Name   string   `json:"name" validate:"required"`
+	Owner  string   `json:"owner" validate:"required"`
+	Role   string   `json:"role"`
+	Scopes []string `json:"scopes"`
+	TTL    string   `json:"ttl"`
+}
+
+// The key is only ever returned when it's created or rotated.
+type APIKeyResponse struct {
+	dbgen.APIKey
+	Key string `json:"key"`
+}
+
 type Location struct {
 	Type        string    `json:"type"`
 	Coordinates []float64 `json:"coordinates"`
 }
 
+// Body of the deprecated PUT /property; zero values are left alone.
+type PutPropertyBody struct {
+	dbgen.PutPropertyParams
+	Location *Location `json:"location"`
+}
+
+// PatchPropertyBody is a JSON merge patch of a property. Absent fields are left
+// alone and null fields are cleared; last_scrape_metadata is itself merge
+// patched onto the current metadata.
+type PatchPropertyBody struct {
+	URL                PatchValue[string]          `json:"url"`
+	Zipcode            PatchValue[string]          `json:"zipcode"`
+	City               PatchValue[string]          `json:"city"`
+	State              PatchValue[string]          `json:"state"`
+	Location           PatchValue[Location]        `json:"location"`
+	LastScrapeTS       PatchValue[time.Time]       `json:"last_scrape_ts"`
+	LastScrapeStatus   PatchValue[string]          `json:"last_scrape_status"`
+	LastScrapeMetadata PatchValue[json.RawMessage] `json:"last_scrape_metadata"`
+}
+
 type CreatePropertyParams struct {
 	dbgen.CreatePropertyParams
 	Location Location `json:"location"`
 }
 
+// Properties are returned with their location as GeoJSON; see
+// makeLocationSerializable.
+type PropertyResponse struct {
+	dbgen.Property
+	Location Location `json:"location"`
+}
+
+type PropertyPriceResponse struct {
+	dbgen.PropertyPrice
+	Location Location `json:"location"`
+}
+
+// A bucket of the realtor prices plot.
+type PriceBin struct {
+	Price float64 `json:"price"`
+	Count int     `json:"count"`
+}
+
+// A point of the property prices plot.
+type PricePoint struct {
+	X string `json:"timestamp"`
+	Y int32  `json:"price"`
+}
+
 // This needs to check for any types that contain *geom.Point fields and make
 // them serializable because it has proven to be annoyingly difficult to have
 // sqlc, pgx, and go-geom to use a serializable type location data. This should
@@ -36,30 +262,244 @@ func makeLocationSerializable(v any) any {
 	switch qr := v.(type) {
 	// query response was a single Property
 	case dbgen.Property:
-		type serialPrice struct {
-			dbgen.Property
-			Location Location `json:"location"`
-		}
-		return serialPrice{qr, Location{Type: "Point", Coordinates: qr.Location.Coords()}}
+		return PropertyResponse{qr, Location{Type: "Point", Coordinates: qr.Location.Coords()}}
 	// query response was a single PropertyPrice
 	case dbgen.PropertyPrice:
-		type serialPrice struct {
-			dbgen.PropertyPrice
-			Location Location `json:"location"`
-		}
-		return serialPrice{qr, Location{Type: "Point", Coordinates: qr.Location.Coords()}}
+		return PropertyPriceResponse{qr, Location{Type: "Point", Coordinates: qr.Location.Coords()}}
 	// query response was a list of PropertyPrice
 	case []dbgen.PropertyPrice:
-		type serialPrice struct {
-			dbgen.PropertyPrice
-			Location Location `json:"location"`
-		}
-		res := []serialPrice{}
+		res := []PropertyPriceResponse{}
 		for _, p := range qr {
-			res = append(res, serialPrice{p, Location{Type: "Point", Coordinates: p.Location.Coords()}})
+			res = append(res, PropertyPriceResponse{p, Location{Type: "Point", Coordinates: p.Location.Coords()}})
 		}
 		return res
 	default:
 		return v
 	}
 }
+
+// Query params of GET /comps.
+type CompsQuery struct {
+	PropertyID int32   `query:"property_id" validate:"required"`
+	ListingID  int32   `query:"listing_id" validate:"required"`
+	Radius     float64 `query:"radius" validate:"gt=0"`
+	Months     int     `query:"months" validate:"min=1"`
+	Limit      int     `query:"limit" validate:"min=1"`
+}
+
+// Query params of GET /job. A job_id returns that job; otherwise the most
+// recently updated jobs are listed.
+type JobQuery struct {
+	JobID  int64  `query:"job_id"`
+	Kind   string `query:"kind"`
+	Status string `query:"status" validate:"oneof=queued running succeeded dead"`
+	Limit  int    `query:"limit" validate:"min=1,max=1000"`
+}
+
+// Query params of POST /job/claim. Lease is a duration (e.g., "10m").
+type JobClaimQuery struct {
+	Kinds []string `query:"kind" validate:"required"`
+	Owner string   `query:"owner" validate:"required"`
+	Limit int      `query:"limit" validate:"min=1,max=100"`
+	Lease string   `query:"lease"`
+}
+
+// Query params of GET /admin/audit-log. Duration limits the entries to those
+// made within it (e.g., "24h").
+type AuditLogQuery struct {
+	KeyID     int64         `query:"key_id"`
+	Principal string        `query:"principal"`
+	Duration  time.Duration `query:"duration"`
+	Limit     int           `query:"limit" validate:"min=1,max=1000"`
+}
+
+// Query params of the routes that take the SHA-256 hash of an archived payload
+// or image.
+type HashQuery struct {
+	Hash string `query:"hash" validate:"required,sha256"`
+}
+
+// Query params of GET /payload-archive. Start and end are dates or RFC 3339
+// timestamps; see ParseTimeRange.
+type PayloadArchiveQuery struct {
+	PropertyID int32  `query:"property_id"`
+	ListingID  int32  `query:"listing_id"`
+	Start      string `query:"start"`
+	End        string `query:"end"`
+	Limit      int    `query:"limit" validate:"min=1,max=10000"`
+	Offset     int    `query:"offset" validate:"min=0"`
+}
+
+// Query params of GET /payload-archive/diff. From and to are dates or RFC 3339
+// timestamps; to defaults to now.
+type PayloadDiffQuery struct {
+	PropertyID int32  `query:"property_id" validate:"required"`
+	ListingID  int32  `query:"listing_id" validate:"required"`
+	Endpoint   string `query:"endpoint" validate:"required"`
+	From       string `query:"from" validate:"required"`
+	To         string `query:"to"`
+}
+
+// Query params of GET /avm-estimates and GET /rental-estimates. The listing_id
+// is optional.
+type EstimatesQuery struct {
+	PropertyID int32 `query:"property_id" validate:"required"`
+	ListingID  int32 `query:"listing_id"`
+}
+
+// Query params of GET /avm-accuracy. RealtorID and Name only apply to
+// group_by=realtor.
+type AVMAccuracyQuery struct {
+	GroupBy    string `query:"group_by" validate:"oneof=zipcode realtor"`
+	PropertyID int32  `query:"property_id"`
+	Zipcode    string `query:"zipcode"`
+	RealtorID  int32  `query:"realtor_id"`
+	Name       string `query:"name"`
+}
+
+// Query params of GET /rental-yield. MinYield is a fraction (e.g., 0.06).
+type RentalYieldQuery struct {
+	Zipcode  string  `query:"zipcode"`
+	City     string  `query:"city"`
+	State    string  `query:"state"`
+	MinYield float64 `query:"min_yield"`
+}
+
+// Query params of GET /near-duplicate-images. MaxDistance is the
+// Hamming distance between difference hashes.
+type NearDuplicateImagesQuery struct {
+	PropertyID  int32 `query:"property_id" validate:"required"`
+	ListingID   int32 `query:"listing_id"`
+	MaxDistance int   `query:"max_distance" validate:"min=0,max=16"`
+	Limit       int   `query:"limit" validate:"min=1,max=1000"`
+}
+
+// Query params that identify a single property listing.
+type PropertyKeyQuery struct {
+	PropertyID int32 `query:"property_id" validate:"required"`
+	ListingID  int32 `query:"listing_id" validate:"required"`
+}
+
+// Query params of GET and DELETE /property. Omitting the listing_id selects
+// every listing of the property.
+type PropertyQuery struct {
+	PropertyID int32 `query:"property_id"`
+	ListingID  int32 `query:"listing_id"`
+}
+
+// Query params of GET and DELETE /search. A search is identified by either its
+// id or its query.
+type SearchQuery struct {
+	SearchID int32  `query:"search_id"`
+	Query    string `query:"search_query"`
+}
+
+// Query params of GET /realtor. Without an id or name, realtors are searched
+// for by the search term, which lists them all when empty.
+type RealtorQuery struct {
+	RealtorID int32  `query:"id"`
+	Name      string `query:"name"`
+	Search    string `query:"search"`
+}
+
+// Query params of GET /market-stats. The region is exactly one of: zipcode,
+// city (with state), or polygon (WKT). Start and end are dates or RFC 3339
+// timestamps; see ParseTimeRange.
+type MarketStatsQuery struct {
+	Zipcode string `query:"zipcode"`
+	City    string `query:"city"`
+	State   string `query:"state"`
+	Polygon string `query:"polygon"`
+	Start   string `query:"start"`
+	End     string `query:"end"`
+}
+
+// Query params of the routes that operate on a provider payload endpoint's
+// baseline.
+type PayloadEndpointQuery struct {
+	Provider string `query:"provider" validate:"required"`
+	Endpoint string `query:"endpoint" validate:"required"`
+}
+
+// Query params of GET /admin/payload-drift. Since is a date or RFC 3339
+// timestamp and defaults to a week ago.
+type PayloadDriftQuery struct {
+	Provider string    `query:"provider"`
+	Endpoint string    `query:"endpoint"`
+	Since    time.Time `query:"since"`
+}
+
+// Query params of POST /token. TTL is a duration (e.g., "720h") and defaults
+// to the key ring's token lifetime.
+type IssueTokenQuery struct {
+	Email  string        `query:"email"`
+	Role   string        `query:"role"`
+	Scopes []string      `query:"scope"`
+	TTL    time.Duration `query:"ttl"`
+}
+
+// Query params of the API key routes that act on a single key.
+type APIKeyIDQuery struct {
+	KeyID int64 `query:"key_id" validate:"required"`
+}
+
+// Query params of GET /api-key. A key_id returns that key; otherwise keys are
+// listed, optionally only those of the owner.
+type APIKeyQuery struct {
+	KeyID int64  `query:"key_id"`
+	Owner string `query:"owner"`
+}
+
+// Path params of GET /image/{hash}.
+type ImagePath struct {
+	Hash string `path:"hash" validate:"required,sha256"`
+}
+
+// Query params of DELETE /job.
+type JobIDQuery struct {
+	JobID int64 `query:"job_id" validate:"required"`
+}
+
+// Query params of GET /admin/job-stats. Duration limits the counts to jobs
+// updated within it (e.g., "24h").
+type JobStatsQuery struct {
+	Duration time.Duration `query:"duration"`
+}
+
+// Query params of GET /realtor-prices-plot.
+type RealtorPricesPlotQuery struct {
+	Version string `query:"version" validate:"oneof=1"`
+	Name    string `query:"name" validate:"required"`
+}
+
+// Query params of GET /property-prices-plot.
+type PropertyPricesPlotQuery struct {
+	Version    string `query:"version" validate:"oneof=1"`
+	PropertyID int32  `query:"property_id" validate:"required"`
+}
+
+// Query params of GET /property-events.
+type PropertyEventsQuery struct {
+	PropertyID int32 `query:"property_id" validate:"required"`
+}
+
+// Query params of DELETE /property-events.
+type PropertyEventsDeleteQuery struct {
+	EventIDs []int32 `query:"event_id" validate:"required"`
+}
+
+// Query params of DELETE /realtor. With a property_id but no listing_id, every
+// listing of the realtor is deleted.
+type RealtorDeleteQuery struct {
+	RealtorID  int32 `query:"realtor_id" validate:"required"`
+	PropertyID int32 `query:"property_id"`
+	ListingID  int32 `query:"listing_id"`
+}
+
+// Params of the signed blob routes of the local blob store; see
+// LocalBlobStore.verify.
+type BlobParams struct {
+	Key       string `path:"key"`
+	Expires   int64  `query:"expires" validate:"required"`
+	Signature string `query:"signature" validate:"required"`
+}
@@ -1,13 +1,12 @@
 package server
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"strings"
 
-	"firebase.google.com/go/auth"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/brojonat/gredfin/server/db/dbgen"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -16,13 +15,26 @@ func getRootHandler(
 	l *slog.Logger,
 	p *pgxpool.Pool,
 	q *dbgen.Queries,
-	s3 *s3.Client,
-	fbc *auth.Client,
+	bs BlobStore,
+	fbc IDTokenVerifier,
+	kr *KeyRing,
+	rls *RateLimits,
 ) http.Handler {
-	mux := http.NewServeMux()
+	mux := newRouteTable(l)
+	if os.Getenv("OPENAPI_CHECK_RESPONSES") != "" {
+		mux.checker = l
+	}
+	if s := os.Getenv("UNVERSIONED_ROUTES_SUNSET"); s != "" {
+		t, err := parseQueryTime(s)
+		if err != nil {
+			panic(fmt.Sprintf("bad UNVERSIONED_ROUTES_SUNSET: %s", err))
+		}
+		mux.unversionedSunset = t
+	}
 
 	// max body size
 	maxBytes := int64(1048576)
+	maxBlobBytes := int64(64 * 1048576)
 
 	// parse and transform the comma separated envs that configure CORS
 	hs := os.Getenv("CORS_HEADERS")
@@ -39,144 +51,557 @@ func getRootHandler(
 	methods := normalizeCORSParams(ms)
 	origins := normalizeCORSParams(ogs)
 
+	// Routes are authorized by scope (see auth.go) and charged against the
+	// principal's rate limit quota (see ratelimit.go). Workers hold the jobs
+	// scope, which only covers the job queue, and the scrape scope, which
+	// covers the routes they read and write scraped data through; each of
+	// those is commented with the worker that uses it. Mutating routes record
+	// each request in the audit log. Routes are served under the current API
+	// version, with deprecated aliases at their unversioned paths (see
+	// version.go); routes added since are only served under the version.
+
 	// helper routes
-	mux.HandleFunc("OPTIONS /", adaptHandler(
+	mux.handleUnversioned("OPTIONS /", adaptHandler(
 		func(w http.ResponseWriter, r *http.Request) {},
 		apiMode(l, maxBytes, headers, methods, origins),
 	))
 	mux.HandleFunc("GET /ping", adaptHandler(
 		handlePing(l, p),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer(), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead, ScopeJobs),
+	))
+	mux.handleUnversioned("GET /.well-known/jwks.json", adaptHandler(
+		handleJWKS(kr),
+		apiMode(l, maxBytes, headers, methods, origins),
+		// no token required here, these are public keys
+	))
+	mux.HandleFunc("GET /whoami", adaptHandler(
+		handleWhoAmI(),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		// any principal can see who they are
 	))
 	mux.HandleFunc("POST /token", adaptHandler(
-		handleIssueToken(l),
+		handleIssueToken(l, kr),
 		apiMode(l, maxBytes, headers, methods, origins),
 		// no token required here
 	))
 
+	// api docs routes, see openapi.go
+	mux.HandleFunc("GET /openapi.json", adaptHandler(
+		handleOpenAPISpec(mux),
+		apiMode(l, maxBytes, headers, methods, origins),
+	))
+	mux.HandleFunc("GET /docs", handleOpenAPIDocs())
+
 	// realtor CRUDL routes
 	mux.HandleFunc("GET /realtor", adaptHandler(
 		handleRealtorGet(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer(), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead),
 	))
+	// scrape: the property worker records the listing agent
 	mux.HandleFunc("POST /realtor", adaptHandler(
 		handleRealtorPost(l, p, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite, ScopeScrape),
 	))
 	mux.HandleFunc("DELETE /realtor", adaptHandler(
 		handleRealtorDelete(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite),
 	))
 
 	// search CRUDL routes
 	mux.HandleFunc("GET /search", adaptHandler(
 		handleSearchGet(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead),
 	))
 	mux.HandleFunc("POST /search", adaptHandler(
 		handleSearchPost(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite),
 	))
 	mux.HandleFunc("DELETE /search", adaptHandler(
-		handleSearchDelete(l, q),
+		handleSearchDelete(l, p, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite),
 	))
 
 	// property CRUDL routes
 	mux.HandleFunc("GET /property", adaptHandler(
 		handlePropertyGet(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer(), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costQuery),
+		requireScope(ScopeRead),
+	))
+	// scrape: the property worker reads the listing it's scraping
+	mux.HandleFunc("GET /property-basic", adaptHandler(
+		handlePropertyBasicGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead, ScopeScrape),
 	))
+	// scrape: the search worker adds the listings a search finds
 	mux.HandleFunc("POST /property", adaptHandler(
 		handlePropertyPost(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite, ScopeScrape),
 	))
+	// Deprecated: PUT /property can't clear fields; use PATCH /property.
 	mux.HandleFunc("PUT /property", adaptHandler(
 		handlePropertyUpdate(l, p, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite),
+	))
+	// scrape: the property worker records each scrape
+	mux.HandleFunc("PATCH /property", adaptHandler(
+		handlePropertyPatch(l, p, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite, ScopeScrape),
 	))
 	mux.HandleFunc("DELETE /property", adaptHandler(
 		handlePropertyDelete(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite),
+	))
+
+	// property-details routes
+	mux.HandleFunc("GET /property-details", adaptHandler(
+		handlePropertyDetailsGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead),
+	))
+	// scrape: the property worker records the listing details
+	mux.HandleFunc("PUT /property-details", adaptHandler(
+		handlePropertyDetailsPut(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite, ScopeScrape),
 	))
 
 	// property-event CRUDL routes
 	mux.HandleFunc("GET /property-events", adaptHandler(
 		handlePropertyEventsGet(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer(), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead),
 	))
 	mux.HandleFunc("POST /property-events", adaptHandler(
 		handlePropertyEventsPost(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite),
 	))
+	// scrape: the property worker replaces the listing history
 	mux.HandleFunc("PUT /property-events", adaptHandler(
 		handlePropertyEventsPut(l, p, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite, ScopeScrape),
 	))
 	mux.HandleFunc("DELETE /property-events", adaptHandler(
 		handlePropertyEventsDelete(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite),
 	))
 
-	// search worker routes
-	mux.HandleFunc("POST /search-query/claim-next", adaptHandler(
-		handleSearchClaimNext(l, p, q),
+	// job queue routes
+	mux.HandleFunc("GET /job", adaptHandler(
+		handleJobGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead),
+	))
+	mux.HandleFunc("POST /job", adaptHandler(
+		handleJobPost(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite),
 	))
-	mux.HandleFunc("POST /search-query/set-status", adaptHandler(
-		handleSearchSetStatus(l, q),
-		atLeastOneAuth(bearerAuthorizer()),
+	mux.HandleFunc("DELETE /job", adaptHandler(
+		handleJobDelete(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite),
+	))
+	mux.HandleFunc("POST /job/claim", adaptHandler(
+		handleJobClaim(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeJobs),
 	))
-	// property worker routes
-	mux.HandleFunc("POST /property-query/claim-next", adaptHandler(
-		handlePropertyClaimNext(l, p, q),
+	mux.HandleFunc("POST /job/complete", adaptHandler(
+		handleJobComplete(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeJobs),
 	))
-	mux.HandleFunc("POST /property-query/get-presigned-put-url", adaptHandler(
-		handleGetPresignedPutURL(l, s3, q),
+	mux.HandleFunc("POST /job/fail", adaptHandler(
+		handleJobFail(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer()),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeJobs),
 	))
 
 	// plot data routes
 	mux.HandleFunc("GET /realtor-prices-plot", adaptHandler(
 		handlePlotDataRealtorPrices(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer(), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costQuery),
+		requireScope(ScopeRead),
 	))
 	mux.HandleFunc("GET /property-prices-plot", adaptHandler(
 		handlePlotDataPropertyPrices(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer(), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costQuery),
+		requireScope(ScopeRead),
+	))
+
+	// market stats routes
+	mux.HandleFunc("GET /market-stats", adaptHandler(
+		handleMarketStatsGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costQuery),
+		requireScope(ScopeRead),
+	))
+	mux.HandleFunc("POST /admin/refresh-market-stats", adaptHandler(
+		handleRefreshMarketStats(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeAdmin),
+	))
+
+	// avm routes
+	mux.HandleFunc("GET /avm-estimates", adaptHandler(
+		handleAVMEstimatesGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead),
+	))
+	// scrape: the property worker records the AVM estimates
+	mux.HandleFunc("POST /avm-estimates", adaptHandler(
+		handleAVMEstimatesPost(l, p, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite, ScopeScrape),
+	))
+	mux.HandleFunc("GET /avm-accuracy", adaptHandler(
+		handleAVMAccuracyGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costQuery),
+		requireScope(ScopeRead),
+	))
+
+	// rental routes
+	mux.HandleFunc("GET /rental-estimates", adaptHandler(
+		handleRentalEstimatesGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead),
+	))
+	// scrape: the property worker records the rental estimates
+	mux.HandleFunc("POST /rental-estimates", adaptHandler(
+		handleRentalEstimatesPost(l, p, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite, ScopeScrape),
+	))
+	mux.HandleFunc("GET /rental-yield", adaptHandler(
+		handleRentalYieldGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costQuery),
+		requireScope(ScopeRead),
+	))
+
+	// comps routes
+	mux.HandleFunc("GET /comps", adaptHandler(
+		handleCompsGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costHeavy),
+		requireScope(ScopeRead),
+	))
+	// scrape: the property worker records the similar sold homes
+	mux.HandleFunc("PUT /similar-sold", adaptHandler(
+		handleSimilarSoldPut(l, p, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite, ScopeScrape),
 	))
 
-	// scrape stats routes
-	mux.HandleFunc("GET /admin/search-scrape-stats", adaptHandler(
-		handleGetRecentSearchScrapeStats(l, q),
+	// payload drift routes
+	// scrape: the property worker checks payloads for drift
+	mux.HandleFunc("GET /payload-schema", adaptHandler(
+		handlePayloadSchemaGet(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer(), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead, ScopeScrape),
 	))
-	mux.HandleFunc("GET /admin/property-scrape-stats", adaptHandler(
-		handleGetRecentPropertyScrapeStats(l, q),
+	// scrape: the property worker records the schema of new endpoints
+	mux.HandleFunc("PUT /payload-schema", adaptHandler(
+		handlePayloadSchemaPut(l, q),
 		apiMode(l, maxBytes, headers, methods, origins),
-		atLeastOneAuth(bearerAuthorizer(), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite, ScopeScrape),
 	))
+	// scrape: the property worker reports drift
+	mux.HandleFunc("POST /payload-drift", adaptHandler(
+		handlePayloadDriftPost(l, p, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite, ScopeScrape),
+	))
+	mux.HandleFunc("GET /admin/payload-drift", adaptHandler(
+		handlePayloadDriftGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeAdmin),
+	))
+	mux.HandleFunc("POST /admin/payload-drift/accept", adaptHandler(
+		handlePayloadDriftAccept(l, p, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeAdmin),
+	))
+
+	// payload archive routes
+	// scrape: the property worker archives payloads
+	mux.HandleFunc("POST /payload-archive/presign", adaptHandler(
+		handlePayloadArchivePresign(l, bs),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite, ScopeScrape),
+	))
+	// scrape: reprocessing (./cli admin reprocess) reads the archive
+	mux.HandleFunc("GET /payload-archive", adaptHandler(
+		handlePayloadArchiveGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead, ScopeScrape),
+	))
+	// scrape: the property worker archives payloads
+	mux.HandleFunc("POST /payload-archive", adaptHandler(
+		handlePayloadArchivePost(l, p, bs, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeWrite, ScopeScrape),
+	))
+	// scrape: reprocessing (./cli admin reprocess) reads the archive
+	mux.HandleFunc("GET /payload-archive/object", adaptHandler(
+		handlePayloadArchiveObjectGet(l, bs),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead, ScopeScrape),
+	))
+	mux.HandleFunc("GET /payload-archive/url", adaptHandler(
+		handlePayloadArchiveURLGet(l, bs, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeRead),
+	))
+	mux.HandleFunc("GET /payload-archive/diff", adaptHandler(
+		handlePayloadArchiveDiffGet(l, bs, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costHeavy),
+		requireScope(ScopeRead),
+	))
+
+	// image mirroring routes
+	// scrape: the image worker records mirrored images
+	mux.HandleFunc("POST /listing-image", adaptHandler(
+		handleListingImagePost(l, p, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeScrape),
+	))
+	// scrape: the image worker uploads mirrored images
+	mux.HandleFunc("POST /image/presign", adaptHandler(
+		handleImagePresign(l, bs, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeScrape),
+	))
+	mux.HandleFunc("GET /near-duplicate-images", adaptHandler(
+		handleNearDuplicateImagesGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costHeavy),
+		requireScope(ScopeRead),
+	))
+	mux.HandleFunc("GET /image/{hash}", adaptHandler(
+		handleImageGet(l, bs, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		// no token required here, see handleImageGet
+	))
+
+	// Signed blob routes. These are only served by the local blob store; the
+	// signature in the URL is the authorization, so there's no token check.
+	if lbs, ok := bs.(*LocalBlobStore); ok {
+		mux.handleUnversioned("PUT /blob/{key...}", adaptHandler(
+			handleBlobPut(l, lbs),
+			apiMode(l, maxBlobBytes, headers, methods, origins),
+		))
+		mux.handleUnversioned("GET /blob/{key...}", adaptHandler(
+			handleBlobGet(l, lbs),
+			apiMode(l, maxBytes, headers, methods, origins),
+		))
+	}
+
+	// api key routes
+	mux.HandleFunc("GET /api-key", adaptHandler(
+		handleAPIKeyGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeAdmin),
+	))
+	mux.HandleFunc("POST /api-key", adaptHandler(
+		handleAPIKeyPost(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeAdmin),
+	))
+	mux.HandleFunc("POST /api-key/revoke", adaptHandler(
+		handleAPIKeyRevoke(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeAdmin),
+	))
+	mux.HandleFunc("POST /api-key/rotate", adaptHandler(
+		handleAPIKeyRotate(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		auditRequest(l, q),
+		requireScope(ScopeAdmin),
+	))
+	mux.HandleFunc("GET /admin/audit-log", adaptHandler(
+		handleAuditLogGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeAdmin),
+	))
+
+	// job stats routes
+	mux.HandleFunc("GET /admin/job-stats", adaptHandler(
+		handleJobStatsGet(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costDefault),
+		requireScope(ScopeAdmin),
+	))
+
+	// graphql routes, see graphql.go; queries are read-only, so they're
+	// authorized like the GET routes they stand in for
+	mux.handleVersioned("POST /graphql", adaptHandler(
+		handleGraphQL(l, q),
+		apiMode(l, maxBytes, headers, methods, origins),
+		atLeastOneAuth(bearerAuthorizer(kr), apiKeyAuthorizer(q), firebaseAuthorizer(FirebaseJWTHeader, fbc)),
+		rateLimit(l, rls, costHeavy),
+		requireScope(ScopeRead),
+	))
+
+	// every route is registered, so the spec can be generated
+	if err := mux.buildSpec(); err != nil {
+		panic(err)
+	}
 	return mux
 }
@@ -0,0 +1,69 @@
+-- name: CreateAPIKey :one
+INSERT INTO api_key (
+  name, owner, prefix, key_hash, role, scopes, expires_ts
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7
+)
+RETURNING *;
+
+-- name: GetAPIKey :one
+SELECT *
+FROM api_key
+WHERE key_id = $1;
+
+-- name: ListAPIKeys :many
+SELECT *
+FROM api_key
+WHERE owner = @owner OR @owner = ''
+ORDER BY key_id;
+
+-- name: GetAPIKeyByHash :one
+-- Returns the key with the supplied hash if it's neither revoked nor expired.
+SELECT *
+FROM api_key
+WHERE
+  key_hash = $1 AND
+  revoked_ts IS NULL AND
+  (expires_ts IS NULL OR expires_ts > NOW());
+
+-- name: TouchAPIKey :exec
+-- Records that the key was used. Keys are authorized on every request, so the
+-- timestamp is only updated once it's stale, which keeps this from writing
+-- the row on every request.
+UPDATE api_key
+  SET last_used_ts = NOW()
+WHERE
+  key_id = $1 AND
+  (last_used_ts IS NULL OR last_used_ts < NOW() - INTERVAL '1 minute');
+
+-- name: RevokeAPIKey :execrows
+UPDATE api_key
+  SET revoked_ts = NOW()
+WHERE key_id = $1 AND revoked_ts IS NULL;
+
+-- name: RotateAPIKey :one
+-- Replaces the key of an API key that hasn't been revoked. The old key stops
+-- working immediately.
+UPDATE api_key
+  SET prefix = @prefix,
+  key_hash = @key_hash,
+  last_used_ts = NULL
+WHERE key_id = @key_id AND revoked_ts IS NULL
+RETURNING *;
+
+-- name: InsertAuditLog :exec
+INSERT INTO audit_log (
+  key_id, principal, method, path, status, remote_addr
+) VALUES (
+  $1, $2, $3, $4, $5, $6
+);
+
+-- name: ListAuditLog :many
+SELECT *
+FROM audit_log
+WHERE
+  (@key_id::BIGINT = 0 OR key_id = @key_id) AND
+  (principal = @principal OR @principal = '') AND
+  created_ts > @since
+ORDER BY created_ts DESC
+LIMIT @row_limit;
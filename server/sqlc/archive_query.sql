@@ -0,0 +1,33 @@
+-- name: CreatePayloadArchiveEntry :execrows
+-- Entries are immutable, so re-uploading an existing entry is a no-op.
+INSERT INTO payload_archive (
+  property_id, listing_id, endpoint, scrape_ts, hash
+) VALUES (
+  $1, $2, $3, $4, $5
+) ON CONFLICT DO NOTHING;
+
+-- name: IsPayloadArchived :one
+SELECT EXISTS(SELECT 1 FROM payload_archive WHERE hash = @hash)::BOOLEAN AS archived;
+
+-- name: GetPayloadArchiveEntries :many
+SELECT *
+FROM payload_archive
+WHERE
+  (property_id = @property_id OR @property_id = 0) AND
+  (listing_id = @listing_id OR @listing_id = 0) AND
+  scrape_ts >= @start_ts::TIMESTAMP AND
+  scrape_ts < @end_ts::TIMESTAMP
+ORDER BY property_id, listing_id, scrape_ts, endpoint
+LIMIT @row_limit OFFSET @row_offset;
+
+-- name: GetPayloadArchiveEntryAt :one
+-- Returns the entry for the endpoint from the latest scrape at or before ts.
+SELECT *
+FROM payload_archive
+WHERE
+  property_id = @property_id AND
+  listing_id = @listing_id AND
+  endpoint = @endpoint AND
+  scrape_ts <= @ts::TIMESTAMP
+ORDER BY scrape_ts DESC
+LIMIT 1;
@@ -0,0 +1,62 @@
+-- name: CreateAVMEstimate :execrows
+-- Estimates are immutable, so re-uploading an existing estimate is a no-op.
+INSERT INTO avm_estimate (
+  property_id, listing_id, estimate_ts, value, value_low, value_high, source
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7
+) ON CONFLICT DO NOTHING;
+
+-- name: GetAVMEstimates :many
+SELECT *
+FROM avm_estimate
+WHERE
+  property_id = @property_id AND
+  (listing_id = @listing_id OR @listing_id = 0)
+ORDER BY estimate_ts;
+
+-- name: GetAVMAccuracy :many
+SELECT *
+FROM avm_accuracy
+WHERE
+  (property_id = @property_id OR @property_id = 0) AND
+  (zipcode = @zipcode OR @zipcode = '')
+ORDER BY sale_ts DESC
+LIMIT 1000;
+
+-- name: GetAVMAccuracyByZipcode :many
+SELECT
+  zipcode,
+  COUNT(*)::INT AS sale_count,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY ABS(estimate_error)) AS median_abs_estimate_error,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY list_to_estimate) AS median_list_to_estimate,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY sale_to_list) AS median_sale_to_list
+FROM avm_accuracy
+WHERE
+  zipcode IS NOT NULL AND
+  (zipcode = @zipcode OR @zipcode = '')
+GROUP BY zipcode
+ORDER BY sale_count DESC;
+
+-- name: GetAVMAccuracyByRealtor :many
+-- Aggregates AVM accuracy by the listing realtor. The list_to_estimate and
+-- sale_to_list medians indicate how well a realtor prices listings relative
+-- to the market estimate and to the eventual sale.
+SELECT
+  r.realtor_id,
+  r.name,
+  r.company,
+  COUNT(*)::INT AS sale_count,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY ABS(aa.estimate_error)) AS median_abs_estimate_error,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY aa.list_to_estimate) AS median_list_to_estimate,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY aa.sale_to_list) AS median_sale_to_list
+FROM avm_accuracy aa
+INNER JOIN realtor_property_through rpt
+  ON aa.property_id = rpt.property_id AND aa.listing_id = rpt.listing_id
+INNER JOIN realtor r
+  ON rpt.realtor_id = r.realtor_id
+WHERE
+  (r.realtor_id = @realtor_id OR @realtor_id = 0) AND
+  (r.name = @name OR @name = '')
+GROUP BY r.realtor_id, r.name, r.company
+ORDER BY sale_count DESC
+LIMIT 100;
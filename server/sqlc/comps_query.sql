@@ -0,0 +1,68 @@
+-- name: GetCompCandidates :many
+-- Returns listing cycles that sold at or after start_ts within radius meters
+-- of the subject property, along with the details of the sold listing where
+-- known. Scoring and filtering on attributes is left to the caller.
+SELECT
+  lc.property_id,
+  lc.listing_id,
+  p.url,
+  lc.sale_ts,
+  lc.sale_price::INT AS sale_price,
+  pd.beds,
+  pd.baths,
+  pd.sqft,
+  pd.year_built,
+  pd.property_type,
+  ST_Distance(lc.location::GEOGRAPHY, s.location::GEOGRAPHY)::FLOAT AS distance_m
+FROM property s
+INNER JOIN listing_cycle lc ON
+  ST_DWithin(lc.location::GEOGRAPHY, s.location::GEOGRAPHY, @radius::FLOAT)
+INNER JOIN property p ON
+  lc.property_id = p.property_id AND
+  lc.listing_id = p.listing_id
+LEFT JOIN property_details pd ON
+  lc.property_id = pd.property_id AND
+  lc.listing_id = pd.listing_id
+WHERE
+  s.property_id = @property_id AND
+  s.listing_id = @listing_id AND
+  lc.property_id != s.property_id AND
+  lc.sale_price IS NOT NULL AND
+  lc.sale_ts >= @start_ts::TIMESTAMP
+ORDER BY distance_m
+LIMIT 200;
+
+-- name: GetSimilarSold :many
+SELECT
+  ss.similar_property_id,
+  ss.similar_listing_id,
+  ss.url,
+  ss.price,
+  ss.sale_ts,
+  ss.beds,
+  ss.baths,
+  ss.sqft,
+  ST_Distance(ss.location::GEOGRAPHY, p.location::GEOGRAPHY)::FLOAT AS distance_m
+FROM similar_sold ss
+INNER JOIN property p ON
+  ss.property_id = p.property_id AND
+  ss.listing_id = p.listing_id
+WHERE
+  ss.property_id = @property_id AND
+  ss.listing_id = @listing_id;
+
+-- name: CreateSimilarSold :exec
+INSERT INTO similar_sold (
+  property_id, listing_id, similar_property_id, similar_listing_id, url,
+  price, sale_ts, beds, baths, sqft, location
+) VALUES (
+  @property_id, @listing_id, @similar_property_id, @similar_listing_id, @url,
+  @price, @sale_ts, @beds, @baths, @sqft,
+  ST_SetSRID(ST_MakePoint(@longitude::FLOAT, @latitude::FLOAT), 4326)
+) ON CONFLICT DO NOTHING;
+
+-- name: DeleteSimilarSold :exec
+DELETE FROM similar_sold
+WHERE
+  property_id = @property_id AND
+  listing_id = @listing_id;
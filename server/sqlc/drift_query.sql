@@ -0,0 +1,45 @@
+-- name: GetPayloadSchema :many
+SELECT *
+FROM payload_schema
+WHERE provider = @provider AND endpoint = @endpoint
+ORDER BY path;
+
+-- name: UpsertPayloadSchema :exec
+INSERT INTO payload_schema (provider, endpoint, path, value_type)
+SELECT @provider::VARCHAR, @endpoint::VARCHAR, UNNEST(@paths::TEXT[]), UNNEST(@value_types::TEXT[])
+ON CONFLICT (provider, endpoint, path) DO UPDATE SET value_type = EXCLUDED.value_type;
+
+-- name: DeletePayloadSchemaSubtree :exec
+-- Deletes the path and all of its descendants from the baseline. This matches
+-- prefixes with starts_with rather than LIKE, since keys often contain _.
+DELETE FROM payload_schema
+WHERE
+  provider = @provider AND
+  endpoint = @endpoint AND
+  (path = @path::TEXT OR starts_with(path, @path::TEXT || '.') OR starts_with(path, @path::TEXT || '[]'));
+
+-- name: UpsertPayloadDrift :exec
+INSERT INTO payload_drift (
+  provider, endpoint, path, change, baseline_type, observed_type, last_property_id, last_listing_id
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8
+) ON CONFLICT (provider, endpoint, path, change) DO UPDATE SET
+  baseline_type = EXCLUDED.baseline_type,
+  observed_type = EXCLUDED.observed_type,
+  occurrences = payload_drift.occurrences + 1,
+  last_seen_ts = NOW(),
+  last_property_id = EXCLUDED.last_property_id,
+  last_listing_id = EXCLUDED.last_listing_id;
+
+-- name: GetPayloadDrift :many
+SELECT *
+FROM payload_drift
+WHERE
+  (provider = @provider OR @provider = '') AND
+  (endpoint = @endpoint OR @endpoint = '') AND
+  last_seen_ts >= @since::TIMESTAMP
+ORDER BY last_seen_ts DESC, occurrences DESC;
+
+-- name: DeletePayloadDrift :execrows
+DELETE FROM payload_drift
+WHERE provider = @provider AND endpoint = @endpoint;
@@ -0,0 +1,59 @@
+-- name: CreateImage :exec
+-- Images are immutable, so re-uploading an existing image is a no-op.
+INSERT INTO image (
+  hash, content_type, size_bytes, width, height, ahash, dhash
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7
+) ON CONFLICT DO NOTHING;
+
+-- name: CreateListingImage :exec
+-- Records that a scraped listing image was mirrored. Mirroring the same URL
+-- again (e.g., after the listing's photos are reordered) updates the row.
+INSERT INTO listing_image (
+  property_id, listing_id, source_url, kind, position, hash, mirrored_ts
+) VALUES (
+  $1, $2, $3, $4, $5, $6, NOW()
+) ON CONFLICT (property_id, listing_id, source_url) DO UPDATE SET
+  kind = EXCLUDED.kind,
+  position = EXCLUDED.position,
+  hash = EXCLUDED.hash,
+  mirrored_ts = NOW();
+
+-- name: ImageExists :one
+SELECT EXISTS(SELECT 1 FROM image WHERE hash = @hash)::BOOLEAN AS stored;
+
+-- name: GetMirroredImageHashes :many
+-- Returns the mirrored image hash for each of the supplied source URLs that
+-- has been mirrored.
+SELECT DISTINCT ON (source_url) source_url, hash::CHAR(64) AS hash
+FROM listing_image
+WHERE source_url = ANY(@source_urls::TEXT[])
+ORDER BY source_url, mirrored_ts DESC;
+
+-- name: GetNearDuplicateImages :many
+-- Returns the photos on other listings that are within max_distance (Hamming
+-- distance between difference hashes) of the photos on the supplied listing.
+-- A match on the same property under a different listing id is a relist;
+-- a match on a different property is likely a reused (e.g., stock) photo.
+SELECT DISTINCT
+  li.property_id,
+  li.listing_id,
+  i.hash,
+  mli.property_id AS match_property_id,
+  mli.listing_id AS match_listing_id,
+  m.hash AS match_hash,
+  (mli.property_id = li.property_id)::BOOLEAN AS relist,
+  bit_count((i.ahash # m.ahash)::BIT(64))::INT AS ahash_distance,
+  bit_count((i.dhash # m.dhash)::BIT(64))::INT AS dhash_distance
+FROM listing_image li
+INNER JOIN image i ON li.hash = i.hash
+INNER JOIN image m ON bit_count((i.dhash # m.dhash)::BIT(64)) <= @max_distance::INT
+INNER JOIN listing_image mli ON m.hash = mli.hash
+WHERE
+  li.property_id = @property_id AND
+  (li.listing_id = @listing_id OR @listing_id = 0) AND
+  li.kind = 'image' AND
+  mli.kind = 'image' AND
+  NOT (mli.property_id = li.property_id AND mli.listing_id = li.listing_id)
+ORDER BY dhash_distance, ahash_distance, match_property_id, match_listing_id
+LIMIT @row_limit;
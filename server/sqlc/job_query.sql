@@ -0,0 +1,188 @@
+-- name: EnqueueJob :one
+-- Creates a job. If a job of the same kind with the same dedupe key already
+-- exists, its payload and settings are updated instead, but its schedule and
+-- status are left alone.
+INSERT INTO job (
+  kind, dedupe_key, payload, priority, max_attempts, run_at, repeat_seconds
+) VALUES (
+  @kind, @dedupe_key, @payload, @priority, @max_attempts, @run_at, @repeat_seconds
+)
+ON CONFLICT (kind, dedupe_key) DO UPDATE
+  SET payload = EXCLUDED.payload,
+  priority = EXCLUDED.priority,
+  max_attempts = EXCLUDED.max_attempts,
+  repeat_seconds = EXCLUDED.repeat_seconds,
+  updated_ts = NOW()
+RETURNING *;
+
+-- name: ClaimJobs :many
+-- Leases up to row_limit runnable jobs of the supplied kinds to a worker. A
+-- job is runnable if it's queued and due, or if it's running but its lease
+-- has expired (i.e., the worker that claimed it went away) and it has attempts
+-- left; ExpireJobLeases gives up on the ones that don't. Higher priority
+-- jobs are claimed first, then the jobs that have been due the longest. Rows
+-- are locked with SKIP LOCKED so concurrent claims don't block each other.
+UPDATE job
+  SET status = 'running',
+  attempts = attempts + 1,
+  lease_owner = @lease_owner,
+  lease_expires_ts = NOW() + MAKE_INTERVAL(secs => @lease_seconds::INT),
+  updated_ts = NOW()
+WHERE job_id IN (
+  SELECT j.job_id
+  FROM job j
+  WHERE
+    j.kind = ANY(@kinds::VARCHAR[]) AND (
+      (j.status = 'queued' AND j.run_at <= NOW()) OR
+      (j.status = 'running' AND j.lease_expires_ts < NOW() AND j.attempts < j.max_attempts)
+    )
+  ORDER BY j.priority DESC, j.run_at
+  LIMIT @row_limit
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: CompleteJob :execrows
+-- Marks a leased job as succeeded. Repeating jobs are requeued to run again
+-- after their interval instead. Affects no rows if the caller no longer holds
+-- the lease.
+UPDATE job
+  SET status = CASE WHEN repeat_seconds IS NULL THEN 'succeeded' ELSE 'queued' END,
+  run_at = CASE WHEN repeat_seconds IS NULL THEN run_at ELSE NOW() + MAKE_INTERVAL(secs => repeat_seconds) END,
+  attempts = CASE WHEN repeat_seconds IS NULL THEN attempts ELSE 0 END,
+  result = @result,
+  last_error = NULL,
+  lease_owner = NULL,
+  lease_expires_ts = NULL,
+  updated_ts = NOW()
+WHERE job_id = @job_id AND lease_owner = @lease_owner AND status = 'running';
+
+-- name: FailJob :execrows
+-- Records a failed attempt of a leased job. The job is retried with
+-- exponential backoff until it runs out of attempts, at which point it's
+-- marked dead. Repeating jobs are never marked dead; they start over at their
+-- next interval. Affects no rows if the caller no longer holds the lease.
+UPDATE job
+  SET status = CASE WHEN attempts >= max_attempts AND repeat_seconds IS NULL THEN 'dead' ELSE 'queued' END,
+  run_at = CASE
+    WHEN attempts >= max_attempts THEN NOW() + MAKE_INTERVAL(secs => COALESCE(repeat_seconds, 0))
+    ELSE NOW() + MAKE_INTERVAL(secs => @backoff_seconds::INT * POWER(2, attempts - 1))
+  END,
+  attempts = CASE WHEN attempts >= max_attempts AND repeat_seconds IS NOT NULL THEN 0 ELSE attempts END,
+  last_error = @last_error,
+  lease_owner = NULL,
+  lease_expires_ts = NULL,
+  updated_ts = NOW()
+WHERE job_id = @job_id AND lease_owner = @lease_owner AND status = 'running';
+
+-- name: ExpireJobLeases :execrows
+-- Gives up on leased jobs of the supplied kinds whose lease expired on their
+-- last attempt (e.g., because the job crashed its worker). Like FailJob, they
+-- are marked dead, or start over at their next interval if they repeat.
+UPDATE job
+  SET status = CASE WHEN repeat_seconds IS NULL THEN 'dead' ELSE 'queued' END,
+  run_at = NOW() + MAKE_INTERVAL(secs => COALESCE(repeat_seconds, 0)),
+  attempts = CASE WHEN repeat_seconds IS NULL THEN attempts ELSE 0 END,
+  last_error = 'lease expired',
+  lease_owner = NULL,
+  lease_expires_ts = NULL,
+  updated_ts = NOW()
+WHERE
+  kind = ANY(@kinds::VARCHAR[]) AND
+  status = 'running' AND
+  lease_expires_ts < NOW() AND
+  attempts >= max_attempts;
+
+-- name: GetJob :one
+SELECT *
+FROM job
+WHERE job_id = $1;
+
+-- name: ListJobs :many
+SELECT *
+FROM job
+WHERE
+  (kind = @kind OR @kind = '') AND
+  (status = @status OR @status = '')
+ORDER BY updated_ts DESC
+LIMIT @row_limit;
+
+-- name: DeleteJob :execrows
+DELETE FROM job
+WHERE job_id = $1;
+
+-- name: DeleteJobByDedupeKey :exec
+DELETE FROM job
+WHERE kind = $1 AND dedupe_key = $2;
+
+-- name: DropJob :execrows
+-- Deletes a leased job that no longer applies (e.g., because the record it
+-- refers to was deleted). Affects no rows if the caller no longer holds the
+-- lease.
+DELETE FROM job
+WHERE job_id = @job_id AND lease_owner = @lease_owner AND status = 'running';
+
+-- name: GetJobStats :many
+-- Counts jobs by kind and status. Only jobs updated since the supplied time are
+-- counted.
+SELECT kind, status, COUNT(*) AS count
+FROM job
+WHERE updated_ts > @since
+GROUP BY kind, status
+ORDER BY kind, status;
+
+-- name: EnqueueSearchJobs :execrows
+-- Ensures every search has a repeating scrape job. This migrates searches that
+-- were created before the job queue existed; it's a no-op for searches that
+-- already have a job.
+INSERT INTO job (kind, dedupe_key, payload, repeat_seconds)
+SELECT 'search', s.query, JSONB_BUILD_OBJECT('query', s.query), @repeat_seconds::INT
+FROM search s
+WHERE s.query IS NOT NULL
+ON CONFLICT (kind, dedupe_key) DO NOTHING;
+
+-- name: EnqueuePropertyJobs :execrows
+-- Ensures every property listing has a repeating scrape job for its provider.
+-- Like EnqueueSearchJobs, this migrates listings created before the job queue
+-- existed.
+INSERT INTO job (kind, dedupe_key, payload, repeat_seconds)
+SELECT
+  'property:' || p.provider,
+  p.property_id || '/' || p.listing_id,
+  JSONB_BUILD_OBJECT('property_id', p.property_id, 'listing_id', p.listing_id),
+  @repeat_seconds::INT
+FROM property p
+ON CONFLICT (kind, dedupe_key) DO NOTHING;
+
+-- name: EnqueueImageJobs :execrows
+-- Ensures every scraped photo and thumbnail URL of the supplied listing (or of
+-- every listing, if property_id is 0) that hasn't been mirrored has an image
+-- mirroring job. Jobs are keyed by listing and URL, so each image of a listing
+-- is only mirrored once.
+WITH scraped AS (
+  SELECT p.property_id, p.listing_id, 'image'::VARCHAR AS kind, u.source_url, (u.position - 1)::INT AS position
+  FROM property p, jsonb_array_elements_text(p.last_scrape_metadata->'image_urls') WITH ORDINALITY AS u(source_url, position)
+  WHERE (p.property_id = @property_id AND p.listing_id = @listing_id) OR @property_id = 0
+  UNION ALL
+  SELECT p.property_id, p.listing_id, 'thumbnail'::VARCHAR AS kind, u.source_url, (u.position - 1)::INT AS position
+  FROM property p, jsonb_array_elements_text(p.last_scrape_metadata->'thumbnail_urls') WITH ORDINALITY AS u(source_url, position)
+  WHERE (p.property_id = @property_id AND p.listing_id = @listing_id) OR @property_id = 0
+)
+INSERT INTO job (kind, dedupe_key, payload)
+SELECT
+  'image',
+  s.property_id || '/' || s.listing_id || '/' || MD5(s.source_url),
+  JSONB_BUILD_OBJECT(
+    'property_id', s.property_id,
+    'listing_id', s.listing_id,
+    'kind', s.kind,
+    'position', s.position,
+    'source_url', s.source_url
+  )
+FROM scraped s
+LEFT JOIN listing_image li ON
+  s.property_id = li.property_id AND
+  s.listing_id = li.listing_id AND
+  s.source_url = li.source_url
+WHERE li.hash IS NULL
+ON CONFLICT (kind, dedupe_key) DO NOTHING;
@@ -0,0 +1,59 @@
+-- name: RefreshListingCycles :exec
+REFRESH MATERIALIZED VIEW CONCURRENTLY listing_cycle;
+
+-- name: RefreshMarketStats :exec
+-- Note that this depends on listing_cycle, so callers should refresh that
+-- view first.
+REFRESH MATERIALIZED VIEW CONCURRENTLY market_stats_monthly;
+
+-- name: GetMarketStats :many
+SELECT *
+FROM market_stats_monthly
+WHERE
+  region_type = @region_type AND
+  region = @region AND
+  month >= DATE_TRUNC('month', @start_ts::TIMESTAMP) AND
+  month < @end_ts::TIMESTAMP
+ORDER BY month;
+
+-- name: GetMarketStatsByPolygon :many
+-- This computes the same aggregates as market_stats_monthly, but on the fly
+-- for listing cycles located within the supplied WKT polygon. This can't be
+-- materialized since the polygon is arbitrary, but it only touches the
+-- listing_cycle view so it's still reasonably cheap.
+WITH months AS (
+  SELECT GENERATE_SERIES(
+    DATE_TRUNC('month', @start_ts::TIMESTAMP),
+    @end_ts::TIMESTAMP - '1 microsecond'::INTERVAL,
+    '1 month'::INTERVAL
+  ) AS month
+), active AS (
+  SELECT
+    m.month,
+    lc.sqft,
+    lc.list_ts,
+    lc.list_price,
+    lc.sale_ts,
+    lc.sale_price,
+    (lc.sale_ts >= m.month AND lc.sale_ts < m.month + '1 month'::INTERVAL) AS sold
+  FROM months m
+  INNER JOIN listing_cycle lc ON
+    lc.list_ts < m.month + '1 month'::INTERVAL AND
+    (lc.off_market_ts >= m.month OR lc.off_market_ts IS NULL)
+  WHERE ST_Within(lc.location, ST_GeomFromText(@polygon::TEXT, 4326))
+)
+SELECT
+  'polygon'::TEXT AS region_type,
+  @polygon::TEXT AS region,
+  a.month,
+  COUNT(*) AS inventory_count,
+  COUNT(*) FILTER (WHERE a.list_ts >= a.month) AS new_listings,
+  COUNT(*) FILTER (WHERE a.sold) AS sales_count,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY a.list_price) AS median_list_price,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY a.sale_price) FILTER (WHERE a.sold) AS median_sale_price,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY a.sale_price::FLOAT / a.sqft) FILTER (WHERE a.sold AND a.sqft > 0) AS median_price_per_sqft,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM a.sale_ts - a.list_ts) / 86400) FILTER (WHERE a.sold) AS median_days_on_market,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY a.sale_price::FLOAT / a.list_price) FILTER (WHERE a.sold AND a.list_price > 0) AS sale_to_list_ratio
+FROM active a
+GROUP BY a.month
+ORDER BY a.month;
@@ -28,6 +28,13 @@ WHERE
   (source_id = @source_id OR @source_id IS NULL OR @source_id = '')
 ORDER BY event_ts;
 
+-- name: GetPropertyEventsByPropertyIDs :many
+-- Returns the events of each of the supplied properties.
+SELECT *
+FROM property_events
+WHERE property_id = ANY(sqlc.arg(property_ids)::INT[])
+ORDER BY property_id, event_ts;
+
 -- name: CreatePropertyEvent :copyfrom
 INSERT INTO property_events (
   property_id, listing_id, price, event_description, source, source_id, event_ts
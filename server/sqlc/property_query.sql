@@ -19,29 +19,32 @@ WHERE
   (last_scrape_status = @last_scrape_status OR @last_scrape_status IS NULL OR @last_scrape_status = '')
 ORDER BY property_id;
 
--- name: GetNNextPropertyScrapeForUpdate :one
--- Get the next N property entries that have a last_scrape_status in the
--- supplied slice. Rows are locked for update; callers are expected to set
--- status rows to PENDING after retrieving rows. Note that this query uses
--- the "basic" property table, and NOT the property_price view because
--- callers may expect this to return properties with no price events.
+-- name: GetPropertiesWithPriceByIDs :many
+-- Returns the listings of each of the supplied properties.
 SELECT *
-FROM property
-WHERE last_scrape_status = ANY(sqlc.arg(statuses)::VARCHAR[])
-ORDER BY NOW()::timestamp - last_scrape_ts DESC
-LIMIT sqlc.arg(count)
-FOR UPDATE;
+FROM property_price
+WHERE property_id = ANY(sqlc.arg(property_ids)::INT[])
+ORDER BY property_id, listing_id;
 
 -- name: ListPropertiesPrices :many
 SELECT *
 FROM property_price p
 ORDER BY p.property_id;
 
+-- name: GetPropertyByExternalID :one
+SELECT *
+FROM property
+WHERE provider = $1 AND external_id = $2
+LIMIT 1;
+
+-- name: NextExternalPropertyID :one
+SELECT NEXTVAL('external_property_id_seq')::INT AS property_id;
+
 -- name: CreateProperty :exec
 INSERT INTO property (
-  property_id, listing_id, url, location
+  property_id, listing_id, url, location, provider, external_id
 ) VALUES (
-  $1, $2, $3, $4
+  $1, $2, $3, $4, $5, $6
 );
 
 -- name: PutProperty :exec
@@ -56,12 +59,6 @@ UPDATE property
   last_scrape_metadata = $10
 WHERE property_id = $1 AND listing_id = $2;
 
--- name: UpdatePropertyStatus :exec
-UPDATE property
-  SET last_scrape_ts = NOW()::timestamp,
-  last_scrape_status = $3
-WHERE property_id = $1 AND listing_id = $2;
-
 -- name: DeletePropertyListing :exec
 DELETE FROM property
 WHERE property_id = $1 AND listing_id = $2;
@@ -70,11 +67,21 @@ WHERE property_id = $1 AND listing_id = $2;
 DELETE FROM property
 WHERE property_id = $1;
 
--- name: GetRecentPropertyScrapeStats :one
-SELECT
-       COUNT(*) FILTER (WHERE last_scrape_status = 'good') AS good,
-       COUNT(*) FILTER (WHERE last_scrape_status = 'pending') AS pending,
-       COUNT(*) FILTER (WHERE last_scrape_status = 'bad') AS bad,
-       COUNT(*) FILTER (WHERE last_scrape_status IS NULL) AS "null"
-FROM property
-WHERE last_scrape_ts > $1;
+-- name: GetPropertyDetails :one
+SELECT *
+FROM property_details
+WHERE property_id = $1 AND listing_id = $2
+LIMIT 1;
+
+-- name: UpsertPropertyDetails :exec
+INSERT INTO property_details (
+  property_id, listing_id, beds, baths, sqft, lot_sqft, year_built, property_type
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8
+) ON CONFLICT (property_id, listing_id) DO UPDATE
+  SET beds = EXCLUDED.beds,
+  baths = EXCLUDED.baths,
+  sqft = EXCLUDED.sqft,
+  lot_sqft = EXCLUDED.lot_sqft,
+  year_built = EXCLUDED.year_built,
+  property_type = EXCLUDED.property_type;
@@ -44,6 +44,58 @@ WHERE
   -- FIXME: add a bunch more filters, this is the main query
 ORDER BY r.name;
 
+-- name: GetRealtorsByIDs :many
+SELECT *
+FROM realtor
+WHERE realtor_id = ANY(sqlc.arg(realtor_ids)::INT[])
+ORDER BY realtor_id;
+
+-- name: SearchRealtors :many
+SELECT *
+FROM realtor
+WHERE
+  (POSITION(LOWER(@search) IN LOWER(name)) > 0) OR
+  (POSITION(LOWER(@search) IN LOWER(company)) > 0)
+ORDER BY name, company
+LIMIT 100;
+
+-- name: GetListingRealtors :many
+-- Returns the realtors of each of the listings of the supplied properties.
+SELECT rp.property_id, rp.listing_id, r.realtor_id, r.name, r.company
+FROM realtor_property_through rp
+INNER JOIN realtor r ON rp.realtor_id = r.realtor_id
+WHERE rp.property_id = ANY(sqlc.arg(property_ids)::INT[])
+ORDER BY r.name, r.company;
+
+-- name: GetRealtorListings :many
+-- Returns the listings of each of the supplied realtors.
+SELECT
+  rp.realtor_id,
+  p.property_id, p.listing_id, p.price, p.url, p.zipcode, p.city, p.state,
+  p.location, p.last_scrape_ts, p.last_scrape_status, p.last_scrape_metadata
+FROM realtor_property_through rp
+INNER JOIN property_price p
+  ON rp.property_id = p.property_id AND rp.listing_id = p.listing_id
+WHERE rp.realtor_id = ANY(sqlc.arg(realtor_ids)::INT[])
+ORDER BY rp.realtor_id, p.property_id, p.listing_id;
+
+-- name: GetRealtorStats :many
+-- Returns aggregate stats over the listings of each of the supplied realtors.
+-- Realtors without listings are omitted.
+SELECT
+  rp.realtor_id,
+  COUNT(*)::INT AS "listing_count",
+  AVG(p.price)::INT AS "avg_price",
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY p.price)::INT AS "median_price",
+  MIN(p.price)::INT AS "min_price",
+  MAX(p.price)::INT AS "max_price",
+  COALESCE(STRING_AGG(DISTINCT p.zipcode, ','), '')::TEXT AS "zipcodes"
+FROM realtor_property_through rp
+INNER JOIN property_price p
+  ON rp.property_id = p.property_id AND rp.listing_id = p.listing_id
+WHERE rp.realtor_id = ANY(sqlc.arg(realtor_ids)::INT[])
+GROUP BY rp.realtor_id;
+
 -- name: CreateRealtor :exec
 INSERT INTO realtor (
   name, company
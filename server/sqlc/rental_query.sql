@@ -0,0 +1,26 @@
+-- name: CreateRentalEstimate :execrows
+-- Estimates are immutable, so re-uploading an existing estimate is a no-op.
+INSERT INTO rental_estimate (
+  property_id, listing_id, estimate_ts, rent, rent_low, rent_high
+) VALUES (
+  $1, $2, $3, $4, $5, $6
+) ON CONFLICT DO NOTHING;
+
+-- name: GetRentalEstimates :many
+SELECT *
+FROM rental_estimate
+WHERE
+  property_id = @property_id AND
+  (listing_id = @listing_id OR @listing_id = 0)
+ORDER BY estimate_ts;
+
+-- name: GetRentalYield :many
+SELECT *
+FROM rental_yield
+WHERE
+  (zipcode = @zipcode OR @zipcode = '') AND
+  (city = @city OR @city = '') AND
+  (state = @state OR @state = '') AND
+  gross_yield >= @min_yield::FLOAT
+ORDER BY gross_yield DESC
+LIMIT 1000;
@@ -3,9 +3,6 @@ CREATE EXTENSION IF NOT EXISTS postgis;
 CREATE TABLE search (
   search_id SERIAL PRIMARY KEY,
   query VARCHAR(128),
-  last_scrape_ts TIMESTAMP NOT NULL DEFAULT '19700101 00:00:00'::TIMESTAMP,
-  last_scrape_status VARCHAR(16) NOT NULL DEFAULT 'good',
-  last_scrape_metadata JSONB NOT NULL DEFAULT '{}'::JSONB,
   UNIQUE (query)
 );
 
@@ -20,9 +17,16 @@ CREATE TABLE property (
   last_scrape_ts TIMESTAMP DEFAULT '19700101 00:00:00'::TIMESTAMP,
   last_scrape_status VARCHAR(16) DEFAULT 'good',
   last_scrape_metadata JSONB NOT NULL DEFAULT '{}'::JSONB,
-  PRIMARY KEY (property_id, listing_id)
+  provider VARCHAR(32) NOT NULL DEFAULT 'redfin',
+  external_id VARCHAR(128),
+  PRIMARY KEY (property_id, listing_id),
+  UNIQUE (provider, external_id)
 );
 
+-- Identifiers for listings from providers that don't have native integer
+-- identifiers. These count down from -1 so they never collide with Redfin's.
+CREATE SEQUENCE external_property_id_seq INCREMENT BY -1 START WITH -1 MAXVALUE -1;
+
 CREATE TABLE realtor (
   realtor_id SERIAL,
   name VARCHAR(128),
@@ -60,6 +64,63 @@ CREATE TABLE property_events (
   PRIMARY KEY (property_id, listing_id, event_description, event_ts)
 );
 
+CREATE TABLE property_details (
+  property_id INT,
+  listing_id INT,
+  beds REAL,
+  baths REAL,
+  sqft INT,
+  lot_sqft INT,
+  year_built INT,
+  property_type VARCHAR(64),
+  FOREIGN KEY (property_id, listing_id) REFERENCES property (property_id, listing_id) ON DELETE CASCADE,
+  PRIMARY KEY (property_id, listing_id)
+);
+
+-- Time series of Redfin Estimates (AVM). The source is either "avm_details"
+-- (the estimate captured at scrape time) or "avm_historical" (backfilled).
+CREATE TABLE avm_estimate (
+  property_id INT,
+  listing_id INT,
+  estimate_ts TIMESTAMP NOT NULL,
+  value INT NOT NULL,
+  value_low INT,
+  value_high INT,
+  source VARCHAR(32) NOT NULL,
+  FOREIGN KEY (property_id, listing_id) REFERENCES property (property_id, listing_id) ON DELETE CASCADE,
+  PRIMARY KEY (property_id, listing_id, estimate_ts)
+);
+
+-- Time series of Redfin rental estimates (monthly rent).
+CREATE TABLE rental_estimate (
+  property_id INT,
+  listing_id INT,
+  estimate_ts TIMESTAMP NOT NULL,
+  rent INT NOT NULL,
+  rent_low INT,
+  rent_high INT,
+  FOREIGN KEY (property_id, listing_id) REFERENCES property (property_id, listing_id) ON DELETE CASCADE,
+  PRIMARY KEY (property_id, listing_id, estimate_ts)
+);
+
+-- Redfin's own "similar sold" homes for a property. These are replaced on
+-- every scrape and are merged into the comps computed from listing_cycle.
+CREATE TABLE similar_sold (
+  property_id INT,
+  listing_id INT,
+  similar_property_id INT NOT NULL,
+  similar_listing_id INT,
+  url VARCHAR(128),
+  price INT NOT NULL,
+  sale_ts TIMESTAMP,
+  beds REAL,
+  baths REAL,
+  sqft INT,
+  location GEOMETRY(Point, 4326) NOT NULL,
+  FOREIGN KEY (property_id, listing_id) REFERENCES property (property_id, listing_id) ON DELETE CASCADE,
+  PRIMARY KEY (property_id, listing_id, similar_property_id)
+);
+
 -- NOTE: Annoyingly, atlas isn't applying the following views. I need to debug
 -- why, but for now, note that these were manually added to the DB.
 
@@ -88,3 +149,328 @@ FROM property p
 INNER JOIN last_property_price_event pe ON
 	p.property_id = pe.property_id AND
 	p.listing_id = pe.listing_id;
+
+-- This materialized view splits each property's event history into listing
+-- cycles. A cycle starts with a Listed/Relisted event and ends with the first
+-- sale or delisting before the next cycle starts. Region and size attributes
+-- are joined in so the market aggregates don't need to touch the base tables.
+-- Sales that never went through a listing (e.g., public record transfers) are
+-- intentionally excluded.
+CREATE MATERIALIZED VIEW listing_cycle AS
+WITH listed AS (
+  SELECT
+    event_id, property_id, listing_id, price, event_ts,
+    LEAD(event_ts) OVER (PARTITION BY property_id ORDER BY event_ts) AS next_list_ts
+  FROM property_events
+  WHERE event_description IN ('Listed', 'Relisted')
+)
+SELECT
+  l.event_id,
+  l.property_id,
+  l.listing_id,
+  p.zipcode,
+  p.city,
+  p.state,
+  p.location,
+  pd.sqft,
+  l.event_ts AS list_ts,
+  l.price AS list_price,
+  s.event_ts AS sale_ts,
+  s.price AS sale_price,
+  LEAST(s.event_ts, d.event_ts) AS off_market_ts
+FROM listed l
+INNER JOIN property p ON
+  p.property_id = l.property_id AND
+  p.listing_id = l.listing_id
+LEFT JOIN property_details pd ON
+  pd.property_id = l.property_id AND
+  pd.listing_id = l.listing_id
+LEFT JOIN LATERAL (
+  SELECT e.event_ts, e.price
+  FROM property_events e
+  WHERE
+    e.property_id = l.property_id AND
+    e.event_ts >= l.event_ts AND
+    (e.event_ts < l.next_list_ts OR l.next_list_ts IS NULL) AND
+    e.event_description LIKE 'Sold%' AND
+    e.price != 0
+  ORDER BY e.event_ts
+  LIMIT 1
+) s ON TRUE
+LEFT JOIN LATERAL (
+  SELECT e.event_ts
+  FROM property_events e
+  WHERE
+    e.property_id = l.property_id AND
+    e.event_ts >= l.event_ts AND
+    (e.event_ts < l.next_list_ts OR l.next_list_ts IS NULL) AND
+    e.event_description IN ('Delisted', 'Listing Removed', 'Expired', 'Withdrawn', 'Cancelled')
+  ORDER BY e.event_ts
+  LIMIT 1
+) d ON TRUE;
+
+CREATE UNIQUE INDEX listing_cycle_event_id_idx ON listing_cycle (event_id);
+CREATE INDEX listing_cycle_location_idx ON listing_cycle USING GIST (location);
+CREATE INDEX listing_cycle_geography_idx ON listing_cycle USING GIST ((location::GEOGRAPHY));
+
+-- This materialized view aggregates listing cycles by month for every zipcode
+-- and every city. A cycle counts towards a month's inventory if it was on the
+-- market at any point during that month. It's refreshed periodically by the
+-- server (see refreshMarketStats).
+CREATE MATERIALIZED VIEW market_stats_monthly AS
+WITH months AS (
+  SELECT GENERATE_SERIES(
+    DATE_TRUNC('month', MIN(list_ts)),
+    DATE_TRUNC('month', NOW()::TIMESTAMP),
+    '1 month'::INTERVAL
+  ) AS month
+  FROM listing_cycle
+), active AS (
+  SELECT
+    m.month,
+    lc.zipcode,
+    lc.city,
+    lc.state,
+    lc.sqft,
+    lc.list_ts,
+    lc.list_price,
+    lc.sale_ts,
+    lc.sale_price,
+    (lc.sale_ts >= m.month AND lc.sale_ts < m.month + '1 month'::INTERVAL) AS sold
+  FROM months m
+  INNER JOIN listing_cycle lc ON
+    lc.list_ts < m.month + '1 month'::INTERVAL AND
+    (lc.off_market_ts >= m.month OR lc.off_market_ts IS NULL)
+)
+SELECT
+  CASE WHEN GROUPING(a.zipcode) = 0 THEN 'zipcode' ELSE 'city' END AS region_type,
+  CASE WHEN GROUPING(a.zipcode) = 0 THEN a.zipcode ELSE a.city || ', ' || a.state END AS region,
+  a.month,
+  COUNT(*) AS inventory_count,
+  COUNT(*) FILTER (WHERE a.list_ts >= a.month) AS new_listings,
+  COUNT(*) FILTER (WHERE a.sold) AS sales_count,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY a.list_price) AS median_list_price,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY a.sale_price) FILTER (WHERE a.sold) AS median_sale_price,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY a.sale_price::FLOAT / a.sqft) FILTER (WHERE a.sold AND a.sqft > 0) AS median_price_per_sqft,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM a.sale_ts - a.list_ts) / 86400) FILTER (WHERE a.sold) AS median_days_on_market,
+  PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY a.sale_price::FLOAT / a.list_price) FILTER (WHERE a.sold AND a.list_price > 0) AS sale_to_list_ratio
+FROM active a
+GROUP BY GROUPING SETS ((a.month, a.zipcode), (a.month, a.city, a.state))
+HAVING
+  (GROUPING(a.zipcode) = 0 AND a.zipcode IS NOT NULL) OR
+  (GROUPING(a.zipcode) = 1 AND a.city IS NOT NULL AND a.state IS NOT NULL);
+
+CREATE UNIQUE INDEX market_stats_monthly_region_month_idx ON market_stats_monthly (region_type, region, month);
+
+-- This view compares the Redfin Estimate in effect when each listing cycle
+-- started with the price the listing eventually sold for. Only sold cycles
+-- with at least one estimate at or before the list date are included.
+CREATE OR REPLACE VIEW avm_accuracy AS
+SELECT
+  lc.property_id,
+  lc.listing_id,
+  lc.zipcode,
+  lc.list_ts,
+  lc.list_price,
+  lc.sale_ts,
+  lc.sale_price,
+  ae.estimate_ts,
+  ae.value AS estimate,
+  (ae.value - lc.sale_price)::FLOAT / lc.sale_price AS estimate_error,
+  lc.list_price::FLOAT / NULLIF(ae.value, 0) AS list_to_estimate,
+  lc.sale_price::FLOAT / NULLIF(lc.list_price, 0) AS sale_to_list
+FROM listing_cycle lc
+INNER JOIN LATERAL (
+  SELECT a.estimate_ts, a.value
+  FROM avm_estimate a
+  WHERE a.property_id = lc.property_id AND a.estimate_ts <= lc.list_ts
+  ORDER BY a.estimate_ts DESC
+  LIMIT 1
+) ae ON TRUE
+WHERE lc.sale_price IS NOT NULL;
+
+CREATE OR REPLACE VIEW last_rental_estimate AS
+SELECT DISTINCT ON (property_id, listing_id) property_id, listing_id, estimate_ts, rent, rent_low, rent_high
+FROM rental_estimate
+ORDER BY property_id, listing_id, estimate_ts DESC;
+
+-- This view returns the estimated gross rental yield (annual rent over price)
+-- for each property with a rental estimate. The price is taken from the most
+-- recent price event, so price_event indicates whether it's a list or sale
+-- price.
+CREATE OR REPLACE VIEW rental_yield AS
+SELECT
+  p.property_id,
+  p.listing_id,
+  p.url,
+  p.zipcode,
+  p.city,
+  p.state,
+  pe.price,
+  pe.event_description AS price_event,
+  pe.event_ts AS price_ts,
+  re.rent,
+  re.rent_low,
+  re.rent_high,
+  re.estimate_ts AS rent_estimate_ts,
+  (re.rent * 12)::FLOAT / pe.price AS gross_yield
+FROM property p
+INNER JOIN last_property_price_event pe ON
+  p.property_id = pe.property_id AND
+  p.listing_id = pe.listing_id
+INNER JOIN last_rental_estimate re ON
+  p.property_id = re.property_id AND
+  p.listing_id = re.listing_id;
+
+-- The baseline structure of each provider payload as (key path, type) pairs.
+-- Workers seed this from the first payload they see for an endpoint and
+-- compare every subsequent payload against it.
+CREATE TABLE payload_schema (
+  provider VARCHAR(32) NOT NULL,
+  endpoint VARCHAR(64) NOT NULL,
+  path TEXT NOT NULL,
+  value_type VARCHAR(16) NOT NULL,
+  PRIMARY KEY (provider, endpoint, path)
+);
+
+-- Differences between observed payloads and the baseline, aggregated by path
+-- and kind of change (new, missing, or retyped).
+CREATE TABLE payload_drift (
+  provider VARCHAR(32) NOT NULL,
+  endpoint VARCHAR(64) NOT NULL,
+  path TEXT NOT NULL,
+  change VARCHAR(16) NOT NULL,
+  baseline_type VARCHAR(16),
+  observed_type VARCHAR(16),
+  occurrences INT NOT NULL DEFAULT 1,
+  first_seen_ts TIMESTAMP NOT NULL DEFAULT NOW(),
+  last_seen_ts TIMESTAMP NOT NULL DEFAULT NOW(),
+  last_property_id INT,
+  last_listing_id INT,
+  PRIMARY KEY (provider, endpoint, path, change)
+);
+
+-- Index of archived raw payloads. The payloads themselves are stored in the
+-- object store under a content addressed key derived from their SHA-256 hash,
+-- so identical payloads are only stored once no matter how often they're
+-- scraped.
+CREATE TABLE payload_archive (
+  property_id INT NOT NULL,
+  listing_id INT NOT NULL,
+  endpoint VARCHAR(64) NOT NULL,
+  scrape_ts TIMESTAMP NOT NULL,
+  hash CHAR(64) NOT NULL,
+  PRIMARY KEY (property_id, listing_id, endpoint, scrape_ts),
+  FOREIGN KEY (property_id, listing_id) REFERENCES property (property_id, listing_id) ON DELETE CASCADE
+);
+
+CREATE INDEX payload_archive_hash_idx ON payload_archive (hash);
+CREATE INDEX payload_archive_scrape_ts_idx ON payload_archive (scrape_ts);
+
+-- Mirrored listing photos. Images are stored in the object store under a
+-- content addressed key derived from their SHA-256 hash, so a photo that
+-- appears on several listings (or under several CDN URLs) is only stored once.
+-- The average (ahash) and difference (dhash) hashes are 64 bit perceptual
+-- hashes for finding near-duplicate images.
+CREATE TABLE image (
+  hash CHAR(64) NOT NULL,
+  content_type VARCHAR(64) NOT NULL,
+  size_bytes INT NOT NULL,
+  width INT NOT NULL,
+  height INT NOT NULL,
+  ahash BIGINT NOT NULL,
+  dhash BIGINT NOT NULL,
+  created_ts TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (hash)
+);
+
+-- Maps the photo and thumbnail URLs scraped for each listing to the mirrored
+-- image. Rows are only written once an image is mirrored; the mirroring
+-- itself (and retrying failed downloads) is done by "image" jobs.
+CREATE TABLE listing_image (
+  property_id INT NOT NULL,
+  listing_id INT NOT NULL,
+  source_url TEXT NOT NULL,
+  kind VARCHAR(16) NOT NULL,
+  position INT NOT NULL,
+  hash CHAR(64) NOT NULL,
+  mirrored_ts TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (property_id, listing_id, source_url),
+  FOREIGN KEY (property_id, listing_id) REFERENCES property (property_id, listing_id) ON DELETE CASCADE,
+  FOREIGN KEY (hash) REFERENCES image (hash)
+);
+
+CREATE INDEX listing_image_source_url_idx ON listing_image (source_url);
+CREATE INDEX listing_image_hash_idx ON listing_image (hash);
+
+-- Generic work queue. The kind determines how workers interpret the payload
+-- (e.g., "search" or "property:redfin"). Jobs with a dedupe_key are unique per
+-- kind, so enqueueing the same work twice updates the existing job. Claims
+-- lease a job to a worker; if the lease expires before the job is completed or
+-- failed, the job can be claimed again. Failed jobs are retried with backoff
+-- until they run out of attempts. Jobs with repeat_seconds are requeued after
+-- they finish rather than being retired.
+CREATE TABLE job (
+  job_id BIGSERIAL NOT NULL,
+  kind VARCHAR(64) NOT NULL,
+  dedupe_key VARCHAR(256),
+  payload JSONB NOT NULL DEFAULT '{}'::JSONB,
+  status VARCHAR(16) NOT NULL DEFAULT 'queued',
+  priority INT NOT NULL DEFAULT 0,
+  attempts INT NOT NULL DEFAULT 0,
+  max_attempts INT NOT NULL DEFAULT 3,
+  run_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  repeat_seconds INT,
+  lease_owner VARCHAR(128),
+  lease_expires_ts TIMESTAMP,
+  last_error TEXT,
+  result JSONB,
+  created_ts TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_ts TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (job_id),
+  UNIQUE (kind, dedupe_key)
+);
+
+CREATE INDEX job_claim_idx ON job (kind, status, run_at);
+
+-- API keys for workers and other service clients. Only the SHA-256 hash of a
+-- key is stored; the key itself is returned once, when it's created or
+-- rotated. The prefix is the start of the key so that keys can be told apart
+-- in listings without revealing them. Keys grant the scopes of their role plus
+-- any additional scopes, like tokens do. Revoked and expired keys are
+-- rejected.
+CREATE TABLE api_key (
+  key_id BIGSERIAL NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  owner VARCHAR(255) NOT NULL,
+  prefix VARCHAR(16) NOT NULL,
+  key_hash BYTEA NOT NULL,
+  role VARCHAR(32) NOT NULL DEFAULT '',
+  scopes TEXT[] NOT NULL DEFAULT '{}',
+  expires_ts TIMESTAMP,
+  last_used_ts TIMESTAMP,
+  revoked_ts TIMESTAMP,
+  created_ts TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (key_id),
+  UNIQUE (key_hash)
+);
+
+-- Records who made each mutating request and how the server responded. The
+-- principal is the user ID of whoever made the request (the owner of the API
+-- key, the subject of the token, or the Firebase UID); the key_id is only set
+-- for requests made with an API key.
+CREATE TABLE audit_log (
+  audit_id BIGSERIAL NOT NULL,
+  key_id BIGINT,
+  principal VARCHAR(255) NOT NULL,
+  method VARCHAR(16) NOT NULL,
+  path TEXT NOT NULL,
+  status INT NOT NULL,
+  remote_addr VARCHAR(64) NOT NULL,
+  created_ts TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (audit_id),
+  FOREIGN KEY (key_id) REFERENCES api_key (key_id) ON DELETE SET NULL
+);
+
+CREATE INDEX audit_log_key_id_idx ON audit_log (key_id, created_ts);
+CREATE INDEX audit_log_created_ts_idx ON audit_log (created_ts);
@@ -1,22 +1,11 @@
 package server
 
 const ScrapeStatusGood = "good"
-const ScrapeStatusPending = "pending"
 const ScrapeStatusBad = "bad"
 
-func getValidStatuses() []string {
-	return []string{
-		ScrapeStatusGood,
-		ScrapeStatusPending,
-		ScrapeStatusBad,
-	}
-}
-
-func isValidStatus(v string) bool {
-	for _, s := range getValidStatuses() {
-		if v == s {
-			return true
-		}
-	}
-	return false
-}
+// Job statuses. Queued jobs are waiting to be claimed, running jobs are leased
+// to a worker, and dead jobs ran out of attempts without succeeding.
+const JobStatusQueued = "queued"
+const JobStatusRunning = "running"
+const JobStatusSucceeded = "succeeded"
+const JobStatusDead = "dead"
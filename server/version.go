@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Routes are served under the path prefix of the API version they belong to
+// (e.g., GET /v1/property). Before the API was versioned, routes were served
+// at the root; those paths are still served as aliases of the current version,
+// but they're deprecated and will be removed once their sunset has passed.
+// Routes added since then have no unversioned alias. Response shapes only
+// change in a new version, so clients on a versioned path aren't broken by
+// them. A few routes aren't versioned: OPTIONS, the JWKS (whose path is fixed
+// by RFC 8615), and the signed blob URLs, which are minted by the server
+// rather than built by clients.
+const apiPathPrefix = "/v1"
+
+// When the unversioned paths were deprecated.
+var unversionedDeprecated = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+// deprecation describes a deprecated route.
+type deprecation struct {
+	// When the route was deprecated.
+	Since time.Time
+	// When the route will stop being served; zero until that's decided.
+	Sunset time.Time
+	// Returns the URL of the route that replaces it for the request, if
+	// there's one.
+	Successor func(*http.Request) string
+}
+
+// Marks the responses of a deprecated route with the Deprecation (RFC 9745)
+// and Sunset (RFC 8594) headers and a link to its successor, and records the
+// client that made the request in the deprecation log. The principal is only
+// known once the authorizers have run, so the client is recorded after the
+// wrapped handler returns.
+func deprecate(dl *deprecationLog, route string, dep deprecation) handlerAdapter {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", dep.Since.Unix()))
+			if !dep.Sunset.IsZero() {
+				w.Header().Set("Sunset", dep.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if dep.Successor != nil {
+				w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, dep.Successor(r)))
+			}
+			next(w, r)
+			dl.record(route, r, w.Header().Get(requestIDHeader))
+		}
+	}
+}
+
+// Returns the path of the request under the current API version.
+func versionedSuccessor(r *http.Request) string {
+	return apiPathPrefix + r.URL.Path
+}
+
+// Interval at which the use of a deprecated route by a client is logged.
+const deprecationLogInterval = time.Hour
+
+// deprecationLog logs which clients are still using deprecated routes. A
+// client's first request to a route is logged, and after that at most one
+// line per interval with the number of requests since the last one, so the
+// log shows who has yet to migrate without a line per request. Clients are
+// told apart by principal (see rateLimitKey) and user agent, which carries
+// the app version of our own clients.
+type deprecationLog struct {
+	l         *slog.Logger
+	mu        sync.Mutex
+	uses      map[deprecationUseKey]*deprecationUse
+	lastSweep time.Time
+}
+
+type deprecationUseKey struct {
+	route     string
+	client    string
+	userAgent string
+}
+
+type deprecationUse struct {
+	count  int
+	logged time.Time
+}
+
+func newDeprecationLog(l *slog.Logger) *deprecationLog {
+	return &deprecationLog{l: l, uses: map[deprecationUseKey]*deprecationUse{}, lastSweep: time.Now()}
+}
+
+func (dl *deprecationLog) record(route string, r *http.Request, requestID string) {
+	client := "anonymous"
+	if p, ok := PrincipalFromContext(r.Context()); ok {
+		client = rateLimitKey(p)
+	}
+	k := deprecationUseKey{route: route, client: client, userAgent: r.UserAgent()}
+
+	dl.mu.Lock()
+	now := time.Now()
+	dl.sweep(now)
+	u, ok := dl.uses[k]
+	if !ok {
+		u = &deprecationUse{}
+		dl.uses[k] = u
+	}
+	u.count++
+	count := u.count
+	if ok && now.Sub(u.logged) < deprecationLogInterval {
+		dl.mu.Unlock()
+		return
+	}
+	u.count = 0
+	u.logged = now
+	dl.mu.Unlock()
+
+	dl.l.Warn(
+		"deprecated route used",
+		"route", route,
+		"client", client,
+		"user_agent", k.userAgent,
+		"requests", count,
+		"request_id", requestID,
+	)
+}
+
+// Drops clients that haven't used their route since it was last logged. This
+// runs at most once per interval.
+func (dl *deprecationLog) sweep(now time.Time) {
+	if now.Sub(dl.lastSweep) < deprecationLogInterval {
+		return
+	}
+	dl.lastSweep = now
+	for k, u := range dl.uses {
+		if u.count == 0 && now.Sub(u.logged) >= deprecationLogInterval {
+			delete(dl.uses, k)
+		}
+	}
+}
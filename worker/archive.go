@@ -0,0 +1,336 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brojonat/gredfin/server"
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// The number of archive entries fetched per request when reprocessing.
+const payloadArchivePageSize = 1000
+
+// Archive the raw payloads from a scrape. Payloads are content addressed, so
+// each one is only uploaded if the server doesn't already have it, but an
+// archive entry is recorded for every payload so the scrape can be replayed.
+// Payloads are identified by their basename without the extension (e.g.,
+// "mls_info"). Entries are only recorded for payloads whose upload succeeded
+// (or that were already stored); returns the hashes of the payloads that were
+// archived by basename, which is empty if recording the entries failed.
+func archivePayloads(end string, h http.Header, l *slog.Logger, p *dbgen.Property, payloads map[string][]byte, scrapeTS time.Time) (map[string]string, error) {
+	basenames := []string{}
+	for basename := range payloads {
+		basenames = append(basenames, basename)
+	}
+	sort.Strings(basenames)
+
+	var errs []error
+	hashes := map[string]string{}
+	entries := []dbgen.CreatePayloadArchiveEntryParams{}
+	for _, basename := range basenames {
+		b := payloads[basename]
+		hash := hashBytes(b)
+		if err := uploadPayload(end, h, hash, b); err != nil {
+			errs = append(errs, fmt.Errorf("error uploading %s bytes: %w", basename, err))
+			continue
+		}
+		hashes[basename] = hash
+		entries = append(entries, dbgen.CreatePayloadArchiveEntryParams{
+			PropertyID: p.PropertyID,
+			ListingID:  p.ListingID,
+			Endpoint:   strings.TrimSuffix(basename, ".json"),
+			ScrapeTS:   pgtype.Timestamp{Time: scrapeTS, Valid: true},
+			Hash:       hash,
+		})
+	}
+	if len(entries) == 0 {
+		return hashes, errors.Join(errs...)
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return map[string]string{}, fmt.Errorf("error serializing archive entries (property_id: %d, listing_id: %d): %w", p.PropertyID, p.ListingID, err)
+	}
+	if err = createPayloadArchiveEntries(end, h, b); err != nil {
+		return map[string]string{}, err
+	}
+	return hashes, errors.Join(errs...)
+}
+
+// Upload a payload to the archive unless it's already there.
+func uploadPayload(end string, h http.Header, hash string, b []byte) error {
+	pr, err := presignPayload(end, h, hash)
+	if err != nil {
+		return err
+	}
+	if pr.Archived {
+		return nil
+	}
+	return putPresigned(pr.URL, b)
+}
+
+// PUT the bytes to a presigned upload URL.
+func putPresigned(u string, b []byte) error {
+	req, err := http.NewRequest(
+		http.MethodPut,
+		u,
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf(res.Status)
+	}
+	return nil
+}
+
+// Re-runs extraction over the archived Redfin payloads matching the supplied
+// property (zero values match all properties) and scrape time range, and
+// uploads the results as if the payloads had just been scraped. Scrapes are
+// replayed in chronological order, so the latest scrape wins. This makes it
+// possible to backfill history after fixing an extraction bug without
+// scraping Redfin again.
+func ReprocessArchivedPayloads(
+	ctx context.Context,
+	l *slog.Logger,
+	end string,
+	authToken string,
+	ec *ExtractionConfig,
+	pid int32,
+	lid int32,
+	ts time.Time,
+	te time.Time,
+) error {
+	h := server.GetDefaultServerHeaders(authToken)
+
+	// Collect all the matching entries up front so scrapes that straddle a
+	// page boundary are kept together.
+	entries := []dbgen.PayloadArchive{}
+	for offset := 0; ; offset += payloadArchivePageSize {
+		page, err := getPayloadArchiveEntries(end, h, pid, lid, ts, te, offset)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, page...)
+		if len(page) < payloadArchivePageSize {
+			break
+		}
+	}
+
+	// group the entries by scrape
+	type scrapeKey struct {
+		pid int32
+		lid int32
+		ts  time.Time
+	}
+	scrapes := map[scrapeKey]map[string]string{}
+	keys := []scrapeKey{}
+	for _, e := range entries {
+		k := scrapeKey{e.PropertyID, e.ListingID, e.ScrapeTS.Time}
+		if _, ok := scrapes[k]; !ok {
+			scrapes[k] = map[string]string{}
+			keys = append(keys, k)
+		}
+		scrapes[k][e.Endpoint] = e.Hash
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].ts.Before(keys[j].ts) })
+	l.Info("reprocessing archived scrapes", "entries", len(entries), "scrapes", len(keys))
+
+	nerr := 0
+	for _, k := range keys {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		p := &dbgen.Property{PropertyID: k.pid, ListingID: k.lid}
+		if err := reprocessScrape(end, h, l, ec, p, scrapes[k], k.ts); err != nil {
+			logPropertyError(l, "error reprocessing scrape", err, p)
+			nerr += 1
+		}
+	}
+	l.Info("reprocessed archived scrapes", "error", nerr, "success", len(keys)-nerr)
+	if nerr > 0 {
+		return fmt.Errorf("reprocessing failed for %d / %d scrapes", nerr, len(keys))
+	}
+	return nil
+}
+
+func reprocessScrape(end string, h http.Header, l *slog.Logger, ec *ExtractionConfig, p *dbgen.Property, hashes map[string]string, scrapeTS time.Time) error {
+	payloads := map[string][]byte{}
+	for _, endpoint := range []string{"initial_info", "mls_info", "avm_info"} {
+		hash, ok := hashes[endpoint]
+		if !ok {
+			return fmt.Errorf("scrape at %s is missing the %s payload", scrapeTS.Format(time.RFC3339), endpoint)
+		}
+		b, err := getArchivedPayload(end, h, hash)
+		if err != nil {
+			return fmt.Errorf("error getting archived %s payload: %w", endpoint, err)
+		}
+		payloads[endpoint] = b
+	}
+	d, err := parseRedfinPayloads(ec, payloads["initial_info"], payloads["mls_info"], payloads["avm_info"])
+	if err != nil {
+		return err
+	}
+	// the estimate was current as of the scrape, not now
+	if d.Estimate != nil {
+		d.Estimate.TS = scrapeTS
+	}
+	return handleListingDetails(end, h, l, p, d)
+}
+
+func presignPayload(end string, h http.Header, hash string) (*server.PresignPayloadResponse, error) {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/v1/payload-archive/presign", end),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	q.Add("hash", hash)
+	req.URL.RawQuery = q.Encode()
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		var data server.DefaultJSONResponse
+		json.Unmarshal(b, &data)
+		return nil, fmt.Errorf("unexpected response code for POST /payload-archive/presign: %s (%s)", res.Status, data.Error)
+	}
+	var pr server.PresignPayloadResponse
+	if err = json.Unmarshal(b, &pr); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+func createPayloadArchiveEntries(end string, h http.Header, b []byte) error {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/v1/payload-archive", end),
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err = io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	var data server.DefaultJSONResponse
+	if err = json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response code for POST /payload-archive: %s (%s)", res.Status, data.Error)
+	}
+	return nil
+}
+
+// GET a page of archive entries. Returns an empty page if there are no more.
+func getPayloadArchiveEntries(end string, h http.Header, pid, lid int32, ts, te time.Time, offset int) ([]dbgen.PayloadArchive, error) {
+	req, err := http.NewRequest(
+		http.MethodGet,
+		fmt.Sprintf("%s/v1/payload-archive", end),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	if pid != 0 {
+		q.Add("property_id", strconv.Itoa(int(pid)))
+	}
+	if lid != 0 {
+		q.Add("listing_id", strconv.Itoa(int(lid)))
+	}
+	q.Add("start", ts.Format(time.RFC3339))
+	q.Add("end", te.Format(time.RFC3339))
+	q.Add("limit", strconv.Itoa(payloadArchivePageSize))
+	q.Add("offset", strconv.Itoa(offset))
+	req.URL.RawQuery = q.Encode()
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return []dbgen.PayloadArchive{}, nil
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		var data server.DefaultJSONResponse
+		json.Unmarshal(b, &data)
+		return nil, fmt.Errorf("unexpected response code for GET /payload-archive: %s (%s)", res.Status, data.Error)
+	}
+	var es []dbgen.PayloadArchive
+	if err = json.Unmarshal(b, &es); err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+func getArchivedPayload(end string, h http.Header, hash string) ([]byte, error) {
+	req, err := http.NewRequest(
+		http.MethodGet,
+		fmt.Sprintf("%s/v1/payload-archive/object", end),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	q.Add("hash", hash)
+	req.URL.RawQuery = q.Encode()
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		var data server.DefaultJSONResponse
+		json.Unmarshal(b, &data)
+		return nil, fmt.Errorf("unexpected response code for GET /payload-archive/object: %s (%s)", res.Status, data.Error)
+	}
+	return b, nil
+}
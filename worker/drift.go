@@ -0,0 +1,327 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brojonat/gredfin/server"
+	"github.com/brojonat/gredfin/server/db/dbgen"
+)
+
+// How long a worker caches a payload baseline before fetching it again. This
+// bounds how long it takes workers to pick up an accepted baseline change.
+const payloadBaselineTTL = time.Hour
+
+// JSON value types recorded in payload fingerprints.
+const jsonTypeObject = "object"
+const jsonTypeArray = "array"
+const jsonTypeString = "string"
+const jsonTypeNumber = "number"
+const jsonTypeBoolean = "boolean"
+const jsonTypeNull = "null"
+const jsonTypeMixed = "mixed"
+
+// Returns the structure of a JSON payload as a map of key paths to value
+// types. Array elements are collapsed into a single "[]" path segment and
+// numeric object keys (which Redfin uses for ids) into "*", so the fingerprint
+// reflects the shape of the payload rather than its contents.
+func fingerprintPayload(b []byte) (map[string]string, error) {
+	var data interface{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("error parsing payload: %w", err)
+	}
+	fp := map[string]string{}
+	fingerprintValue("", data, fp)
+	return fp, nil
+}
+
+func fingerprintValue(path string, v interface{}, fp map[string]string) {
+	var t string
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		t = jsonTypeObject
+		for k, e := range tv {
+			if isNumericKey(k) {
+				k = "*"
+			}
+			fingerprintValue(joinPayloadPath(path, k), e, fp)
+		}
+	case []interface{}:
+		t = jsonTypeArray
+		for _, e := range tv {
+			fingerprintValue(path+"[]", e, fp)
+		}
+	case string:
+		t = jsonTypeString
+	case float64:
+		t = jsonTypeNumber
+	case bool:
+		t = jsonTypeBoolean
+	default:
+		t = jsonTypeNull
+	}
+	if path == "" {
+		return
+	}
+	// Collapsed paths can be observed more than once. Nulls don't tell us
+	// anything about the type, and conflicting types are recorded as mixed.
+	switch prev, ok := fp[path]; {
+	case !ok || prev == jsonTypeNull:
+		fp[path] = t
+	case t != jsonTypeNull && t != prev:
+		fp[path] = jsonTypeMixed
+	}
+}
+
+func isNumericKey(k string) bool {
+	if k == "" {
+		return false
+	}
+	for _, r := range k {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func joinPayloadPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// Returns the parent of a payload path, or "" for top level paths.
+func parentPayloadPath(path string) string {
+	if strings.HasSuffix(path, "[]") {
+		return strings.TrimSuffix(path, "[]")
+	}
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+// Compares an observed fingerprint against the baseline. Null is compatible
+// with any type since optional values are frequently null. To keep the noise
+// down, a path is only reported missing if its parent was observed (i.e., only
+// the top of a missing subtree is reported) and empty arrays aren't reported
+// at all.
+func diffPayloadFingerprint(baseline, observed map[string]string) []server.PayloadChange {
+	changes := []server.PayloadChange{}
+	for path, ot := range observed {
+		bt, ok := baseline[path]
+		if !ok {
+			changes = append(changes, server.PayloadChange{
+				Path: path, Change: server.PayloadChangeNew, ObservedType: ot})
+			continue
+		}
+		if bt != ot && bt != jsonTypeNull && ot != jsonTypeNull {
+			changes = append(changes, server.PayloadChange{
+				Path: path, Change: server.PayloadChangeRetyped, BaselineType: bt, ObservedType: ot})
+		}
+	}
+	for path, bt := range baseline {
+		if _, ok := observed[path]; ok || strings.HasSuffix(path, "[]") {
+			continue
+		}
+		if parent := parentPayloadPath(path); parent != "" {
+			if pt, ok := observed[parent]; !ok || pt == jsonTypeNull {
+				continue
+			}
+		}
+		changes = append(changes, server.PayloadChange{
+			Path: path, Change: server.PayloadChangeMissing, BaselineType: bt})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+type payloadBaseline struct {
+	paths     map[string]string
+	fetchedAt time.Time
+}
+
+// Detects drift in provider payloads. Baselines are fetched from the server
+// and cached; if the server doesn't have a baseline for an endpoint yet, the
+// first observed payload is uploaded as the baseline. Safe for concurrent use.
+type driftDetector struct {
+	end       string
+	h         http.Header
+	mu        sync.Mutex
+	baselines map[string]payloadBaseline
+}
+
+func newDriftDetector(end string, h http.Header) *driftDetector {
+	return &driftDetector{end: end, h: h, baselines: map[string]payloadBaseline{}}
+}
+
+// Fingerprints the payload, compares it against the baseline for the
+// endpoint, and reports any changes to the server. Returns the changes.
+func (dd *driftDetector) Check(l *slog.Logger, prov, endpoint string, p *dbgen.Property, b []byte) ([]server.PayloadChange, error) {
+	observed, err := fingerprintPayload(b)
+	if err != nil {
+		return nil, err
+	}
+	baseline, err := dd.baseline(prov, endpoint, observed)
+	if err != nil {
+		return nil, err
+	}
+	changes := diffPayloadFingerprint(baseline, observed)
+	if len(changes) == 0 {
+		return changes, nil
+	}
+	l.Warn("payload drift detected", "provider", prov, "endpoint", endpoint, "changes", len(changes), "property_id", p.PropertyID, "listing_id", p.ListingID)
+	body, err := json.Marshal(server.PostPayloadDriftBody{
+		Provider:   prov,
+		Endpoint:   endpoint,
+		PropertyID: p.PropertyID,
+		ListingID:  p.ListingID,
+		Changes:    changes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error serializing payload drift: %w", err)
+	}
+	if err = createPayloadDrift(dd.end, dd.h, body); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Returns the cached baseline for the endpoint, fetching it from the server if
+// it's stale. If the server doesn't have one, the observed fingerprint is
+// uploaded and used as the baseline.
+func (dd *driftDetector) baseline(prov, endpoint string, observed map[string]string) (map[string]string, error) {
+	key := prov + "/" + endpoint
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	if pb, ok := dd.baselines[key]; ok && time.Since(pb.fetchedAt) < payloadBaselineTTL {
+		return pb.paths, nil
+	}
+	paths, err := getPayloadSchema(dd.end, dd.h, prov, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if paths == nil {
+		b, err := json.Marshal(server.PutPayloadSchemaBody{Provider: prov, Endpoint: endpoint, Paths: observed})
+		if err != nil {
+			return nil, fmt.Errorf("error serializing payload schema: %w", err)
+		}
+		if err = putPayloadSchema(dd.end, dd.h, b); err != nil {
+			return nil, err
+		}
+		paths = observed
+	}
+	dd.baselines[key] = payloadBaseline{paths: paths, fetchedAt: time.Now()}
+	return paths, nil
+}
+
+// GET the baseline for a payload endpoint. Returns nil if there isn't one.
+func getPayloadSchema(end string, h http.Header, prov, endpoint string) (map[string]string, error) {
+	req, err := http.NewRequest(
+		http.MethodGet,
+		fmt.Sprintf("%s/v1/payload-schema", end),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	q.Add("provider", prov)
+	q.Add("endpoint", endpoint)
+	req.URL.RawQuery = q.Encode()
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		var data server.DefaultJSONResponse
+		json.Unmarshal(b, &data)
+		return nil, fmt.Errorf("unexpected response code for GET /payload-schema: %s (%s)", res.Status, data.Error)
+	}
+	var ps []dbgen.PayloadSchema
+	if err = json.Unmarshal(b, &ps); err != nil {
+		return nil, err
+	}
+	paths := map[string]string{}
+	for _, p := range ps {
+		paths[p.Path] = p.ValueType
+	}
+	return paths, nil
+}
+
+func putPayloadSchema(end string, h http.Header, b []byte) error {
+	req, err := http.NewRequest(
+		http.MethodPut,
+		fmt.Sprintf("%s/v1/payload-schema", end),
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err = io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	var data server.DefaultJSONResponse
+	if err = json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response code for PUT /payload-schema: %s (%s)", res.Status, data.Error)
+	}
+	return nil
+}
+
+func createPayloadDrift(end string, h http.Header, b []byte) error {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/v1/payload-drift", end),
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err = io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	var data server.DefaultJSONResponse
+	if err = json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response code for POST /payload-drift: %s (%s)", res.Status, data.Error)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package worker
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/jmespath/go-jmespath"
+	"gopkg.in/yaml.v3"
+)
+
+// The version of the extraction config format this worker understands. Bump
+// this when the format (not the field expressions) changes incompatibly.
+const ExtractionConfigVersion = 1
+
+// Coercion rules for extracted values. Numbers are accepted as JSON numbers or
+// numeric strings since Redfin isn't consistent about it.
+const FieldTypeString = "string"          // string
+const FieldTypeInt = "int"                // int32 (rounded)
+const FieldTypeFloat = "float"            // float64
+const FieldTypeStringList = "string_list" // []string
+const FieldTypeObjectList = "object_list" // []map[string]interface{}
+
+// The payloads and fields the worker reads, and the type it expects for each.
+// A config must define every one of these (and nothing else) with a matching
+// type, so callers can type assert extracted values without checking.
+var extractionFields = map[string]map[string]string{
+	"initial_info": {
+		"property_id": FieldTypeInt,
+		"listing_id":  FieldTypeInt,
+		"latitude":    FieldTypeFloat,
+		"longitude":   FieldTypeFloat,
+	},
+	"mls_info": {
+		"zipcode":        FieldTypeString,
+		"city":           FieldTypeString,
+		"state":          FieldTypeString,
+		"beds":           FieldTypeFloat,
+		"baths":          FieldTypeFloat,
+		"sqft":           FieldTypeInt,
+		"lot_sqft":       FieldTypeInt,
+		"year_built":     FieldTypeInt,
+		"property_type":  FieldTypeString,
+		"events":         FieldTypeObjectList,
+		"image_urls":     FieldTypeStringList,
+		"thumbnail_urls": FieldTypeStringList,
+		"realtor":        FieldTypeString,
+	},
+	"avm_info": {
+		"value":      FieldTypeInt,
+		"value_low":  FieldTypeInt,
+		"value_high": FieldTypeInt,
+	},
+	"rental_info": {
+		"rent":      FieldTypeInt,
+		"rent_low":  FieldTypeInt,
+		"rent_high": FieldTypeInt,
+	},
+}
+
+//go:embed extraction.yaml
+var defaultExtractionConfig []byte
+
+// FieldSpec describes how to extract a single field from a payload. The
+// fallbacks are tried in order if the expression doesn't produce a value.
+type FieldSpec struct {
+	Expression string   `yaml:"expression" json:"expression"`
+	Type       string   `yaml:"type" json:"type"`
+	Required   bool     `yaml:"required" json:"required"`
+	Fallbacks  []string `yaml:"fallbacks,omitempty" json:"fallbacks,omitempty"`
+	compiled   []*jmespath.JMESPath
+}
+
+// ExtractionConfig is a versioned mapping from payload fields to jmespath
+// expressions. Payloads are keyed by the basename of the archived payload
+// (without the extension), e.g., "mls_info".
+type ExtractionConfig struct {
+	Version  int                             `yaml:"version" json:"version"`
+	Revision string                          `yaml:"revision" json:"revision"`
+	Payloads map[string]map[string]FieldSpec `yaml:"payloads" json:"payloads"`
+}
+
+// Loads the extraction config from a YAML or JSON file. If path is empty, the
+// config embedded in the binary is used.
+func LoadExtractionConfig(path string) (*ExtractionConfig, error) {
+	b := defaultExtractionConfig
+	if path != "" {
+		var err error
+		b, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading extraction config: %w", err)
+		}
+	}
+	return ParseExtractionConfig(b)
+}
+
+// Parses and validates an extraction config. YAML is a superset of JSON, so
+// this accepts either.
+func ParseExtractionConfig(b []byte) (*ExtractionConfig, error) {
+	var ec ExtractionConfig
+	if err := yaml.Unmarshal(b, &ec); err != nil {
+		return nil, fmt.Errorf("error parsing extraction config: %w", err)
+	}
+	if ec.Version != ExtractionConfigVersion {
+		return nil, fmt.Errorf("unsupported extraction config version: %d (expected %d)", ec.Version, ExtractionConfigVersion)
+	}
+	for payload, fields := range ec.Payloads {
+		if _, ok := extractionFields[payload]; !ok {
+			return nil, fmt.Errorf("unsupported payload: %s", payload)
+		}
+		for field, spec := range fields {
+			if _, ok := extractionFields[payload][field]; !ok {
+				return nil, fmt.Errorf("unsupported field: %s.%s", payload, field)
+			}
+			for _, expr := range append([]string{spec.Expression}, spec.Fallbacks...) {
+				jp, err := jmespath.Compile(expr)
+				if err != nil {
+					return nil, fmt.Errorf("bad expression for %s.%s (%s): %w", payload, field, expr, err)
+				}
+				spec.compiled = append(spec.compiled, jp)
+			}
+			fields[field] = spec
+		}
+	}
+	for payload, fields := range extractionFields {
+		for field, t := range fields {
+			spec, ok := ec.Payloads[payload][field]
+			if !ok {
+				return nil, fmt.Errorf("missing field: %s.%s", payload, field)
+			}
+			if spec.Type != t {
+				return nil, fmt.Errorf("bad type for %s.%s: %s (expected %s)", payload, field, spec.Type, t)
+			}
+		}
+	}
+	return &ec, nil
+}
+
+// Extract a field from the payload data. Callers pass in just the payload
+// object from the response body. The expression and then each fallback are
+// tried in order; the first one to produce a value wins. If none do, this
+// returns an error for required fields and nil otherwise.
+func (ec *ExtractionConfig) Extract(payload, field string, data interface{}) (interface{}, error) {
+	spec, ok := ec.Payloads[payload][field]
+	if !ok {
+		return nil, fmt.Errorf("unsupported field: %s.%s", payload, field)
+	}
+	for _, jp := range spec.compiled {
+		v, err := jp.Search(data)
+		if err != nil || v == nil {
+			continue
+		}
+		cv, err := coerceField(spec.Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("error coercing %s.%s: %w", payload, field, err)
+		}
+		return cv, nil
+	}
+	if spec.Required {
+		return nil, fmt.Errorf("null result extracting %s.%s", payload, field)
+	}
+	return nil, nil
+}
+
+// Extract every field of the payload and return the errors, if any. This is
+// used to validate a config against recorded payloads.
+func (ec *ExtractionConfig) Check(payload string, data interface{}) []error {
+	fields := []string{}
+	for field := range ec.Payloads[payload] {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	errs := []error{}
+	for _, field := range fields {
+		if _, err := ec.Extract(payload, field, data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func coerceField(t string, v interface{}) (interface{}, error) {
+	switch t {
+	case FieldTypeString:
+		switch tv := v.(type) {
+		case string:
+			return tv, nil
+		case float64:
+			return strconv.FormatFloat(tv, 'f', -1, 64), nil
+		}
+	case FieldTypeInt:
+		if f, ok := jmesNumber(v); ok {
+			return int32(math.Round(f)), nil
+		}
+	case FieldTypeFloat:
+		if f, ok := jmesNumber(v); ok {
+			return f, nil
+		}
+	case FieldTypeStringList:
+		vs, ok := v.([]interface{})
+		if !ok {
+			break
+		}
+		ss := []string{}
+		for _, e := range vs {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("could not coerce %T element to string", e)
+			}
+			ss = append(ss, s)
+		}
+		return ss, nil
+	case FieldTypeObjectList:
+		vs, ok := v.([]interface{})
+		if !ok {
+			break
+		}
+		ms := []map[string]interface{}{}
+		for _, e := range vs {
+			m, ok := e.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("could not coerce %T element to object", e)
+			}
+			ms = append(ms, m)
+		}
+		return ms, nil
+	default:
+		return nil, fmt.Errorf("unsupported type: %s", t)
+	}
+	return nil, fmt.Errorf("could not coerce %T to %s", v, t)
+}
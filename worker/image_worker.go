@@ -0,0 +1,197 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/brojonat/gredfin/server"
+	"github.com/brojonat/gredfin/server/db/dbgen"
+	_ "golang.org/x/image/webp"
+)
+
+// Images larger than this aren't mirrored. Decoding allocates memory for every
+// pixel, so images are also capped by their dimensions, which are read from
+// the header before the image is decoded.
+const maxImageBytes = 20 << 20
+const maxImagePixels = 50_000_000
+
+// Client for downloading images from the provider's CDN.
+var imageHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Image jobs download one image, so they get a short lease.
+const imageJobLease = 2 * time.Minute
+
+// Default implementation of an image mirroring worker. The worker claims image
+// jobs from the server and runs them with ImageJobHandler.
+func MakeImageMirrorWorkerFunc(
+	end string,
+	authToken string,
+) func(context.Context, *slog.Logger) {
+	return MakeJobWorkerFunc(end, authToken, imageJobLease, ImageJobHandler(end, authToken))
+}
+
+// Returns a JobHandler that mirrors the scraped listing image of each image
+// job. The image is downloaded, its dimensions and perceptual hashes are
+// recorded, and it's uploaded to the object store (unless an identical image
+// is already stored). Failed downloads are retried by the job queue.
+func ImageJobHandler(end string, authToken string) JobHandler {
+	return HandleJob(server.JobKindImage, func(ctx context.Context, l *slog.Logger, j server.ImageJobPayload) (any, error) {
+		h := server.GetDefaultServerHeaders(authToken)
+		ci, err := mirrorImage(ctx, end, h, j.SourceURL)
+		if err != nil {
+			return nil, fmt.Errorf("error mirroring image %s: %w", j.SourceURL, err)
+		}
+		err = createListingImage(end, h, server.PostListingImageBody{
+			PropertyID: j.PropertyID,
+			ListingID:  j.ListingID,
+			SourceURL:  j.SourceURL,
+			Kind:       j.Kind,
+			Position:   j.Position,
+			Image:      *ci,
+		})
+		if err != nil {
+			return nil, err
+		}
+		l.Info("mirrored listing image", "property_id", j.PropertyID, "listing_id", j.ListingID, "hash", ci.Hash)
+		return nil, nil
+	})
+}
+
+// Downloads the image at u, uploads it to the object store if it isn't there
+// already, and returns its metadata. Images that are too large to decode are
+// rejected with ErrDropJob, since retrying them can't succeed.
+func mirrorImage(ctx context.Context, end string, h http.Header, u string) (*dbgen.CreateImageParams, error) {
+	b, err := downloadImage(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("image dimensions %dx%d exceed %d pixels: %w", cfg.Width, cfg.Height, maxImagePixels, ErrDropJob)
+	}
+	img, format, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %w", err)
+	}
+	ci := &dbgen.CreateImageParams{
+		Hash:        hashBytes(b),
+		ContentType: "image/" + format,
+		SizeBytes:   int32(len(b)),
+		Width:       int32(img.Bounds().Dx()),
+		Height:      int32(img.Bounds().Dy()),
+		AHash:       int64(averageHash(img)),
+		DHash:       int64(differenceHash(img)),
+	}
+	pr, err := presignImage(end, h, ci.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if !pr.Stored {
+		if err = putPresigned(pr.URL, b); err != nil {
+			return nil, fmt.Errorf("error uploading image: %w", err)
+		}
+	}
+	return ci, nil
+}
+
+func downloadImage(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := imageHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response code for GET %s: %s", u, res.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxImageBytes {
+		return nil, fmt.Errorf("image larger than %d bytes", maxImageBytes)
+	}
+	return b, nil
+}
+
+func presignImage(end string, h http.Header, hash string) (*server.PresignImageResponse, error) {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/v1/image/presign", end),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	q.Add("hash", hash)
+	req.URL.RawQuery = q.Encode()
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		var data server.DefaultJSONResponse
+		json.Unmarshal(b, &data)
+		return nil, fmt.Errorf("unexpected response code for POST /image/presign: %s (%s)", res.Status, data.Error)
+	}
+	var pr server.PresignImageResponse
+	if err = json.Unmarshal(b, &pr); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+// POST a mirrored listing image. Returns ErrDropJob if the listing no longer
+// exists.
+func createListingImage(end string, h http.Header, body server.PostListingImageBody) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/v1/listing-image", end),
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("listing %d/%d: %w", body.PropertyID, body.ListingID, ErrDropJob)
+	}
+	if res.StatusCode != http.StatusOK {
+		var data server.DefaultJSONResponse
+		json.NewDecoder(res.Body).Decode(&data)
+		return fmt.Errorf("unexpected response code for POST /listing-image: %s (%s)", res.Status, data.Error)
+	}
+	return nil
+}
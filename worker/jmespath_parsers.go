@@ -1,32 +1,22 @@
 package worker
 
 import (
-	"encoding/json"
 	"fmt"
 	"math"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/brojonat/gredfin/provider"
 	"github.com/jmespath/go-jmespath"
 )
 
-// NOTE: callers pass in just they payload object from the response body
-// parse the MLSInfo bytes and extract the realtor name and company
-func parseRealtorInfo(b []byte) (string, string, error) {
-	var data interface{}
-	if err := json.Unmarshal(b, &data); err != nil {
-		return "", "", fmt.Errorf("error parsing MLS data for realtor: %w", err)
-	}
-	result, err := jmespath.Search("propertyHistoryInfo.mediaBrowserInfoBySourceId.*.photoAttribution | [0]", data)
-	if err != nil {
-		return "", "", fmt.Errorf("error searching MLS data for realtor: %w", err)
-	}
-	if result == nil {
-		return "", "", fmt.Errorf("null result searching MLS data for realtor")
-	}
-	parts := strings.Split(result.(string), "•")
+// Parse the listing attribution extracted from the MLS payload into the
+// realtor name and company.
+func parseRealtorAttribution(s string) (string, string, error) {
+	parts := strings.Split(s, "•")
 	if len(parts) < 2 {
-		return "", "", fmt.Errorf("unexpected format for realtor name/company: `%s`", result.(string))
+		return "", "", fmt.Errorf("unexpected format for realtor name/company: `%s`", s)
 	}
 	// remove leading/trailing whitespace and an expected prefix
 	name := strings.ReplaceAll(parts[0], "Listed by ", "")
@@ -38,135 +28,169 @@ func parseRealtorInfo(b []byte) (string, string, error) {
 	return name, company, nil
 }
 
-// NOTE: callers pass in just the payload object from the response body
-func jmesParseInitialInfoParams(p string, data interface{}) (interface{}, error) {
-	switch p {
-	case "property_id":
-		return jmespath.Search("propertyId", data)
-	case "listing_id":
-		return jmespath.Search("listingId", data)
-	case "latitude":
-		return jmespath.Search("latLong.latitude", data)
-	case "longitude":
-		return jmespath.Search("latLong.longitude", data)
-	default:
-		return "", fmt.Errorf("unsupported param: %s", p)
+// Convert the property history events extracted from the MLS payload. Missing
+// or mistyped values are left as zero values since they're unimportant.
+func parseHistoryEvents(ms []map[string]interface{}) []provider.Event {
+	events := []provider.Event{}
+	for _, m := range ms {
+		e := provider.Event{}
+		if v, ok := jmesNumber(m["price"]); ok {
+			e.Price = int32(math.Round(v))
+		}
+		if v, ok := m["description"].(string); ok {
+			e.Description = v
+		}
+		if v, ok := m["source"].(string); ok {
+			e.Source = v
+		}
+		if v, ok := m["source_id"].(string); ok {
+			e.SourceID = v
+		}
+		if v, ok := jmesNumber(m["ts"]); ok {
+			e.TS = time.Unix(0, int64(time.Millisecond)*int64(math.Round(v)))
+		}
+		events = append(events, e)
 	}
+	return events
 }
 
-// NOTE: callers pass in just the payload object from the response body
-func jmesParseMLSParams(p string, data interface{}) (interface{}, error) {
+// NOTE: callers pass in just the payload object from the response body. The
+// current estimate is extracted from the AVMDetails payload using the
+// extraction config, while "history" is extracted from the AVMHistorical
+// payload here.
+func jmesParseAVMParams(p string, data interface{}) (interface{}, error) {
 	switch p {
-	case "zipcode":
-		return jmespath.Search("publicRecordsInfo.addressInfo.zip", data)
-	case "city":
-		return jmespath.Search("publicRecordsInfo.addressInfo.city", data)
-	case "state":
-		return jmespath.Search("publicRecordsInfo.addressInfo.state", data)
-	case "price":
-		// first get the number of events
-		nevents, err := jmespath.Search("length(propertyHistoryInfo.events)", data)
-		if err != nil {
-			return nil, fmt.Errorf("could not parse events")
-		}
-		// iterate over the events and grab the first price entry
-		for i := range int(math.Round(nevents.(float64))) {
-			path := fmt.Sprintf("propertyHistoryInfo.events[%d].price", i)
-			res, err := jmespath.Search(path, data)
-			if err != nil {
-				return "", fmt.Errorf("error accessing property history events: %w", err)
-			}
-			if res != nil {
-				return res, nil
-			}
-		}
-		return nil, fmt.Errorf("no price found in property history events")
-	case "events":
-		events := []historyEvent{}
-		nevents, err := jmespath.Search("length(propertyHistoryInfo.events)", data)
+	case "history":
+		points := []provider.Estimate{}
+		npoints, err := jmespath.Search("length(propertyTimeSeries)", data)
 		if err != nil {
-			return nil, fmt.Errorf("could not parse events")
+			return nil, fmt.Errorf("could not parse avm history")
 		}
-		if nevents == nil {
-			return events, nil
+		if npoints == nil {
+			return points, nil
 		}
-		for i := range int(math.Round(nevents.(float64))) {
-			pricePath := fmt.Sprintf("propertyHistoryInfo.events[%d].price", i)
-			descPath := fmt.Sprintf("propertyHistoryInfo.events[%d].eventDescription", i)
-			srcPath := fmt.Sprintf("propertyHistoryInfo.events[%d].source", i)
-			srcIDPath := fmt.Sprintf("propertyHistoryInfo.events[%d].sourceId", i)
-			tsPath := fmt.Sprintf("propertyHistoryInfo.events[%d].eventDate", i)
+		for i := range int(math.Round(npoints.(float64))) {
+			valuePath := fmt.Sprintf("propertyTimeSeries[%d].value", i)
+			tsPath := fmt.Sprintf("propertyTimeSeries[%d].date", i)
 
-			// ignore errors here since they're unimportant
-			price, _ := jmespath.Search(pricePath, data)
-			desc, _ := jmespath.Search(descPath, data)
-			src, _ := jmespath.Search(srcPath, data)
-			srcID, _ := jmespath.Search(srcIDPath, data)
+			// skip points missing either field rather than failing the batch
+			value, _ := jmespath.Search(valuePath, data)
 			ts, _ := jmespath.Search(tsPath, data)
-			pe := historyEvent{}
-			if price != nil {
-				pe.Price = int32(math.Round(price.(float64)))
-			}
-			if desc != nil {
-				pe.EventDescription = desc.(string)
-			}
-			if src != nil {
-				pe.Source = src.(string)
-			}
-			if srcID != nil {
-				pe.SourceID = srcID.(string)
+			fvalue, ok := value.(float64)
+			if !ok {
+				continue
 			}
-			if ts != nil {
-				pe.EventTS = time.Unix(0, int64(time.Millisecond)*int64(math.Round(ts.(float64))))
+			fts, ok := ts.(float64)
+			if !ok {
+				continue
 			}
-			events = append(events, pe)
+			points = append(points, provider.Estimate{
+				Value: int32(math.Round(fvalue)),
+				TS:    time.Unix(0, int64(time.Millisecond)*int64(math.Round(fts))),
+			})
 		}
-		return events, nil
-	case "image_urls":
-		iface, err := jmespath.Search("propertyHistoryInfo.mediaBrowserInfoBySourceId.*.photos[].photoUrls.nonFullScreenPhotoUrlCompressed", data)
+		return points, nil
+	default:
+		return nil, fmt.Errorf("unsupported field: %s", p)
+	}
+}
+
+// NOTE: callers pass in just the payload object from the response body
+func jmesParseSimilarSoldParams(p string, data interface{}) (interface{}, error) {
+	switch p {
+	case "homes":
+		iface, err := jmespath.Search(
+			"homes[].homeData.{"+
+				"property_id: propertyId, "+
+				"listing_id: listingId, "+
+				"url: url, "+
+				"price: priceInfo.amount.amount, "+
+				"sale_ts: lastSaleData.lastSoldDate, "+
+				"beds: beds, "+
+				"baths: baths, "+
+				"sqft: sqftInfo.amount.value, "+
+				"latitude: addressInfo.centroid.centroid.latitude, "+
+				"longitude: addressInfo.centroid.centroid.longitude}",
+			data,
+		)
 		if err != nil {
 			return nil, err
 		}
+		homes := []provider.SimilarHome{}
 		ifaces, ok := iface.([]interface{})
 		if !ok {
-			return nil, fmt.Errorf("could not handle jmes return type for image_urls")
+			return homes, nil
 		}
-		urls := []string{}
 		for _, rv := range ifaces {
-			url, ok := rv.(string)
+			m, ok := rv.(map[string]interface{})
 			if !ok {
-				return nil, fmt.Errorf("could not handle jmes return type for image_urls element")
+				return nil, fmt.Errorf("could not handle jmes return type for homes element")
 			}
-			urls = append(urls, url)
-		}
-		return urls, nil
-	case "thumbnail_urls":
-		iface, err := jmespath.Search("propertyHistoryInfo.mediaBrowserInfoBySourceId.*.photos[].thumbnailData.thumbnailUrl", data)
-		if err != nil {
-			return nil, err
-		}
-		ifaces, ok := iface.([]interface{})
-		if !ok {
-			return nil, fmt.Errorf("could not handle jmes return type for thumbnail_urls")
-		}
-		urls := []string{}
-		for _, rv := range ifaces {
-			url, ok := rv.(string)
+			// homes without an id, price, or location are useless as comps
+			pid, ok := jmesNumber(m["property_id"])
+			if !ok {
+				continue
+			}
+			price, ok := jmesNumber(m["price"])
 			if !ok {
-				return nil, fmt.Errorf("could not handle jmes return type for thumbnail_urls element")
+				continue
 			}
-			urls = append(urls, url)
+			lat, ok := jmesNumber(m["latitude"])
+			if !ok {
+				continue
+			}
+			lon, ok := jmesNumber(m["longitude"])
+			if !ok {
+				continue
+			}
+			sh := provider.SimilarHome{
+				ListingRef: provider.ListingRef{
+					Provider:   provider.Redfin,
+					PropertyID: int32(math.Round(pid)),
+				},
+				Price:     int32(math.Round(price)),
+				Latitude:  lat,
+				Longitude: lon,
+			}
+			if v, ok := jmesNumber(m["listing_id"]); ok {
+				sh.ListingID = int32(math.Round(v))
+			}
+			sh.ExternalID = provider.RedfinExternalID(sh.PropertyID, sh.ListingID)
+			if v, ok := m["url"].(string); ok {
+				sh.URL = v
+			}
+			if v, ok := jmesNumber(m["sale_ts"]); ok {
+				t := time.Unix(0, int64(time.Millisecond)*int64(math.Round(v)))
+				sh.SaleTS = &t
+			}
+			if v, ok := jmesNumber(m["beds"]); ok {
+				sh.Beds = &v
+			}
+			if v, ok := jmesNumber(m["baths"]); ok {
+				sh.Baths = &v
+			}
+			if v, ok := jmesNumber(m["sqft"]); ok {
+				sqft := int32(math.Round(v))
+				sh.Sqft = &sqft
+			}
+			homes = append(homes, sh)
 		}
-		return urls, nil
+		return homes, nil
 	default:
 		return nil, fmt.Errorf("unsupported field: %s", p)
 	}
 }
 
-type historyEvent struct {
-	Price            int32
-	EventDescription string
-	Source           string
-	SourceID         string
-	EventTS          time.Time
+// Redfin is inconsistent about whether ids are numbers or strings, so this
+// accepts both.
+func jmesNumber(v interface{}) (float64, bool) {
+	switch tv := v.(type) {
+	case float64:
+		return tv, true
+	case string:
+		f, err := strconv.ParseFloat(tv, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
 }
@@ -0,0 +1,210 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/brojonat/gredfin/server"
+	"github.com/brojonat/gredfin/server/db/dbgen"
+)
+
+// ErrDropJob can be returned (or wrapped) by a job handler when a job no longer
+// applies, e.g., because the record it refers to was deleted. The job is
+// reported as failed with drop set, so the server deletes it from the queue
+// rather than retrying it.
+var ErrDropJob = errors.New("job no longer applies")
+
+// JobHandler runs the jobs of a single kind.
+type JobHandler interface {
+	Kind() string
+	Handle(ctx context.Context, l *slog.Logger, j dbgen.Job) (any, error)
+}
+
+type jobHandlerFunc[T any] struct {
+	kind string
+	f    func(context.Context, *slog.Logger, T) (any, error)
+}
+
+func (h jobHandlerFunc[T]) Kind() string {
+	return h.kind
+}
+
+func (h jobHandlerFunc[T]) Handle(ctx context.Context, l *slog.Logger, j dbgen.Job) (any, error) {
+	var payload T
+	if err := json.Unmarshal(j.Payload, &payload); err != nil {
+		return nil, fmt.Errorf("error deserializing %s job payload: %w", h.kind, err)
+	}
+	return h.f(ctx, l, payload)
+}
+
+// Returns a JobHandler for the supplied kind that decodes each job's payload
+// into a T before passing it to f. Whatever f returns is stored as the job's
+// result.
+func HandleJob[T any](kind string, f func(context.Context, *slog.Logger, T) (any, error)) JobHandler {
+	return jobHandlerFunc[T]{kind: kind, f: f}
+}
+
+// Identifies this process when leasing jobs.
+func jobLeaseOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Generic job worker. The worker claims a job of one of the kinds handled by
+// the supplied handlers, runs it with the matching handler, and reports the
+// outcome to the server. Jobs are leased for the supplied duration; a job that
+// isn't finished by then may be claimed by another worker.
+func MakeJobWorkerFunc(
+	end string,
+	authToken string,
+	lease time.Duration,
+	hs ...JobHandler,
+) func(context.Context, *slog.Logger) {
+	owner := jobLeaseOwner()
+	handlers := map[string]JobHandler{}
+	kinds := []string{}
+	for _, h := range hs {
+		handlers[h.Kind()] = h
+		kinds = append(kinds, h.Kind())
+	}
+	f := func(ctx context.Context, l *slog.Logger) {
+		h := server.GetDefaultServerHeaders(authToken)
+		js, err := claimJobs(end, h, owner, kinds, 1, lease)
+		if err != nil {
+			l.Error("error claiming job from server", "error", err.Error())
+			return
+		}
+		if len(js) == 0 {
+			l.Debug("no jobs to run", "kinds", kinds)
+			ReportIdle(ctx)
+			return
+		}
+		for _, j := range js {
+			jh, ok := handlers[j.Kind]
+			if !ok {
+				l.Error("claimed job of unhandled kind", "job_id", j.JobID, "kind", j.Kind)
+				continue
+			}
+			runJob(ctx, l, end, h, owner, jh, j)
+		}
+	}
+	return f
+}
+
+// Runs a claimed job and reports the outcome to the server.
+func runJob(ctx context.Context, l *slog.Logger, end string, h http.Header, owner string, jh JobHandler, j dbgen.Job) {
+	l = l.With("job_id", j.JobID, "kind", j.Kind, "attempt", j.Attempts)
+	l.Info("running job")
+	res, err := jh.Handle(ctx, l, j)
+	if errors.Is(err, ErrDropJob) {
+		l.Info("dropping job", "reason", err.Error())
+		body := server.FailJobBody{JobID: j.JobID, LeaseOwner: owner, Error: err.Error(), Drop: true}
+		if err = postJobOutcome(end, h, "fail", body); err != nil {
+			l.Error("error dropping job", "error", err.Error())
+		}
+		return
+	}
+	if err != nil {
+		l.Error("job failed", "error", err.Error())
+		body := server.FailJobBody{JobID: j.JobID, LeaseOwner: owner, Error: err.Error()}
+		if err = postJobOutcome(end, h, "fail", body); err != nil {
+			l.Error("error reporting job failure", "error", err.Error())
+		}
+		return
+	}
+	body := server.CompleteJobBody{JobID: j.JobID, LeaseOwner: owner}
+	if res != nil {
+		if body.Result, err = json.Marshal(res); err != nil {
+			l.Error("error serializing job result", "error", err.Error())
+		}
+	}
+	if err = postJobOutcome(end, h, "complete", body); err != nil {
+		l.Error("error reporting job completion", "error", err.Error())
+		return
+	}
+	l.Info("job succeeded")
+}
+
+// POST a request to lease jobs of the supplied kinds. Returns an empty slice if
+// there's no work to do.
+func claimJobs(end string, h http.Header, owner string, kinds []string, limit int, lease time.Duration) ([]dbgen.Job, error) {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/v1/job/claim", end),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	for _, k := range kinds {
+		q.Add("kind", k)
+	}
+	q.Add("owner", owner)
+	q.Add("limit", strconv.Itoa(limit))
+	q.Add("lease", lease.String())
+	req.URL.RawQuery = q.Encode()
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return []dbgen.Job{}, nil
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		var data server.DefaultJSONResponse
+		json.Unmarshal(b, &data)
+		return nil, fmt.Errorf("unexpected response code for POST /job/claim: %s (%s)", res.Status, data.Error)
+	}
+	var js []dbgen.Job
+	if err = json.Unmarshal(b, &js); err != nil {
+		return nil, err
+	}
+	return js, nil
+}
+
+// POST the outcome of a job to /job/complete or /job/fail.
+func postJobOutcome(end string, h http.Header, outcome string, body any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/v1/job/%s", end, outcome),
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var data server.DefaultJSONResponse
+		json.NewDecoder(res.Body).Decode(&data)
+		return fmt.Errorf("unexpected response code for POST /job/%s: %s (%s)", outcome, res.Status, data.Error)
+	}
+	return nil
+}
@@ -3,34 +3,173 @@ package worker
 import (
 	"context"
 	"log/slog"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+type idleKey struct{}
+
+// ReportIdle tells the pool running the worker function that there was no work
+// to do on this run (e.g., the queue was empty), so it can back off. It's a
+// no-op outside of RunWorkerFunc.
+func ReportIdle(ctx context.Context) {
+	if idle, ok := ctx.Value(idleKey{}).(*atomic.Bool); ok {
+		idle.Store(true)
+	}
+}
+
+// DefaultIdleBackoff is the default cap of WithIdleBackoff for registered
+// worker kinds: short enough that an idle pool picks up new work within
+// seconds, long enough to cut an idle worker's polling by an order of
+// magnitude at a 1s interval.
+const DefaultIdleBackoff = 10 * time.Second
+
+// PoolOption configures RunWorkerFunc.
+type PoolOption func(*poolConfig)
+
+type poolConfig struct {
+	concurrency  int
+	jitter       time.Duration
+	maxIdleDelay time.Duration
+	drainTimeout time.Duration
+}
+
+// Runs up to n copies of the worker function at once.
+func WithConcurrency(n int) PoolOption {
+	return func(c *poolConfig) { c.concurrency = n }
+}
+
+// Adds a random delay of up to d between runs so that workers started at the
+// same time don't hit the server in lockstep.
+func WithJitter(d time.Duration) PoolOption {
+	return func(c *poolConfig) { c.jitter = d }
+}
+
+// Doubles the delay between runs, up to d, while the worker function reports
+// that it's idle. The delay resets as soon as any run finds work, which wakes
+// the other backed off runs of the pool. Nothing outside the pool wakes them,
+// though, so when every run is idle (always the case with a concurrency of
+// 1), work that shows up waits for up to d before it's picked up. Keep d
+// short (see DefaultIdleBackoff) where that latency matters.
+func WithIdleBackoff(d time.Duration) PoolOption {
+	return func(c *poolConfig) { c.maxIdleDelay = d }
+}
+
+// Lets in-flight runs finish for up to d after the context is cancelled rather
+// than cancelling them immediately.
+func WithDrain(d time.Duration) PoolOption {
+	return func(c *poolConfig) { c.drainTimeout = d }
+}
+
 // RunWorkerFunc is a general purpose entry point for running cancelable
 // periodic worker functions on some interval. Callers simply supply an interval
-// and their worker function.
+// and their worker function. The function runs immediately, then again each
+// interval; the options run it concurrently, add jitter, back off while there's
+// no work, and drain in-flight runs on shutdown. RunWorkerFunc returns once the
+// context is cancelled and every run has returned.
 func RunWorkerFunc(
 	ctx context.Context,
 	logger *slog.Logger,
 	interval time.Duration,
 	f func(context.Context, *slog.Logger),
+	opts ...PoolOption,
 ) error {
-	// TODO: set this in the past to trigger immediate run? The first run doesn't
-	// happen until after a delay.
-	lastRun := time.Now()
-	for {
-		delay := time.NewTimer(lastRun.Truncate(interval).Add(interval).Sub(lastRun))
+	c := poolConfig{concurrency: 1}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.concurrency < 1 {
+		c.concurrency = 1
+	}
+
+	// Runs get their own context so they can outlive ctx while draining.
+	runCtx, cancelRuns := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRuns()
+	go func() {
+		<-ctx.Done()
+		if c.drainTimeout <= 0 {
+			cancelRuns()
+			return
+		}
+		logger.Info("worker context cancelled, draining in-flight runs", "timeout", c.drainTimeout.String())
 		select {
-		case <-delay.C:
-			f(ctx, logger)
-		case <-ctx.Done():
-			logger.Info("search worker context cancelled, return context err")
-			if !delay.Stop() {
-				logger.Info("flushing work timer thingy")
-				<-delay.C
-			}
-			return ctx.Err()
+		case <-time.After(c.drainTimeout):
+			logger.Warn("drain timed out, cancelling in-flight runs")
+			cancelRuns()
+		case <-runCtx.Done():
+		}
+	}()
+
+	// Idle workers wait on wake, which is closed (and replaced) whenever a run
+	// finds work so that backed off workers pick up new work quickly.
+	var mu sync.Mutex
+	wake := make(chan struct{})
+	idleCount := 0
+	signalWork := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if idleCount > 0 {
+			close(wake)
+			wake = make(chan struct{})
 		}
-		lastRun = time.Now()
 	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			delay := interval
+			for {
+				idle := &atomic.Bool{}
+				f(context.WithValue(runCtx, idleKey{}, idle), logger)
+				if ctx.Err() != nil {
+					return
+				}
+
+				// back off while idle, reset (and wake the others) on work
+				if idle.Load() && c.maxIdleDelay > interval {
+					delay = min(delay*2, c.maxIdleDelay)
+				} else {
+					delay = interval
+					signalWork()
+				}
+
+				d := delay
+				if c.jitter > 0 {
+					d += time.Duration(rand.Int63n(int64(c.jitter)))
+				}
+				mu.Lock()
+				w := wake
+				backedOff := delay > interval
+				if backedOff {
+					idleCount++
+				}
+				mu.Unlock()
+
+				timer := time.NewTimer(d)
+				select {
+				case <-timer.C:
+				case <-w:
+					timer.Stop()
+					delay = interval
+				case <-ctx.Done():
+					timer.Stop()
+				}
+				if backedOff {
+					mu.Lock()
+					idleCount--
+					mu.Unlock()
+				}
+				if ctx.Err() != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	logger.Info("worker context cancelled, all runs finished")
+	return ctx.Err()
 }
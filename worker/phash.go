@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// Returns the 64 bit average hash (aHash) of the image: the image is shrunk to
+// 8x8 grayscale and each bit is set if the pixel is brighter than the mean.
+// Visually similar images have hashes with a small Hamming distance.
+func averageHash(img image.Image) uint64 {
+	px := grayThumbnail(img, 8, 8)
+	var sum int
+	for _, p := range px.Pix {
+		sum += int(p)
+	}
+	mean := sum / len(px.Pix)
+	var h uint64
+	for _, p := range px.Pix {
+		h <<= 1
+		if int(p) > mean {
+			h |= 1
+		}
+	}
+	return h
+}
+
+// Returns the 64 bit difference hash (dHash) of the image: the image is shrunk
+// to 9x8 grayscale and each bit is set if a pixel is brighter than its right
+// neighbor. This is more robust to brightness and contrast changes than the
+// average hash.
+func differenceHash(img image.Image) uint64 {
+	px := grayThumbnail(img, 9, 8)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if px.GrayAt(x, y).Y > px.GrayAt(x+1, y).Y {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+func grayThumbnail(img image.Image, w, h int) *image.Gray {
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
@@ -3,7 +3,7 @@ package worker
 import (
 	"bytes"
 	"context"
-	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -11,12 +11,14 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/brojonat/gredfin/redfin"
+	"github.com/brojonat/gredfin/provider"
 	"github.com/brojonat/gredfin/server"
 	"github.com/brojonat/gredfin/server/db/dbgen"
-	"github.com/brojonat/gredfin/server/db/jsonb"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
@@ -29,267 +31,248 @@ func logPropertyError(l *slog.Logger, msg string, err error, p *dbgen.Property)
 }
 
 func hashBytes(b []byte) string {
-	hash := md5.Sum(b)
+	hash := sha256.Sum256(b)
 	return hex.EncodeToString(hash[:])
 }
 
-// Default implementation of a Property scrape worker.
+// Lease for property jobs. Scraping a listing takes a handful of requests to
+// the provider, so this is plenty.
+const propertyJobLease = 10 * time.Minute
+
+// Default implementation of a Property scrape worker. The worker claims
+// property jobs for the provider from the server and runs them with
+// PropertyJobHandler.
 func MakePropertyWorkerFunc(
 	end string,
 	authToken string,
-	grc redfin.Client,
+	prov provider.Provider,
 ) func(context.Context, *slog.Logger) {
-	f := func(ctx context.Context, l *slog.Logger) {
+	return MakeJobWorkerFunc(end, authToken, propertyJobLease, PropertyJobHandler(end, authToken, prov))
+}
+
+// Returns a JobHandler that scrapes the listing referenced by each property
+// job: it fetches the listing's details from the provider and uploads the
+// results. Any optional capabilities the provider implements (estimate
+// history, rent estimates, similar sold homes) are used on a best effort
+// basis. Jobs for listings that have since been deleted are dropped.
+func PropertyJobHandler(
+	end string,
+	authToken string,
+	prov provider.Provider,
+) JobHandler {
+	dd := newDriftDetector(end, server.GetDefaultServerHeaders(authToken))
+	f := func(ctx context.Context, l *slog.Logger, j server.PropertyJobPayload) (any, error) {
 		h := server.GetDefaultServerHeaders(authToken)
-		p, err := claimProperty(end, h)
+		p, err := getPropertyBasic(end, h, j.PropertyID, j.ListingID)
 		if err != nil {
-			logPropertyError(l, "error claiming property from server", err, p)
-			return
+			return nil, err
 		}
 		pid := p.PropertyID
 		lid := p.ListingID
-		url := p.URL.String
-		l.Info("running scrape worker", "property_id", pid, "listing_id", lid, "url", url)
-
-		// The following is a bit verbose with logging statements so here's a
-		// high level overview. Make Redfin client calls for the InitialInfo,
-		// BelowTheFold (MLSInfo), and AVMDetails data. For each call, if an
-		// error is returned, then log it and mark the scrape bad. Marking bad
-		// can also fail (e.g., due to network reasons), so also log an error in
-		// that case too before returning. If the client call doesn't return an
-		// error, then parse the response. If that returns an error, do the same
-		// error handling as above. Finally, check the error code/response
-		// message in the Redfin response itself and if that doesn't indicate
-		// success, then do the same error handling as above.
-
-		var iiRes redfin.RedfinResponse
-		var mlsRes redfin.RedfinResponse
-		var avmRes redfin.RedfinResponse
-
-		// pull InitialInfo bytes
-		params := map[string]string{}
-		iib, err := grc.InitialInfo(p.URL.String, params)
-		if err != nil {
-			logPropertyError(l, "error getting InitialInfo, marking scrape bad", err, p)
-			if err = markPropertyScrapeBad(end, h, pid, lid); err != nil {
-				logPropertyError(l, "error marking scrape bad", err, p)
-			}
-			return
-		}
-		if err = json.Unmarshal(iib, &iiRes); err != nil {
-			logPropertyError(l, "error serializing InitialInfo response, marking scrape bad", err, p)
-			if err = markPropertyScrapeBad(end, h, pid, lid); err != nil {
-				logPropertyError(l, "error marking scrape bad", err, p)
-			}
-			return
-		}
-		if err = checkRedfinResponse(iiRes); err != nil {
-			logPropertyError(l, "error with InitialInfo response, marking scrape bad", err, p)
-			if err = markPropertyScrapeBad(end, h, pid, lid); err != nil {
-				logPropertyError(l, "error marking scrape bad", err, p)
+		ref := propertyRef(p)
+		l.Info("running scrape worker", "provider", ref.Provider, "property_id", pid, "listing_id", lid, "url", ref.URL)
+
+		// Fetch the listing details from the provider. If that fails, or if
+		// handling the details fails, mark the scrape bad and fail the job so
+		// it's retried. Marking bad can also fail (e.g., due to network
+		// reasons), so log an error in that case too before returning.
+		scrapeTS := time.Now()
+		d, err := prov.Details(ctx, ref)
+
+		// Check the raw payloads for drift before anything else, since a
+		// change in the payload shape is the most likely reason extraction
+		// fails. This is best effort and doesn't affect the scrape status.
+		if d != nil {
+			checkPayloadDrift(l, dd, prov.Name(), p, d.Payloads)
+		}
+
+		// Archive the raw payloads so they can be reprocessed later (e.g.,
+		// after fixing an extraction bug). This is also best effort; only
+		// the hashes of the payloads that made it into the archive are
+		// recorded on the property.
+		hashes := map[string]string{}
+		if d != nil {
+			var aerr error
+			if hashes, aerr = archivePayloads(end, h, l, p, d.Payloads, scrapeTS); aerr != nil {
+				logPropertyError(l, "error archiving payloads", aerr, p)
 			}
-			return
 		}
-
-		// pull MLS (below-the-fold) bytes
-		property_id := strconv.Itoa(int(p.PropertyID))
-		listing_id := strconv.Itoa(int(p.ListingID))
-		mlsb, err := grc.BelowTheFold(property_id, params)
 		if err != nil {
-			logPropertyError(l, "error getting BelowTheFold (MLS) data, marking scrape bad", err, p)
-			if err = markPropertyScrapeBad(end, h, pid, lid); err != nil {
-				logPropertyError(l, "error marking scrape bad", err, p)
-			}
-			return
+			markPropertyScrapeBad(l, end, h, p)
+			return nil, fmt.Errorf("error getting listing details: %w", err)
 		}
-		if err = json.Unmarshal(mlsb, &mlsRes); err != nil {
-			logPropertyError(l, "error serializing mls response, marking scrape bad", err, p)
-			if err = markPropertyScrapeBad(end, h, pid, lid); err != nil {
-				logPropertyError(l, "error marking scrape bad", err, p)
+		err = handleListingDetails(end, h, l, p, d)
+		if err != nil {
+			markPropertyScrapeBad(l, end, h, p)
+			return nil, fmt.Errorf("error handling property data: %w", err)
+		}
+
+		// Backfill the estimate history the first time we successfully scrape
+		// this property. This is best effort; if it fails, it'll be retried on
+		// the next scrape rather than marking this scrape bad.
+		backfilled := p.LastScrapeMetadata.AVMHistoryBackfilled
+		if eh, ok := prov.(provider.EstimateHistorian); ok && !backfilled {
+			if err = backfillEstimateHistory(ctx, end, h, eh, p, ref); err != nil {
+				logPropertyError(l, "error backfilling avm history", err, p)
+			} else {
+				backfilled = true
 			}
-			return
 		}
-		if err = checkRedfinResponse(mlsRes); err != nil {
-			l.Info(string(mlsb))
-			logPropertyError(l, "error with mls (below the fold) response, marking scrape bad", err, p)
-			if err = markPropertyScrapeBad(end, h, pid, lid); err != nil {
-				logPropertyError(l, "error marking scrape bad", err, p)
+
+		// Capture the rental estimate. Not every property has one, so this is
+		// also best effort.
+		if re, ok := prov.(provider.RentEstimator); ok {
+			if err = captureRentEstimate(ctx, end, h, re, p, ref); err != nil {
+				logPropertyError(l, "error capturing rental estimate", err, p)
 			}
-			return
 		}
 
-		// pull AVM bytes
-		avmb, err := grc.AVMDetails(property_id, listing_id, params)
-		if err != nil {
-			logPropertyError(l, "error getting avm info, marking scrape bad", err, p)
-			if err = markPropertyScrapeBad(end, h, pid, lid); err != nil {
-				logPropertyError(l, "error marking scrape bad", err, p)
+		// Capture the provider's similar sold homes for use as comps. This is
+		// also best effort.
+		if sf, ok := prov.(provider.SimilarSoldFinder); ok {
+			if err = captureSimilarSold(ctx, end, h, sf, p, ref); err != nil {
+				logPropertyError(l, "error capturing similar sold homes", err, p)
 			}
-			return
 		}
-		if err = json.Unmarshal(avmb, &avmRes); err != nil {
-			logPropertyError(l, "error serializing avm response, marking scrape bad", err, p)
-			if err = markPropertyScrapeBad(end, h, pid, lid); err != nil {
-				logPropertyError(l, "error marking scrape bad", err, p)
-			}
-			return
+
+		// Mark the scrape status as good on the server. The metadata is merge
+		// patched, so the hashes of endpoints that weren't archived by this
+		// scrape are cleared explicitly; otherwise their hashes from earlier
+		// scrapes would be kept.
+		metadata := map[string]any{}
+		ph := map[string]any{}
+		for endpoint := range p.LastScrapeMetadata.PayloadHashes {
+			ph[endpoint] = nil
 		}
-		if err = checkRedfinResponse(avmRes); err != nil {
-			logPropertyError(l, "error with avm response, marking scrape bad", err, p)
-			if err = markPropertyScrapeBad(end, h, pid, lid); err != nil {
-				logPropertyError(l, "error marking scrape bad", err, p)
-			}
-			return
+		for endpoint, hash := range hashes {
+			ph[endpoint] = hash
 		}
-
-		// At this point, we've successfully fetched all the bytes from Redfin
-		// pertaining to this property. Now we just have to do something with
-		// them. Pass them to handlePropertyBytes which will process them (extract
-		// useful data, make some queries to the server, etc).
-		err = handlePropertyBytes(end, h, l, p, iiRes.Payload, mlsRes.Payload, avmRes.Payload)
-		if err != nil {
-			logPropertyError(l, "error handling property data, marking scrape bad", err, p)
-			if err = markPropertyScrapeBad(end, h, pid, lid); err != nil {
-				logPropertyError(l, "error marking scrape bad", err, p)
-			}
-			return
+		if len(ph) > 0 {
+			metadata["payload_hashes"] = ph
 		}
-
-		// Mark the scrape status as good on the server
-		payload := dbgen.PutPropertyParams{
-			PropertyID:       p.PropertyID,
-			ListingID:        p.ListingID,
-			LastScrapeStatus: server.ScrapeStatusGood,
-			LastScrapeMetadata: jsonb.PropertyScrapeMetadata{
-				InitialInfoHash: hashBytes(iiRes.Payload),
-				MLSHash:         hashBytes(mlsRes.Payload),
-				AVMHash:         hashBytes(avmRes.Payload),
-			},
+		if backfilled {
+			metadata["avm_history_backfilled"] = true
 		}
-		b, err := json.Marshal(payload)
-		if err != nil {
-			logPropertyError(l, "error serializing property update payload", err, p)
-			if err = markPropertyScrapeBad(end, h, pid, lid); err != nil {
-				logPropertyError(l, "error marking scrape bad", err, p)
-			}
-			return
+		patch := map[string]any{
+			"last_scrape_ts":       time.Now().UTC(),
+			"last_scrape_status":   server.ScrapeStatusGood,
+			"last_scrape_metadata": metadata,
 		}
-		if err = updateProperty(end, h, b); err != nil {
-			logPropertyError(l, "error updating property scrape metadata", err, p)
-			if err = markPropertyScrapeBad(end, h, pid, lid); err != nil {
-				logPropertyError(l, "error marking scrape bad", err, p)
-			}
-			return
+		if err := patchProperty(end, h, p, patch); err != nil {
+			markPropertyScrapeBad(l, end, h, p)
+			return nil, fmt.Errorf("error updating property scrape metadata: %w", err)
 		}
+		return nil, nil
 	}
-	return f
+	return HandleJob(server.PropertyJobKind(prov.Name()), f)
 }
 
-func checkRedfinResponse(r redfin.RedfinResponse) error {
-	if r.ResultCode != 0 || r.ErrorMessage != "Success" {
-		return fmt.Errorf("bad redfin response: (code: %d, message: %s)", r.ResultCode, r.ErrorMessage)
+// Checks each of the payloads for drift against its baseline. Payloads are
+// identified by their basename without the extension (e.g., "mls_info").
+func checkPayloadDrift(l *slog.Logger, dd *driftDetector, prov string, p *dbgen.Property, payloads map[string][]byte) {
+	basenames := []string{}
+	for basename := range payloads {
+		basenames = append(basenames, basename)
+	}
+	sort.Strings(basenames)
+	for _, basename := range basenames {
+		endpoint := strings.TrimSuffix(basename, ".json")
+		if _, err := dd.Check(l, prov, endpoint, p, payloads[basename]); err != nil {
+			logPropertyError(l, fmt.Sprintf("error checking %s payload drift", endpoint), err, p)
+		}
 	}
-	return nil
 }
 
-// Parse property scrape bytes and upload relevant data.
-func handlePropertyBytes(end string, h http.Header, l *slog.Logger, p *dbgen.Property, iib, mlsb, avmb []byte) error {
-
-	// first parse the bytes into an empty interface for jmespath search
-	var jmesMLS interface{}
-	err := json.Unmarshal(mlsb, &jmesMLS)
-	if err != nil {
-		return fmt.Errorf("error parsing MLS bytes")
+// Returns the provider reference for a stored property.
+func propertyRef(p *dbgen.Property) provider.ListingRef {
+	prov := p.Provider
+	if prov == "" {
+		prov = provider.Redfin
 	}
+	return provider.ListingRef{
+		Provider:   prov,
+		ExternalID: p.ExternalID.String,
+		URL:        p.URL.String,
+		PropertyID: p.PropertyID,
+		ListingID:  p.ListingID,
+	}
+}
 
-	// Helper closure to parse and upload basic property data. This sets the
-	// data in the property table.
-	parseUploadProperty := func() error {
+// Upload the listing details to the server.
+func handleListingDetails(end string, h http.Header, l *slog.Logger, p *dbgen.Property, d *provider.ListingDetails) error {
 
-		// parse zipcode
-		zipcode, err := jmesParseMLSParams("zipcode", jmesMLS)
-		if err != nil {
-			return fmt.Errorf("error searching for zipcode: %w", err)
+	// Helper closure to upload basic property data. This sets the data in the
+	// property table.
+	uploadProperty := func() error {
+		patch := map[string]any{"last_scrape_ts": time.Now().UTC()}
+		for k, v := range map[string]string{"zipcode": d.Zipcode, "city": d.City, "state": d.State} {
+			if v != "" {
+				patch[k] = v
+			}
 		}
-		if zipcode == nil {
-			return fmt.Errorf("null result extracting zipcode")
+		metadata := map[string]any{}
+		if d.ThumbnailURLs != nil {
+			metadata["thumbnail_urls"] = d.ThumbnailURLs
 		}
-
-		// parse city
-		city, err := jmesParseMLSParams("city", jmesMLS)
-		if err != nil {
-			return fmt.Errorf("error searching for city %w", err)
+		if d.ImageURLs != nil {
+			metadata["image_urls"] = d.ImageURLs
 		}
-		if city == nil {
-			return fmt.Errorf("null result extracting city")
+		patch["last_scrape_metadata"] = metadata
+		if err := patchProperty(end, h, p, patch); err != nil {
+			return fmt.Errorf("error uploading property: %w", err)
 		}
+		return nil
+	}
 
-		// parse state
-		state, err := jmesParseMLSParams("state", jmesMLS)
-		if err != nil {
-			return fmt.Errorf("error searching for state %w", err)
+	// Helper closure to upload property details. Unknown attributes are
+	// uploaded as nulls.
+	uploadPropertyDetails := func() error {
+		a := d.Attributes
+		pd := dbgen.UpsertPropertyDetailsParams{
+			PropertyID: p.PropertyID,
+			ListingID:  p.ListingID,
 		}
-		if state == nil {
-			return fmt.Errorf("null result extracting state")
+		if a.Beds != nil {
+			pd.Beds = pgtype.Float4{Float32: float32(*a.Beds), Valid: true}
 		}
-
-		// parse thumbnail urls
-		uis, err := jmesParseMLSParams("thumbnail_urls", jmesMLS)
-		if err != nil {
-			return fmt.Errorf("error extracting thumbnail urls: %w", err)
+		if a.Baths != nil {
+			pd.Baths = pgtype.Float4{Float32: float32(*a.Baths), Valid: true}
 		}
-		turls, ok := uis.([]string)
-		if !ok {
-			return fmt.Errorf("could not type assert thumbnail urls")
+		if a.Sqft != nil {
+			pd.Sqft = pgtype.Int4{Int32: *a.Sqft, Valid: true}
 		}
-
-		// parse image urls
-		uis, err = jmesParseMLSParams("image_urls", jmesMLS)
-		if err != nil {
-			return fmt.Errorf("error extracting image urls: %w", err)
+		if a.LotSqft != nil {
+			pd.LotSqft = pgtype.Int4{Int32: *a.LotSqft, Valid: true}
 		}
-		urls, ok := uis.([]string)
-		if !ok {
-			return fmt.Errorf("could not type assert image urls")
+		if a.YearBuilt != nil {
+			pd.YearBuilt = pgtype.Int4{Int32: *a.YearBuilt, Valid: true}
 		}
-
-		// upload
-		np := dbgen.PutPropertyParams{
-			PropertyID:         p.PropertyID,
-			ListingID:          p.ListingID,
-			URL:                p.URL,
-			Zipcode:            pgtype.Text{String: zipcode.(string), Valid: true},
-			City:               pgtype.Text{String: city.(string), Valid: true},
-			State:              pgtype.Text{String: state.(string), Valid: true},
-			LastScrapeMetadata: jsonb.PropertyScrapeMetadata{ThumbnailURLs: turls, ImageURLs: urls},
+		if a.PropertyType != nil {
+			pd.PropertyType = pgtype.Text{String: *a.PropertyType, Valid: true}
 		}
-		b, err := json.Marshal(np)
+		b, err := json.Marshal(pd)
 		if err != nil {
-			return fmt.Errorf("error serializing property (property_id: %d, listing_id: %d): %w", p.PropertyID, p.ListingID, err)
+			return fmt.Errorf("error serializing property details (property_id: %d, listing_id: %d): %w", p.PropertyID, p.ListingID, err)
 		}
-		if err = updateProperty(end, h, b); err != nil {
-			return fmt.Errorf("error uploading property: %w", err)
+		if err = putPropertyDetails(end, h, b); err != nil {
+			return fmt.Errorf("error uploading property details: %w", err)
 		}
 		return nil
 	}
 
-	// helper closure to parse and upload property history events. This sets the
-	// data in the property_events table AND the property_events_property_through table.
-	parseUploadPropertyEvents := func() error {
-		hevents, err := jmesParseMLSParams("events", jmesMLS)
-		if err != nil {
-			return err
-		}
+	// helper closure to upload property history events. This sets the data in
+	// the property_events table AND the property_events_property_through table.
+	uploadPropertyEvents := func() error {
 		events := []dbgen.CreatePropertyEventParams{}
-		for _, he := range hevents.([]historyEvent) {
+		for _, e := range d.Events {
 			events = append(events, dbgen.CreatePropertyEventParams{
 				PropertyID:       p.PropertyID,
 				ListingID:        p.ListingID,
-				Price:            he.Price,
-				EventDescription: pgtype.Text{String: he.EventDescription, Valid: true},
-				Source:           pgtype.Text{String: he.Source, Valid: true},
-				SourceID:         pgtype.Text{String: he.SourceID, Valid: true},
-				EventTS:          pgtype.Timestamp{Time: he.EventTS, Valid: true},
+				Price:            e.Price,
+				EventDescription: pgtype.Text{String: e.Description, Valid: true},
+				Source:           pgtype.Text{String: e.Source, Valid: true},
+				SourceID:         pgtype.Text{String: e.SourceID, Valid: true},
+				EventTS:          pgtype.Timestamp{Time: e.TS, Valid: true},
 			})
 		}
 
@@ -308,163 +291,232 @@ func handlePropertyBytes(end string, h http.Header, l *slog.Logger, p *dbgen.Pro
 		return nil
 	}
 
-	// Helper closure to parse and upload realtor data. This sets the data in
-	// the realtor-property through table.
-	parseUploadRealtor := func() error {
-		// parse and upload the realtor data to the server
-		name, company, err := parseRealtorInfo(mlsb)
-		if err != nil {
-			return fmt.Errorf("error extracting realtor: %w", err)
-		}
-		r := server.PostRealtorBody{
-			Name:       name,
-			Company:    company,
-			PropertyID: p.PropertyID,
-			ListingID:  p.ListingID,
-		}
-
-		b, err := json.Marshal(r)
-		if err != nil {
-			return fmt.Errorf("could not serialize realtor for create request: %w", err)
-		}
-		if err = createRealtor(end, h, b); err != nil {
-			return fmt.Errorf("error uploading realtor: %w", err)
+	// Helper closure to upload realtor data. This sets the data in the
+	// realtor-property through table.
+	uploadRealtors := func() error {
+		for _, a := range d.Agents {
+			r := server.PostRealtorBody{
+				Name:       a.Name,
+				Company:    a.Company,
+				PropertyID: p.PropertyID,
+				ListingID:  p.ListingID,
+			}
+			b, err := json.Marshal(r)
+			if err != nil {
+				return fmt.Errorf("could not serialize realtor for create request: %w", err)
+			}
+			if err = createRealtor(end, h, b); err != nil {
+				return fmt.Errorf("error uploading realtor: %w", err)
+			}
 		}
 		return nil
-
 	}
 
-	// Helper closure to upload bytes to S3 if the hash is different from the
-	// last scrape. This sets the data in the object storage.
-	maybeS3Upload := func(b []byte, hash string, basename string) error {
-		if hashBytes(b) == hash || true {
-			l.Debug("skipping scrape upload, bytes unchanged", "property_id", p.PropertyID, "listing_id", p.ListingID, "basename", basename)
+	// Helper closure to upload the current value estimate, if any.
+	uploadEstimate := func() error {
+		if d.Estimate == nil {
+			l.Debug("no avm estimate for property", "property_id", p.PropertyID, "listing_id", p.ListingID)
 			return nil
 		}
-		url, err := getPresignedPutURL(end, h, p, basename)
+		b, err := json.Marshal([]dbgen.CreateAVMEstimateParams{
+			makeAVMEstimateParams(p, *d.Estimate, server.AVMSourceDetails),
+		})
 		if err != nil {
-			return err
+			return fmt.Errorf("error serializing avm estimate (property_id: %d, listing_id: %d): %w", p.PropertyID, p.ListingID, err)
 		}
-		req, err := http.NewRequest(
-			http.MethodPut,
-			url,
-			bytes.NewReader(b),
-		)
-		if err != nil {
-			return err
-		}
-		res, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return err
-		}
-		if res.StatusCode != http.StatusOK {
-			return fmt.Errorf(res.Status)
+		if err = createAVMEstimates(end, h, b); err != nil {
+			return fmt.Errorf("error uploading avm estimate: %w", err)
 		}
 		return nil
 	}
 
-	// parse and upload the property data to the server
-	if err := parseUploadProperty(); err != nil {
+	// upload the property data to the server
+	if err := uploadProperty(); err != nil {
 		return err
 	}
 
-	// parse and upload the property data to the server
-	if err := parseUploadPropertyEvents(); err != nil {
+	// upload the property details to the server
+	if err := uploadPropertyDetails(); err != nil {
 		return err
 	}
 
-	// parse and upload the realtor data for this property to the server
-	if err := parseUploadRealtor(); err != nil {
+	// upload the property history events to the server
+	if err := uploadPropertyEvents(); err != nil {
 		return err
 	}
 
-	// now (maybe) do S3 uploads to the cloud object store
-	if err := maybeS3Upload(iib, p.LastScrapeMetadata.InitialInfoHash, "initial_info.json"); err != nil {
-		return fmt.Errorf("error uploading InitialInfo bytes: %w", err)
-	}
-	if err := maybeS3Upload(mlsb, p.LastScrapeMetadata.MLSHash, "mls_info.json"); err != nil {
-		return fmt.Errorf("error uploading MLSInfo bytes: %w", err)
+	// upload the realtor data for this property to the server
+	if err := uploadRealtors(); err != nil {
+		return err
 	}
-	if err := maybeS3Upload(avmb, p.LastScrapeMetadata.AVMHash, "avm_info.json"); err != nil {
-		return fmt.Errorf("error uploading AVMInfo bytes: %w", err)
+
+	// upload the avm estimate for this property to the server
+	if err := uploadEstimate(); err != nil {
+		return err
 	}
+
 	return nil
 }
 
-func getPresignedPutURL(end string, h http.Header, p *dbgen.Property, basename string) (string, error) {
-	req, err := http.NewRequest(
-		http.MethodPost,
-		fmt.Sprintf("%s/property-query/get-presigned-put-url", end),
-		nil,
-	)
-	if err != nil {
-		return "", fmt.Errorf("error making presigned url request: %w", err)
+func makeAVMEstimateParams(p *dbgen.Property, e provider.Estimate, source string) dbgen.CreateAVMEstimateParams {
+	ep := dbgen.CreateAVMEstimateParams{
+		PropertyID: p.PropertyID,
+		ListingID:  p.ListingID,
+		EstimateTS: pgtype.Timestamp{Time: e.TS, Valid: true},
+		Value:      e.Value,
+		Source:     source,
 	}
-	req.Header = h
-	q := req.URL.Query()
-	q.Add("property_id", strconv.Itoa(int(p.PropertyID)))
-	q.Add("listing_id", strconv.Itoa(int(p.ListingID)))
-	q.Add("basename", basename)
-	req.URL.RawQuery = q.Encode()
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("error doing presigned url request: %w", err)
+	if e.Low != nil {
+		ep.ValueLow = pgtype.Int4{Int32: *e.Low, Valid: true}
 	}
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		return "", fmt.Errorf("error with presigned url response: %s", res.Status)
+	if e.High != nil {
+		ep.ValueHigh = pgtype.Int4{Int32: *e.High, Valid: true}
 	}
+	return ep
+}
 
-	var data server.DefaultJSONResponse
-	b, err := io.ReadAll(res.Body)
+// Fetch the estimate history for a property and upload it to the server.
+func backfillEstimateHistory(ctx context.Context, end string, h http.Header, eh provider.EstimateHistorian, p *dbgen.Property, ref provider.ListingRef) error {
+	history, err := eh.EstimateHistory(ctx, ref)
 	if err != nil {
-		return "", fmt.Errorf("error reading presigned url response: %w", err)
+		return err
+	}
+	es := []dbgen.CreateAVMEstimateParams{}
+	for _, e := range history {
+		es = append(es, makeAVMEstimateParams(p, e, server.AVMSourceHistorical))
 	}
-	if err := json.Unmarshal(b, &data); err != nil {
-		return "", fmt.Errorf("error parsing presigned url response: %w", err)
+	if len(es) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(es)
+	if err != nil {
+		return fmt.Errorf("error serializing avm history (property_id: %d, listing_id: %d): %w", p.PropertyID, p.ListingID, err)
 	}
-	return data.Message, nil
+	return createAVMEstimates(end, h, b)
 }
 
-func markPropertyScrapeBad(endpoint string, h http.Header, pid, lid int32) error {
-	payload := dbgen.UpdatePropertyStatusParams{
-		PropertyID:       pid,
-		ListingID:        lid,
-		LastScrapeStatus: server.ScrapeStatusBad,
+// Fetch the current rental estimate for a property and upload it to the
+// server. A missing estimate is not an error.
+func captureRentEstimate(ctx context.Context, end string, h http.Header, re provider.RentEstimator, p *dbgen.Property, ref provider.ListingRef) error {
+	e, err := re.RentEstimate(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return nil
+	}
+	rp := dbgen.CreateRentalEstimateParams{
+		PropertyID: p.PropertyID,
+		ListingID:  p.ListingID,
+		EstimateTS: pgtype.Timestamp{Time: e.TS, Valid: true},
+		Rent:       e.Value,
+	}
+	if e.Low != nil {
+		rp.RentLow = pgtype.Int4{Int32: *e.Low, Valid: true}
+	}
+	if e.High != nil {
+		rp.RentHigh = pgtype.Int4{Int32: *e.High, Valid: true}
 	}
-	b, err := json.Marshal(payload)
+	b, err := json.Marshal([]dbgen.CreateRentalEstimateParams{rp})
+	if err != nil {
+		return fmt.Errorf("error serializing rental estimate (property_id: %d, listing_id: %d): %w", p.PropertyID, p.ListingID, err)
+	}
+	return createRentalEstimates(end, h, b)
+}
+
+// Fetch the provider's similar sold homes for a property and upload them to
+// the server, replacing any previous results. Homes without an integer
+// property id can't be stored and are skipped.
+func captureSimilarSold(ctx context.Context, end string, h http.Header, sf provider.SimilarSoldFinder, p *dbgen.Property, ref provider.ListingRef) error {
+	homes, err := sf.SimilarSold(ctx, ref)
 	if err != nil {
 		return err
 	}
-	return updateProperty(endpoint, h, b)
+	body := server.PutSimilarSoldBody{
+		PropertyID: p.PropertyID,
+		ListingID:  p.ListingID,
+		Homes:      []dbgen.CreateSimilarSoldParams{},
+	}
+	for _, sh := range homes {
+		if sh.PropertyID == 0 {
+			continue
+		}
+		sp := dbgen.CreateSimilarSoldParams{
+			SimilarPropertyID: sh.PropertyID,
+			SimilarListingID:  pgtype.Int4{Int32: sh.ListingID, Valid: sh.ListingID != 0},
+			URL:               pgtype.Text{String: sh.URL, Valid: sh.URL != ""},
+			Price:             sh.Price,
+			Longitude:         sh.Longitude,
+			Latitude:          sh.Latitude,
+		}
+		if sh.SaleTS != nil {
+			sp.SaleTS = pgtype.Timestamp{Time: *sh.SaleTS, Valid: true}
+		}
+		if sh.Beds != nil {
+			sp.Beds = pgtype.Float4{Float32: float32(*sh.Beds), Valid: true}
+		}
+		if sh.Baths != nil {
+			sp.Baths = pgtype.Float4{Float32: float32(*sh.Baths), Valid: true}
+		}
+		if sh.Sqft != nil {
+			sp.Sqft = pgtype.Int4{Int32: *sh.Sqft, Valid: true}
+		}
+		body.Homes = append(body.Homes, sp)
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error serializing similar sold (property_id: %d, listing_id: %d): %w", p.PropertyID, p.ListingID, err)
+	}
+	return putSimilarSold(end, h, b)
 }
 
-func claimProperty(endpoint string, headers http.Header) (*dbgen.Property, error) {
+// Records a failed scrape on the property. This is best effort; the job
+// failure is what gets the scrape retried.
+func markPropertyScrapeBad(l *slog.Logger, end string, h http.Header, p *dbgen.Property) {
+	patch := map[string]any{
+		"last_scrape_ts":     time.Now().UTC(),
+		"last_scrape_status": server.ScrapeStatusBad,
+	}
+	if err := patchProperty(end, h, p, patch); err != nil {
+		logPropertyError(l, "error marking scrape bad", err, p)
+	}
+}
+
+// GET a property listing from the "basic" property table. Returns ErrDropJob
+// if the listing no longer exists.
+func getPropertyBasic(end string, h http.Header, pid, lid int32) (*dbgen.Property, error) {
 	req, err := http.NewRequest(
-		http.MethodPost,
-		fmt.Sprintf("%s/property-query/claim-next", endpoint),
+		http.MethodGet,
+		fmt.Sprintf("%s/v1/property-basic", end),
 		nil,
 	)
 	if err != nil {
 		return nil, err
 	}
-	req.Header = headers
+	q := req.URL.Query()
+	q.Add("property_id", strconv.Itoa(int(pid)))
+	q.Add("listing_id", strconv.Itoa(int(lid)))
+	req.URL.RawQuery = q.Encode()
+	req.Header = h
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf(res.Status)
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("property %d/%d: %w", pid, lid, ErrDropJob)
 	}
-
-	var p dbgen.Property
 	b, err := io.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(b, &p)
-	if err != nil {
+	if res.StatusCode != http.StatusOK {
+		var data server.DefaultJSONResponse
+		json.Unmarshal(b, &data)
+		return nil, fmt.Errorf("unexpected response code for GET /property-basic: %s (%s)", res.Status, data.Error)
+	}
+	var p dbgen.Property
+	if err = json.Unmarshal(b, &p); err != nil {
 		return nil, err
 	}
 	return &p, nil
@@ -473,7 +525,7 @@ func claimProperty(endpoint string, headers http.Header) (*dbgen.Property, error
 func createProperty(endpoint string, h http.Header, b []byte) error {
 	req, err := http.NewRequest(
 		http.MethodPost,
-		fmt.Sprintf("%s/property", endpoint),
+		fmt.Sprintf("%s/v1/property", endpoint),
 		bytes.NewReader(b),
 	)
 	if err != nil {
@@ -502,33 +554,164 @@ func createProperty(endpoint string, h http.Header, b []byte) error {
 	return nil
 }
 
-func updateProperty(endpoint string, h http.Header, b []byte) error {
+// Applies a JSON merge patch to the property; absent fields are left alone
+// and null fields are cleared.
+func patchProperty(endpoint string, h http.Header, p *dbgen.Property, patch map[string]any) error {
+	b, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("error serializing property patch (property_id: %d, listing_id: %d): %w", p.PropertyID, p.ListingID, err)
+	}
+	req, err := http.NewRequest(
+		http.MethodPatch,
+		fmt.Sprintf("%s/v1/property?property_id=%d&listing_id=%d", endpoint, p.PropertyID, p.ListingID),
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating patch Property request: %w", err)
+	}
+	req.Header = h.Clone()
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error doing patch Property request: %w", err)
+	}
+	defer res.Body.Close()
+	b, err = io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("could not read patch Property response body: %w", err)
+	}
+
+	var body server.DefaultJSONResponse
+	err = json.Unmarshal(b, &body)
+	if err != nil {
+		return fmt.Errorf("could not parse patch Property response body: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response code for patch Property: %s (%s)", res.Status, body.Error)
+	}
+	return nil
+}
+
+// helper function to PUT /property-details
+func putPropertyDetails(end string, h http.Header, b []byte) error {
 	req, err := http.NewRequest(
 		http.MethodPut,
-		fmt.Sprintf("%s/property", endpoint),
+		fmt.Sprintf("%s/v1/property-details", end),
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return fmt.Errorf("error constructing put PropertyDetails request: %w", err)
+	}
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error doing put PropertyDetails request: %w", err)
+	}
+	defer res.Body.Close()
+	b, err = io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("error reading put PropertyDetails response body: %w", err)
+	}
+	var body server.DefaultJSONResponse
+	err = json.Unmarshal(b, &body)
+	if err != nil {
+		return fmt.Errorf("could not parse put PropertyDetails response body: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response code for PUT /property-details: %s (%s)", res.Status, body.Error)
+	}
+	return nil
+}
+
+// helper function to POST /avm-estimates
+func createAVMEstimates(end string, h http.Header, b []byte) error {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/v1/avm-estimates", end),
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return fmt.Errorf("error constructing create AVMEstimates request: %w", err)
+	}
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error doing create AVMEstimates request: %w", err)
+	}
+	defer res.Body.Close()
+	b, err = io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("error reading create AVMEstimates response body: %w", err)
+	}
+	var body server.DefaultJSONResponse
+	err = json.Unmarshal(b, &body)
+	if err != nil {
+		return fmt.Errorf("could not parse create AVMEstimates response body: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response code for POST /avm-estimates: %s (%s)", res.Status, body.Error)
+	}
+	return nil
+}
+
+// helper function to POST /rental-estimates
+func createRentalEstimates(end string, h http.Header, b []byte) error {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/v1/rental-estimates", end),
 		bytes.NewReader(b),
 	)
 	if err != nil {
-		return fmt.Errorf("error creating update Property request: %w", err)
+		return fmt.Errorf("error constructing create RentalEstimates request: %w", err)
 	}
 	req.Header = h
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("error doing update Property request: %w", err)
+		return fmt.Errorf("error doing create RentalEstimates request: %w", err)
 	}
 	defer res.Body.Close()
 	b, err = io.ReadAll(res.Body)
 	if err != nil {
-		return fmt.Errorf("could not read update Property response body: %w", err)
+		return fmt.Errorf("error reading create RentalEstimates response body: %w", err)
+	}
+	var body server.DefaultJSONResponse
+	err = json.Unmarshal(b, &body)
+	if err != nil {
+		return fmt.Errorf("could not parse create RentalEstimates response body: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response code for POST /rental-estimates: %s (%s)", res.Status, body.Error)
 	}
+	return nil
+}
 
+// helper function to PUT /similar-sold
+func putSimilarSold(end string, h http.Header, b []byte) error {
+	req, err := http.NewRequest(
+		http.MethodPut,
+		fmt.Sprintf("%s/v1/similar-sold", end),
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return fmt.Errorf("error constructing put SimilarSold request: %w", err)
+	}
+	req.Header = h
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error doing put SimilarSold request: %w", err)
+	}
+	defer res.Body.Close()
+	b, err = io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("error reading put SimilarSold response body: %w", err)
+	}
 	var body server.DefaultJSONResponse
 	err = json.Unmarshal(b, &body)
 	if err != nil {
-		return fmt.Errorf("could not parse update Property response body: %w", err)
+		return fmt.Errorf("could not parse put SimilarSold response body: %w", err)
 	}
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected response code for update Property: %s (%s)", res.Status, body.Error)
+		return fmt.Errorf("unexpected response code for PUT /similar-sold: %s (%s)", res.Status, body.Error)
 	}
 	return nil
 }
@@ -537,7 +720,7 @@ func updateProperty(endpoint string, h http.Header, b []byte) error {
 func createRealtor(end string, h http.Header, b []byte) error {
 	req, err := http.NewRequest(
 		http.MethodPost,
-		fmt.Sprintf("%s/realtor", end),
+		fmt.Sprintf("%s/v1/realtor", end),
 		bytes.NewReader(b),
 	)
 	if err != nil {
@@ -571,7 +754,7 @@ var errDuplicateEvent = errors.New(http.StatusText(http.StatusConflict))
 func createPropertyEvents(end string, h http.Header, b []byte) error {
 	req, err := http.NewRequest(
 		http.MethodPut,
-		fmt.Sprintf("%s/property-events", end),
+		fmt.Sprintf("%s/v1/property-events", end),
 		bytes.NewReader(b),
 	)
 	if err != nil {
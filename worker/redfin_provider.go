@@ -0,0 +1,329 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brojonat/gredfin/provider"
+	"github.com/brojonat/gredfin/redfin"
+)
+
+// RedfinProvider adapts a redfin.Client to the provider interfaces. In
+// addition to discovery and detail, it supports estimate history, rent
+// estimates, and similar sold homes. Fields are extracted from the Redfin
+// payloads according to the supplied extraction config.
+type RedfinProvider struct {
+	l   *slog.Logger
+	grc redfin.Client
+	ec  *ExtractionConfig
+}
+
+func NewRedfinProvider(l *slog.Logger, grc redfin.Client, ec *ExtractionConfig) *RedfinProvider {
+	return &RedfinProvider{l: l, grc: grc, ec: ec}
+}
+
+func (rp *RedfinProvider) Name() string {
+	return provider.Redfin
+}
+
+// Returns a ref for each listing URL in the Redfin GIS-CSV results for the
+// query. The refs only have a URL; callers must Resolve them.
+func (rp *RedfinProvider) Search(ctx context.Context, query string) ([]provider.ListingRef, error) {
+	urls, err := GetURLSFromQuery(
+		rp.l,
+		rp.grc,
+		query,
+		GetDefaultSearchParams(),
+		GetDefaultGISCSVParams(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	refs := []provider.ListingRef{}
+	for _, u := range urls {
+		refs = append(refs, provider.ListingRef{Provider: provider.Redfin, URL: u})
+	}
+	return refs, nil
+}
+
+// Fetches the InitialInfo for the listing URL and extracts the ids and
+// location.
+func (rp *RedfinProvider) Resolve(ctx context.Context, ref provider.ListingRef) (*provider.Listing, error) {
+	iib, err := fetchRedfinPayload("initial_info", func() ([]byte, error) {
+		return rp.grc.InitialInfo(strings.TrimPrefix(ref.URL, "https://www.redfin.com"), map[string]string{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	var jmesdata interface{}
+	if err := json.Unmarshal(iib, &jmesdata); err != nil {
+		return nil, fmt.Errorf("error parsing initial_info data: %w", err)
+	}
+
+	// parse the ids and location; these are all required
+	vs := map[string]interface{}{}
+	for _, field := range []string{"property_id", "listing_id", "latitude", "longitude"} {
+		v, err := rp.ec.Extract("initial_info", field, jmesdata)
+		if err != nil {
+			return nil, err
+		}
+		vs[field] = v
+	}
+	pid, lid := vs["property_id"].(int32), vs["listing_id"].(int32)
+
+	return &provider.Listing{
+		ListingRef: provider.ListingRef{
+			Provider:   provider.Redfin,
+			ExternalID: provider.RedfinExternalID(pid, lid),
+			URL:        ref.URL,
+			PropertyID: pid,
+			ListingID:  lid,
+		},
+		Latitude:  vs["latitude"].(float64),
+		Longitude: vs["longitude"].(float64),
+	}, nil
+}
+
+// Fetches the InitialInfo, BelowTheFold (MLS), and AVMDetails payloads for the
+// listing and extracts the details from them.
+func (rp *RedfinProvider) Details(ctx context.Context, ref provider.ListingRef) (*provider.ListingDetails, error) {
+	params := map[string]string{}
+	property_id := strconv.Itoa(int(ref.PropertyID))
+	listing_id := strconv.Itoa(int(ref.ListingID))
+
+	iib, err := fetchRedfinPayload("InitialInfo", func() ([]byte, error) {
+		return rp.grc.InitialInfo(ref.URL, params)
+	})
+	if err != nil {
+		return nil, err
+	}
+	mlsb, err := fetchRedfinPayload("BelowTheFold (MLS)", func() ([]byte, error) {
+		return rp.grc.BelowTheFold(property_id, params)
+	})
+	if err != nil {
+		return nil, err
+	}
+	avmb, err := fetchRedfinPayload("AVMDetails", func() ([]byte, error) {
+		return rp.grc.AVMDetails(property_id, listing_id, params)
+	})
+	if err != nil {
+		return nil, err
+	}
+	d, err := parseRedfinPayloads(rp.ec, iib, mlsb, avmb)
+	if err != nil {
+		return &provider.ListingDetails{Payloads: redfinPayloads(iib, mlsb, avmb)}, err
+	}
+	return d, nil
+}
+
+// Fetches the AVM history for the listing.
+func (rp *RedfinProvider) EstimateHistory(ctx context.Context, ref provider.ListingRef) ([]provider.Estimate, error) {
+	b, err := fetchRedfinPayload("avm history", func() ([]byte, error) {
+		return rp.grc.AVMHistorical(strconv.Itoa(int(ref.PropertyID)), strconv.Itoa(int(ref.ListingID)), map[string]string{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	var jmesAVMH interface{}
+	if err = json.Unmarshal(b, &jmesAVMH); err != nil {
+		return nil, fmt.Errorf("error parsing avm history bytes: %w", err)
+	}
+	points, err := jmesParseAVMParams("history", jmesAVMH)
+	if err != nil {
+		return nil, err
+	}
+	return points.([]provider.Estimate), nil
+}
+
+// Fetches the current rental estimate for the listing. A missing estimate is
+// not an error.
+func (rp *RedfinProvider) RentEstimate(ctx context.Context, ref provider.ListingRef) (*provider.Estimate, error) {
+	b, err := fetchRedfinPayload("rental estimate", func() ([]byte, error) {
+		return rp.grc.RentalEstimate(strconv.Itoa(int(ref.PropertyID)), strconv.Itoa(int(ref.ListingID)), map[string]string{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	var jmesRE interface{}
+	if err = json.Unmarshal(b, &jmesRE); err != nil {
+		return nil, fmt.Errorf("error parsing rental estimate bytes: %w", err)
+	}
+	v, err := rp.ec.Extract("rental_info", "rent", jmesRE)
+	if err != nil {
+		return nil, err
+	}
+	rent, ok := v.(int32)
+	if !ok || rent <= 0 {
+		return nil, nil
+	}
+	e := &provider.Estimate{TS: time.Now(), Value: rent}
+	// the range is optional
+	e.Low = extractInt32(rp.ec, "rental_info", "rent_low", jmesRE)
+	e.High = extractInt32(rp.ec, "rental_info", "rent_high", jmesRE)
+	return e, nil
+}
+
+// Fetches Redfin's similar sold homes for the listing.
+func (rp *RedfinProvider) SimilarSold(ctx context.Context, ref provider.ListingRef) ([]provider.SimilarHome, error) {
+	b, err := fetchRedfinPayload("similar sold", func() ([]byte, error) {
+		return rp.grc.SimilarSold(strconv.Itoa(int(ref.PropertyID)), strconv.Itoa(int(ref.ListingID)), map[string]string{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	var jmesSS interface{}
+	if err = json.Unmarshal(b, &jmesSS); err != nil {
+		return nil, fmt.Errorf("error parsing similar sold bytes: %w", err)
+	}
+	homes, err := jmesParseSimilarSoldParams("homes", jmesSS)
+	if err != nil {
+		return nil, fmt.Errorf("error extracting similar sold homes: %w", err)
+	}
+	return homes.([]provider.SimilarHome), nil
+}
+
+// Calls a Redfin client method and returns the payload of the response if
+// the response indicates success.
+func fetchRedfinPayload(name string, f func() ([]byte, error)) ([]byte, error) {
+	b, err := f()
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s: %w", name, err)
+	}
+	var res redfin.RedfinResponse
+	if err = json.Unmarshal(b, &res); err != nil {
+		return nil, fmt.Errorf("error serializing %s response: %w", name, err)
+	}
+	if err = checkRedfinResponse(res); err != nil {
+		return nil, fmt.Errorf("error with %s response: %w", name, err)
+	}
+	return res.Payload, nil
+}
+
+func checkRedfinResponse(r redfin.RedfinResponse) error {
+	if r.ResultCode != 0 || r.ErrorMessage != "Success" {
+		return fmt.Errorf("bad redfin response: (code: %d, message: %s)", r.ResultCode, r.ErrorMessage)
+	}
+	return nil
+}
+
+// Returns the raw payloads keyed by the basename they're archived under.
+func redfinPayloads(iib, mlsb, avmb []byte) map[string][]byte {
+	return map[string][]byte{
+		"initial_info.json": iib,
+		"mls_info.json":     mlsb,
+		"avm_info.json":     avmb,
+	}
+}
+
+// Extracts the listing details from the InitialInfo, MLS, and AVM payloads.
+// Callers pass in just the payload objects from the response bodies.
+func parseRedfinPayloads(ec *ExtractionConfig, iib, mlsb, avmb []byte) (*provider.ListingDetails, error) {
+	// first parse the bytes into an empty interface for jmespath search
+	var jmesMLS interface{}
+	err := json.Unmarshal(mlsb, &jmesMLS)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing MLS bytes")
+	}
+	d := &provider.ListingDetails{Payloads: redfinPayloads(iib, mlsb, avmb)}
+
+	// Parse the address, image urls, and realtor attribution. Whether these
+	// are required is up to the extraction config; a missing optional value
+	// is left as the zero value.
+	vs := map[string]interface{}{}
+	for _, field := range []string{"zipcode", "city", "state", "thumbnail_urls", "image_urls", "realtor"} {
+		v, err := ec.Extract("mls_info", field, jmesMLS)
+		if err != nil {
+			return nil, err
+		}
+		vs[field] = v
+	}
+	d.Zipcode, _ = vs["zipcode"].(string)
+	d.City, _ = vs["city"].(string)
+	d.State, _ = vs["state"].(string)
+	d.ThumbnailURLs, _ = vs["thumbnail_urls"].([]string)
+	d.ImageURLs, _ = vs["image_urls"].([]string)
+
+	// Parse the property attributes. These are optional (e.g., land listings
+	// don't have beds), so missing values are left nil.
+	for _, field := range []string{"beds", "baths", "sqft", "lot_sqft", "year_built", "property_type"} {
+		v, err := ec.Extract("mls_info", field, jmesMLS)
+		if err != nil {
+			return nil, err
+		}
+		switch tv := v.(type) {
+		case float64:
+			switch field {
+			case "beds":
+				d.Attributes.Beds = &tv
+			case "baths":
+				d.Attributes.Baths = &tv
+			}
+		case int32:
+			switch field {
+			case "sqft":
+				d.Attributes.Sqft = &tv
+			case "lot_sqft":
+				d.Attributes.LotSqft = &tv
+			case "year_built":
+				d.Attributes.YearBuilt = &tv
+			}
+		case string:
+			d.Attributes.PropertyType = &tv
+		}
+	}
+
+	// parse property history events
+	hevents, err := ec.Extract("mls_info", "events", jmesMLS)
+	if err != nil {
+		return nil, err
+	}
+	if ms, ok := hevents.([]map[string]interface{}); ok {
+		d.Events = parseHistoryEvents(ms)
+	}
+
+	// parse the listing realtor
+	if attribution, ok := vs["realtor"].(string); ok {
+		name, company, err := parseRealtorAttribution(attribution)
+		if err != nil {
+			return nil, fmt.Errorf("error extracting realtor: %w", err)
+		}
+		d.Agents = []provider.Agent{{Name: name, Company: company}}
+	}
+
+	// Parse the current AVM estimate. Not every property has an estimate, so
+	// a missing value is skipped rather than treated as an error.
+	var jmesAVM interface{}
+	if err := json.Unmarshal(avmb, &jmesAVM); err != nil {
+		return nil, fmt.Errorf("error parsing AVM bytes: %w", err)
+	}
+	v, err := ec.Extract("avm_info", "value", jmesAVM)
+	if err != nil {
+		return nil, err
+	}
+	if value, ok := v.(int32); ok && value > 0 {
+		d.Estimate = &provider.Estimate{TS: time.Now(), Value: value}
+		// the range is optional
+		d.Estimate.Low = extractInt32(ec, "avm_info", "value_low", jmesAVM)
+		d.Estimate.High = extractInt32(ec, "avm_info", "value_high", jmesAVM)
+	}
+	return d, nil
+}
+
+// Returns a pointer to the extracted int field, or nil if it's missing or
+// can't be extracted.
+func extractInt32(ec *ExtractionConfig, payload, field string, data interface{}) *int32 {
+	v, err := ec.Extract(payload, field, data)
+	if err != nil {
+		return nil
+	}
+	i, ok := v.(int32)
+	if !ok {
+		return nil
+	}
+	return &i
+}
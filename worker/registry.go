@@ -0,0 +1,315 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of worker options.
+const OptionString = "string"
+const OptionInt = "int"
+const OptionDuration = "duration"
+const OptionBool = "bool"
+
+// Options every worker kind accepts in addition to its own.
+const OptionInterval = "interval"
+const OptionConcurrency = "concurrency"
+const OptionJitter = "jitter"
+const OptionIdleBackoff = "idle-backoff"
+const OptionDrainTimeout = "drain-timeout"
+
+var commonOptions = []string{
+	OptionInterval,
+	OptionConcurrency,
+	OptionJitter,
+	OptionIdleBackoff,
+	OptionDrainTimeout,
+}
+
+// WorkerOption describes a configuration option of a worker kind. Values are
+// taken from the command line, then EnvVar (if set), then Default.
+type WorkerOption struct {
+	Name    string
+	Type    string
+	Default string
+	EnvVar  string
+	Usage   string
+}
+
+// WorkerKind is a kind of worker that can be run by name. Options is the
+// config schema for the kind; Factory builds the worker function from a
+// config that has been validated against it.
+type WorkerKind struct {
+	Name     string
+	Usage    string
+	Interval time.Duration
+	Options  []WorkerOption
+	Factory  func(l *slog.Logger, c *WorkerConfig) (func(context.Context, *slog.Logger), error)
+}
+
+var (
+	workerKindsMu sync.RWMutex
+	workerKinds   = map[string]WorkerKind{}
+)
+
+// RegisterWorker makes a worker kind available by name. It's meant to be called
+// from init functions, and panics if the kind is invalid or already registered.
+func RegisterWorker(k WorkerKind) {
+	workerKindsMu.Lock()
+	defer workerKindsMu.Unlock()
+	if k.Name == "" || k.Factory == nil {
+		panic("worker: RegisterWorker requires a name and a factory")
+	}
+	if _, ok := workerKinds[k.Name]; ok {
+		panic(fmt.Sprintf("worker: RegisterWorker called twice for kind %s", k.Name))
+	}
+	for _, o := range k.Options {
+		if slices.Contains(commonOptions, o.Name) {
+			panic(fmt.Sprintf("worker: kind %s redeclares the %s option", k.Name, o.Name))
+		}
+	}
+	workerKinds[k.Name] = k
+}
+
+// Returns the registered worker kinds sorted by name.
+func WorkerKinds() []WorkerKind {
+	workerKindsMu.RLock()
+	defer workerKindsMu.RUnlock()
+	ks := []WorkerKind{}
+	for _, k := range workerKinds {
+		ks = append(ks, k)
+	}
+	sort.Slice(ks, func(i, j int) bool { return ks[i].Name < ks[j].Name })
+	return ks
+}
+
+// Returns the full config schema of the kind, including the common options.
+func (k WorkerKind) Schema() []WorkerOption {
+	interval := k.Interval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	return append([]WorkerOption{
+		{Name: OptionInterval, Type: OptionDuration, Default: interval.String(), Usage: "Minimum interval between running tasks."},
+		{Name: OptionConcurrency, Type: OptionInt, Default: "1", Usage: "Number of tasks to run in parallel."},
+		{Name: OptionJitter, Type: OptionDuration, Default: (interval / 10).String(), Usage: "Maximum random delay added to the interval."},
+		{Name: OptionIdleBackoff, Type: OptionDuration, Default: DefaultIdleBackoff.String(), Usage: "Maximum interval to back off to while there's no work; also the longest new work can wait to be picked up by an idle pool."},
+		{Name: OptionDrainTimeout, Type: OptionDuration, Default: "0s", Usage: "How long in-flight tasks may run after shutdown is requested."},
+	}, k.Options...)
+}
+
+// WorkerConfig is the validated configuration of a single worker kind.
+type WorkerConfig struct {
+	Endpoint  string
+	AuthToken string
+	values    map[string]any
+}
+
+// Returns the value of a string option.
+func (c *WorkerConfig) String(name string) string {
+	v, _ := c.values[name].(string)
+	return v
+}
+
+// Returns the value of an int option.
+func (c *WorkerConfig) Int(name string) int {
+	v, _ := c.values[name].(int)
+	return v
+}
+
+// Returns the value of a duration option.
+func (c *WorkerConfig) Duration(name string) time.Duration {
+	v, _ := c.values[name].(time.Duration)
+	return v
+}
+
+// Returns the value of a bool option.
+func (c *WorkerConfig) Bool(name string) bool {
+	v, _ := c.values[name].(bool)
+	return v
+}
+
+// Builds the config for the kind from the supplied settings. Settings are
+// "option=value" pairs, optionally scoped to a kind as "kind.option=value";
+// scoped settings take precedence, and unscoped settings only apply to kinds
+// that declare the option. Scoped settings for options the kind doesn't
+// declare are an error.
+func (k WorkerKind) Config(end, authToken string, settings []string) (*WorkerConfig, error) {
+	schema := k.Schema()
+	declared := map[string]bool{}
+	for _, o := range schema {
+		declared[o.Name] = true
+	}
+	scoped := map[string]string{}
+	unscoped := map[string]string{}
+	for _, s := range settings {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad worker setting %q (expected option=value or kind.option=value)", s)
+		}
+		if kind, opt, ok := strings.Cut(name, "."); ok {
+			if kind != k.Name {
+				continue
+			}
+			if !declared[opt] {
+				return nil, fmt.Errorf("worker kind %s has no option %s", k.Name, opt)
+			}
+			scoped[opt] = value
+			continue
+		}
+		unscoped[name] = value
+	}
+
+	c := &WorkerConfig{Endpoint: end, AuthToken: authToken, values: map[string]any{}}
+	for _, o := range schema {
+		raw, ok := scoped[o.Name]
+		if !ok {
+			raw, ok = unscoped[o.Name]
+		}
+		if !ok && o.EnvVar != "" {
+			raw, ok = os.LookupEnv(o.EnvVar)
+		}
+		if !ok {
+			raw = o.Default
+		}
+		v, err := parseWorkerOption(o, raw)
+		if err != nil {
+			return nil, fmt.Errorf("bad value for %s.%s: %w", k.Name, o.Name, err)
+		}
+		c.values[o.Name] = v
+	}
+	if c.Duration(OptionInterval) <= 0 {
+		return nil, fmt.Errorf("%s.%s must be positive", k.Name, OptionInterval)
+	}
+	if c.Int(OptionConcurrency) < 1 {
+		return nil, fmt.Errorf("%s.%s must be at least 1", k.Name, OptionConcurrency)
+	}
+	for _, name := range []string{OptionJitter, OptionIdleBackoff, OptionDrainTimeout} {
+		if c.Duration(name) < 0 {
+			return nil, fmt.Errorf("%s.%s must not be negative", k.Name, name)
+		}
+	}
+	return c, nil
+}
+
+func parseWorkerOption(o WorkerOption, raw string) (any, error) {
+	switch o.Type {
+	case OptionString, "":
+		return raw, nil
+	case OptionInt:
+		if raw == "" {
+			return 0, nil
+		}
+		return strconv.Atoi(raw)
+	case OptionDuration:
+		if raw == "" {
+			return time.Duration(0), nil
+		}
+		return time.ParseDuration(raw)
+	case OptionBool:
+		if raw == "" {
+			return false, nil
+		}
+		return strconv.ParseBool(raw)
+	default:
+		return nil, fmt.Errorf("unsupported option type %s", o.Type)
+	}
+}
+
+// RunWorkers runs the named worker kinds in one process until the context is
+// cancelled. Each kind runs in its own pool (see RunWorkerFunc) configured by
+// the common options; the settings are passed to WorkerKind.Config. All of the
+// configs are validated before any worker starts.
+func RunWorkers(
+	ctx context.Context,
+	l *slog.Logger,
+	end string,
+	authToken string,
+	kinds []string,
+	settings []string,
+) error {
+	if len(kinds) == 0 {
+		return fmt.Errorf("must supply at least one worker kind")
+	}
+	workerKindsMu.RLock()
+	type run struct {
+		kind WorkerKind
+		conf *WorkerConfig
+	}
+	runs := []run{}
+	seen := map[string]bool{}
+	for _, name := range kinds {
+		if seen[name] {
+			workerKindsMu.RUnlock()
+			return fmt.Errorf("worker kind %s supplied more than once", name)
+		}
+		seen[name] = true
+		k, ok := workerKinds[name]
+		if !ok {
+			workerKindsMu.RUnlock()
+			return fmt.Errorf("unknown worker kind: %s", name)
+		}
+		c, err := k.Config(end, authToken, settings)
+		if err != nil {
+			workerKindsMu.RUnlock()
+			return err
+		}
+		runs = append(runs, run{kind: k, conf: c})
+	}
+	workerKindsMu.RUnlock()
+
+	// unscoped settings have to apply to at least one of the kinds
+	for _, s := range settings {
+		name, _, _ := strings.Cut(s, "=")
+		if strings.Contains(name, ".") {
+			continue
+		}
+		used := false
+		for _, r := range runs {
+			for _, o := range r.kind.Schema() {
+				used = used || o.Name == name
+			}
+		}
+		if !used {
+			return fmt.Errorf("none of the worker kinds have an option %s", name)
+		}
+	}
+
+	fs := []func(context.Context, *slog.Logger){}
+	for _, r := range runs {
+		f, err := r.kind.Factory(l.With("worker", r.kind.Name), r.conf)
+		if err != nil {
+			return fmt.Errorf("error creating %s worker: %w", r.kind.Name, err)
+		}
+		fs = append(fs, f)
+	}
+
+	var wg sync.WaitGroup
+	for i, r := range runs {
+		kl := l.With("worker", r.kind.Name)
+		interval := r.conf.Duration(OptionInterval)
+		concurrency := r.conf.Int(OptionConcurrency)
+		opts := []PoolOption{
+			WithConcurrency(concurrency),
+			WithJitter(r.conf.Duration(OptionJitter)),
+			WithIdleBackoff(r.conf.Duration(OptionIdleBackoff)),
+			WithDrain(r.conf.Duration(OptionDrainTimeout)),
+		}
+		wg.Add(1)
+		go func(f func(context.Context, *slog.Logger)) {
+			defer wg.Done()
+			RunWorkerFunc(ctx, kl, interval, f, opts...)
+		}(fs[i])
+		kl.Info("started worker", "interval", interval.String(), "concurrency", concurrency)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
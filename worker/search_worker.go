@@ -6,58 +6,61 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/brojonat/gredfin/provider"
 	"github.com/brojonat/gredfin/redfin"
 	"github.com/brojonat/gredfin/server"
 	"github.com/brojonat/gredfin/server/db/dbgen"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-// Default implementation of a Search scrape worker. The worker pulls a search
-// query from the service and runs the query against the Redfin API. A list of
-// URLs is extracted from the result and for each URL, a series of queries is
-// performed against the RefinAPI
+// Search jobs can upload hundreds of listings, so they get a long lease.
+const searchJobLease = time.Hour
+
+// Default implementation of a Search scrape worker. The worker claims search
+// jobs from the server and runs them with SearchJobHandler.
 func MakeSearchWorkerFunc(
 	endpoint string,
 	authToken string,
-	grc redfin.Client,
+	prov provider.Discoverer,
 	pqd time.Duration,
 ) func(context.Context, *slog.Logger) {
-	f := func(ctx context.Context, l *slog.Logger) {
-		// claim the search query
-		l.Info("running search scrape worker loop")
-		s, err := claimSearch(endpoint, server.GetDefaultServerHeaders(authToken))
-		if err != nil {
-			l.Error("error getting search, exiting", "error", err.Error())
-			return
-		}
-		l.Info("claimed query", "query", s.Query.String)
+	return MakeJobWorkerFunc(endpoint, authToken, searchJobLease, SearchJobHandler(endpoint, authToken, prov, pqd))
+}
 
-		// run the query and get a list of Redfin URLs
-		urls, err := GetURLSFromQuery(
-			l,
-			grc,
-			s.Query.String,
-			GetDefaultSearchParams(),
-			GetDefaultGISCSVParams(),
-		)
+// Outcome of a search job.
+type SearchJobResult struct {
+	SuccessCount int `json:"success_count"`
+	ErrorCount   int `json:"error_count"`
+}
+
+// Returns a JobHandler that runs each search job's query against the provider.
+// Each of the resulting listings is resolved and uploaded to the server.
+func SearchJobHandler(
+	endpoint string,
+	authToken string,
+	prov provider.Discoverer,
+	pqd time.Duration,
+) JobHandler {
+	return HandleJob(server.JobKindSearch, func(ctx context.Context, l *slog.Logger, s server.SearchJobPayload) (any, error) {
+		l.Info("running search query", "query", s.Query)
+
+		// run the query and get a list of listing references
+		refs, err := prov.Search(ctx, s.Query)
 		if err != nil {
-			l.Error(err.Error())
-			return
+			return nil, err
 		}
 
-		// for each URL, upload the property listing to the DB
+		// for each listing, upload the property listing to the DB
 		h := server.GetDefaultServerHeaders(authToken)
 		nerr := 0
-		nsuccess := len(urls)
-		for _, u := range urls {
-			if err := addPropertyFromURL(endpoint, h, grc, u, pqd); err != nil {
+		nsuccess := len(refs)
+		for _, ref := range refs {
+			if err := addListing(ctx, endpoint, h, prov, ref, pqd); err != nil {
 				l.Error(err.Error())
 				nerr += 1
 				nsuccess -= 1
@@ -66,91 +69,17 @@ func MakeSearchWorkerFunc(
 		l.Info("search results uploaded", "error", nerr, "success", nsuccess)
 
 		// If any properties are uploaded successfully, we consider that a
-		// "good" scrape since there may be problematic properties returned that
-		// we don't expect to be able to parse. A "bad" scrape is one that had
-		// URLs returned and didn't successfully upload any properties to the
-		// server. This may result in some scrapes getting marked bad when in
-		// reality, by chance, they happen to not have any parseable properties,
-		// but it's good to identify those searches anyway.
-		status := server.ScrapeStatusGood
-		if len(urls) > 0 && nsuccess == 0 {
-			status = server.ScrapeStatusBad
+		// successful scrape since there may be problematic properties returned
+		// that we don't expect to be able to parse. A failed scrape is one
+		// that had listings returned and didn't successfully upload any
+		// properties to the server. This may result in some scrapes failing
+		// when in reality, by chance, they happen to not have any parseable
+		// properties, but it's good to identify those searches anyway.
+		if len(refs) > 0 && nsuccess == 0 {
+			return nil, fmt.Errorf("none of the %d search results could be uploaded", len(refs))
 		}
-		if err = markSearchStatus(endpoint, server.GetDefaultServerHeaders(authToken), s, status, nsuccess, nerr); err != nil {
-			l.Error(err.Error())
-			return
-		}
-	}
-	return f
-}
-
-// POST a request to claim an item from the search queue.
-func claimSearch(endpoint string, h http.Header) (*dbgen.Search, error) {
-	req, err := http.NewRequest(
-		http.MethodPost,
-		fmt.Sprintf("%s/search-query/claim-next", endpoint),
-		nil,
-	)
-	if err != nil {
-		return nil, err
-	}
-	req.Header = h
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		if res.StatusCode == http.StatusNotFound {
-			return nil, fmt.Errorf("%s (likely no available search queries to run)", res.Status)
-		}
-		return nil, fmt.Errorf(res.Status)
-	}
-	b, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	var s dbgen.Search
-	err = json.Unmarshal(b, &s)
-	if err != nil {
-		return nil, err
-	}
-	return &s, nil
-}
-
-func markSearchStatus(endpoint string, h http.Header, s *dbgen.Search, status string, success_count, error_count int) error {
-	req, err := http.NewRequest(
-		http.MethodPost,
-		fmt.Sprintf("%s/search-query/set-status", endpoint),
-		nil,
-	)
-	if err != nil {
-		return err
-	}
-	q := req.URL.Query()
-	q.Add("search_id", strconv.Itoa(int(s.SearchID)))
-	q.Add("status", status)
-	q.Add("success_count", strconv.Itoa(success_count))
-	q.Add("error_count", strconv.Itoa(error_count))
-	req.URL.RawQuery = q.Encode()
-	req.Header = h
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-	b, err := io.ReadAll(res.Body)
-	if err != nil {
-		return err
-	}
-	var data server.DefaultJSONResponse
-	if err = json.Unmarshal(b, &data); err != nil {
-		return err
-	}
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("%s (%s)", res.Status, data.Error)
-	}
-	return nil
+		return SearchJobResult{SuccessCount: nsuccess, ErrorCount: nerr}, nil
+	})
 }
 
 func GetURLSFromQuery(
@@ -228,86 +157,42 @@ func GetURLSFromQuery(
 	return urls, nil
 }
 
-func addPropertyFromURL(
+func addListing(
+	ctx context.Context,
 	endpoint string,
 	h http.Header,
-	grc redfin.Client,
-	url string,
+	prov provider.Discoverer,
+	ref provider.ListingRef,
 	delay time.Duration,
 ) error {
 	ts_start := time.Now()
-	b, err := grc.InitialInfo(
-		strings.TrimPrefix(url, "https://www.redfin.com"),
-		map[string]string{},
-	)
-	if err != nil {
-		return fmt.Errorf("error getting initial_info: %w", err)
-	}
-	var res redfin.RedfinResponse
-	if err = json.Unmarshal(b, &res); err != nil {
-		return fmt.Errorf("error parsing initial_info response: %w", err)
-	}
-	var jmesdata interface{}
-	if err := json.Unmarshal(res.Payload, &jmesdata); err != nil {
-		return fmt.Errorf("error parsing initial_info data: %w", err)
-	}
-
-	// parse property_id
-	property_id, err := jmesParseInitialInfoParams("property_id", jmesdata)
-	if err != nil {
-		return fmt.Errorf("error searching for property_id: %w", err)
-	}
-	if property_id == nil {
-		return fmt.Errorf("null result extracting property_id")
-	}
-
-	// parse listing_id
-	listing_id, err := jmesParseInitialInfoParams("listing_id", jmesdata)
-	if err != nil {
-		return fmt.Errorf("error searching for listing_id: %w", err)
-	}
-	if listing_id == nil {
-		return fmt.Errorf("null result extracting listing_id")
-	}
-	pid, lid := int(property_id.(float64)), int(listing_id.(float64))
-
-	// parse lat/long
-	lat, err := jmesParseInitialInfoParams("latitude", jmesdata)
+	ls, err := prov.Resolve(ctx, ref)
 	if err != nil {
-		return fmt.Errorf("error searching for latitude: %w", err)
-	}
-	if lat == nil {
-		return fmt.Errorf("null result extracting latitude")
-	}
-	long, err := jmesParseInitialInfoParams("longitude", jmesdata)
-	if err != nil {
-		return fmt.Errorf("error searching for longitude: %w", err)
-	}
-	if long == nil {
-		return fmt.Errorf("null result extracting longitude")
+		return fmt.Errorf("error resolving listing (url: %s): %w", ref.URL, err)
 	}
-	coords := []float64{long.(float64), lat.(float64)}
 
 	p := &server.CreatePropertyParams{
 		CreatePropertyParams: dbgen.CreatePropertyParams{
-			PropertyID: int32(pid),
-			ListingID:  int32(lid),
-			URL:        pgtype.Text{String: url, Valid: true},
+			PropertyID: ls.PropertyID,
+			ListingID:  ls.ListingID,
+			URL:        pgtype.Text{String: ls.URL, Valid: ls.URL != ""},
+			Provider:   ls.Provider,
+			ExternalID: pgtype.Text{String: ls.ExternalID, Valid: ls.ExternalID != ""},
 		},
-		Location: server.Location{Type: "Point", Coordinates: coords},
+		Location: server.Location{Type: "Point", Coordinates: []float64{ls.Longitude, ls.Latitude}},
 	}
-	b, err = json.Marshal(p)
+	b, err := json.Marshal(p)
 	if err != nil {
 		return fmt.Errorf("error serializing create Property request: %w", err)
 	}
 	if err = createProperty(endpoint, h, b); err != nil {
 		return fmt.Errorf(
-			"error creating property (property_id: %d, listing_id: %d, url: %s): %w",
-			pid, lid, url, err,
+			"error creating property (provider: %s, external_id: %s, url: %s): %w",
+			ls.Provider, ls.ExternalID, ls.URL, err,
 		)
 	}
 
-	// sleep to avoid smashing redfin's api
+	// sleep to avoid smashing the provider's api
 	ts_end := time.Now()
 	time.Sleep(delay - ts_end.Sub(ts_start))
 	return nil